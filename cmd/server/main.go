@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 
@@ -22,12 +23,24 @@ func main() {
 	log.Println("✅ REDIRECT_URI  =", cfg.RedirectURI)
 	log.Println("✅ AUTHORIZE_URL =", cfg.AuthorizeURL)
 
+	if err := service.ConfigureCanadaPostTraffic(cfg.CanadaPost); err != nil {
+		log.Fatal(err)
+	}
+	if err := service.CheckMeasurementUnits(cfg.CanadaPost); err != nil {
+		log.Fatal(err)
+	}
+
 	store, err := database.NewStore(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer store.Close()
 
+	exchangeRates, err := service.NewExchangeRateUpdater(store, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	app := httpapi.NewApp(cfg, store)
 	mux := http.NewServeMux()
 	app.RegisterRoutes(mux)
@@ -35,6 +48,11 @@ func main() {
 	log.Printf("🚀 Server running on :%s\n", cfg.Port)
 
 	go grpcapi.Start(cfg.GRPCAddr, service.NewServer(store, cfg))
+	go service.NewTrackingPoller(store, cfg).Start(context.Background())
+	go service.NewManifestScheduler(store, cfg).Start(context.Background())
+	go service.NewChargesBackfill(store, cfg).Start(context.Background())
+	go service.NewServiceCatalog(store, cfg).Start(context.Background())
+	go exchangeRates.Start(context.Background())
 
 	log.Fatal(http.ListenAndServe("0.0.0.0:"+cfg.Port, mux))
 }
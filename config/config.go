@@ -25,6 +25,10 @@ type Config struct {
 	LabelStoragePath string
 	CanadaPost     CanadaPostConfig
 	Redis          RedisConfig
+	Tracking       TrackingConfig
+	Manifest       ManifestConfig
+	ServiceCatalog ServiceCatalogConfig
+	ExchangeRates  ExchangeRatesConfig
 }
 
 type CanadaPostConfig struct {
@@ -32,6 +36,14 @@ type CanadaPostConfig struct {
 	CustomerNumber string
 	Username       string
 	Password       string
+	// Traffic is "record" to write Canada Post requests and responses to
+	// FixturesDir, "replay" to answer calls from them, or empty for live.
+	Traffic     string
+	FixturesDir string
+	// WeightUnit and DimensionUnit are the units of the weights and parcel
+	// dimensions in plugin requests: oz, lb, g or kg, and in or cm.
+	WeightUnit    string
+	DimensionUnit string
 }
 
 type RedisConfig struct {
@@ -39,6 +51,41 @@ type RedisConfig struct {
 	Password              string
 	DB                    int
 	RateSessionTTLMinutes int
+	// RateQuoteTTLSeconds is how long Canada Post quotes are reused for the
+	// same mailing scenario; 0 turns the quote cache off.
+	RateQuoteTTLSeconds int
+}
+
+type TrackingConfig struct {
+	PollIntervalMinutes int
+	MaxAgeDays          int
+	BatchSize           int
+}
+
+type ManifestConfig struct {
+	// TransmitTime is the local HH:MM at which pending contract shipments are
+	// transmitted. Empty disables the daily job.
+	TransmitTime string
+}
+
+type ServiceCatalogConfig struct {
+	// RefreshHours is how often services are rediscovered from Canada Post.
+	// Zero disables the refresh; the cached catalog is still used.
+	RefreshHours int
+}
+
+type ExchangeRatesConfig struct {
+	// Source is where the shared rates to CAD come from: "valet" for the
+	// Bank of Canada Valet API, "http" for the JSON or CSV document at URL,
+	// or "manual" to use only the rates clients enter or import.
+	Source string
+	// URL overrides the Valet URL, and is required for the http source.
+	URL string
+	// RefreshHours is how often rates are fetched; zero disables the refresh.
+	RefreshHours int
+	// StaleHours is the age after which a rate is flagged as stale in
+	// settings and in the logs; zero never flags rates.
+	StaleHours int
 }
 
 func LoadConfig() Config {
@@ -73,12 +120,34 @@ func LoadConfig() Config {
 			CustomerNumber: v.GetString("canadapost.customer_number"),
 			Username:       v.GetString("canadapost.username"),
 			Password:       v.GetString("canadapost.password"),
+			Traffic:        v.GetString("canadapost.traffic"),
+			FixturesDir:    v.GetString("canadapost.fixtures_dir"),
+			WeightUnit:     v.GetString("canadapost.weight_unit"),
+			DimensionUnit:  v.GetString("canadapost.dimension_unit"),
 		},
 		Redis: RedisConfig{
 			Addr:                  v.GetString("redis.addr"),
 			Password:              v.GetString("redis.password"),
 			DB:                    v.GetInt("redis.db"),
 			RateSessionTTLMinutes: v.GetInt("redis.rate_session_ttl_minutes"),
+			RateQuoteTTLSeconds:   v.GetInt("redis.rate_quote_ttl_seconds"),
+		},
+		Tracking: TrackingConfig{
+			PollIntervalMinutes: v.GetInt("tracking.poll_interval_minutes"),
+			MaxAgeDays:          v.GetInt("tracking.max_age_days"),
+			BatchSize:           v.GetInt("tracking.batch_size"),
+		},
+		Manifest: ManifestConfig{
+			TransmitTime: v.GetString("manifest.transmit_time"),
+		},
+		ServiceCatalog: ServiceCatalogConfig{
+			RefreshHours: v.GetInt("service_catalog.refresh_hours"),
+		},
+		ExchangeRates: ExchangeRatesConfig{
+			Source:       v.GetString("exchange_rates.source"),
+			URL:          v.GetString("exchange_rates.url"),
+			RefreshHours: v.GetInt("exchange_rates.refresh_hours"),
+			StaleHours:   v.GetInt("exchange_rates.stale_hours"),
 		},
 	}
 }
@@ -104,6 +173,10 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("canadapost.customer_number", "")
 	v.SetDefault("canadapost.username", "")
 	v.SetDefault("canadapost.password", "")
+	v.SetDefault("canadapost.traffic", "")
+	v.SetDefault("canadapost.fixtures_dir", "testdata/canadapost")
+	v.SetDefault("canadapost.weight_unit", "oz")
+	v.SetDefault("canadapost.dimension_unit", "cm")
 
 	v.SetDefault("orders.grpc_addr", "192.168.1.99:7000")
 
@@ -111,14 +184,42 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("redis.password", "")
 	v.SetDefault("redis.db", 0)
 	v.SetDefault("redis.rate_session_ttl_minutes", 30)
+	v.SetDefault("redis.rate_quote_ttl_seconds", 120)
+
+	v.SetDefault("tracking.poll_interval_minutes", 60)
+	v.SetDefault("tracking.max_age_days", 30)
+	v.SetDefault("tracking.batch_size", 50)
+
+	v.SetDefault("manifest.transmit_time", "18:00")
+
+	v.SetDefault("service_catalog.refresh_hours", 24)
+
+	v.SetDefault("exchange_rates.source", "valet")
+	v.SetDefault("exchange_rates.url", "")
+	v.SetDefault("exchange_rates.refresh_hours", 6)
+	v.SetDefault("exchange_rates.stale_hours", 96)
 
 	_ = v.BindEnv("canadapost.base_url", "CANADA_POST_BASE_URL", "CANADAPOST_BASE_URL")
 	_ = v.BindEnv("canadapost.customer_number", "CANADA_POST_CUSTOMER_NUMBER", "CANADAPOST_CUSTOMER_NUMBER")
 	_ = v.BindEnv("canadapost.username", "CANADA_POST_USERNAME", "CANADAPOST_USERNAME")
 	_ = v.BindEnv("canadapost.password", "CANADA_POST_PASSWORD", "CANADAPOST_PASSWORD")
+	_ = v.BindEnv("canadapost.traffic", "CANADA_POST_TRAFFIC", "CANADAPOST_TRAFFIC")
+	_ = v.BindEnv("canadapost.fixtures_dir", "CANADA_POST_FIXTURES_DIR", "CANADAPOST_FIXTURES_DIR")
+	_ = v.BindEnv("canadapost.weight_unit", "CANADA_POST_WEIGHT_UNIT", "CANADAPOST_WEIGHT_UNIT")
+	_ = v.BindEnv("canadapost.dimension_unit", "CANADA_POST_DIMENSION_UNIT", "CANADAPOST_DIMENSION_UNIT")
 	_ = v.BindEnv("labels.storage_path", "LABEL_STORAGE_PATH")
 	_ = v.BindEnv("redis.addr", "REDIS_ADDR")
 	_ = v.BindEnv("redis.password", "REDIS_PASSWORD")
 	_ = v.BindEnv("redis.db", "REDIS_DB")
 	_ = v.BindEnv("redis.rate_session_ttl_minutes", "REDIS_RATE_SESSION_TTL_MINUTES")
+	_ = v.BindEnv("redis.rate_quote_ttl_seconds", "REDIS_RATE_QUOTE_TTL_SECONDS")
+	_ = v.BindEnv("tracking.poll_interval_minutes", "TRACKING_POLL_INTERVAL_MINUTES")
+	_ = v.BindEnv("tracking.max_age_days", "TRACKING_MAX_AGE_DAYS")
+	_ = v.BindEnv("tracking.batch_size", "TRACKING_BATCH_SIZE")
+	_ = v.BindEnv("manifest.transmit_time", "MANIFEST_TRANSMIT_TIME")
+	_ = v.BindEnv("service_catalog.refresh_hours", "SERVICE_CATALOG_REFRESH_HOURS")
+	_ = v.BindEnv("exchange_rates.source", "EXCHANGE_RATES_SOURCE")
+	_ = v.BindEnv("exchange_rates.url", "EXCHANGE_RATES_URL")
+	_ = v.BindEnv("exchange_rates.refresh_hours", "EXCHANGE_RATES_REFRESH_HOURS")
+	_ = v.BindEnv("exchange_rates.stale_hours", "EXCHANGE_RATES_STALE_HOURS")
 }
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	// Time zones are embedded so the alpine image needs no tzdata package.
+	_ "time/tzdata"
+)
+
+// DefaultTimeZone is used for the order cutoff when the client has not set
+// a time zone.
+const DefaultTimeZone = "America/Toronto"
+
+// DeliverySchedule is how the client gets orders out the door: the business
+// days it takes to hand an order to Canada Post, and the time of day, in
+// TimeZone, after which an order counts as received the next business day.
+// An empty cutoff means orders are never pushed to the next day.
+type DeliverySchedule struct {
+	HandlingDays int
+	OrderCutoff  string
+	TimeZone     string
+}
+
+// DeliverySchedule returns the client's schedule, falling back to no
+// handling time and no cutoff in Toronto time.
+func (s ShippingSettings) DeliverySchedule() DeliverySchedule {
+	schedule, err := NormalizeDeliverySchedule(DeliverySchedule{
+		HandlingDays: s.HandlingDays,
+		OrderCutoff:  s.OrderCutoff,
+		TimeZone:     s.TimeZone,
+	})
+	if err != nil {
+		return DeliverySchedule{TimeZone: DefaultTimeZone}
+	}
+	return schedule
+}
+
+// Location is the schedule's time zone.
+func (d DeliverySchedule) Location() *time.Location {
+	loc, err := time.LoadLocation(d.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// NormalizeDeliverySchedule fills in the default time zone and checks the
+// cutoff is a 24-hour HH:MM time.
+func NormalizeDeliverySchedule(schedule DeliverySchedule) (DeliverySchedule, error) {
+	schedule.OrderCutoff = strings.TrimSpace(schedule.OrderCutoff)
+	schedule.TimeZone = strings.TrimSpace(schedule.TimeZone)
+	if schedule.TimeZone == "" {
+		schedule.TimeZone = DefaultTimeZone
+	}
+	if schedule.HandlingDays < 0 || schedule.HandlingDays > 30 {
+		return DeliverySchedule{}, errors.New("handling days must be between 0 and 30")
+	}
+	if schedule.OrderCutoff != "" {
+		cutoff, err := time.Parse("15:04", schedule.OrderCutoff)
+		if err != nil {
+			return DeliverySchedule{}, fmt.Errorf("invalid order cutoff %q: use HH:MM, e.g. 14:30", schedule.OrderCutoff)
+		}
+		schedule.OrderCutoff = cutoff.Format("15:04")
+	}
+	if _, err := time.LoadLocation(schedule.TimeZone); err != nil {
+		return DeliverySchedule{}, fmt.Errorf("unknown time zone %q", schedule.TimeZone)
+	}
+	return schedule, nil
+}
+
+func (s *Store) SaveDeliverySchedule(clientID int64, schedule DeliverySchedule) error {
+	schedule, err := NormalizeDeliverySchedule(schedule)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+		INSERT INTO shipping_settings (client_id, account_number, enabled_services, handling_days, order_cutoff, time_zone)
+		VALUES (?, '', '', ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			handling_days = VALUES(handling_days),
+			order_cutoff = VALUES(order_cutoff),
+			time_zone = VALUES(time_zone)
+	`, clientID, schedule.HandlingDays, schedule.OrderCutoff, schedule.TimeZone)
+	return err
+}
@@ -0,0 +1,357 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Exchange rate sources, as stored with each rate.
+const (
+	FXSourceManual = "manual"
+	FXSourceImport = "import"
+	FXSourceValet  = "valet"
+	FXSourceHTTP   = "http"
+)
+
+// ExchangeRate is one version of a currency's rate to CAD: how much CAD 1
+// unit of the currency is worth from EffectiveDate. Rates from the
+// configured source are shared by every client (ClientID 0). A client's
+// own rates, entered or imported in settings, are used instead only if the
+// client opted in (Override), or for a currency without a shared rate.
+// Rates are never updated in place, so the ID identifies the version a
+// quote or label used.
+type ExchangeRate struct {
+	ID            int64
+	ClientID      int64
+	CurrencyCode  string
+	RateToCad     float64
+	Source        string
+	EffectiveDate time.Time
+	CreatedAt     time.Time
+	Override      bool // set when resolving, not stored with the version
+}
+
+// Stale reports whether the rate took effect more than maxAge before now.
+// A maxAge of 0 never makes a rate stale.
+func (r ExchangeRate) Stale(now time.Time, maxAge time.Duration) bool {
+	return maxAge > 0 && now.Sub(r.EffectiveDate) > maxAge
+}
+
+// Own reports whether the rate is the client's own rather than shared.
+func (r ExchangeRate) Own() bool {
+	return r.ClientID != 0
+}
+
+const exchangeRateColumns = "r.id, r.client_id, r.currency_code, r.rate_to_cad, r.source, r.effective_date, r.created_at"
+
+// ensureExchangeRatesTable creates the rate history and records the rates
+// clients entered before it as the first version of their own history.
+// Their currency_rates rows are marked as overrides when the override
+// column is added, so they keep being used instead of the shared rate.
+func (s *Store) ensureExchangeRatesTable() error {
+	_, err := s.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS exchange_rates (
+			id BIGINT PRIMARY KEY AUTO_INCREMENT,
+			client_id BIGINT NOT NULL DEFAULT 0,
+			currency_code VARCHAR(8) NOT NULL,
+			rate_to_cad DOUBLE NOT NULL,
+			source VARCHAR(16) NOT NULL,
+			effective_date DATE NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			KEY idx_exchange_rate_lookup (client_id, currency_code, effective_date)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+		INSERT INTO exchange_rates (client_id, currency_code, rate_to_cad, source, effective_date)
+		SELECT c.client_id, c.currency_code, c.rate_to_cad, ?, DATE(c.updated_at)
+		FROM currency_rates c
+		WHERE NOT EXISTS (
+			SELECT 1 FROM exchange_rates r
+			WHERE r.client_id = c.client_id AND r.currency_code = c.currency_code
+		)
+	`, FXSourceManual)
+	return err
+}
+
+// NormalizeExchangeRate upper-cases the currency and checks the rate.
+func NormalizeExchangeRate(rate ExchangeRate) (ExchangeRate, error) {
+	rate.CurrencyCode = strings.ToUpper(strings.TrimSpace(rate.CurrencyCode))
+	if len(rate.CurrencyCode) != 3 {
+		return ExchangeRate{}, fmt.Errorf("invalid currency code %q", rate.CurrencyCode)
+	}
+	if rate.CurrencyCode == "CAD" {
+		return ExchangeRate{}, errors.New("CAD does not need a rate")
+	}
+	if rate.RateToCad <= 0 {
+		return ExchangeRate{}, errors.New("rate_to_cad must be greater than zero")
+	}
+	if rate.EffectiveDate.IsZero() {
+		rate.EffectiveDate = time.Now().UTC()
+	}
+	return rate, nil
+}
+
+// SaveExchangeRates adds shared rates from a source to the history. A rate
+// already stored for the same currency, source, date and value is skipped,
+// so fetching the same observations again adds nothing. It returns how many
+// rates were added.
+func (s *Store) SaveExchangeRates(rates []ExchangeRate) (int, error) {
+	added := 0
+	for _, rate := range sortedExchangeRates(rates) {
+		rate, err := NormalizeExchangeRate(rate)
+		if err != nil {
+			return added, err
+		}
+		res, err := s.DB.Exec(`
+			INSERT INTO exchange_rates (client_id, currency_code, rate_to_cad, source, effective_date)
+			SELECT 0, ?, ?, ?, ?
+			FROM DUAL
+			WHERE NOT EXISTS (
+				SELECT 1 FROM exchange_rates
+				WHERE client_id = 0 AND currency_code = ? AND source = ? AND effective_date = ? AND rate_to_cad = ?
+			)
+		`, rate.CurrencyCode, rate.RateToCad, rate.Source, rate.EffectiveDate.Format("2006-01-02"),
+			rate.CurrencyCode, rate.Source, rate.EffectiveDate.Format("2006-01-02"), rate.RateToCad)
+		if err != nil {
+			return added, err
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			added += int(n)
+		}
+	}
+	return added, nil
+}
+
+// SaveClientExchangeRates adds the rates to the client's own history. With
+// override the client opts in to using them in place of the shared rates
+// of their currencies; without it they are used only for currencies with
+// no shared rate, and an earlier opt-in for the currencies is withdrawn.
+// For a currency given several times the latest effective date wins.
+func (s *Store) SaveClientExchangeRates(clientID int64, rates []ExchangeRate, override bool) error {
+	rates = sortedExchangeRates(rates)
+	for i := range rates {
+		rate, err := NormalizeExchangeRate(rates[i])
+		if err != nil {
+			return err
+		}
+		rates[i] = rate
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	for _, rate := range rates {
+		if _, err := tx.Exec(`
+			INSERT INTO exchange_rates (client_id, currency_code, rate_to_cad, source, effective_date)
+			VALUES (?, ?, ?, ?, ?)
+		`, clientID, rate.CurrencyCode, rate.RateToCad, defaultSource(rate.Source), rate.EffectiveDate.Format("2006-01-02")); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO currency_rates (client_id, currency_code, rate_to_cad, override)
+			VALUES (?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE rate_to_cad = VALUES(rate_to_cad), override = VALUES(override)
+		`, clientID, rate.CurrencyCode, rate.RateToCad, override); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteCurrencyRate withdraws the client's opt-in for the currency so that
+// the shared rate is used again. Its history is kept.
+func (s *Store) DeleteCurrencyRate(clientID int64, currencyCode string) error {
+	_, err := s.DB.Exec(`DELETE FROM currency_rates WHERE client_id = ? AND currency_code = ?`,
+		clientID, strings.ToUpper(strings.TrimSpace(currencyCode)))
+	return err
+}
+
+// ResolveExchangeRate returns the rate the client converts the currency
+// with: its own latest rate if it opted in, else the shared rate with the
+// latest effective date, else its own latest rate.
+func (s *Store) ResolveExchangeRate(clientID int64, currencyCode string) (ExchangeRate, bool, error) {
+	code := strings.ToUpper(strings.TrimSpace(currencyCode))
+	if code == "" {
+		return ExchangeRate{}, false, nil
+	}
+	for _, query := range []struct {
+		sql      string
+		args     []any
+		override bool
+	}{
+		{sql: `
+			SELECT ` + exchangeRateColumns + `
+			FROM currency_rates c
+			JOIN exchange_rates r ON r.id = (
+				SELECT MAX(id) FROM exchange_rates
+				WHERE client_id = c.client_id AND currency_code = c.currency_code
+			)
+			WHERE c.client_id = ? AND c.currency_code = ? AND c.override
+		`, args: []any{clientID, code}, override: true},
+		{sql: `
+			SELECT ` + exchangeRateColumns + `
+			FROM exchange_rates r
+			WHERE r.client_id = 0 AND r.currency_code = ?
+			ORDER BY r.effective_date DESC, r.id DESC
+			LIMIT 1
+		`, args: []any{code}},
+		{sql: `
+			SELECT ` + exchangeRateColumns + `
+			FROM exchange_rates r
+			WHERE r.client_id = ? AND r.currency_code = ?
+			ORDER BY r.id DESC
+			LIMIT 1
+		`, args: []any{clientID, code}},
+	} {
+		rate, err := scanExchangeRate(s.DB.QueryRow(query.sql, query.args...))
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return ExchangeRate{}, false, err
+		}
+		rate.Override = query.override
+		return rate, true, nil
+	}
+	return ExchangeRate{}, false, nil
+}
+
+// LoadExchangeRates returns the rate the client converts each currency
+// with, by currency: its own rates where it opted in, the latest shared
+// rates of the other currencies, and its own latest rates of the
+// currencies without a shared rate.
+func (s *Store) LoadExchangeRates(clientID int64) ([]ExchangeRate, error) {
+	overrides, err := s.queryExchangeRates(`
+		SELECT `+exchangeRateColumns+`
+		FROM currency_rates c
+		JOIN exchange_rates r ON r.id = (
+			SELECT MAX(id) FROM exchange_rates
+			WHERE client_id = c.client_id AND currency_code = c.currency_code
+		)
+		WHERE c.client_id = ? AND c.override
+	`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := s.queryExchangeRates(`
+		SELECT ` + exchangeRateColumns + `
+		FROM exchange_rates r
+		JOIN (
+			SELECT currency_code, MAX(effective_date) AS effective_date
+			FROM exchange_rates
+			WHERE client_id = 0
+			GROUP BY currency_code
+		) latest ON latest.currency_code = r.currency_code AND latest.effective_date = r.effective_date
+		WHERE r.client_id = 0
+		ORDER BY r.id DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	own, err := s.queryExchangeRates(`
+		SELECT `+exchangeRateColumns+`
+		FROM exchange_rates r
+		JOIN (
+			SELECT MAX(id) AS id
+			FROM exchange_rates
+			WHERE client_id = ?
+			GROUP BY currency_code
+		) latest ON latest.id = r.id
+	`, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	byCode := map[string]ExchangeRate{}
+	for _, rate := range overrides {
+		rate.Override = true
+		byCode[rate.CurrencyCode] = rate
+	}
+	for _, rates := range [][]ExchangeRate{shared, own} {
+		for _, rate := range rates {
+			if _, ok := byCode[rate.CurrencyCode]; !ok {
+				byCode[rate.CurrencyCode] = rate
+			}
+		}
+	}
+	rates := make([]ExchangeRate, 0, len(byCode))
+	for _, rate := range byCode {
+		rates = append(rates, rate)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].CurrencyCode < rates[j].CurrencyCode })
+	return rates, nil
+}
+
+// LoadExchangeRateHistory returns the client's own and the shared rates,
+// latest first.
+func (s *Store) LoadExchangeRateHistory(clientID int64, limit int) ([]ExchangeRate, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return s.queryExchangeRates(`
+		SELECT `+exchangeRateColumns+`
+		FROM exchange_rates r
+		WHERE r.client_id IN (0, ?)
+		ORDER BY r.effective_date DESC, r.id DESC
+		LIMIT ?
+	`, clientID, limit)
+}
+
+func (s *Store) queryExchangeRates(query string, args ...any) ([]ExchangeRate, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []ExchangeRate{}
+	for rows.Next() {
+		rate, err := scanExchangeRate(rows)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
+
+func scanExchangeRate(row rowScanner) (ExchangeRate, error) {
+	var rate ExchangeRate
+	err := row.Scan(
+		&rate.ID,
+		&rate.ClientID,
+		&rate.CurrencyCode,
+		&rate.RateToCad,
+		&rate.Source,
+		&rate.EffectiveDate,
+		&rate.CreatedAt,
+	)
+	return rate, err
+}
+
+// sortedExchangeRates orders rates by effective date, so that the latest
+// observation of a currency is stored last.
+func sortedExchangeRates(rates []ExchangeRate) []ExchangeRate {
+	sorted := append([]ExchangeRate{}, rates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].EffectiveDate.Before(sorted[j].EffectiveDate)
+	})
+	return sorted
+}
+
+func defaultSource(source string) string {
+	if strings.TrimSpace(source) == "" {
+		return FXSourceManual
+	}
+	return source
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// FallbackRate is one row of a client's fallback rate table, served as an
+// estimate when Canada Post can't be reached. Zone is "*" for everywhere, a
+// country ("US"), a province ("CA-ON") or a forward sortation area ("K1A");
+// the most specific zone matching the destination wins. A row prices every
+// shipment up to MaxWeight kg at Price CAD.
+type FallbackRate struct {
+	ID           int64
+	ClientID     int64
+	ServiceCode  string
+	Zone         string
+	MaxWeight    float64
+	Price        float64
+	DeliveryDays int
+	UpdatedAt    time.Time
+}
+
+var (
+	fallbackCountryZone  = regexp.MustCompile(`^[A-Z]{2}$`)
+	fallbackProvinceZone = regexp.MustCompile(`^[A-Z]{2}-[A-Z]{2}$`)
+	fallbackFSAZone      = regexp.MustCompile(`^[A-Z][0-9][A-Z]$`)
+)
+
+func (s *Store) ensureFallbackRatesTable() error {
+	_, err := s.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS fallback_rates (
+			id BIGINT PRIMARY KEY AUTO_INCREMENT,
+			client_id BIGINT NOT NULL,
+			service_code VARCHAR(32) NOT NULL,
+			zone VARCHAR(16) NOT NULL,
+			max_weight DECIMAL(10,3) NOT NULL,
+			price DECIMAL(12,2) NOT NULL,
+			delivery_days INT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			UNIQUE KEY uniq_client_fallback_rate (client_id, service_code, zone, max_weight)
+		)
+	`)
+	return err
+}
+
+// NormalizeFallbackRate trims and upper-cases the row and checks its zone,
+// weight bracket and price.
+func NormalizeFallbackRate(rate FallbackRate) (FallbackRate, error) {
+	rate.ServiceCode = strings.ToUpper(strings.TrimSpace(rate.ServiceCode))
+	if rate.ServiceCode == "" {
+		return FallbackRate{}, errors.New("service is required")
+	}
+	rate.Zone = strings.ToUpper(strings.ReplaceAll(rate.Zone, " ", ""))
+	if rate.Zone == "" {
+		rate.Zone = "*"
+	}
+	if rate.Zone != "*" && !fallbackCountryZone.MatchString(rate.Zone) &&
+		!fallbackProvinceZone.MatchString(rate.Zone) && !fallbackFSAZone.MatchString(rate.Zone) {
+		return FallbackRate{}, fmt.Errorf("invalid zone %q: use *, a country (US), a province (CA-ON) or a postal code prefix (K1A)", rate.Zone)
+	}
+	if rate.MaxWeight <= 0 {
+		return FallbackRate{}, errors.New("max weight must be greater than 0")
+	}
+	if rate.Price < 0 {
+		return FallbackRate{}, errors.New("price cannot be negative")
+	}
+	if rate.DeliveryDays < 0 {
+		return FallbackRate{}, errors.New("delivery days cannot be negative")
+	}
+	return rate, nil
+}
+
+// SaveFallbackRate adds the row to the client's table, or updates the row
+// for the same service, zone and weight bracket.
+func (s *Store) SaveFallbackRate(rate FallbackRate) error {
+	rate, err := NormalizeFallbackRate(rate)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+		INSERT INTO fallback_rates (client_id, service_code, zone, max_weight, price, delivery_days)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			price = VALUES(price),
+			delivery_days = VALUES(delivery_days)
+	`, rate.ClientID, rate.ServiceCode, rate.Zone, rate.MaxWeight, rate.Price, rate.DeliveryDays)
+	return err
+}
+
+func (s *Store) DeleteFallbackRate(clientID int64, id int64) error {
+	_, err := s.DB.Exec(`DELETE FROM fallback_rates WHERE client_id = ? AND id = ?`, clientID, id)
+	return err
+}
+
+// LoadFallbackRates returns the client's table by service, zone and weight.
+func (s *Store) LoadFallbackRates(clientID int64) ([]FallbackRate, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, client_id, service_code, zone, max_weight, price, delivery_days, updated_at
+		FROM fallback_rates
+		WHERE client_id = ?
+		ORDER BY service_code, zone, max_weight
+	`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []FallbackRate{}
+	for rows.Next() {
+		var rate FallbackRate
+		if err := rows.Scan(
+			&rate.ID,
+			&rate.ClientID,
+			&rate.ServiceCode,
+			&rate.Zone,
+			&rate.MaxWeight,
+			&rate.Price,
+			&rate.DeliveryDays,
+			&rate.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Label print preferences accepted in shipping_settings. Paper size and
+// encoding are sent to Canada Post as print-preferences; output decides
+// whether /labels serves the file inline or as a download.
+const (
+	LabelPaperSizeLetter  = "8.5x11"
+	LabelPaperSizeThermal = "4x6"
+	LabelEncodingPDF      = "PDF"
+	LabelEncodingZPL      = "ZPL"
+	LabelOutputInline     = "inline"
+	LabelOutputDownload   = "download"
+)
+
+type LabelPreferences struct {
+	PaperSize string
+	Encoding  string
+	Output    string
+}
+
+// LabelPreferences returns the client's label print preferences, falling
+// back to Canada Post's defaults (8.5x11 PDF) shown inline.
+func (s ShippingSettings) LabelPreferences() LabelPreferences {
+	prefs, err := NormalizeLabelPreferences(LabelPreferences{
+		PaperSize: s.LabelPaperSize,
+		Encoding:  s.LabelEncoding,
+		Output:    s.LabelOutput,
+	})
+	if err != nil {
+		return LabelPreferences{PaperSize: LabelPaperSizeLetter, Encoding: LabelEncodingPDF, Output: LabelOutputInline}
+	}
+	return prefs
+}
+
+// NormalizeLabelPreferences fills in defaults and rejects combinations
+// Canada Post does not print. ZPL is only produced for 4x6 thermal labels.
+func NormalizeLabelPreferences(prefs LabelPreferences) (LabelPreferences, error) {
+	prefs.PaperSize = strings.ToLower(strings.TrimSpace(prefs.PaperSize))
+	prefs.Encoding = strings.ToUpper(strings.TrimSpace(prefs.Encoding))
+	prefs.Output = strings.ToLower(strings.TrimSpace(prefs.Output))
+	if prefs.PaperSize == "" {
+		prefs.PaperSize = LabelPaperSizeLetter
+	}
+	if prefs.Encoding == "" {
+		prefs.Encoding = LabelEncodingPDF
+	}
+	if prefs.Output == "" {
+		prefs.Output = LabelOutputInline
+	}
+	if prefs.PaperSize != LabelPaperSizeLetter && prefs.PaperSize != LabelPaperSizeThermal {
+		return LabelPreferences{}, fmt.Errorf("unsupported label paper size %q", prefs.PaperSize)
+	}
+	if prefs.Encoding != LabelEncodingPDF && prefs.Encoding != LabelEncodingZPL {
+		return LabelPreferences{}, fmt.Errorf("unsupported label encoding %q", prefs.Encoding)
+	}
+	if prefs.Output != LabelOutputInline && prefs.Output != LabelOutputDownload {
+		return LabelPreferences{}, fmt.Errorf("unsupported label output %q", prefs.Output)
+	}
+	if prefs.Encoding == LabelEncodingZPL && prefs.PaperSize != LabelPaperSizeThermal {
+		return LabelPreferences{}, fmt.Errorf("ZPL labels require 4x6 paper")
+	}
+	return prefs, nil
+}
+
+func (s *Store) SaveLabelPreferences(clientID int64, prefs LabelPreferences) error {
+	prefs, err := NormalizeLabelPreferences(prefs)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+		INSERT INTO shipping_settings (client_id, account_number, enabled_services, label_paper_size, label_encoding, label_output)
+		VALUES (?, '', '', ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			label_paper_size = VALUES(label_paper_size),
+			label_encoding = VALUES(label_encoding),
+			label_output = VALUES(label_output)
+	`, clientID, prefs.PaperSize, prefs.Encoding, prefs.Output)
+	return err
+}
+
+// LabelFileExtension is the extension the label of the record is stored
+// and served with.
+func (r LabelRecord) LabelFileExtension() string {
+	if strings.EqualFold(r.LabelEncoding, LabelEncodingZPL) {
+		return "zpl"
+	}
+	return "pdf"
+}
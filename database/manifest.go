@@ -0,0 +1,282 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+)
+
+const (
+	ManifestStatusTransmitted = "transmitted"
+	ManifestStatusManifested  = "manifested"
+	// ManifestStatusVoided marks contract shipments voided before transmit,
+	// so that they are left out of the next transmit.
+	ManifestStatusVoided = "voided"
+)
+
+// ManifestAddress is the sender printed on contract manifests. It is kept
+// from the most recent contract shipment of the client.
+type ManifestAddress struct {
+	Company      string `json:"company"`
+	Name         string `json:"name"`
+	Phone        string `json:"phone"`
+	AddressLine1 string `json:"address_line_1"`
+	AddressLine2 string `json:"address_line_2,omitempty"`
+	City         string `json:"city"`
+	Province     string `json:"province"`
+	PostalCode   string `json:"postal_code"`
+}
+
+func (s *Store) SaveManifestAddress(clientID int64, address ManifestAddress) error {
+	raw, err := json.Marshal(address)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+		INSERT INTO shipping_settings (client_id, account_number, enabled_services, manifest_address)
+		VALUES (?, '', '', ?)
+		ON DUPLICATE KEY UPDATE manifest_address = VALUES(manifest_address)
+	`, clientID, string(raw))
+	return err
+}
+
+// LoadClientsWithPendingManifests returns clients that have contract labels
+// not transmitted yet.
+func (s *Store) LoadClientsWithPendingManifests() ([]int64, error) {
+	rows, err := s.DB.Query(`
+		SELECT DISTINCT client_id
+		FROM label_records
+		WHERE group_id <> '' AND manifest_status = '' AND client_id > 0
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clientIDs := []int64{}
+	for rows.Next() {
+		var clientID int64
+		if err := rows.Scan(&clientID); err != nil {
+			return nil, err
+		}
+		clientIDs = append(clientIDs, clientID)
+	}
+	return clientIDs, rows.Err()
+}
+
+func (s *Store) LoadPendingManifestGroups(clientID int64) ([]string, error) {
+	rows, err := s.DB.Query(`
+		SELECT DISTINCT group_id
+		FROM label_records
+		WHERE client_id = ? AND group_id <> '' AND manifest_status = ''
+		ORDER BY group_id
+	`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []string{}
+	for rows.Next() {
+		var groupID string
+		if err := rows.Scan(&groupID); err != nil {
+			return nil, err
+		}
+		groups = append(groups, groupID)
+	}
+	return groups, rows.Err()
+}
+
+// ManifestLink is a manifest returned by a transmit. It is stored with the
+// transmit so that the manifest can still be downloaded when the download
+// fails or the manifest isn't ready yet.
+type ManifestLink struct {
+	ID          int64
+	ClientID    int64
+	ManifestURL string
+	Attempts    int
+	LastError   string
+}
+
+func (s *Store) ensureManifestLinksTable() error {
+	_, err := s.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS manifest_links (
+			id BIGINT PRIMARY KEY AUTO_INCREMENT,
+			client_id BIGINT NOT NULL,
+			manifest_url VARCHAR(512) NOT NULL,
+			manifest_id VARCHAR(64) NOT NULL DEFAULT '',
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			last_attempt_at DATETIME NULL,
+			saved_at DATETIME NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uniq_manifest_link (client_id, manifest_url)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	exists, err := s.hasColumn("manifest_links", "last_attempt_at")
+	if err != nil || exists {
+		return err
+	}
+	_, err = s.DB.Exec("ALTER TABLE manifest_links ADD COLUMN last_attempt_at DATETIME NULL AFTER last_error")
+	return err
+}
+
+// MarkGroupsTransmitted records the transmit of the groups and the links of
+// the manifests it returned in one transaction, so that a transmitted group
+// always has its manifests to download.
+func (s *Store) MarkGroupsTransmitted(clientID int64, groupIDs []string, manifestURLs []string) error {
+	if len(groupIDs) == 0 {
+		return nil
+	}
+	args := []any{ManifestStatusTransmitted, clientID}
+	for _, groupID := range groupIDs {
+		args = append(args, groupID)
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE label_records
+		SET manifest_status = ?, transmitted_at = UTC_TIMESTAMP()
+		WHERE client_id = ? AND manifest_status = '' AND group_id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(groupIDs)), ",")+`)
+	`, args...); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	for _, manifestURL := range manifestURLs {
+		if _, err := tx.Exec(`
+			INSERT IGNORE INTO manifest_links (client_id, manifest_url)
+			VALUES (?, ?)
+		`, clientID, manifestURL); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadPendingManifestLinks returns the manifests not downloaded yet, of
+// every client when clientID is 0. Links already tried maxAttempts times are
+// left out, and a failed link waits an hour per attempt before it is
+// returned again.
+func (s *Store) LoadPendingManifestLinks(clientID int64, maxAttempts int) ([]ManifestLink, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, client_id, manifest_url, attempts, last_error
+		FROM manifest_links
+		WHERE saved_at IS NULL
+			AND (? = 0 OR client_id = ?)
+			AND attempts < ?
+			AND (last_attempt_at IS NULL OR last_attempt_at <= UTC_TIMESTAMP() - INTERVAL attempts HOUR)
+		ORDER BY id
+	`, clientID, clientID, maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ManifestLink{}
+	for rows.Next() {
+		var link ManifestLink
+		var lastError sql.NullString
+		if err := rows.Scan(&link.ID, &link.ClientID, &link.ManifestURL, &link.Attempts, &lastError); err != nil {
+			return nil, err
+		}
+		link.LastError = lastError.String
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// MarkManifestLinkSaved records that the manifest was downloaded.
+func (s *Store) MarkManifestLinkSaved(id int64, manifestID string) error {
+	_, err := s.DB.Exec(`
+		UPDATE manifest_links
+		SET manifest_id = ?, attempts = attempts + 1, last_error = NULL, last_attempt_at = UTC_TIMESTAMP(), saved_at = UTC_TIMESTAMP()
+		WHERE id = ?
+	`, manifestID, id)
+	return err
+}
+
+// RecordManifestLinkError records a failed download, to be tried again
+// until LoadPendingManifestLinks leaves it out.
+func (s *Store) RecordManifestLinkError(id int64, message string) error {
+	_, err := s.DB.Exec(`
+		UPDATE manifest_links
+		SET attempts = attempts + 1, last_error = ?, last_attempt_at = UTC_TIMESTAMP()
+		WHERE id = ?
+	`, message, id)
+	return err
+}
+
+// ManifestBelongsToClient reports whether the manifest lists any of the
+// client's labels.
+func (s *Store) ManifestBelongsToClient(clientID int64, manifestID string) (bool, error) {
+	var found int
+	err := s.DB.QueryRow(`
+		SELECT 1
+		FROM label_records
+		WHERE client_id = ? AND manifest_id = ?
+		LIMIT 1
+	`, clientID, manifestID).Scan(&found)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Store) SaveLabelManifest(clientID int64, shipmentIDs []string, manifestID string) error {
+	if len(shipmentIDs) == 0 {
+		return nil
+	}
+	args := []any{ManifestStatusManifested, manifestID, clientID}
+	for _, shipmentID := range shipmentIDs {
+		args = append(args, shipmentID)
+	}
+	_, err := s.DB.Exec(`
+		UPDATE label_records
+		SET manifest_status = ?, manifest_id = ?
+		WHERE client_id = ? AND shipment_id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(shipmentIDs)), ",")+`)
+	`, args...)
+	return err
+}
+
+// MarkLabelsRefunded records that a refund was requested for the
+// non-contract shipments of the labels.
+func (s *Store) MarkLabelsRefunded(clientID int64, labelIDs []string) error {
+	if len(labelIDs) == 0 {
+		return nil
+	}
+	args := []any{clientID}
+	for _, labelID := range labelIDs {
+		args = append(args, labelID)
+	}
+	_, err := s.DB.Exec(`
+		UPDATE label_records
+		SET refunded_at = UTC_TIMESTAMP()
+		WHERE client_id = ? AND refunded_at IS NULL AND id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(labelIDs)), ",")+`)
+	`, args...)
+	return err
+}
+
+// MarkLabelsVoided records that the contract shipments of the labels were
+// voided.
+func (s *Store) MarkLabelsVoided(clientID int64, labelIDs []string) error {
+	if len(labelIDs) == 0 {
+		return nil
+	}
+	args := []any{ManifestStatusVoided, clientID}
+	for _, labelID := range labelIDs {
+		args = append(args, labelID)
+	}
+	_, err := s.DB.Exec(`
+		UPDATE label_records
+		SET manifest_status = ?
+		WHERE client_id = ? AND manifest_status = '' AND id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(labelIDs)), ",")+`)
+	`, args...)
+	return err
+}
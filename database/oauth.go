@@ -142,10 +142,22 @@ func (s *Store) CleanupPluginData(storeID int64) error {
 		rollback()
 		return err
 	}
+	if err := deleteStep("delete exchange_rates", "DELETE FROM exchange_rates WHERE client_id = ?", storeID); err != nil {
+		rollback()
+		return err
+	}
 	if err := deleteStep("delete client_post_offices", "DELETE FROM client_post_offices WHERE client_id = ?", storeID); err != nil {
 		rollback()
 		return err
 	}
+	if err := deleteStep("delete client_pickups", "DELETE FROM client_pickups WHERE client_id = ?", storeID); err != nil {
+		rollback()
+		return err
+	}
+	if err := deleteStep("delete manifest_links", "DELETE FROM manifest_links WHERE client_id = ?", storeID); err != nil {
+		rollback()
+		return err
+	}
 
 	if err := tx.Commit(); err != nil {
 		rollback()
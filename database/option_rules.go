@@ -0,0 +1,137 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// CatalogOptionRule is a Canada Post option as offered for one service, with
+// the rules returned by the Get Option endpoint.
+type CatalogOptionRule struct {
+	ServiceCode       string
+	OptionCode        string
+	Name              string
+	QualifierRequired bool
+	QualifierMax      float64
+	Conflicts         []string
+	Prerequisites     []string
+	RefreshedAt       time.Time
+}
+
+func (s *Store) ensureServiceOptionRulesTable() error {
+	_, err := s.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS service_option_rules (
+			service_code VARCHAR(32) NOT NULL,
+			option_code VARCHAR(16) NOT NULL,
+			option_name VARCHAR(255) NOT NULL DEFAULT '',
+			qualifier_required TINYINT(1) NOT NULL DEFAULT 0,
+			qualifier_max DECIMAL(12,2) NOT NULL DEFAULT 0,
+			conflicting_options TEXT,
+			prerequisite_options TEXT,
+			refreshed_at DATETIME NULL,
+			PRIMARY KEY (service_code, option_code)
+		)
+	`)
+	return err
+}
+
+// SaveCatalogOptionRules replaces the stored option rules of every service
+// present in rules.
+func (s *Store) SaveCatalogOptionRules(rules []CatalogOptionRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	services := map[string]struct{}{}
+	for _, rule := range rules {
+		code := strings.ToUpper(strings.TrimSpace(rule.ServiceCode))
+		if _, ok := services[code]; ok || code == "" {
+			continue
+		}
+		services[code] = struct{}{}
+		if _, err := tx.Exec(`DELETE FROM service_option_rules WHERE service_code = ?`, code); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO service_option_rules (
+			service_code, option_code, option_name, qualifier_required, qualifier_max,
+			conflicting_options, prerequisite_options, refreshed_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	for _, rule := range rules {
+		serviceCode := strings.ToUpper(strings.TrimSpace(rule.ServiceCode))
+		optionCode := strings.ToUpper(strings.TrimSpace(rule.OptionCode))
+		if serviceCode == "" || optionCode == "" {
+			continue
+		}
+		if _, err := stmt.Exec(
+			serviceCode,
+			optionCode,
+			strings.TrimSpace(rule.Name),
+			rule.QualifierRequired,
+			rule.QualifierMax,
+			strings.Join(rule.Conflicts, ","),
+			strings.Join(rule.Prerequisites, ","),
+			now,
+		); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadCatalogOptionRules returns the stored option rules of every service.
+func (s *Store) LoadCatalogOptionRules() ([]CatalogOptionRule, error) {
+	rows, err := s.DB.Query(`
+		SELECT service_code, option_code, option_name, qualifier_required, qualifier_max,
+			COALESCE(conflicting_options, ''), COALESCE(prerequisite_options, ''), refreshed_at
+		FROM service_option_rules
+		ORDER BY service_code, option_code
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []CatalogOptionRule{}
+	for rows.Next() {
+		var rule CatalogOptionRule
+		var conflicts, prerequisites string
+		var refreshedAt sql.NullTime
+		if err := rows.Scan(
+			&rule.ServiceCode,
+			&rule.OptionCode,
+			&rule.Name,
+			&rule.QualifierRequired,
+			&rule.QualifierMax,
+			&conflicts,
+			&prerequisites,
+			&refreshedAt,
+		); err != nil {
+			return nil, err
+		}
+		rule.Conflicts = splitCatalogList(conflicts)
+		rule.Prerequisites = splitCatalogList(prerequisites)
+		if refreshedAt.Valid {
+			rule.RefreshedAt = refreshedAt.Time
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// PackagingBox is a box from a client's packaging library. Dimensions are
+// the inside of the box in cm and WallThickness is the thickness of its
+// sides in cm; weights are in kg. A MaxWeight of 0 means the box holds up
+// to Canada Post's parcel limit.
+type PackagingBox struct {
+	ID            int64
+	ClientID      int64
+	Name          string
+	Length        float64
+	Width         float64
+	Height        float64
+	WallThickness float64
+	TareWeight    float64
+	MaxWeight     float64
+	UpdatedAt     time.Time
+}
+
+// OuterDimensions returns the outside of the box in cm, which is what
+// Canada Post measures: each inner dimension plus a wall on either side.
+func (b PackagingBox) OuterDimensions() (length, width, height float64) {
+	walls := 2 * b.WallThickness
+	return b.Length + walls, b.Width + walls, b.Height + walls
+}
+
+func (s *Store) ensurePackagingBoxesTable() error {
+	_, err := s.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS packaging_boxes (
+			id BIGINT PRIMARY KEY AUTO_INCREMENT,
+			client_id BIGINT NOT NULL,
+			name VARCHAR(64) NOT NULL,
+			inner_length DECIMAL(8,2) NOT NULL,
+			inner_width DECIMAL(8,2) NOT NULL,
+			inner_height DECIMAL(8,2) NOT NULL,
+			wall_thickness DECIMAL(6,2) NOT NULL DEFAULT 0,
+			tare_weight DECIMAL(8,3) NOT NULL DEFAULT 0,
+			max_weight DECIMAL(8,3) NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			UNIQUE KEY uniq_client_box (client_id, name)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	return s.ensurePackagingBoxColumns()
+}
+
+func (s *Store) ensurePackagingBoxColumns() error {
+	exists, err := s.hasColumn("packaging_boxes", "wall_thickness")
+	if err != nil || exists {
+		return err
+	}
+	_, err = s.DB.Exec("ALTER TABLE packaging_boxes ADD COLUMN wall_thickness DECIMAL(6,2) NOT NULL DEFAULT 0 AFTER inner_height")
+	return err
+}
+
+// NormalizePackagingBox trims the name and rejects boxes that can't hold
+// anything.
+func NormalizePackagingBox(box PackagingBox) (PackagingBox, error) {
+	box.Name = strings.TrimSpace(box.Name)
+	if box.Name == "" {
+		return PackagingBox{}, errors.New("box name is required")
+	}
+	if len(box.Name) > 64 {
+		return PackagingBox{}, errors.New("box name must be 64 characters or fewer")
+	}
+	if box.Length <= 0 || box.Width <= 0 || box.Height <= 0 {
+		return PackagingBox{}, errors.New("box dimensions must be positive numbers")
+	}
+	if box.WallThickness < 0 {
+		return PackagingBox{}, errors.New("box wall thickness cannot be negative")
+	}
+	if box.TareWeight < 0 || box.MaxWeight < 0 {
+		return PackagingBox{}, errors.New("box weights cannot be negative")
+	}
+	if box.MaxWeight > 0 && box.MaxWeight <= box.TareWeight {
+		return PackagingBox{}, errors.New("box max weight must be more than its tare weight")
+	}
+	return box, nil
+}
+
+// SavePackagingBox adds the box to the client's library, or updates the box
+// with the same name.
+func (s *Store) SavePackagingBox(box PackagingBox) error {
+	box, err := NormalizePackagingBox(box)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+		INSERT INTO packaging_boxes (client_id, name, inner_length, inner_width, inner_height, wall_thickness, tare_weight, max_weight)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			inner_length = VALUES(inner_length),
+			inner_width = VALUES(inner_width),
+			inner_height = VALUES(inner_height),
+			wall_thickness = VALUES(wall_thickness),
+			tare_weight = VALUES(tare_weight),
+			max_weight = VALUES(max_weight)
+	`, box.ClientID, box.Name, box.Length, box.Width, box.Height, box.WallThickness, box.TareWeight, box.MaxWeight)
+	return err
+}
+
+func (s *Store) DeletePackagingBox(clientID int64, id int64) error {
+	_, err := s.DB.Exec(`DELETE FROM packaging_boxes WHERE client_id = ? AND id = ?`, clientID, id)
+	return err
+}
+
+// LoadPackagingBoxes returns the client's boxes, smallest first.
+func (s *Store) LoadPackagingBoxes(clientID int64) ([]PackagingBox, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, client_id, name, inner_length, inner_width, inner_height, wall_thickness, tare_weight, max_weight, updated_at
+		FROM packaging_boxes
+		WHERE client_id = ?
+		ORDER BY inner_length * inner_width * inner_height, name
+	`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	boxes := []PackagingBox{}
+	for rows.Next() {
+		var box PackagingBox
+		if err := rows.Scan(
+			&box.ID,
+			&box.ClientID,
+			&box.Name,
+			&box.Length,
+			&box.Width,
+			&box.Height,
+			&box.WallThickness,
+			&box.TareWeight,
+			&box.MaxWeight,
+			&box.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, box)
+	}
+	return boxes, rows.Err()
+}
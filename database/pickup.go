@@ -0,0 +1,163 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const (
+	PickupStatusActive    = "Active"
+	PickupStatusCancelled = "Cancelled"
+)
+
+// ClientPickup is a carrier pickup request scheduled with Canada Post.
+type ClientPickup struct {
+	ClientID      int64
+	RequestID     string
+	Status        string
+	PickupType    string
+	PostalCode    string
+	PickupDate    string
+	PreferredTime string
+	ClosingTime   string
+	ContactName   string
+	ContactEmail  string
+	ContactPhone  string
+	Volume        int
+	Instructions  string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (s *Store) ensureClientPickupsTable() error {
+	_, err := s.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS client_pickups (
+			id BIGINT PRIMARY KEY AUTO_INCREMENT,
+			client_id BIGINT NOT NULL,
+			request_id VARCHAR(32) NOT NULL,
+			status VARCHAR(32) NOT NULL DEFAULT '',
+			pickup_type VARCHAR(32) NOT NULL DEFAULT '',
+			postal_code VARCHAR(10) NOT NULL DEFAULT '',
+			pickup_date VARCHAR(10) NOT NULL DEFAULT '',
+			preferred_time VARCHAR(5) NOT NULL DEFAULT '',
+			closing_time VARCHAR(5) NOT NULL DEFAULT '',
+			contact_name VARCHAR(100) NOT NULL DEFAULT '',
+			contact_email VARCHAR(100) NOT NULL DEFAULT '',
+			contact_phone VARCHAR(32) NOT NULL DEFAULT '',
+			volume INT NOT NULL DEFAULT 0,
+			instructions VARCHAR(255) NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			UNIQUE KEY uniq_client_pickup (client_id, request_id),
+			KEY idx_client_pickup_date (client_id, pickup_date)
+		)
+	`)
+	return err
+}
+
+func (s *Store) SaveClientPickup(pickup ClientPickup) error {
+	_, err := s.DB.Exec(`
+		INSERT INTO client_pickups (
+			client_id, request_id, status, pickup_type, postal_code, pickup_date,
+			preferred_time, closing_time, contact_name, contact_email, contact_phone,
+			volume, instructions
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			status = VALUES(status),
+			pickup_type = VALUES(pickup_type),
+			postal_code = VALUES(postal_code),
+			pickup_date = VALUES(pickup_date),
+			preferred_time = VALUES(preferred_time),
+			closing_time = VALUES(closing_time),
+			contact_name = VALUES(contact_name),
+			contact_email = VALUES(contact_email),
+			contact_phone = VALUES(contact_phone),
+			volume = VALUES(volume),
+			instructions = VALUES(instructions)
+	`, pickup.ClientID, strings.TrimSpace(pickup.RequestID), pickup.Status, pickup.PickupType,
+		pickup.PostalCode, pickup.PickupDate, pickup.PreferredTime, pickup.ClosingTime,
+		pickup.ContactName, pickup.ContactEmail, pickup.ContactPhone, pickup.Volume, pickup.Instructions)
+	return err
+}
+
+func (s *Store) UpdateClientPickupStatus(clientID int64, requestID, status string) error {
+	_, err := s.DB.Exec(`
+		UPDATE client_pickups
+		SET status = ?
+		WHERE client_id = ? AND request_id = ?
+	`, status, clientID, strings.TrimSpace(requestID))
+	return err
+}
+
+const clientPickupColumns = `
+	client_id, request_id, status, pickup_type, postal_code, pickup_date,
+	preferred_time, closing_time, contact_name, contact_email, contact_phone,
+	volume, instructions, created_at, updated_at
+`
+
+// LoadClientPickup returns the stored pickup, or a zero value when the client
+// has no pickup with that request ID.
+func (s *Store) LoadClientPickup(clientID int64, requestID string) (ClientPickup, error) {
+	row := s.DB.QueryRow(`
+		SELECT `+clientPickupColumns+`
+		FROM client_pickups
+		WHERE client_id = ? AND request_id = ?
+	`, clientID, strings.TrimSpace(requestID))
+	pickup, err := scanClientPickup(row)
+	if err == sql.ErrNoRows {
+		return ClientPickup{}, nil
+	}
+	return pickup, err
+}
+
+// LoadClientPickups returns the client's pickups, latest pickup date first.
+func (s *Store) LoadClientPickups(clientID int64, limit int) ([]ClientPickup, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := s.DB.Query(`
+		SELECT `+clientPickupColumns+`
+		FROM client_pickups
+		WHERE client_id = ?
+		ORDER BY pickup_date DESC, created_at DESC
+		LIMIT ?
+	`, clientID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pickups := []ClientPickup{}
+	for rows.Next() {
+		pickup, err := scanClientPickup(rows)
+		if err != nil {
+			return nil, err
+		}
+		pickups = append(pickups, pickup)
+	}
+	return pickups, rows.Err()
+}
+
+func scanClientPickup(row rowScanner) (ClientPickup, error) {
+	var pickup ClientPickup
+	err := row.Scan(
+		&pickup.ClientID,
+		&pickup.RequestID,
+		&pickup.Status,
+		&pickup.PickupType,
+		&pickup.PostalCode,
+		&pickup.PickupDate,
+		&pickup.PreferredTime,
+		&pickup.ClosingTime,
+		&pickup.ContactName,
+		&pickup.ContactEmail,
+		&pickup.ContactPhone,
+		&pickup.Volume,
+		&pickup.Instructions,
+		&pickup.CreatedAt,
+		&pickup.UpdatedAt,
+	)
+	return pickup, err
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rate adjustment kinds. Amounts are in CAD except for percentages and the
+// charm rounding ending.
+const (
+	AdjustMarkupPercent   = "markup_percent"   // percent of the carrier price; negative for a discount
+	AdjustMarkupFlat      = "markup_flat"      // added to the price; negative for a discount
+	AdjustHandlingFee     = "handling_fee"     // added to the price
+	AdjustOptionSurcharge = "option_surcharge" // added when OptionCode is requested
+	AdjustMinPrice        = "min_price"
+	AdjustMaxPrice        = "max_price"
+	AdjustCharmRounding   = "charm_rounding" // price rounded up to end in Amount, e.g. 0.99
+)
+
+// RateAdjustmentKinds lists the kinds in the order they are applied.
+var RateAdjustmentKinds = []string{
+	AdjustMarkupPercent,
+	AdjustMarkupFlat,
+	AdjustHandlingFee,
+	AdjustOptionSurcharge,
+	AdjustCharmRounding,
+	AdjustMinPrice,
+	AdjustMaxPrice,
+}
+
+// RateAdjustment is a client's pricing rule for Canada Post rates. An empty
+// ServiceCode applies to every service; a rule for the service wins over it.
+type RateAdjustment struct {
+	ID          int64
+	ClientID    int64
+	Kind        string
+	ServiceCode string
+	OptionCode  string
+	Amount      float64
+	UpdatedAt   time.Time
+}
+
+func (s *Store) ensureRateAdjustmentsTable() error {
+	_, err := s.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS rate_adjustments (
+			id BIGINT PRIMARY KEY AUTO_INCREMENT,
+			client_id BIGINT NOT NULL,
+			kind VARCHAR(32) NOT NULL,
+			service_code VARCHAR(32) NOT NULL DEFAULT '',
+			option_code VARCHAR(16) NOT NULL DEFAULT '',
+			amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			UNIQUE KEY uniq_client_adjustment (client_id, kind, service_code, option_code)
+		)
+	`)
+	return err
+}
+
+// NormalizeRateAdjustment upper-cases the codes and rejects amounts that
+// make no sense for the kind.
+func NormalizeRateAdjustment(rule RateAdjustment) (RateAdjustment, error) {
+	rule.Kind = strings.ToLower(strings.TrimSpace(rule.Kind))
+	rule.ServiceCode = strings.ToUpper(strings.TrimSpace(rule.ServiceCode))
+	rule.OptionCode = strings.ToUpper(strings.TrimSpace(rule.OptionCode))
+	known := false
+	for _, kind := range RateAdjustmentKinds {
+		known = known || kind == rule.Kind
+	}
+	if !known {
+		return RateAdjustment{}, fmt.Errorf("unknown adjustment %q", rule.Kind)
+	}
+	if rule.Kind == AdjustOptionSurcharge {
+		if rule.OptionCode == "" {
+			return RateAdjustment{}, errors.New("option surcharge needs an option code")
+		}
+	} else {
+		rule.OptionCode = ""
+	}
+	switch rule.Kind {
+	case AdjustMarkupPercent:
+		if rule.Amount <= -100 {
+			return RateAdjustment{}, errors.New("a discount must be less than 100%")
+		}
+	case AdjustHandlingFee, AdjustOptionSurcharge, AdjustMinPrice, AdjustMaxPrice:
+		if rule.Amount < 0 {
+			return RateAdjustment{}, errors.New("fees and price limits cannot be negative")
+		}
+	case AdjustCharmRounding:
+		if rule.Amount < 0 || rule.Amount >= 1 {
+			return RateAdjustment{}, errors.New("charm rounding ending must be between 0 and 0.99")
+		}
+	}
+	return rule, nil
+}
+
+// SaveRateAdjustment adds the rule, or updates the amount of the client's
+// rule of the same kind for the same service and option.
+func (s *Store) SaveRateAdjustment(rule RateAdjustment) error {
+	rule, err := NormalizeRateAdjustment(rule)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+		INSERT INTO rate_adjustments (client_id, kind, service_code, option_code, amount)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE amount = VALUES(amount)
+	`, rule.ClientID, rule.Kind, rule.ServiceCode, rule.OptionCode, rule.Amount)
+	return err
+}
+
+func (s *Store) DeleteRateAdjustment(clientID int64, id int64) error {
+	_, err := s.DB.Exec(`DELETE FROM rate_adjustments WHERE client_id = ? AND id = ?`, clientID, id)
+	return err
+}
+
+// LoadRateAdjustments returns the client's rules, grouped by kind.
+func (s *Store) LoadRateAdjustments(clientID int64) ([]RateAdjustment, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, client_id, kind, service_code, option_code, amount, updated_at
+		FROM rate_adjustments
+		WHERE client_id = ?
+		ORDER BY kind, service_code, option_code
+	`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []RateAdjustment{}
+	for rows.Next() {
+		var rule RateAdjustment
+		if err := rows.Scan(
+			&rule.ID,
+			&rule.ClientID,
+			&rule.Kind,
+			&rule.ServiceCode,
+			&rule.OptionCode,
+			&rule.Amount,
+			&rule.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
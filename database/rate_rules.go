@@ -0,0 +1,146 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rate rule actions.
+const (
+	RuleFreeShipping = "free_shipping" // the services are free
+	RuleHideService  = "hide_service"  // the services are not offered
+	RuleHideSlower   = "hide_slower"   // hide a service when a faster one costs at most Amount percent more
+)
+
+// RateRuleActions lists the actions a rule can take.
+var RateRuleActions = []string{RuleFreeShipping, RuleHideService, RuleHideSlower}
+
+// RateRule is a client's conditional rule for the rates offered at checkout.
+// Conditions are checked by the service package, e.g. "subtotal >= 100;
+// country = CA"; an empty condition always matches. Services are the
+// service codes the action applies to, empty for every service. Rules run
+// in ID order.
+type RateRule struct {
+	ID         int64
+	ClientID   int64
+	Name       string
+	Conditions string
+	Action     string
+	Services   []string
+	Amount     float64
+	UpdatedAt  time.Time
+}
+
+func (s *Store) ensureRateRulesTable() error {
+	_, err := s.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS rate_rules (
+			id BIGINT PRIMARY KEY AUTO_INCREMENT,
+			client_id BIGINT NOT NULL,
+			name VARCHAR(64) NOT NULL,
+			conditions TEXT,
+			action VARCHAR(32) NOT NULL,
+			services VARCHAR(255) NOT NULL DEFAULT '',
+			amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			UNIQUE KEY uniq_client_rule (client_id, name)
+		)
+	`)
+	return err
+}
+
+// NormalizeRateRule trims the rule and upper-cases its service codes. It
+// does not parse the conditions.
+func NormalizeRateRule(rule RateRule) (RateRule, error) {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return RateRule{}, errors.New("rule name is required")
+	}
+	if len(rule.Name) > 64 {
+		return RateRule{}, errors.New("rule name must be 64 characters or fewer")
+	}
+	rule.Conditions = strings.TrimSpace(rule.Conditions)
+	rule.Action = strings.ToLower(strings.TrimSpace(rule.Action))
+	known := false
+	for _, action := range RateRuleActions {
+		known = known || action == rule.Action
+	}
+	if !known {
+		return RateRule{}, fmt.Errorf("unknown rule action %q", rule.Action)
+	}
+	services := []string{}
+	for _, code := range rule.Services {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			services = append(services, code)
+		}
+	}
+	rule.Services = services
+	if len(strings.Join(rule.Services, ",")) > 255 {
+		return RateRule{}, errors.New("too many services in one rule")
+	}
+	if rule.Action == RuleHideSlower && rule.Amount < 0 {
+		return RateRule{}, errors.New("the price difference cannot be negative")
+	}
+	return rule, nil
+}
+
+// SaveRateRule adds the rule to the client's rules, or updates the rule
+// with the same name.
+func (s *Store) SaveRateRule(rule RateRule) error {
+	rule, err := NormalizeRateRule(rule)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`
+		INSERT INTO rate_rules (client_id, name, conditions, action, services, amount)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			conditions = VALUES(conditions),
+			action = VALUES(action),
+			services = VALUES(services),
+			amount = VALUES(amount)
+	`, rule.ClientID, rule.Name, rule.Conditions, rule.Action, strings.Join(rule.Services, ","), rule.Amount)
+	return err
+}
+
+func (s *Store) DeleteRateRule(clientID int64, id int64) error {
+	_, err := s.DB.Exec(`DELETE FROM rate_rules WHERE client_id = ? AND id = ?`, clientID, id)
+	return err
+}
+
+// LoadRateRules returns the client's rules in the order they run.
+func (s *Store) LoadRateRules(clientID int64) ([]RateRule, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, client_id, name, COALESCE(conditions, ''), action, services, amount, updated_at
+		FROM rate_rules
+		WHERE client_id = ?
+		ORDER BY id
+	`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []RateRule{}
+	for rows.Next() {
+		var rule RateRule
+		var services string
+		if err := rows.Scan(
+			&rule.ID,
+			&rule.ClientID,
+			&rule.Name,
+			&rule.Conditions,
+			&rule.Action,
+			&services,
+			&rule.Amount,
+			&rule.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		rule.Services = splitCatalogList(services)
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// LabelCharges is what Canada Post billed for a shipment, in CAD cents.
+// BilledAt is zero until the receipt or price has been fetched.
+type LabelCharges struct {
+	BaseCents    int64
+	TaxesCents   int64
+	OptionsCents int64
+	DueCents     int64
+	BilledAt     time.Time
+}
+
+// ChargeReconciliation compares the quoted shipping charges of a client's
+// labels with what Canada Post billed.
+type ChargeReconciliation struct {
+	LabelCount    int
+	BilledCount   int
+	QuotedCents   int64 // quoted total of the billed labels
+	BilledCents   int64
+	Discrepancies []LabelRecord
+}
+
+// MissingCount is the number of labels without a fetched receipt or price.
+func (r ChargeReconciliation) MissingCount() int {
+	return r.LabelCount - r.BilledCount
+}
+
+func (r ChargeReconciliation) DifferenceCents() int64 {
+	return r.BilledCents - r.QuotedCents
+}
+
+func nullTime(value time.Time) sql.NullTime {
+	if value.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: value, Valid: true}
+}
+
+// LoadChargeReconciliation summarises the client's outbound labels created
+// between fromDate and toDate (YYYY-MM-DD, both optional) and returns up to
+// limit labels whose billed amount differs from the quote, largest difference
+// first. Return labels have no quote and are left out, as are voided and
+// refunded labels, which are not charged.
+func (s *Store) LoadChargeReconciliation(clientID int64, fromDate string, toDate string, limit int) (ChargeReconciliation, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	clauses := []string{"client_id = ?", "return_of = ''", "manifest_status <> ?", "refunded_at IS NULL"}
+	args := []any{clientID, ManifestStatusVoided}
+	if strings.TrimSpace(fromDate) != "" {
+		clauses = append(clauses, "created_at >= ?")
+		args = append(args, fromDate+" 00:00:00")
+	}
+	if strings.TrimSpace(toDate) != "" {
+		clauses = append(clauses, "created_at <= ?")
+		args = append(args, toDate+" 23:59:59")
+	}
+	where := " WHERE " + strings.Join(clauses, " AND ")
+
+	var report ChargeReconciliation
+	var billedCount sql.NullInt64
+	var quoted, billed sql.NullInt64
+	if err := s.DB.QueryRow(`
+		SELECT COUNT(*),
+			SUM(CASE WHEN billed_at IS NOT NULL THEN 1 ELSE 0 END),
+			SUM(CASE WHEN billed_at IS NOT NULL THEN shipping_charges_cents ELSE 0 END),
+			SUM(CASE WHEN billed_at IS NOT NULL THEN billed_due_cents ELSE 0 END)
+		FROM label_records`+where, args...).Scan(&report.LabelCount, &billedCount, &quoted, &billed); err != nil {
+		return ChargeReconciliation{}, err
+	}
+	report.BilledCount = int(billedCount.Int64)
+	report.QuotedCents = quoted.Int64
+	report.BilledCents = billed.Int64
+
+	rows, err := s.DB.Query(`
+		SELECT `+labelRecordColumns+`
+		FROM label_records`+where+` AND billed_at IS NOT NULL AND billed_due_cents <> shipping_charges_cents
+		ORDER BY ABS(billed_due_cents - shipping_charges_cents) DESC, created_at DESC
+		LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		return ChargeReconciliation{}, err
+	}
+	defer rows.Close()
+
+	report.Discrepancies = []LabelRecord{}
+	for rows.Next() {
+		rec, err := scanLabelRecord(rows)
+		if err != nil {
+			return ChargeReconciliation{}, err
+		}
+		report.Discrepancies = append(report.Discrepancies, rec)
+	}
+	return report, rows.Err()
+}
+
+// LoadUnbilledLabelRecords returns up to limit labels created since the given
+// time whose charges were never fetched but can be, least tried first. Labels
+// already tried maxAttempts times are left out, as are voided and refunded
+// labels.
+func (s *Store) LoadUnbilledLabelRecords(since time.Time, maxAttempts int, limit int) ([]LabelRecord, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := s.DB.Query(`
+		SELECT `+labelRecordColumns+`
+		FROM label_records
+		WHERE billed_at IS NULL AND charges_link <> '' AND manifest_status <> ? AND refunded_at IS NULL
+			AND created_at >= ? AND charges_attempts < ?
+		ORDER BY charges_attempts, charges_attempted_at, created_at
+		LIMIT ?
+	`, ManifestStatusVoided, since, maxAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []LabelRecord{}
+	for rows.Next() {
+		rec, err := scanLabelRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// RecordLabelChargesAttempt records a failed attempt to fetch the label's
+// charges.
+func (s *Store) RecordLabelChargesAttempt(labelID string) error {
+	_, err := s.DB.Exec(`
+		UPDATE label_records
+		SET charges_attempts = charges_attempts + 1, charges_attempted_at = UTC_TIMESTAMP()
+		WHERE id = ?
+	`, labelID)
+	return err
+}
+
+// SaveLabelCharges stores the charges fetched for a label after it was saved.
+func (s *Store) SaveLabelCharges(labelID string, charges LabelCharges) error {
+	_, err := s.DB.Exec(`
+		UPDATE label_records
+		SET billed_base_cents = ?, billed_taxes_cents = ?, billed_options_cents = ?, billed_due_cents = ?, billed_at = ?
+		WHERE id = ?
+	`, charges.BaseCents, charges.TaxesCents, charges.OptionsCents, charges.DueCents, nullTime(charges.BilledAt), labelID)
+	return err
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// LabelAddress is a party of a created shipment, kept on the label record so
+// return labels can be issued after the rate snapshot has expired.
+type LabelAddress struct {
+	Name         string `json:"name"`
+	Company      string `json:"company,omitempty"`
+	Phone        string `json:"phone,omitempty"`
+	Email        string `json:"email,omitempty"`
+	AddressLine1 string `json:"address_line_1"`
+	AddressLine2 string `json:"address_line_2,omitempty"`
+	City         string `json:"city"`
+	Province     string `json:"province"`
+	PostalCode   string `json:"postal_code"`
+	CountryCode  string `json:"country_code"`
+}
+
+func (a LabelAddress) IsZero() bool {
+	return strings.TrimSpace(a.AddressLine1) == "" && strings.TrimSpace(a.PostalCode) == ""
+}
+
+func encodeLabelAddress(address LabelAddress) sql.NullString {
+	if address.IsZero() {
+		return sql.NullString{}
+	}
+	raw, err := json.Marshal(address)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(raw), Valid: true}
+}
+
+func decodeLabelAddress(raw sql.NullString) LabelAddress {
+	var address LabelAddress
+	if !raw.Valid || strings.TrimSpace(raw.String) == "" {
+		return address
+	}
+	if err := json.Unmarshal([]byte(raw.String), &address); err != nil {
+		log.Println("failed to parse label address:", err)
+		return LabelAddress{}
+	}
+	return address
+}
+
+// LoadReturnLabelIDs maps original label IDs to the return labels issued for
+// them.
+func (s *Store) LoadReturnLabelIDs(labelIDs []string) (map[string]string, error) {
+	result := map[string]string{}
+	if len(labelIDs) == 0 {
+		return result, nil
+	}
+	placeholders := make([]string, 0, len(labelIDs))
+	args := make([]any, 0, len(labelIDs))
+	for _, id := range labelIDs {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+	rows, err := s.DB.Query(`
+		SELECT return_of, id
+		FROM label_records
+		WHERE return_of IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY created_at
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var originalID, returnID string
+		if err := rows.Scan(&originalID, &returnID); err != nil {
+			return nil, err
+		}
+		result[originalID] = returnID
+	}
+	return result, rows.Err()
+}
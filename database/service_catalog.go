@@ -0,0 +1,160 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// CatalogService is a Canada Post service as discovered through the Discover
+// Services and Get Service endpoints.
+type CatalogService struct {
+	Code                string
+	Name                string
+	Options             []string
+	MandatoryOptions    []string
+	MinWeightGrams      int
+	MaxWeightGrams      int
+	AllowedAsReturn     bool
+	ClientVoiceRequired bool
+	Active              bool
+	RefreshedAt         time.Time
+}
+
+func (s *Store) ensureServiceCatalogTable() error {
+	_, err := s.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS service_catalog (
+			service_code VARCHAR(32) PRIMARY KEY,
+			service_name VARCHAR(255) NOT NULL DEFAULT '',
+			options TEXT,
+			mandatory_options TEXT,
+			min_weight_grams INT NOT NULL DEFAULT 0,
+			max_weight_grams INT NOT NULL DEFAULT 0,
+			allowed_as_return TINYINT(1) NOT NULL DEFAULT 0,
+			client_voice_required TINYINT(1) NOT NULL DEFAULT 0,
+			active TINYINT(1) NOT NULL DEFAULT 1,
+			refreshed_at DATETIME NULL
+		)
+	`)
+	return err
+}
+
+// SaveCatalogServices upserts the discovered services and marks every service
+// missing from the list as retired. client_voice_required is only set when a
+// service is first seen so it can be corrected in the table.
+func (s *Store) SaveCatalogServices(services []CatalogService) error {
+	if len(services) == 0 {
+		return nil
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`
+		INSERT INTO service_catalog (
+			service_code, service_name, options, mandatory_options, min_weight_grams,
+			max_weight_grams, allowed_as_return, client_voice_required, active, refreshed_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1, ?)
+		ON DUPLICATE KEY UPDATE
+			service_name = VALUES(service_name),
+			options = VALUES(options),
+			mandatory_options = VALUES(mandatory_options),
+			min_weight_grams = VALUES(min_weight_grams),
+			max_weight_grams = VALUES(max_weight_grams),
+			allowed_as_return = VALUES(allowed_as_return),
+			active = 1,
+			refreshed_at = VALUES(refreshed_at)
+	`)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	codes := make([]any, 0, len(services))
+	for _, svc := range services {
+		code := strings.ToUpper(strings.TrimSpace(svc.Code))
+		if code == "" {
+			continue
+		}
+		if _, err := stmt.Exec(
+			code,
+			strings.TrimSpace(svc.Name),
+			strings.Join(svc.Options, ","),
+			strings.Join(svc.MandatoryOptions, ","),
+			svc.MinWeightGrams,
+			svc.MaxWeightGrams,
+			svc.AllowedAsReturn,
+			svc.ClientVoiceRequired,
+			now,
+		); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		codes = append(codes, code)
+	}
+	if len(codes) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(codes)), ",")
+		if _, err := tx.Exec(`UPDATE service_catalog SET active = 0 WHERE service_code NOT IN (`+placeholders+`)`, codes...); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadCatalogServices returns every known service, retired ones included,
+// ordered by service code.
+func (s *Store) LoadCatalogServices() ([]CatalogService, error) {
+	rows, err := s.DB.Query(`
+		SELECT service_code, service_name, COALESCE(options, ''), COALESCE(mandatory_options, ''),
+			min_weight_grams, max_weight_grams, allowed_as_return, client_voice_required, active, refreshed_at
+		FROM service_catalog
+		ORDER BY service_code
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	services := []CatalogService{}
+	for rows.Next() {
+		var svc CatalogService
+		var options, mandatory string
+		var refreshedAt sql.NullTime
+		if err := rows.Scan(
+			&svc.Code,
+			&svc.Name,
+			&options,
+			&mandatory,
+			&svc.MinWeightGrams,
+			&svc.MaxWeightGrams,
+			&svc.AllowedAsReturn,
+			&svc.ClientVoiceRequired,
+			&svc.Active,
+			&refreshedAt,
+		); err != nil {
+			return nil, err
+		}
+		svc.Options = splitCatalogList(options)
+		svc.MandatoryOptions = splitCatalogList(mandatory)
+		if refreshedAt.Valid {
+			svc.RefreshedAt = refreshedAt.Time
+		}
+		services = append(services, svc)
+	}
+	return services, rows.Err()
+}
+
+func splitCatalogList(value string) []string {
+	parts := strings.Split(value, ",")
+	out := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
@@ -61,6 +62,36 @@ func (s *Store) ensureTables() error {
 	if err := s.ensureClientPostOfficesTable(); err != nil {
 		return err
 	}
+	if err := s.ensureTrackingEventsTable(); err != nil {
+		return err
+	}
+	if err := s.ensureClientPickupsTable(); err != nil {
+		return err
+	}
+	if err := s.ensureServiceCatalogTable(); err != nil {
+		return err
+	}
+	if err := s.ensureServiceOptionRulesTable(); err != nil {
+		return err
+	}
+	if err := s.ensurePackagingBoxesTable(); err != nil {
+		return err
+	}
+	if err := s.ensureRateAdjustmentsTable(); err != nil {
+		return err
+	}
+	if err := s.ensureRateRulesTable(); err != nil {
+		return err
+	}
+	if err := s.ensureFallbackRatesTable(); err != nil {
+		return err
+	}
+	if err := s.ensureExchangeRatesTable(); err != nil {
+		return err
+	}
+	if err := s.ensureManifestLinksTable(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -93,6 +124,16 @@ func (s *Store) ensureShippingSettingsTable() error {
 			account_number VARCHAR(255) NOT NULL,
 			enabled_services TEXT NOT NULL,
 			default_postal_code VARCHAR(10) NOT NULL DEFAULT '',
+			contract_id VARCHAR(32) NOT NULL DEFAULT '',
+			payment_method VARCHAR(32) NOT NULL DEFAULT '',
+			group_id VARCHAR(32) NOT NULL DEFAULT '',
+			manifest_address TEXT,
+			label_paper_size VARCHAR(16) NOT NULL DEFAULT '',
+			label_encoding VARCHAR(8) NOT NULL DEFAULT '',
+			label_output VARCHAR(16) NOT NULL DEFAULT '',
+			handling_days INT NOT NULL DEFAULT 0,
+			order_cutoff VARCHAR(5) NOT NULL DEFAULT '',
+			time_zone VARCHAR(64) NOT NULL DEFAULT '',
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
 		)
 	`)
@@ -109,13 +150,48 @@ func (s *Store) ensureCurrencyRatesTable() error {
 			client_id BIGINT NOT NULL,
 			currency_code VARCHAR(8) NOT NULL,
 			rate_to_cad DOUBLE NOT NULL,
+			override BOOLEAN NOT NULL DEFAULT FALSE,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 			UNIQUE KEY uniq_currency_rate (client_id, currency_code)
 		)
 	`)
+	if err != nil {
+		return err
+	}
+	exists, err := s.hasColumn("currency_rates", "override")
+	if err != nil || exists {
+		return err
+	}
+	if _, err := s.DB.Exec("ALTER TABLE currency_rates ADD COLUMN override BOOLEAN NOT NULL DEFAULT FALSE AFTER rate_to_cad"); err != nil {
+		return err
+	}
+	// Rates entered before overrides were opt-in were always used, so they
+	// stay overrides until the client switches back to the shared rate.
+	_, err = s.DB.Exec("UPDATE currency_rates SET override = TRUE")
 	return err
 }
 
+// hasColumn reports whether the table has the column. Without a selected
+// database it reports true, so that nothing is altered.
+func (s *Store) hasColumn(table string, column string) (bool, error) {
+	var dbName string
+	if err := s.DB.QueryRow(`SELECT DATABASE()`).Scan(&dbName); err != nil {
+		return false, err
+	}
+	if strings.TrimSpace(dbName) == "" {
+		return true, nil
+	}
+	var count int
+	if err := s.DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM information_schema.columns
+		WHERE table_schema = ? AND table_name = ? AND column_name = ?
+	`, dbName, table, column).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 func (s *Store) ensureLabelRecordsTable() error {
 	_, err := s.DB.Exec(`
 		CREATE TABLE IF NOT EXISTS label_records (
@@ -132,6 +208,34 @@ func (s *Store) ensureLabelRecordsTable() error {
 			delivery_days INT NOT NULL DEFAULT 0,
 			refund_link TEXT,
 			weight DOUBLE NOT NULL,
+			client_id BIGINT NOT NULL DEFAULT 0,
+			tracking_status VARCHAR(32) NOT NULL DEFAULT '',
+			tracking_status_reported VARCHAR(32) NOT NULL DEFAULT '',
+			tracking_checked_at DATETIME NULL,
+			group_id VARCHAR(32) NOT NULL DEFAULT '',
+			manifest_status VARCHAR(32) NOT NULL DEFAULT '',
+			manifest_id VARCHAR(64) NOT NULL DEFAULT '',
+			transmitted_at DATETIME NULL,
+			sender_address TEXT,
+			destination_address TEXT,
+			return_of VARCHAR(64) NOT NULL DEFAULT '',
+			billed_base_cents BIGINT NOT NULL DEFAULT 0,
+			billed_taxes_cents BIGINT NOT NULL DEFAULT 0,
+			billed_options_cents BIGINT NOT NULL DEFAULT 0,
+			billed_due_cents BIGINT NOT NULL DEFAULT 0,
+			billed_at DATETIME NULL,
+			artifacts TEXT,
+			label_encoding VARCHAR(8) NOT NULL DEFAULT '',
+			piece_of VARCHAR(64) NOT NULL DEFAULT '',
+			customer_price_cents BIGINT NOT NULL DEFAULT 0,
+			currency_code VARCHAR(8) NOT NULL DEFAULT '',
+			rate_to_cad DOUBLE NOT NULL DEFAULT 0,
+			fx_rate_id BIGINT NOT NULL DEFAULT 0,
+			shipment_link TEXT,
+			charges_link TEXT,
+			charges_attempts INT NOT NULL DEFAULT 0,
+			charges_attempted_at DATETIME NULL,
+			refunded_at DATETIME NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)
 	`)
@@ -184,6 +288,34 @@ func (s *Store) ensureLabelRecordColumns() error {
 		{name: "delivery_date", def: "delivery_date VARCHAR(32) NOT NULL DEFAULT ''"},
 		{name: "delivery_days", def: "delivery_days INT NOT NULL DEFAULT 0"},
 		{name: "refund_link", def: "refund_link TEXT"},
+		{name: "client_id", def: "client_id BIGINT NOT NULL DEFAULT 0"},
+		{name: "tracking_status", def: "tracking_status VARCHAR(32) NOT NULL DEFAULT ''"},
+		{name: "tracking_status_reported", def: "tracking_status_reported VARCHAR(32) NOT NULL DEFAULT ''"},
+		{name: "tracking_checked_at", def: "tracking_checked_at DATETIME NULL"},
+		{name: "group_id", def: "group_id VARCHAR(32) NOT NULL DEFAULT ''"},
+		{name: "manifest_status", def: "manifest_status VARCHAR(32) NOT NULL DEFAULT ''"},
+		{name: "manifest_id", def: "manifest_id VARCHAR(64) NOT NULL DEFAULT ''"},
+		{name: "transmitted_at", def: "transmitted_at DATETIME NULL"},
+		{name: "sender_address", def: "sender_address TEXT"},
+		{name: "destination_address", def: "destination_address TEXT"},
+		{name: "return_of", def: "return_of VARCHAR(64) NOT NULL DEFAULT ''"},
+		{name: "billed_base_cents", def: "billed_base_cents BIGINT NOT NULL DEFAULT 0"},
+		{name: "billed_taxes_cents", def: "billed_taxes_cents BIGINT NOT NULL DEFAULT 0"},
+		{name: "billed_options_cents", def: "billed_options_cents BIGINT NOT NULL DEFAULT 0"},
+		{name: "billed_due_cents", def: "billed_due_cents BIGINT NOT NULL DEFAULT 0"},
+		{name: "billed_at", def: "billed_at DATETIME NULL"},
+		{name: "artifacts", def: "artifacts TEXT"},
+		{name: "label_encoding", def: "label_encoding VARCHAR(8) NOT NULL DEFAULT ''"},
+		{name: "piece_of", def: "piece_of VARCHAR(64) NOT NULL DEFAULT ''"},
+		{name: "customer_price_cents", def: "customer_price_cents BIGINT NOT NULL DEFAULT 0"},
+		{name: "currency_code", def: "currency_code VARCHAR(8) NOT NULL DEFAULT ''"},
+		{name: "rate_to_cad", def: "rate_to_cad DOUBLE NOT NULL DEFAULT 0"},
+		{name: "fx_rate_id", def: "fx_rate_id BIGINT NOT NULL DEFAULT 0"},
+		{name: "shipment_link", def: "shipment_link TEXT"},
+		{name: "charges_link", def: "charges_link TEXT"},
+		{name: "charges_attempts", def: "charges_attempts INT NOT NULL DEFAULT 0"},
+		{name: "charges_attempted_at", def: "charges_attempted_at DATETIME NULL"},
+		{name: "refunded_at", def: "refunded_at DATETIME NULL"},
 	}
 
 	for _, col := range columns {
@@ -259,6 +391,16 @@ func (s *Store) ensureShippingSettingsColumns() error {
 		def  string
 	}{
 		{name: "default_postal_code", def: "default_postal_code VARCHAR(10) NOT NULL DEFAULT ''"},
+		{name: "contract_id", def: "contract_id VARCHAR(32) NOT NULL DEFAULT ''"},
+		{name: "payment_method", def: "payment_method VARCHAR(32) NOT NULL DEFAULT ''"},
+		{name: "group_id", def: "group_id VARCHAR(32) NOT NULL DEFAULT ''"},
+		{name: "manifest_address", def: "manifest_address TEXT"},
+		{name: "label_paper_size", def: "label_paper_size VARCHAR(16) NOT NULL DEFAULT ''"},
+		{name: "label_encoding", def: "label_encoding VARCHAR(8) NOT NULL DEFAULT ''"},
+		{name: "label_output", def: "label_output VARCHAR(16) NOT NULL DEFAULT ''"},
+		{name: "handling_days", def: "handling_days INT NOT NULL DEFAULT 0"},
+		{name: "order_cutoff", def: "order_cutoff VARCHAR(5) NOT NULL DEFAULT ''"},
+		{name: "time_zone", def: "time_zone VARCHAR(64) NOT NULL DEFAULT ''"},
 	}
 	for _, col := range columns {
 		if existing[col.name] {
@@ -343,9 +485,41 @@ type LabelRecord struct {
 	DeliveryDays         int
 	RefundLink           string
 	Weight               float64
+	ClientID             int64
+	TrackingStatus       string
+	ReportedStatus       string
+	GroupID              string // empty for non-contract shipments
+	ManifestStatus       string
+	ManifestID           string
+	Sender               LabelAddress
+	Destination          LabelAddress
+	ReturnOf             string // label ID of the original shipment for return labels
+	Billed               LabelCharges
+	Artifacts            []string  // stored artifact names, e.g. "label", "commercial-invoice"
+	LabelEncoding        string    // PDF or ZPL; empty for labels stored before print preferences
+	PieceOf              string    // label ID of the first piece, for the other pieces of a multi-piece shipment
+	CustomerPriceCents   int64     // what the customer was charged in CAD after rate adjustments; 0 before them
+	CurrencyCode         string    // the store currency the rate was quoted in
+	RateToCad            float64   // the exchange rate the quote was converted with; 1 for CAD
+	FXRateID             int64     // the exchange_rates version of RateToCad; 0 for CAD and older labels
+	ShipmentLink         string    // self link of a contract shipment, used to void it before transmit
+	ChargesLink          string    // receipt or price link the billed charges are fetched from
+	RefundedAt           time.Time // when a non-contract refund was requested; zero if never
 	CreatedAt            time.Time
 }
 
+// OwnedBy reports whether the label belongs to the client. Labels saved
+// before client_id was recorded have no owner and are accepted for any
+// signed-in client, since nothing recorded then maps them to one.
+func (r LabelRecord) OwnedBy(clientID int64) bool {
+	return r.ClientID == 0 || r.ClientID == clientID
+}
+
+// MarginCents is the customer price less the quoted carrier cost.
+func (r LabelRecord) MarginCents() int64 {
+	return r.CustomerPriceCents - r.ShippingChargesCents
+}
+
 func (s *Store) SaveLabelRecord(record LabelRecord) error {
 	_, err := s.DB.Exec(`
 		INSERT INTO label_records (
@@ -361,9 +535,28 @@ func (s *Store) SaveLabelRecord(record LabelRecord) error {
 			delivery_date,
 			delivery_days,
 			refund_link,
-			weight
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, record.ID, record.ShipmentID, record.TrackingNumber, record.InvoiceUUID, record.RateID, record.Carrier, record.ServiceCode, record.ServiceName, record.ShippingChargesCents, record.DeliveryDate, record.DeliveryDays, record.RefundLink, record.Weight)
+			weight,
+			client_id,
+			group_id,
+			sender_address,
+			destination_address,
+			return_of,
+			billed_base_cents,
+			billed_taxes_cents,
+			billed_options_cents,
+			billed_due_cents,
+			billed_at,
+			artifacts,
+			label_encoding,
+			piece_of,
+			customer_price_cents,
+			currency_code,
+			rate_to_cad,
+			fx_rate_id,
+			shipment_link,
+			charges_link
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, record.ID, record.ShipmentID, record.TrackingNumber, record.InvoiceUUID, record.RateID, record.Carrier, record.ServiceCode, record.ServiceName, record.ShippingChargesCents, record.DeliveryDate, record.DeliveryDays, record.RefundLink, record.Weight, record.ClientID, record.GroupID, encodeLabelAddress(record.Sender), encodeLabelAddress(record.Destination), record.ReturnOf, record.Billed.BaseCents, record.Billed.TaxesCents, record.Billed.OptionsCents, record.Billed.DueCents, nullTime(record.Billed.BilledAt), strings.Join(record.Artifacts, ","), record.LabelEncoding, record.PieceOf, record.CustomerPriceCents, record.CurrencyCode, record.RateToCad, record.FXRateID, record.ShipmentLink, record.ChargesLink)
 	return err
 }
 
@@ -380,7 +573,7 @@ func (s *Store) LoadLabelRecordsPage(fromDate string, toDate string, limit int,
 		offset = 0
 	}
 	query := `
-		SELECT id, shipment_id, tracking_number, invoice_uuid, rate_id, carrier, service_code, service_name, shipping_charges_cents, delivery_date, delivery_days, refund_link, weight, created_at
+		SELECT ` + labelRecordColumns + `
 		FROM label_records
 	`
 	args := []any{}
@@ -408,29 +601,10 @@ func (s *Store) LoadLabelRecordsPage(fromDate string, toDate string, limit int,
 
 	records := []LabelRecord{}
 	for rows.Next() {
-		var rec LabelRecord
-		var refundLink sql.NullString
-		if err := rows.Scan(
-			&rec.ID,
-			&rec.ShipmentID,
-			&rec.TrackingNumber,
-			&rec.InvoiceUUID,
-			&rec.RateID,
-			&rec.Carrier,
-			&rec.ServiceCode,
-			&rec.ServiceName,
-			&rec.ShippingChargesCents,
-			&rec.DeliveryDate,
-			&rec.DeliveryDays,
-			&refundLink,
-			&rec.Weight,
-			&rec.CreatedAt,
-		); err != nil {
+		rec, err := scanLabelRecord(rows)
+		if err != nil {
 			return nil, false, err
 		}
-		if refundLink.Valid {
-			rec.RefundLink = refundLink.String
-		}
 		records = append(records, rec)
 	}
 	if err := rows.Err(); err != nil {
@@ -505,14 +679,76 @@ func (s *Store) LoadLabelRecordByLabelID(labelID string) (LabelRecord, error) {
 	if labelID == "" {
 		return LabelRecord{}, nil
 	}
-	var rec LabelRecord
-	var refundLink sql.NullString
-	err := s.DB.QueryRow(`
-		SELECT id, shipment_id, tracking_number, invoice_uuid, rate_id, carrier, service_code, service_name, shipping_charges_cents, delivery_date, delivery_days, refund_link, weight, created_at
+	rec, err := scanLabelRecord(s.DB.QueryRow(`
+		SELECT `+labelRecordColumns+`
 		FROM label_records
 		WHERE id = ?
 		LIMIT 1
-	`, labelID).Scan(
+	`, labelID))
+	if err == sql.ErrNoRows {
+		return LabelRecord{}, nil
+	}
+	return rec, err
+}
+
+func (s *Store) LoadLabelRecordByTrackingNumber(trackingNumber string) (LabelRecord, error) {
+	trackingNumber = strings.TrimSpace(trackingNumber)
+	if trackingNumber == "" {
+		return LabelRecord{}, nil
+	}
+	rec, err := scanLabelRecord(s.DB.QueryRow(`
+		SELECT `+labelRecordColumns+`
+		FROM label_records
+		WHERE tracking_number = ?
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, trackingNumber))
+	if err == sql.ErrNoRows {
+		return LabelRecord{}, nil
+	}
+	return rec, err
+}
+
+// LoadLabelPieces returns the other pieces of the multi-piece shipment whose
+// first piece is labelID, in piece order.
+func (s *Store) LoadLabelPieces(labelID string) ([]LabelRecord, error) {
+	labelID = strings.TrimSpace(labelID)
+	if labelID == "" {
+		return nil, nil
+	}
+	rows, err := s.DB.Query(`
+		SELECT `+labelRecordColumns+`
+		FROM label_records
+		WHERE piece_of = ?
+		ORDER BY created_at, id
+	`, labelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []LabelRecord{}
+	for rows.Next() {
+		rec, err := scanLabelRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+const labelRecordColumns = "id, shipment_id, tracking_number, invoice_uuid, rate_id, carrier, service_code, service_name, shipping_charges_cents, delivery_date, delivery_days, refund_link, weight, client_id, tracking_status, tracking_status_reported, group_id, manifest_status, manifest_id, sender_address, destination_address, return_of, billed_base_cents, billed_taxes_cents, billed_options_cents, billed_due_cents, billed_at, artifacts, label_encoding, piece_of, customer_price_cents, currency_code, rate_to_cad, fx_rate_id, shipment_link, charges_link, refunded_at, created_at"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanLabelRecord(row rowScanner) (LabelRecord, error) {
+	var rec LabelRecord
+	var refundLink, senderAddress, destinationAddress, artifacts, shipmentLink, chargesLink sql.NullString
+	var billedAt, refundedAt sql.NullTime
+	if err := row.Scan(
 		&rec.ID,
 		&rec.ShipmentID,
 		&rec.TrackingNumber,
@@ -526,27 +762,65 @@ func (s *Store) LoadLabelRecordByLabelID(labelID string) (LabelRecord, error) {
 		&rec.DeliveryDays,
 		&refundLink,
 		&rec.Weight,
+		&rec.ClientID,
+		&rec.TrackingStatus,
+		&rec.ReportedStatus,
+		&rec.GroupID,
+		&rec.ManifestStatus,
+		&rec.ManifestID,
+		&senderAddress,
+		&destinationAddress,
+		&rec.ReturnOf,
+		&rec.Billed.BaseCents,
+		&rec.Billed.TaxesCents,
+		&rec.Billed.OptionsCents,
+		&rec.Billed.DueCents,
+		&billedAt,
+		&artifacts,
+		&rec.LabelEncoding,
+		&rec.PieceOf,
+		&rec.CustomerPriceCents,
+		&rec.CurrencyCode,
+		&rec.RateToCad,
+		&rec.FXRateID,
+		&shipmentLink,
+		&chargesLink,
+		&refundedAt,
 		&rec.CreatedAt,
-	)
-	if err == sql.ErrNoRows {
-		return LabelRecord{}, nil
+	); err != nil {
+		return LabelRecord{}, err
 	}
 	if refundLink.Valid {
 		rec.RefundLink = refundLink.String
 	}
-	return rec, err
+	rec.Sender = decodeLabelAddress(senderAddress)
+	rec.Destination = decodeLabelAddress(destinationAddress)
+	if billedAt.Valid {
+		rec.Billed.BilledAt = billedAt.Time
+	}
+	rec.Artifacts = splitCatalogList(artifacts.String)
+	rec.ShipmentLink = shipmentLink.String
+	rec.ChargesLink = chargesLink.String
+	if refundedAt.Valid {
+		rec.RefundedAt = refundedAt.Time
+	}
+	return rec, nil
 }
 
 type ShippingSettings struct {
 	AccountNumber     string
 	EnabledServices   map[string]bool
 	DefaultPostalCode string
-}
-
-type CurrencyRate struct {
-	CurrencyCode string
-	RateToCad    float64
-	UpdatedAt    time.Time
+	ContractID        string
+	PaymentMethod     string
+	GroupID           string
+	ManifestAddress   ManifestAddress
+	LabelPaperSize    string
+	LabelEncoding     string
+	LabelOutput       string
+	HandlingDays      int
+	OrderCutoff       string
+	TimeZone          string
 }
 
 func (s *Store) SaveShippingSettings(clientID int64, accountNumber string, enabledServices []string) error {
@@ -562,11 +836,14 @@ func (s *Store) SaveShippingSettings(clientID int64, accountNumber string, enabl
 func (s *Store) LoadShippingSettings(clientID int64) (ShippingSettings, error) {
 	var settings ShippingSettings
 	var services string
+	var manifestAddress sql.NullString
 	err := s.DB.QueryRow(`
-		SELECT account_number, enabled_services, default_postal_code
+		SELECT account_number, enabled_services, default_postal_code, contract_id, payment_method, group_id, manifest_address,
+			label_paper_size, label_encoding, label_output, handling_days, order_cutoff, time_zone
 		FROM shipping_settings
 		WHERE client_id = ?
-	`, clientID).Scan(&settings.AccountNumber, &services, &settings.DefaultPostalCode)
+	`, clientID).Scan(&settings.AccountNumber, &services, &settings.DefaultPostalCode, &settings.ContractID, &settings.PaymentMethod, &settings.GroupID, &manifestAddress,
+		&settings.LabelPaperSize, &settings.LabelEncoding, &settings.LabelOutput, &settings.HandlingDays, &settings.OrderCutoff, &settings.TimeZone)
 	if err == sql.ErrNoRows {
 		return ShippingSettings{}, nil
 	}
@@ -574,9 +851,26 @@ func (s *Store) LoadShippingSettings(clientID int64) (ShippingSettings, error) {
 		return ShippingSettings{}, err
 	}
 	settings.EnabledServices = parseEnabledServices(services)
+	if manifestAddress.Valid && strings.TrimSpace(manifestAddress.String) != "" {
+		if err := json.Unmarshal([]byte(manifestAddress.String), &settings.ManifestAddress); err != nil {
+			log.Printf("invalid manifest address for client %d: %v", clientID, err)
+		}
+	}
 	return settings, nil
 }
 
+func (s *Store) SaveContractSettings(clientID int64, contractID, paymentMethod, groupID string) error {
+	_, err := s.DB.Exec(`
+		INSERT INTO shipping_settings (client_id, account_number, enabled_services, contract_id, payment_method, group_id)
+		VALUES (?, '', '', ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			contract_id = VALUES(contract_id),
+			payment_method = VALUES(payment_method),
+			group_id = VALUES(group_id)
+	`, clientID, strings.TrimSpace(contractID), strings.TrimSpace(paymentMethod), strings.TrimSpace(groupID))
+	return err
+}
+
 func (s *Store) SaveDefaultPostalCode(clientID int64, postalCode string) error {
 	postalCode = strings.ToUpper(strings.TrimSpace(postalCode))
 	_, err := s.DB.Exec(`
@@ -587,7 +881,10 @@ func (s *Store) SaveDefaultPostalCode(clientID int64, postalCode string) error {
 	return err
 }
 
-func (s *Store) SaveCurrencyRate(clientID int64, currencyCode string, rateToCad float64) error {
+// SaveCurrencyRate adds the client's own rate for the currency, entered in
+// settings, effective today. With override it is used in place of the
+// shared rate.
+func (s *Store) SaveCurrencyRate(clientID int64, currencyCode string, rateToCad float64, override bool) error {
 	code := strings.ToUpper(strings.TrimSpace(currencyCode))
 	if code == "" {
 		return fmt.Errorf("currency code is required")
@@ -595,58 +892,11 @@ func (s *Store) SaveCurrencyRate(clientID int64, currencyCode string, rateToCad
 	if rateToCad <= 0 {
 		return fmt.Errorf("rate_to_cad must be greater than zero")
 	}
-	_, err := s.DB.Exec(`
-		INSERT INTO currency_rates (client_id, currency_code, rate_to_cad)
-		VALUES (?, ?, ?)
-		ON DUPLICATE KEY UPDATE rate_to_cad = VALUES(rate_to_cad)
-	`, clientID, code, rateToCad)
-	return err
-}
-
-func (s *Store) LoadCurrencyRate(clientID int64, currencyCode string) (float64, bool, error) {
-	code := strings.ToUpper(strings.TrimSpace(currencyCode))
-	if code == "" {
-		return 0, false, nil
-	}
-	var rate float64
-	err := s.DB.QueryRow(`
-		SELECT rate_to_cad
-		FROM currency_rates
-		WHERE client_id = ? AND currency_code = ?
-	`, clientID, code).Scan(&rate)
-	if err == sql.ErrNoRows {
-		return 0, false, nil
-	}
-	if err != nil {
-		return 0, false, err
-	}
-	return rate, true, nil
-}
-
-func (s *Store) LoadCurrencyRates(clientID int64) ([]CurrencyRate, error) {
-	rows, err := s.DB.Query(`
-		SELECT currency_code, rate_to_cad, updated_at
-		FROM currency_rates
-		WHERE client_id = ?
-		ORDER BY currency_code
-	`, clientID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var rates []CurrencyRate
-	for rows.Next() {
-		var rate CurrencyRate
-		if err := rows.Scan(&rate.CurrencyCode, &rate.RateToCad, &rate.UpdatedAt); err != nil {
-			return nil, err
-		}
-		rates = append(rates, rate)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return rates, nil
+	return s.SaveClientExchangeRates(clientID, []ExchangeRate{{
+		CurrencyCode: code,
+		RateToCad:    rateToCad,
+		Source:       FXSourceManual,
+	}}, override)
 }
 
 func parseEnabledServices(value string) map[string]bool {
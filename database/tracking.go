@@ -0,0 +1,264 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type TrackingEvent struct {
+	TrackingNumber string
+	Identifier     string
+	Description    string
+	Site           string
+	Province       string
+	SignatoryName  string
+	EventDate      string
+	EventTime      string
+	EventTimeZone  string
+	OccurredAt     time.Time
+	CreatedAt      time.Time
+}
+
+func (s *Store) ensureTrackingEventsTable() error {
+	_, err := s.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS tracking_events (
+			id BIGINT PRIMARY KEY AUTO_INCREMENT,
+			tracking_number VARCHAR(64) NOT NULL,
+			event_identifier VARCHAR(16) NOT NULL DEFAULT '',
+			event_description VARCHAR(255) NOT NULL DEFAULT '',
+			event_site VARCHAR(100) NOT NULL DEFAULT '',
+			event_province VARCHAR(8) NOT NULL DEFAULT '',
+			signatory_name VARCHAR(100) NOT NULL DEFAULT '',
+			event_date VARCHAR(16) NOT NULL DEFAULT '',
+			event_time VARCHAR(16) NOT NULL DEFAULT '',
+			event_time_zone VARCHAR(8) NOT NULL DEFAULT '',
+			occurred_at DATETIME NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uniq_tracking_event (tracking_number, event_identifier, event_date, event_time),
+			KEY idx_tracking_number (tracking_number)
+		)
+	`)
+	return err
+}
+
+func (s *Store) SaveTrackingEvents(trackingNumber string, events []TrackingEvent) error {
+	trackingNumber = strings.TrimSpace(trackingNumber)
+	if trackingNumber == "" || len(events) == 0 {
+		return nil
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`
+		INSERT INTO tracking_events (
+			tracking_number, event_identifier, event_description, event_site, event_province,
+			signatory_name, event_date, event_time, event_time_zone, occurred_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			event_description = VALUES(event_description),
+			event_site = VALUES(event_site),
+			event_province = VALUES(event_province),
+			signatory_name = VALUES(signatory_name),
+			event_time_zone = VALUES(event_time_zone),
+			occurred_at = VALUES(occurred_at)
+	`)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, ev := range events {
+		var occurredAt any
+		if !ev.OccurredAt.IsZero() {
+			occurredAt = ev.OccurredAt.UTC()
+		}
+		if _, err := stmt.Exec(
+			trackingNumber,
+			ev.Identifier,
+			ev.Description,
+			ev.Site,
+			ev.Province,
+			ev.SignatoryName,
+			ev.EventDate,
+			ev.EventTime,
+			ev.EventTimeZone,
+			occurredAt,
+		); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadTrackingEvents returns the stored events for a PIN, newest first.
+func (s *Store) LoadTrackingEvents(trackingNumber string) ([]TrackingEvent, error) {
+	trackingNumber = strings.TrimSpace(trackingNumber)
+	if trackingNumber == "" {
+		return nil, nil
+	}
+	rows, err := s.DB.Query(`
+		SELECT `+trackingEventColumns+`
+		FROM tracking_events
+		WHERE tracking_number = ?
+		ORDER BY occurred_at DESC, id DESC
+	`, trackingNumber)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []TrackingEvent{}
+	for rows.Next() {
+		ev, err := scanTrackingEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// LoadLatestTrackingEvents returns the most recent stored event per PIN.
+func (s *Store) LoadLatestTrackingEvents(trackingNumbers []string) (map[string]TrackingEvent, error) {
+	result := map[string]TrackingEvent{}
+	args := make([]any, 0, len(trackingNumbers))
+	seen := map[string]bool{}
+	for _, pin := range trackingNumbers {
+		pin = strings.TrimSpace(pin)
+		if pin == "" || seen[pin] {
+			continue
+		}
+		seen[pin] = true
+		args = append(args, pin)
+	}
+	if len(args) == 0 {
+		return result, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(args)), ",")
+	rows, err := s.DB.Query(`
+		SELECT `+trackingEventColumns+`
+		FROM tracking_events
+		WHERE tracking_number IN (`+placeholders+`)
+		ORDER BY tracking_number, occurred_at DESC, id DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ev, err := scanTrackingEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := result[ev.TrackingNumber]; ok {
+			continue
+		}
+		result[ev.TrackingNumber] = ev
+	}
+	return result, rows.Err()
+}
+
+const trackingEventColumns = "tracking_number, event_identifier, event_description, event_site, event_province, signatory_name, event_date, event_time, event_time_zone, occurred_at, created_at"
+
+func scanTrackingEvent(row rowScanner) (TrackingEvent, error) {
+	var ev TrackingEvent
+	var occurredAt sql.NullTime
+	if err := row.Scan(
+		&ev.TrackingNumber,
+		&ev.Identifier,
+		&ev.Description,
+		&ev.Site,
+		&ev.Province,
+		&ev.SignatoryName,
+		&ev.EventDate,
+		&ev.EventTime,
+		&ev.EventTimeZone,
+		&occurredAt,
+		&ev.CreatedAt,
+	); err != nil {
+		return TrackingEvent{}, err
+	}
+	if occurredAt.Valid {
+		ev.OccurredAt = occurredAt.Time
+	}
+	return ev, nil
+}
+
+// LoadLabelsForTracking returns labels created since the given time whose
+// tracking status is not final yet and, with unreported, those whose latest
+// status has not been reported to orders. Return labels are skipped since their status does not
+// belong on the order, and so are the extra pieces of multi-piece shipments,
+// whose first piece stands for the order. Least recently checked labels come
+// first.
+func (s *Store) LoadLabelsForTracking(since time.Time, finalStatuses []string, unreported bool, limit int) ([]LabelRecord, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	args := []any{since.UTC()}
+	clauses := []string{}
+	if len(finalStatuses) > 0 {
+		clauses = append(clauses, "tracking_status NOT IN ("+strings.TrimSuffix(strings.Repeat("?,", len(finalStatuses)), ",")+")")
+		for _, status := range finalStatuses {
+			args = append(args, status)
+		}
+	}
+	if unreported {
+		clauses = append(clauses, "tracking_status <> tracking_status_reported")
+	}
+	statusClause := ""
+	if len(clauses) > 0 {
+		statusClause = "AND (" + strings.Join(clauses, " OR ") + ")"
+	}
+	args = append(args, limit)
+
+	rows, err := s.DB.Query(`
+		SELECT `+labelRecordColumns+`
+		FROM label_records
+		WHERE tracking_number <> ''
+			AND return_of = ''
+			AND piece_of = ''
+			AND created_at >= ?
+			`+statusClause+`
+		ORDER BY tracking_checked_at IS NOT NULL, tracking_checked_at ASC, created_at ASC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []LabelRecord{}
+	for rows.Next() {
+		rec, err := scanLabelRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func (s *Store) SaveLabelTrackingStatus(labelID string, status string) error {
+	_, err := s.DB.Exec(`
+		UPDATE label_records
+		SET tracking_status = ?, tracking_checked_at = UTC_TIMESTAMP()
+		WHERE id = ?
+	`, status, labelID)
+	return err
+}
+
+func (s *Store) MarkLabelTrackingStatusReported(labelID string, status string) error {
+	_, err := s.DB.Exec(`
+		UPDATE label_records
+		SET tracking_status_reported = ?
+		WHERE id = ?
+	`, status, labelID)
+	return err
+}
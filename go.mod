@@ -6,6 +6,7 @@ replace bitbucket.org/lexmodo/proto => /home/lexmodo2/Downloads/proto/lexmodo-pr
 
 require (
 	bitbucket.org/lexmodo/proto v0.0.0
+	github.com/DATA-DOG/go-sqlmock v1.5.2
 	github.com/go-sql-driver/mysql v1.9.3
 	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/golang-jwt/jwt/v5 v5.3.0
@@ -88,6 +88,8 @@ func (a *App) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/settings", a.settingsHandler)
 	mux.HandleFunc("/uninstall", a.HandleUninstall)
 	mux.HandleFunc("/labels/", a.labelHandler)
+	mux.HandleFunc("/tracking/", a.trackingHandler)
+	mux.HandleFunc("/manifests/", a.manifestHandler)
 	mux.Handle(
 		"/files/postage_label/",
 		http.StripPrefix(
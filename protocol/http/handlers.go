@@ -8,7 +8,6 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -169,19 +168,29 @@ func (a *App) labelHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	labelID := strings.TrimPrefix(r.URL.Path, "/labels/")
-	labelID = strings.TrimSpace(labelID)
-	labelID = strings.Trim(labelID, "/")
-	if labelID == "" || strings.Contains(labelID, "/") {
+	// /labels/{id}.pdf serves the label, /labels/{id}/{artifact}.pdf any other
+	// stored artifact such as the commercial invoice. Labels printed as ZPL
+	// are stored and served as .zpl; the extension may also be left out.
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/labels/"), "/"), "/")
+	if len(parts) > 2 {
 		http.NotFound(w, r)
 		return
 	}
-
-	labelID = path.Base(labelID)
-	labelID = strings.TrimSuffix(labelID, ".pdf")
-	labelID = strings.TrimSpace(labelID)
-	if labelID == "" || strings.Contains(labelID, "/") || strings.Contains(labelID, "\\") || strings.Contains(labelID, "..") {
-		http.NotFound(w, r)
+	extensions := []string{"pdf", "zpl"}
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if i == len(parts)-1 && strings.HasSuffix(part, ".zpl") {
+			extensions = []string{"zpl", "pdf"}
+		}
+		part = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(part, ".pdf"), ".zpl"))
+		if part == "" || strings.Contains(part, "\\") || strings.Contains(part, "..") {
+			http.NotFound(w, r)
+			return
+		}
+		parts[i] = part
+	}
+	labelID := parts[0]
+	if len(parts) == 2 && !a.authorizeLabelArtifact(w, r, labelID) {
 		return
 	}
 
@@ -190,17 +199,29 @@ func (a *App) labelHandler(w http.ResponseWriter, r *http.Request) {
 		storagePath = "files/labels"
 	}
 
-	filePath := filepath.Join(storagePath, labelID+".pdf")
-	info, err := os.Stat(filePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			http.NotFound(w, r)
+	var filePath, fileName, extension string
+	for _, ext := range extensions {
+		candidate := filepath.Join(storagePath, labelID+"."+ext)
+		name := labelID + "." + ext
+		if len(parts) == 2 {
+			candidate = filepath.Join(storagePath, labelID, parts[1]+"."+ext)
+			name = labelID + "-" + parts[1] + "." + ext
+		}
+		info, err := os.Stat(candidate)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			http.Error(w, "failed to read label", http.StatusInternalServerError)
 			return
 		}
-		http.Error(w, "failed to read label", http.StatusInternalServerError)
-		return
+		if info.IsDir() {
+			continue
+		}
+		filePath, fileName, extension = candidate, name, ext
+		break
 	}
-	if info.IsDir() {
+	if filePath == "" {
 		http.NotFound(w, r)
 		return
 	}
@@ -212,14 +233,76 @@ func (a *App) labelHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	w.Header().Set("Content-Type", "application/pdf")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s.pdf\"", labelID))
+	contentType := "application/pdf"
+	if extension == "zpl" {
+		contentType = "application/zpl"
+	}
+	disposition := "inline"
+	if a.labelOutput(labelID) == database.LabelOutputDownload {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, fileName))
 	w.WriteHeader(http.StatusOK)
 	if _, err := io.Copy(w, file); err != nil {
 		log.Println("failed to write label response:", err)
 	}
 }
 
+// authorizeLabelArtifact checks the label's token, which the artifact URLs
+// returned by CreateLabel carry, or else the session of the client that
+// created the label. Artifacts such as commercial invoices carry customs
+// details, so unlike the label itself they are only served to that client;
+// another client's label is reported as missing.
+func (a *App) authorizeLabelArtifact(w http.ResponseWriter, r *http.Request, labelID string) bool {
+	if token := strings.TrimSpace(r.URL.Query().Get("token")); token != "" {
+		if service.ValidLabelArtifactToken(a.Config.AppSecret, labelID, token, time.Now()) {
+			return true
+		}
+		http.Error(w, "invalid or expired label token", http.StatusUnauthorized)
+		return false
+	}
+	clientID := parseClientID(r.URL.Query().Get("client_id"))
+	sessionToken := strings.TrimSpace(r.URL.Query().Get("session_token"))
+	if clientID == 0 || sessionToken == "" {
+		http.Error(w, "token or client_id and session_token are required", http.StatusBadRequest)
+		return false
+	}
+	if !a.validateSessionToken(clientID, sessionToken) && !isValidJWTSessionForClient(clientID, sessionToken) {
+		http.Error(w, "invalid or expired session token", http.StatusUnauthorized)
+		return false
+	}
+	record, err := a.Store.LoadLabelRecordByLabelID(labelID)
+	if err != nil {
+		log.Println("failed to load label record:", err)
+		http.Error(w, "failed to load label", http.StatusInternalServerError)
+		return false
+	}
+	if record.ID == "" || !record.OwnedBy(clientID) {
+		http.NotFound(w, r)
+		return false
+	}
+	return true
+}
+
+// labelOutput is the label output preference of the client that created the
+// label, inline when the label or the client's settings can't be loaded.
+func (a *App) labelOutput(labelID string) string {
+	if a.Store == nil {
+		return database.LabelOutputInline
+	}
+	record, err := a.Store.LoadLabelRecordByLabelID(labelID)
+	if err != nil || record.ClientID == 0 {
+		return database.LabelOutputInline
+	}
+	settings, err := a.Store.LoadShippingSettings(record.ClientID)
+	if err != nil {
+		log.Printf("failed to load label preferences: label_id=%s err=%v", labelID, err)
+		return database.LabelOutputInline
+	}
+	return settings.LabelPreferences().Output
+}
+
 type serviceOption struct {
 	ID    string
 	Label string
@@ -230,29 +313,28 @@ type currencyOption struct {
 	Label string
 }
 
-var serviceOptions = []serviceOption{
-	// Domestic (Canada)
-	{ID: "DOM.RP", Label: "Regular Parcel (Domestic)"},
-	{ID: "DOM.EP", Label: "Expedited Parcel (Domestic)"},
-	{ID: "DOM.XP", Label: "Xpresspost (Domestic)"},
-	{ID: "DOM.XP.CERT", Label: "Xpresspost Certified (Domestic)"},
-	{ID: "DOM.PC", Label: "Priority (Domestic)"},
-	{ID: "DOM.LIB", Label: "Library Materials (Domestic)"},
-
-	// USA
-	{ID: "USA.EP", Label: "Expedited Parcel USA"},
-	{ID: "USA.SP.AIR", Label: "Small Packet USA Air"},
-	{ID: "USA.TP", Label: "Tracked Packet – USA"},
-	{ID: "USA.TP.LVM", Label: "Tracked Packet – USA (LVM)"},
-	{ID: "USA.XP", Label: "Xpresspost USA"},
-
-	// International
-	{ID: "INT.XP", Label: "Xpresspost International"},
-	{ID: "INT.IP.AIR", Label: "International Parcel Air"},
-	{ID: "INT.IP.SURF", Label: "International Parcel Surface"},
-	{ID: "INT.SP.AIR", Label: "Small Packet International Air"},
-	{ID: "INT.SP.SURF", Label: "Small Packet International Surface"},
-	{ID: "INT.TP", Label: "Tracked Packet – International"},
+var paymentMethodOptions = []serviceOption{
+	{ID: "Account", Label: "Account"},
+	{ID: "CreditCard", Label: "Credit Card"},
+}
+
+// rateAdjustmentKindOptions are the rate adjustment kinds as shown in
+// settings, in the order they are applied.
+var rateAdjustmentKindOptions = []serviceOption{
+	{ID: database.AdjustMarkupPercent, Label: "Markup (%)"},
+	{ID: database.AdjustMarkupFlat, Label: "Markup (CAD)"},
+	{ID: database.AdjustHandlingFee, Label: "Handling fee (CAD)"},
+	{ID: database.AdjustOptionSurcharge, Label: "Option surcharge (CAD)"},
+	{ID: database.AdjustCharmRounding, Label: "Round up to end in (e.g. 0.99)"},
+	{ID: database.AdjustMinPrice, Label: "Minimum price (CAD)"},
+	{ID: database.AdjustMaxPrice, Label: "Maximum price (CAD)"},
+}
+
+// rateRuleActionOptions are the rate rule actions as shown in settings.
+var rateRuleActionOptions = []serviceOption{
+	{ID: database.RuleFreeShipping, Label: "Free shipping"},
+	{ID: database.RuleHideService, Label: "Hide services"},
+	{ID: database.RuleHideSlower, Label: "Hide slower services when a faster one costs at most Amount % more"},
 }
 
 var currencyOptions = []currencyOption{
@@ -428,19 +510,54 @@ var currencyOptions = []currencyOption{
 	{Code: "ZWL", Label: "ZWL"},
 }
 
+// currencyRateRow is the rate a client converts a currency with, as shown
+// in settings.
+type currencyRateRow struct {
+	database.ExchangeRate
+	Stale bool
+}
+
 type settingsPageData struct {
 	ClientID        int64
 	AccountNumber   string
+	ContractID      string
+	PaymentMethod   string
+	PaymentMethods  []serviceOption
+	GroupID         string
 	Services        []serviceOption
 	Enabled         map[string]bool
-	CurrencyRates   []database.CurrencyRate
+	CurrencyRates   []currencyRateRow
+	RateHistory     []database.ExchangeRate
+	FXSource        string
+	FXStaleHours    int
+	FXStale         string // currencies whose rate in use is stale
 	Currencies      []currencyOption
 	PostalCodes     []string
 	DefaultPostal   string
 	SessionToken    string
 	Message         string
 	CurrencyMessage string
+	LabelPrefs      database.LabelPreferences
+	FormatMessage   string
+	Schedule        database.DeliverySchedule
+	ScheduleMsg     string
+	PackagingBoxes  []database.PackagingBox
+	BoxMessage      string
+	Adjustments     []database.RateAdjustment
+	AdjustKinds     []serviceOption
+	PricingMessage  string
+	RateRules       []database.RateRule
+	RuleActions     []serviceOption
+	RuleMessage     string
+	FallbackRates   []database.FallbackRate
+	FallbackMsg     string
 	PostalMessage   string
+	LabelsMessage   string
+	PickupMessage   string
+	Pickups         []database.ClientPickup
+	PickupAvail     *service.PickupAvailabilityXML
+	PickupAvailErr  string
+	Today           string
 	PostalPage      int
 	PostalPageSize  int
 	PostalHasNext   bool
@@ -448,6 +565,9 @@ type settingsPageData struct {
 	FromDate        string
 	ToDate          string
 	Labels          []database.LabelRecord
+	TrackingStatus  map[string]string
+	ReturnLabels    map[string]string
+	Reconciliation  database.ChargeReconciliation
 	ActiveTab       string
 	Page            int
 	PageSize        int
@@ -512,6 +632,10 @@ func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "currency code is required", http.StatusBadRequest)
 				return
 			}
+			if strings.EqualFold(code, "CAD") {
+				http.Error(w, "CAD does not need a rate", http.StatusBadRequest)
+				return
+			}
 			if rateValue == "" {
 				http.Error(w, "rate_to_cad is required", http.StatusBadRequest)
 				return
@@ -521,12 +645,37 @@ func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "rate_to_cad must be a positive number", http.StatusBadRequest)
 				return
 			}
-			if err := a.Store.SaveCurrencyRate(clientID, code, rateToCad); err != nil {
+			override := r.FormValue("override") == "1"
+			if err := a.Store.SaveCurrencyRate(clientID, code, rateToCad, override); err != nil {
 				log.Println("failed to save currency rate:", err)
 				http.Error(w, "failed to save currency rate", http.StatusInternalServerError)
 				return
 			}
-			log.Printf("currency rate saved: client_id=%d currency=%s rate_to_cad=%.6f", clientID, strings.ToUpper(code), rateToCad)
+			log.Printf("currency rate saved: client_id=%d currency=%s rate_to_cad=%.6f override=%t", clientID, strings.ToUpper(code), rateToCad, override)
+		} else if formType == "currency_import" {
+			rates, err := currencyRatesFromUpload(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			override := r.FormValue("override") == "1"
+			if err := a.Store.SaveClientExchangeRates(clientID, rates, override); err != nil {
+				log.Println("failed to import currency rates:", err)
+				http.Error(w, "failed to import currency rates", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("currency rates imported: client_id=%d count=%d override=%t", clientID, len(rates), override)
+		} else if formType == "currency_delete" {
+			code := strings.TrimSpace(r.FormValue("currency_code"))
+			if code == "" {
+				http.Error(w, "currency code is required", http.StatusBadRequest)
+				return
+			}
+			if err := a.Store.DeleteCurrencyRate(clientID, code); err != nil {
+				log.Println("failed to remove currency rate:", err)
+				http.Error(w, "failed to remove currency rate", http.StatusInternalServerError)
+				return
+			}
 		} else if formType == "postoffice_search" {
 			postalCode := normalizePostalCode(r.FormValue("postal_code"))
 			if postalCode == "" {
@@ -576,6 +725,197 @@ func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "failed to remove postal code", http.StatusInternalServerError)
 				return
 			}
+		} else if formType == "manifest_transmit" {
+			result, err := service.NewManifestService(a.Store, a.Config).TransmitClient(r.Context(), clientID)
+			if err != nil {
+				log.Printf("failed to transmit shipments: client_id=%d err=%v", clientID, err)
+				http.Error(w, "failed to transmit shipments: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+			log.Printf("shipments transmitted: client_id=%d groups=%v manifests=%v", clientID, result.GroupIDs, result.ManifestIDs)
+		} else if formType == "return_label" {
+			labelID := strings.TrimSpace(r.FormValue("label_id"))
+			if labelID == "" {
+				http.Error(w, "label id is required", http.StatusBadRequest)
+				return
+			}
+			if _, err := service.NewServer(a.Store, a.Config).CreateReturnLabel(r.Context(), clientID, labelID); err != nil {
+				log.Printf("failed to create return label: client_id=%d label_id=%s err=%v", clientID, labelID, err)
+				http.Error(w, "failed to create return label: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+		} else if formType == "pickup_create" {
+			if _, err := service.NewPickupService(a.Store, a.Config).Schedule(r.Context(), clientID, pickupInputFromForm(r)); err != nil {
+				log.Printf("failed to schedule pickup: client_id=%d err=%v", clientID, err)
+				http.Error(w, "failed to schedule pickup: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+		} else if formType == "pickup_update" {
+			requestID := strings.TrimSpace(r.FormValue("request_id"))
+			if requestID == "" {
+				http.Error(w, "pickup request id is required", http.StatusBadRequest)
+				return
+			}
+			if _, err := service.NewPickupService(a.Store, a.Config).Reschedule(r.Context(), clientID, requestID, pickupInputFromForm(r)); err != nil {
+				log.Printf("failed to update pickup: client_id=%d request_id=%s err=%v", clientID, requestID, err)
+				http.Error(w, "failed to update pickup: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+		} else if formType == "pickup_cancel" {
+			requestID := strings.TrimSpace(r.FormValue("request_id"))
+			if requestID == "" {
+				http.Error(w, "pickup request id is required", http.StatusBadRequest)
+				return
+			}
+			if err := service.NewPickupService(a.Store, a.Config).Cancel(r.Context(), clientID, requestID); err != nil {
+				log.Printf("failed to cancel pickup: client_id=%d request_id=%s err=%v", clientID, requestID, err)
+				http.Error(w, "failed to cancel pickup: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+		} else if formType == "label_preferences" {
+			prefs := database.LabelPreferences{
+				PaperSize: r.FormValue("label_paper_size"),
+				Encoding:  r.FormValue("label_encoding"),
+				Output:    r.FormValue("label_output"),
+			}
+			if _, err := database.NormalizeLabelPreferences(prefs); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := a.Store.SaveLabelPreferences(clientID, prefs); err != nil {
+				log.Println("failed to save label preferences:", err)
+				http.Error(w, "failed to save label preferences", http.StatusInternalServerError)
+				return
+			}
+		} else if formType == "delivery_schedule" {
+			schedule := database.DeliverySchedule{
+				OrderCutoff: r.FormValue("order_cutoff"),
+				TimeZone:    r.FormValue("time_zone"),
+			}
+			if raw := strings.TrimSpace(r.FormValue("handling_days")); raw != "" {
+				days, err := strconv.Atoi(raw)
+				if err != nil {
+					http.Error(w, "handling days must be a whole number", http.StatusBadRequest)
+					return
+				}
+				schedule.HandlingDays = days
+			}
+			if _, err := database.NormalizeDeliverySchedule(schedule); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := a.Store.SaveDeliverySchedule(clientID, schedule); err != nil {
+				log.Println("failed to save delivery schedule:", err)
+				http.Error(w, "failed to save delivery schedule", http.StatusInternalServerError)
+				return
+			}
+		} else if formType == "packaging_box" {
+			box, err := packagingBoxFromForm(r)
+			if err == nil {
+				box.ClientID = clientID
+				box, err = database.NormalizePackagingBox(box)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := a.Store.SavePackagingBox(box); err != nil {
+				log.Println("failed to save packaging box:", err)
+				http.Error(w, "failed to save packaging box", http.StatusInternalServerError)
+				return
+			}
+		} else if formType == "packaging_box_delete" {
+			boxID, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("box_id")), 10, 64)
+			if err != nil || boxID <= 0 {
+				http.Error(w, "box id is required", http.StatusBadRequest)
+				return
+			}
+			if err := a.Store.DeletePackagingBox(clientID, boxID); err != nil {
+				log.Println("failed to delete packaging box:", err)
+				http.Error(w, "failed to remove packaging box", http.StatusInternalServerError)
+				return
+			}
+		} else if formType == "rate_adjustment" {
+			rule, err := rateAdjustmentFromForm(r)
+			if err == nil {
+				rule.ClientID = clientID
+				rule, err = database.NormalizeRateAdjustment(rule)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := a.Store.SaveRateAdjustment(rule); err != nil {
+				log.Println("failed to save rate adjustment:", err)
+				http.Error(w, "failed to save rate adjustment", http.StatusInternalServerError)
+				return
+			}
+		} else if formType == "rate_adjustment_delete" {
+			ruleID, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("adjustment_id")), 10, 64)
+			if err != nil || ruleID <= 0 {
+				http.Error(w, "adjustment id is required", http.StatusBadRequest)
+				return
+			}
+			if err := a.Store.DeleteRateAdjustment(clientID, ruleID); err != nil {
+				log.Println("failed to delete rate adjustment:", err)
+				http.Error(w, "failed to remove rate adjustment", http.StatusInternalServerError)
+				return
+			}
+		} else if formType == "rate_rule" {
+			rule, err := rateRuleFromForm(r)
+			if err == nil {
+				rule.ClientID = clientID
+				rule, err = database.NormalizeRateRule(rule)
+			}
+			if err == nil {
+				err = service.ValidateRateRuleConditions(rule.Conditions)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := a.Store.SaveRateRule(rule); err != nil {
+				log.Println("failed to save rate rule:", err)
+				http.Error(w, "failed to save rate rule", http.StatusInternalServerError)
+				return
+			}
+		} else if formType == "rate_rule_delete" {
+			ruleID, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("rule_id")), 10, 64)
+			if err != nil || ruleID <= 0 {
+				http.Error(w, "rule id is required", http.StatusBadRequest)
+				return
+			}
+			if err := a.Store.DeleteRateRule(clientID, ruleID); err != nil {
+				log.Println("failed to delete rate rule:", err)
+				http.Error(w, "failed to remove rate rule", http.StatusInternalServerError)
+				return
+			}
+		} else if formType == "fallback_rate" {
+			rate, err := fallbackRateFromForm(r)
+			if err == nil {
+				rate.ClientID = clientID
+				rate, err = database.NormalizeFallbackRate(rate)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := a.Store.SaveFallbackRate(rate); err != nil {
+				log.Println("failed to save fallback rate:", err)
+				http.Error(w, "failed to save fallback rate", http.StatusInternalServerError)
+				return
+			}
+		} else if formType == "fallback_rate_delete" {
+			rateID, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("fallback_id")), 10, 64)
+			if err != nil || rateID <= 0 {
+				http.Error(w, "fallback rate id is required", http.StatusBadRequest)
+				return
+			}
+			if err := a.Store.DeleteFallbackRate(clientID, rateID); err != nil {
+				log.Println("failed to delete fallback rate:", err)
+				http.Error(w, "failed to remove fallback rate", http.StatusInternalServerError)
+				return
+			}
 		} else if formType == "postoffice_default" {
 			postalCode := normalizePostalCode(r.FormValue("postal_code"))
 			if postalCode == "" {
@@ -596,12 +936,31 @@ func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "account number is required", http.StatusBadRequest)
 				return
 			}
+			contractID := strings.TrimSpace(r.FormValue("contract_id"))
+			if contractID != "" && !isDigits(contractID) {
+				http.Error(w, "contract ID must contain digits only", http.StatusBadRequest)
+				return
+			}
+			paymentMethod := strings.TrimSpace(r.FormValue("payment_method"))
+			if !isPaymentMethodOption(paymentMethod) {
+				paymentMethod = paymentMethodOptions[0].ID
+			}
+			groupID := strings.TrimSpace(r.FormValue("group_id"))
+			if len(groupID) > 32 {
+				http.Error(w, "group ID must be 32 characters or fewer", http.StatusBadRequest)
+				return
+			}
 			enabled := r.Form["services"]
 			if err := a.Store.SaveShippingSettings(clientID, accountNumber, enabled); err != nil {
 				log.Println("failed to save settings:", err)
 				http.Error(w, "failed to save settings", http.StatusInternalServerError)
 				return
 			}
+			if err := a.Store.SaveContractSettings(clientID, contractID, paymentMethod, groupID); err != nil {
+				log.Println("failed to save contract settings:", err)
+				http.Error(w, "failed to save settings", http.StatusInternalServerError)
+				return
+			}
 		}
 		var err error
 		nextToken, err = a.createSessionToken(clientID, 2*time.Minute)
@@ -612,12 +971,46 @@ func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
 		savedParam := "saved=1"
 		if formType == "currency" {
 			savedParam = "saved_currency=1"
+		} else if formType == "currency_import" {
+			savedParam = "imported_currency=1"
+		} else if formType == "currency_delete" {
+			savedParam = "deleted_currency=1"
+		} else if formType == "label_preferences" {
+			savedParam = "saved_label_preferences=1"
+		} else if formType == "delivery_schedule" {
+			savedParam = "saved_schedule=1"
+		} else if formType == "packaging_box" {
+			savedParam = "saved_box=1"
+		} else if formType == "packaging_box_delete" {
+			savedParam = "deleted_box=1"
+		} else if formType == "rate_adjustment" {
+			savedParam = "saved_adjustment=1"
+		} else if formType == "rate_adjustment_delete" {
+			savedParam = "deleted_adjustment=1"
+		} else if formType == "rate_rule" {
+			savedParam = "saved_rule=1"
+		} else if formType == "rate_rule_delete" {
+			savedParam = "deleted_rule=1"
+		} else if formType == "fallback_rate" {
+			savedParam = "saved_fallback=1"
+		} else if formType == "fallback_rate_delete" {
+			savedParam = "deleted_fallback=1"
 		} else if formType == "postoffice_search" {
 			savedParam = "saved_postoffice=1"
 		} else if formType == "postoffice_delete" {
 			savedParam = "deleted_postoffice=1"
 		} else if formType == "postoffice_default" {
 			savedParam = "saved_postoffice_default=1"
+		} else if formType == "manifest_transmit" {
+			savedParam = "transmitted=1"
+		} else if formType == "return_label" {
+			savedParam = "return_created=1"
+		} else if formType == "pickup_create" {
+			savedParam = "pickup_scheduled=1"
+		} else if formType == "pickup_update" {
+			savedParam = "pickup_updated=1"
+		} else if formType == "pickup_cancel" {
+			savedParam = "pickup_cancelled=1"
 		}
 		redirectURL := "/settings?client_id=" + strconv.FormatInt(clientID, 10) + "&session_token=" + url.QueryEscape(nextToken) + "&" + savedParam
 		if widgetsParam != "" {
@@ -636,18 +1029,51 @@ func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
 	if settings.EnabledServices == nil {
 		settings.EnabledServices = make(map[string]bool)
 	}
-	currencyRates, err := a.Store.LoadCurrencyRates(clientID)
+	exchangeRates, err := a.Store.LoadExchangeRates(clientID)
 	if err != nil {
 		log.Println("failed to load currency rates:", err)
 		http.Error(w, "failed to load currency rates", http.StatusInternalServerError)
 		return
 	}
-	if len(currencyRates) == 0 {
-		log.Printf("currency rates loaded: client_id=%d count=0", clientID)
-	} else {
-		for _, rate := range currencyRates {
-			log.Printf("currency rates loaded: client_id=%d currency=%s rate_to_cad=%.6f updated_at=%s", clientID, rate.CurrencyCode, rate.RateToCad, rate.UpdatedAt.Format(time.RFC3339))
+	staleAfter := time.Duration(a.Config.ExchangeRates.StaleHours) * time.Hour
+	currencyRates := make([]currencyRateRow, 0, len(exchangeRates))
+	staleCurrencies := []string{}
+	for _, rate := range exchangeRates {
+		row := currencyRateRow{ExchangeRate: rate, Stale: rate.Stale(time.Now(), staleAfter)}
+		if row.Stale {
+			staleCurrencies = append(staleCurrencies, rate.CurrencyCode)
 		}
+		currencyRates = append(currencyRates, row)
+	}
+	if len(staleCurrencies) > 0 {
+		log.Printf("⚠️ stale exchange rates in use: client_id=%d currencies=%s", clientID, strings.Join(staleCurrencies, ","))
+	}
+	log.Printf("currency rates loaded: client_id=%d count=%d", clientID, len(currencyRates))
+	rateHistory, err := a.Store.LoadExchangeRateHistory(clientID, 20)
+	if err != nil {
+		log.Println("failed to load exchange rate history:", err)
+		rateHistory = nil
+	}
+
+	packagingBoxes, err := a.Store.LoadPackagingBoxes(clientID)
+	if err != nil {
+		log.Println("failed to load packaging boxes:", err)
+		packagingBoxes = nil
+	}
+	rateAdjustments, err := a.Store.LoadRateAdjustments(clientID)
+	if err != nil {
+		log.Println("failed to load rate adjustments:", err)
+		rateAdjustments = nil
+	}
+	rateRules, err := a.Store.LoadRateRules(clientID)
+	if err != nil {
+		log.Println("failed to load rate rules:", err)
+		rateRules = nil
+	}
+	fallbackRates, err := a.Store.LoadFallbackRates(clientID)
+	if err != nil {
+		log.Println("failed to load fallback rates:", err)
+		fallbackRates = nil
 	}
 
 	postalCodes := []string{}
@@ -669,6 +1095,12 @@ func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
 	if activeTab == "" && (fromDate != "" || toDate != "") {
 		activeTab = "labels"
 	}
+	if activeTab == "" && (r.URL.Query().Get("transmitted") == "1" || r.URL.Query().Get("return_created") == "1") {
+		activeTab = "labels"
+	}
+	if activeTab == "" && (r.URL.Query().Get("pickup_scheduled") == "1" || r.URL.Query().Get("pickup_updated") == "1" || r.URL.Query().Get("pickup_cancelled") == "1") {
+		activeTab = "pickups"
+	}
 	if activeTab == "" && r.URL.Query().Get("postal_page") != "" {
 		activeTab = "postoffice"
 	}
@@ -691,12 +1123,66 @@ func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	trackingNumbers := make([]string, 0, len(labels))
+	labelIDs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		trackingNumbers = append(trackingNumbers, label.TrackingNumber)
+		labelIDs = append(labelIDs, label.ID)
+	}
+	latestEvents, err := a.Store.LoadLatestTrackingEvents(trackingNumbers)
+	if err != nil {
+		log.Println("failed to load tracking events:", err)
+		latestEvents = nil
+	}
+	returnLabels, err := a.Store.LoadReturnLabelIDs(labelIDs)
+	if err != nil {
+		log.Println("failed to load return labels:", err)
+		returnLabels = nil
+	}
+
+	reconciliation, err := a.Store.LoadChargeReconciliation(clientID, fromDate, toDate, 50)
+	if err != nil {
+		log.Println("failed to load charge reconciliation:", err)
+	}
+
+	pickups, err := a.Store.LoadClientPickups(clientID, 50)
+	if err != nil {
+		log.Println("failed to load pickups:", err)
+		pickups = nil
+	}
+	// Availability is a live Canada Post call, so only look it up when the
+	// pickups tab is open.
+	var pickupAvail *service.PickupAvailabilityXML
+	pickupAvailErr := ""
+	if activeTab == "pickups" && strings.TrimSpace(settings.DefaultPostalCode) != "" {
+		if _, pickupAvail, err = service.NewPickupService(a.Store, a.Config).Availability(r.Context(), clientID); err != nil {
+			log.Printf("failed to load pickup availability: client_id=%d err=%v", clientID, err)
+			pickupAvailErr = "Pickup availability could not be loaded."
+		}
+	}
+
 	data := settingsPageData{
 		ClientID:       clientID,
 		AccountNumber:  settings.AccountNumber,
-		Services:       serviceOptions,
+		ContractID:     settings.ContractID,
+		PaymentMethod:  settings.PaymentMethod,
+		PaymentMethods: paymentMethodOptions,
+		GroupID:        settings.GroupID,
+		Services:       catalogServiceOptions(),
 		Enabled:        settings.EnabledServices,
+		LabelPrefs:     settings.LabelPreferences(),
+		Schedule:       settings.DeliverySchedule(),
+		PackagingBoxes: packagingBoxes,
+		Adjustments:    rateAdjustments,
+		AdjustKinds:    rateAdjustmentKindOptions,
+		RateRules:      rateRules,
+		RuleActions:    rateRuleActionOptions,
+		FallbackRates:  fallbackRates,
 		CurrencyRates:  currencyRates,
+		FXStale:        strings.Join(staleCurrencies, ", "),
+		RateHistory:    rateHistory,
+		FXSource:       a.Config.ExchangeRates.Source,
+		FXStaleHours:   a.Config.ExchangeRates.StaleHours,
 		Currencies:     currencyOptions,
 		PostalCodes:    postalCodes,
 		DefaultPostal:  normalizePostalCode(settings.DefaultPostalCode),
@@ -708,6 +1194,13 @@ func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
 		FromDate:       fromDate,
 		ToDate:         toDate,
 		Labels:         labels,
+		TrackingStatus: trackingStatusByPin(latestEvents),
+		ReturnLabels:   returnLabels,
+		Reconciliation: reconciliation,
+		Pickups:        pickups,
+		PickupAvail:    pickupAvail,
+		PickupAvailErr: pickupAvailErr,
+		Today:          time.Now().Format("2006-01-02"),
 		ActiveTab:      activeTab,
 		Page:           page,
 		PageSize:       pageSize,
@@ -721,6 +1214,42 @@ func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Query().Get("saved_currency") == "1" {
 		data.CurrencyMessage = "Currency rate saved."
 	}
+	if r.URL.Query().Get("imported_currency") == "1" {
+		data.CurrencyMessage = "Currency rates imported."
+	}
+	if r.URL.Query().Get("deleted_currency") == "1" {
+		data.CurrencyMessage = "Currency rate removed; the shared rate is used."
+	}
+	if r.URL.Query().Get("saved_label_preferences") == "1" {
+		data.FormatMessage = "Label preferences saved."
+	}
+	if r.URL.Query().Get("saved_schedule") == "1" {
+		data.ScheduleMsg = "Delivery schedule saved."
+	}
+	if r.URL.Query().Get("saved_box") == "1" {
+		data.BoxMessage = "Packaging box saved."
+	}
+	if r.URL.Query().Get("deleted_box") == "1" {
+		data.BoxMessage = "Packaging box removed."
+	}
+	if r.URL.Query().Get("saved_adjustment") == "1" {
+		data.PricingMessage = "Rate adjustment saved."
+	}
+	if r.URL.Query().Get("deleted_adjustment") == "1" {
+		data.PricingMessage = "Rate adjustment removed."
+	}
+	if r.URL.Query().Get("saved_rule") == "1" {
+		data.RuleMessage = "Rate rule saved."
+	}
+	if r.URL.Query().Get("deleted_rule") == "1" {
+		data.RuleMessage = "Rate rule removed."
+	}
+	if r.URL.Query().Get("saved_fallback") == "1" {
+		data.FallbackMsg = "Fallback rate saved."
+	}
+	if r.URL.Query().Get("deleted_fallback") == "1" {
+		data.FallbackMsg = "Fallback rate removed."
+	}
 	if r.URL.Query().Get("saved_postoffice") == "1" {
 		data.PostalMessage = "Default postal code updated."
 	}
@@ -730,6 +1259,21 @@ func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Query().Get("saved_postoffice_default") == "1" {
 		data.PostalMessage = "Default postal code saved."
 	}
+	if r.URL.Query().Get("transmitted") == "1" {
+		data.LabelsMessage = "Shipments transmitted to Canada Post."
+	}
+	if r.URL.Query().Get("return_created") == "1" {
+		data.LabelsMessage = "Return label created."
+	}
+	if r.URL.Query().Get("pickup_scheduled") == "1" {
+		data.PickupMessage = "Pickup scheduled."
+	}
+	if r.URL.Query().Get("pickup_updated") == "1" {
+		data.PickupMessage = "Pickup updated."
+	}
+	if r.URL.Query().Get("pickup_cancelled") == "1" {
+		data.PickupMessage = "Pickup cancelled."
+	}
 
 	renderSettingsPage(w, data)
 }
@@ -855,6 +1399,26 @@ func renderSettingsPage(w http.ResponseWriter, data settingsPageData) {
 		"div100": func(value int64) float64 {
 			return float64(value) / 100.0
 		},
+		"sub": func(a, b int64) int64 {
+			return a - b
+		},
+		"adjustmentKind": func(kind string) string {
+			for _, option := range rateAdjustmentKindOptions {
+				if option.ID == kind {
+					return option.Label
+				}
+			}
+			return kind
+		},
+		"ruleAction": func(action string) string {
+			for _, option := range rateRuleActionOptions {
+				if option.ID == action {
+					return option.Label
+				}
+			}
+			return action
+		},
+		"join": strings.Join,
 	}).Parse(settingsHTML))
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := tmpl.Execute(w, data); err != nil {
@@ -875,6 +1439,185 @@ func (a *App) postOfficeService() *service.PostOfficeService {
 	return service.NewPostOfficeService(client, a.Store.DB)
 }
 
+// catalogServiceOptions lists the active Canada Post services for the
+// settings checkboxes.
+func catalogServiceOptions() []serviceOption {
+	services := service.CatalogServices()
+	options := make([]serviceOption, 0, len(services))
+	for _, svc := range services {
+		label := svc.Name
+		if strings.HasPrefix(svc.Code, "DOM.") {
+			label += " (Domestic)"
+		}
+		options = append(options, serviceOption{ID: svc.Code, Label: label})
+	}
+	return options
+}
+
+func pickupInputFromForm(r *http.Request) service.PickupInput {
+	volume, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("pickup_volume")))
+	return service.PickupInput{
+		PickupDate:    strings.TrimSpace(r.FormValue("pickup_date")),
+		PreferredTime: strings.TrimSpace(r.FormValue("preferred_time")),
+		ClosingTime:   strings.TrimSpace(r.FormValue("closing_time")),
+		Volume:        volume,
+		ContactName:   strings.TrimSpace(r.FormValue("contact_name")),
+		ContactEmail:  strings.TrimSpace(r.FormValue("contact_email")),
+		ContactPhone:  strings.TrimSpace(r.FormValue("contact_phone")),
+		Instructions:  strings.TrimSpace(r.FormValue("pickup_instructions")),
+		Company:       strings.TrimSpace(r.FormValue("pickup_company")),
+		AddressLine1:  strings.TrimSpace(r.FormValue("pickup_address")),
+		City:          strings.TrimSpace(r.FormValue("pickup_city")),
+		Province:      strings.TrimSpace(r.FormValue("pickup_province")),
+	}
+}
+
+// packagingBoxFromForm reads a box from the packaging form. Dimensions are
+// inner cm, the wall thickness is cm and weights are kg; an empty wall
+// thickness is 0 and an empty max weight means no limit.
+func packagingBoxFromForm(r *http.Request) (database.PackagingBox, error) {
+	box := database.PackagingBox{Name: r.FormValue("box_name")}
+	fields := []struct {
+		name     string
+		value    *float64
+		optional bool
+	}{
+		{"box_length", &box.Length, false},
+		{"box_width", &box.Width, false},
+		{"box_height", &box.Height, false},
+		{"box_wall_thickness", &box.WallThickness, true},
+		{"box_tare_weight", &box.TareWeight, true},
+		{"box_max_weight", &box.MaxWeight, true},
+	}
+	for _, field := range fields {
+		raw := strings.TrimSpace(r.FormValue(field.name))
+		if raw == "" && field.optional {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return database.PackagingBox{}, fmt.Errorf("%s must be a number", strings.ReplaceAll(field.name, "_", " "))
+		}
+		*field.value = parsed
+	}
+	return box, nil
+}
+
+// rateAdjustmentFromForm reads a rule from the pricing form. An empty
+// service applies the rule to every service.
+func rateAdjustmentFromForm(r *http.Request) (database.RateAdjustment, error) {
+	rule := database.RateAdjustment{
+		Kind:        r.FormValue("adjustment_kind"),
+		ServiceCode: r.FormValue("adjustment_service"),
+		OptionCode:  r.FormValue("adjustment_option"),
+	}
+	amount, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue("adjustment_amount")), 64)
+	if err != nil {
+		return database.RateAdjustment{}, fmt.Errorf("amount must be a number")
+	}
+	rule.Amount = amount
+	return rule, nil
+}
+
+// rateRuleFromForm reads a rule from the rate rules form. Services are
+// comma separated; the amount is only used by hide_slower.
+func rateRuleFromForm(r *http.Request) (database.RateRule, error) {
+	rule := database.RateRule{
+		Name:       r.FormValue("rule_name"),
+		Conditions: r.FormValue("rule_conditions"),
+		Action:     r.FormValue("rule_action"),
+		Services:   strings.Split(r.FormValue("rule_services"), ","),
+	}
+	if raw := strings.TrimSpace(r.FormValue("rule_amount")); raw != "" {
+		amount, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return database.RateRule{}, fmt.Errorf("amount must be a number")
+		}
+		rule.Amount = amount
+	}
+	return rule, nil
+}
+
+// fallbackRateFromForm reads a row from the fallback rates form. The max
+// weight is kg and the price is CAD; an empty delivery time is unknown.
+func fallbackRateFromForm(r *http.Request) (database.FallbackRate, error) {
+	rate := database.FallbackRate{
+		ServiceCode: r.FormValue("fallback_service"),
+		Zone:        r.FormValue("fallback_zone"),
+	}
+	maxWeight, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue("fallback_max_weight")), 64)
+	if err != nil {
+		return database.FallbackRate{}, fmt.Errorf("max weight must be a number")
+	}
+	rate.MaxWeight = maxWeight
+	price, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue("fallback_price")), 64)
+	if err != nil {
+		return database.FallbackRate{}, fmt.Errorf("price must be a number")
+	}
+	rate.Price = price
+	if raw := strings.TrimSpace(r.FormValue("fallback_days")); raw != "" {
+		days, err := strconv.Atoi(raw)
+		if err != nil {
+			return database.FallbackRate{}, fmt.Errorf("delivery days must be a whole number")
+		}
+		rate.DeliveryDays = days
+	}
+	return rate, nil
+}
+
+// currencyRatesFromUpload reads the Bank of Canada Valet CSV or JSON file
+// of the currency import form, as the client's own rates. Only the selected
+// currency is kept when one was chosen.
+func currencyRatesFromUpload(r *http.Request) ([]database.ExchangeRate, error) {
+	file, _, err := r.FormFile("rates_file")
+	if err != nil {
+		return nil, fmt.Errorf("choose a Bank of Canada Valet CSV or JSON file")
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, 4<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the file")
+	}
+	parsed, err := service.ParseValetRates(data)
+	if err != nil {
+		return nil, err
+	}
+	code := strings.ToUpper(strings.TrimSpace(r.FormValue("currency_code")))
+	rates := []database.ExchangeRate{}
+	for _, rate := range parsed {
+		if code != "" && rate.CurrencyCode != code {
+			continue
+		}
+		rate.Source = database.FXSourceImport
+		rates = append(rates, rate)
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("the file has no %s rates", code)
+	}
+	return rates, nil
+}
+
+func isPaymentMethodOption(value string) bool {
+	for _, opt := range paymentMethodOptions {
+		if opt.ID == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isDigits(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 func parseClientID(value string) int64 {
 	value = strings.TrimSpace(value)
 	if value == "" {
@@ -1271,6 +2014,8 @@ const settingsHTML = `<!doctype html>
     <div class="tabs" role="tablist">
       <button class="tab {{if eq .ActiveTab "settings"}}active{{end}}" data-target="settings-panel" type="button">Canada Post Account Settings</button>
       <button class="tab {{if eq .ActiveTab "labels"}}active{{end}}" data-target="labels-panel" type="button">Created Labels</button>
+      <button class="tab {{if eq .ActiveTab "pickups"}}active{{end}}" data-target="pickups-panel" type="button">Pickups</button>
+      <button class="tab {{if eq .ActiveTab "reconciliation"}}active{{end}}" data-target="reconciliation-panel" type="button">Reconciliation</button>
     </div>
     {{end}}
 
@@ -1282,6 +2027,17 @@ const settingsHTML = `<!doctype html>
         <input type="hidden" name="form_type" value="settings">
         <label for="account_number">Canada Post Customer Number</label>
         <input id="account_number" name="account_number" type="text" value="{{.AccountNumber}}" placeholder="Enter customer number" required>
+        <label for="contract_id">Contract ID (optional)</label>
+        <input id="contract_id" name="contract_id" type="text" value="{{.ContractID}}" placeholder="Leave empty to use non-contract shipping">
+        <label for="payment_method">Payment Method</label>
+        <select id="payment_method" name="payment_method" style="width:100%; border:1px solid var(--border); border-radius:10px; padding:11px 12px; font-size:14px; margin-bottom:14px; background:#fbfcfe;">
+          {{range .PaymentMethods}}
+            <option value="{{.ID}}" {{if eq $.PaymentMethod .ID}}selected{{end}}>{{.Label}}</option>
+          {{end}}
+        </select>
+        <label for="group_id">Shipment Group ID (optional)</label>
+        <input id="group_id" name="group_id" type="text" value="{{.GroupID}}" placeholder="Defaults to the shipping date (YYYYMMDD)">
+        <p class="hint" style="margin:0 0 14px;">When a contract ID is set, labels are created with your Canada Post contract and billed to it.</p>
         <div class="list">
           {{range .Services}}
           <label class="row">
@@ -1296,9 +2052,297 @@ const settingsHTML = `<!doctype html>
         </div>
         {{if .Message}}<div class="message">{{.Message}}</div>{{end}}
       </form>
+      <div style="margin-top:26px; border-top:1px solid var(--border); padding-top:22px;">
+        <h1>Label Format</h1>
+        <p class="hint" style="margin:0 0 14px;">ZPL labels are only available on 4x6 thermal paper.</p>
+        <form method="post" action="/settings?client_id={{.ClientID}}">
+          <input type="hidden" name="session_token" value="{{.SessionToken}}">
+          <input type="hidden" name="form_type" value="label_preferences">
+          <label for="label_paper_size">Paper Size</label>
+          <select id="label_paper_size" name="label_paper_size" style="width:100%; border:1px solid var(--border); border-radius:10px; padding:11px 12px; font-size:14px; margin-bottom:14px; background:#fbfcfe;">
+            <option value="8.5x11" {{if eq .LabelPrefs.PaperSize "8.5x11"}}selected{{end}}>8.5x11 (letter)</option>
+            <option value="4x6" {{if eq .LabelPrefs.PaperSize "4x6"}}selected{{end}}>4x6 (thermal)</option>
+          </select>
+          <label for="label_encoding">Encoding</label>
+          <select id="label_encoding" name="label_encoding" style="width:100%; border:1px solid var(--border); border-radius:10px; padding:11px 12px; font-size:14px; margin-bottom:14px; background:#fbfcfe;">
+            <option value="PDF" {{if eq .LabelPrefs.Encoding "PDF"}}selected{{end}}>PDF</option>
+            <option value="ZPL" {{if eq .LabelPrefs.Encoding "ZPL"}}selected{{end}}>ZPL</option>
+          </select>
+          <label for="label_output">Output</label>
+          <select id="label_output" name="label_output" style="width:100%; border:1px solid var(--border); border-radius:10px; padding:11px 12px; font-size:14px; margin-bottom:14px; background:#fbfcfe;">
+            <option value="inline" {{if eq .LabelPrefs.Output "inline"}}selected{{end}}>Open in browser</option>
+            <option value="download" {{if eq .LabelPrefs.Output "download"}}selected{{end}}>Download</option>
+          </select>
+          <div class="actions">
+            <button type="submit">Save Label Format</button>
+          </div>
+          {{if .FormatMessage}}<div class="message">{{.FormatMessage}}</div>{{end}}
+        </form>
+      </div>
+      <div style="margin-top:26px; border-top:1px solid var(--border); padding-top:22px;">
+        <h1>Delivery Schedule</h1>
+        <p class="hint" style="margin:0 0 14px;">Delivery dates at checkout count business days, skipping weekends and Canadian statutory holidays. Orders placed after the cutoff, or on a day off, are handled from the next business day; the handling days are added before the parcel is mailed.</p>
+        <form method="post" action="/settings?client_id={{.ClientID}}">
+          <input type="hidden" name="session_token" value="{{.SessionToken}}">
+          <input type="hidden" name="form_type" value="delivery_schedule">
+          <label for="handling_days">Handling Days</label>
+          <input id="handling_days" name="handling_days" type="text" value="{{.Schedule.HandlingDays}}" placeholder="1">
+          <label for="order_cutoff">Order Cutoff (HH:MM, optional)</label>
+          <input id="order_cutoff" name="order_cutoff" type="text" value="{{.Schedule.OrderCutoff}}" placeholder="14:00">
+          <label for="time_zone">Time Zone</label>
+          <input id="time_zone" name="time_zone" type="text" value="{{.Schedule.TimeZone}}" placeholder="America/Toronto">
+          <div class="actions">
+            <button type="submit">Save Delivery Schedule</button>
+          </div>
+          {{if .ScheduleMsg}}<div class="message">{{.ScheduleMsg}}</div>{{end}}
+        </form>
+      </div>
+      <div style="margin-top:26px; border-top:1px solid var(--border); padding-top:22px;">
+        <h1>Packaging</h1>
+        <p class="hint" style="margin:0 0 14px;">Order items sent with their sizes are packed into these boxes at checkout. Saving a box with an existing name updates it.</p>
+        <form method="post" action="/settings?client_id={{.ClientID}}">
+          <input type="hidden" name="session_token" value="{{.SessionToken}}">
+          <input type="hidden" name="form_type" value="packaging_box">
+          <label for="box_name">Box Name</label>
+          <input id="box_name" name="box_name" type="text" placeholder="Small box" required>
+          <label for="box_length">Inner Length x Width x Height (cm)</label>
+          <div style="display:flex; gap:8px;">
+            <input id="box_length" name="box_length" type="text" placeholder="30" required>
+            <input id="box_width" name="box_width" type="text" placeholder="20" required>
+            <input id="box_height" name="box_height" type="text" placeholder="15" required>
+          </div>
+          <label for="box_wall_thickness">Wall Thickness (cm)</label>
+          <input id="box_wall_thickness" name="box_wall_thickness" type="text" placeholder="0.5">
+          <label for="box_tare_weight">Empty Box Weight (kg)</label>
+          <input id="box_tare_weight" name="box_tare_weight" type="text" placeholder="0.2">
+          <label for="box_max_weight">Max Weight (kg, optional)</label>
+          <input id="box_max_weight" name="box_max_weight" type="text" placeholder="Up to 30">
+          <div class="actions">
+            <button type="submit">Save Box</button>
+          </div>
+          {{if .BoxMessage}}<div class="message">{{.BoxMessage}}</div>{{end}}
+        </form>
+        {{if .PackagingBoxes}}
+          <div class="table-wrap" style="margin-top:18px;">
+            <table>
+              <thead>
+                <tr>
+                  <th>Box</th>
+                  <th>Inner (cm)</th>
+                  <th>Wall (cm)</th>
+                  <th>Tare (kg)</th>
+                  <th>Max (kg)</th>
+                  <th></th>
+                </tr>
+              </thead>
+              <tbody>
+                {{range .PackagingBoxes}}
+                <tr>
+                  <td>{{.Name}}</td>
+                  <td>{{.Length}} x {{.Width}} x {{.Height}}</td>
+                  <td>{{.WallThickness}}</td>
+                  <td>{{.TareWeight}}</td>
+                  <td>{{if gt .MaxWeight 0.0}}{{.MaxWeight}}{{else}}-{{end}}</td>
+                  <td>
+                    <form method="post" action="/settings?client_id={{$.ClientID}}">
+                      <input type="hidden" name="session_token" value="{{$.SessionToken}}">
+                      <input type="hidden" name="form_type" value="packaging_box_delete">
+                      <input type="hidden" name="box_id" value="{{.ID}}">
+                      <button type="submit">Remove</button>
+                    </form>
+                  </td>
+                </tr>
+                {{end}}
+              </tbody>
+            </table>
+          </div>
+        {{end}}
+      </div>
+      <div style="margin-top:26px; border-top:1px solid var(--border); padding-top:22px;">
+        <h1>Rate Adjustments</h1>
+        <p class="hint" style="margin:0 0 14px;">Change what customers pay for Canada Post rates, in CAD before currency conversion. Leave the service empty to apply a rule to every service; a rule for a service wins over it. Saving the same rule again updates its amount.</p>
+        <form method="post" action="/settings?client_id={{.ClientID}}">
+          <input type="hidden" name="session_token" value="{{.SessionToken}}">
+          <input type="hidden" name="form_type" value="rate_adjustment">
+          <label for="adjustment_kind">Adjustment</label>
+          <select id="adjustment_kind" name="adjustment_kind" style="width:100%; border:1px solid var(--border); border-radius:10px; padding:11px 12px; font-size:14px; margin-bottom:14px; background:#fbfcfe;">
+            {{range .AdjustKinds}}
+              <option value="{{.ID}}">{{.Label}}</option>
+            {{end}}
+          </select>
+          <label for="adjustment_service">Service</label>
+          <select id="adjustment_service" name="adjustment_service" style="width:100%; border:1px solid var(--border); border-radius:10px; padding:11px 12px; font-size:14px; margin-bottom:14px; background:#fbfcfe;">
+            <option value="">All services</option>
+            {{range .Services}}
+              <option value="{{.ID}}">{{.Label}}</option>
+            {{end}}
+          </select>
+          <label for="adjustment_option">Option Code (surcharges only)</label>
+          <input id="adjustment_option" name="adjustment_option" type="text" placeholder="SO">
+          <label for="adjustment_amount">Amount</label>
+          <input id="adjustment_amount" name="adjustment_amount" type="text" placeholder="10" required>
+          <div class="actions">
+            <button type="submit">Save Adjustment</button>
+          </div>
+          {{if .PricingMessage}}<div class="message">{{.PricingMessage}}</div>{{end}}
+        </form>
+        {{if .Adjustments}}
+          <div class="table-wrap" style="margin-top:18px;">
+            <table>
+              <thead>
+                <tr>
+                  <th>Adjustment</th>
+                  <th>Service</th>
+                  <th>Option</th>
+                  <th>Amount</th>
+                  <th></th>
+                </tr>
+              </thead>
+              <tbody>
+                {{range .Adjustments}}
+                <tr>
+                  <td>{{adjustmentKind .Kind}}</td>
+                  <td>{{if .ServiceCode}}{{.ServiceCode}}{{else}}All{{end}}</td>
+                  <td>{{if .OptionCode}}{{.OptionCode}}{{else}}-{{end}}</td>
+                  <td>{{printf "%.2f" .Amount}}</td>
+                  <td>
+                    <form method="post" action="/settings?client_id={{$.ClientID}}">
+                      <input type="hidden" name="session_token" value="{{$.SessionToken}}">
+                      <input type="hidden" name="form_type" value="rate_adjustment_delete">
+                      <input type="hidden" name="adjustment_id" value="{{.ID}}">
+                      <button type="submit">Remove</button>
+                    </form>
+                  </td>
+                </tr>
+                {{end}}
+              </tbody>
+            </table>
+          </div>
+        {{end}}
+      </div>
+      <div style="margin-top:26px; border-top:1px solid var(--border); padding-top:22px;">
+        <h1>Rate Rules</h1>
+        <p class="hint" style="margin:0 0 14px;">Rules run in order after rate adjustments. Conditions are separated by ";" and all have to match, e.g. <code>subtotal &gt;= 100; country = CA</code> or <code>province in NU,NT,YT</code>. Fields: subtotal (CAD), weight (kg), country, province. Leave services empty to act on every service. Saving a rule with an existing name updates it.</p>
+        <form method="post" action="/settings?client_id={{.ClientID}}">
+          <input type="hidden" name="session_token" value="{{.SessionToken}}">
+          <input type="hidden" name="form_type" value="rate_rule">
+          <label for="rule_name">Rule Name</label>
+          <input id="rule_name" name="rule_name" type="text" placeholder="Free shipping over $100" required>
+          <label for="rule_conditions">Conditions</label>
+          <input id="rule_conditions" name="rule_conditions" type="text" placeholder="subtotal >= 100; country = CA">
+          <label for="rule_action">Action</label>
+          <select id="rule_action" name="rule_action" style="width:100%; border:1px solid var(--border); border-radius:10px; padding:11px 12px; font-size:14px; margin-bottom:14px; background:#fbfcfe;">
+            {{range .RuleActions}}
+              <option value="{{.ID}}">{{.Label}}</option>
+            {{end}}
+          </select>
+          <label for="rule_services">Services (comma separated, optional)</label>
+          <input id="rule_services" name="rule_services" type="text" placeholder="DOM.RP,DOM.EP">
+          <label for="rule_amount">Amount (%)</label>
+          <input id="rule_amount" name="rule_amount" type="text" placeholder="10">
+          <div class="actions">
+            <button type="submit">Save Rule</button>
+          </div>
+          {{if .RuleMessage}}<div class="message">{{.RuleMessage}}</div>{{end}}
+        </form>
+        {{if .RateRules}}
+          <div class="table-wrap" style="margin-top:18px;">
+            <table>
+              <thead>
+                <tr>
+                  <th>Rule</th>
+                  <th>Conditions</th>
+                  <th>Action</th>
+                  <th>Services</th>
+                  <th></th>
+                </tr>
+              </thead>
+              <tbody>
+                {{range .RateRules}}
+                <tr>
+                  <td>{{.Name}}</td>
+                  <td>{{if .Conditions}}{{.Conditions}}{{else}}Always{{end}}</td>
+                  <td>{{ruleAction .Action}}{{if eq .Action "hide_slower"}} ({{printf "%.2f" .Amount}}%){{end}}</td>
+                  <td>{{if .Services}}{{join .Services ", "}}{{else}}All{{end}}</td>
+                  <td>
+                    <form method="post" action="/settings?client_id={{$.ClientID}}">
+                      <input type="hidden" name="session_token" value="{{$.SessionToken}}">
+                      <input type="hidden" name="form_type" value="rate_rule_delete">
+                      <input type="hidden" name="rule_id" value="{{.ID}}">
+                      <button type="submit">Remove</button>
+                    </form>
+                  </td>
+                </tr>
+                {{end}}
+              </tbody>
+            </table>
+          </div>
+        {{end}}
+      </div>
+      <div style="margin-top:26px; border-top:1px solid var(--border); padding-top:22px;">
+        <h1>Fallback Rates</h1>
+        <p class="hint" style="margin:0 0 14px;">Offered as estimates, marked "(estimate)", when Canada Post can't be reached. Prices are in CAD and go through rate adjustments and rules like live rates. A zone is <code>*</code> for everywhere, a country (<code>US</code>), a province (<code>CA-ON</code>) or the first three characters of a Canadian postal code (<code>K1A</code>); the most specific zone wins, then the smallest max weight the shipment fits in. Labels for estimated rates are re-quoted with Canada Post when they are bought.</p>
+        <form method="post" action="/settings?client_id={{.ClientID}}">
+          <input type="hidden" name="session_token" value="{{.SessionToken}}">
+          <input type="hidden" name="form_type" value="fallback_rate">
+          <label for="fallback_service">Service</label>
+          <select id="fallback_service" name="fallback_service" style="width:100%; border:1px solid var(--border); border-radius:10px; padding:11px 12px; font-size:14px; margin-bottom:14px; background:#fbfcfe;">
+            {{range .Services}}
+              <option value="{{.ID}}">{{.Label}}</option>
+            {{end}}
+          </select>
+          <label for="fallback_zone">Zone</label>
+          <input id="fallback_zone" name="fallback_zone" type="text" placeholder="CA-ON" required>
+          <label for="fallback_max_weight">Max Weight (kg)</label>
+          <input id="fallback_max_weight" name="fallback_max_weight" type="text" placeholder="2" required>
+          <label for="fallback_price">Price (CAD)</label>
+          <input id="fallback_price" name="fallback_price" type="text" placeholder="18.50" required>
+          <label for="fallback_days">Delivery Days (optional)</label>
+          <input id="fallback_days" name="fallback_days" type="text" placeholder="3">
+          <div class="actions">
+            <button type="submit">Save Fallback Rate</button>
+          </div>
+          {{if .FallbackMsg}}<div class="message">{{.FallbackMsg}}</div>{{end}}
+        </form>
+        {{if .FallbackRates}}
+          <div class="table-wrap" style="margin-top:18px;">
+            <table>
+              <thead>
+                <tr>
+                  <th>Service</th>
+                  <th>Zone</th>
+                  <th>Max Weight (kg)</th>
+                  <th>Price (CAD)</th>
+                  <th>Days</th>
+                  <th></th>
+                </tr>
+              </thead>
+              <tbody>
+                {{range .FallbackRates}}
+                <tr>
+                  <td>{{.ServiceCode}}</td>
+                  <td>{{.Zone}}</td>
+                  <td>{{printf "%.3f" .MaxWeight}}</td>
+                  <td>{{printf "%.2f" .Price}}</td>
+                  <td>{{if .DeliveryDays}}{{.DeliveryDays}}{{else}}-{{end}}</td>
+                  <td>
+                    <form method="post" action="/settings?client_id={{$.ClientID}}">
+                      <input type="hidden" name="session_token" value="{{$.SessionToken}}">
+                      <input type="hidden" name="form_type" value="fallback_rate_delete">
+                      <input type="hidden" name="fallback_id" value="{{.ID}}">
+                      <button type="submit">Remove</button>
+                    </form>
+                  </td>
+                </tr>
+                {{end}}
+              </tbody>
+            </table>
+          </div>
+        {{end}}
+      </div>
       <div style="margin-top:26px; border-top:1px solid var(--border); padding-top:22px;">
         <h1>Currency Conversion Rates</h1>
-        <p class="hint" style="margin:0 0 14px;">Set how much CAD equals 1 unit of the selected currency (e.g., 1 USD = 0.74 CAD).</p>
+        <p class="hint" style="margin:0 0 14px;">Rates say how much CAD equals 1 unit of a currency (e.g., 1 USD = 1.37 CAD). {{if and .FXSource (ne .FXSource "manual")}}Shared rates are updated automatically from {{if eq .FXSource "valet"}}the Bank of Canada{{else}}the configured rate feed{{end}}. {{end}}A rate you set or import is added to your history. It is used instead of the shared rate only if you tick "Use instead of the shared rate", until you remove it; without a shared rate your latest rate is used.{{if .FXStaleHours}} Rates older than {{.FXStaleHours}} hours are marked stale.{{end}}</p>
         <form method="post" action="/settings?client_id={{.ClientID}}">
           <input type="hidden" name="session_token" value="{{.SessionToken}}">
           <input type="hidden" name="form_type" value="currency">
@@ -1309,13 +2353,37 @@ const settingsHTML = `<!doctype html>
             {{end}}
           </select>
           <label for="rate_to_cad">Rate to CAD</label>
-          <input id="rate_to_cad" name="rate_to_cad" type="text" placeholder="0.74" required>
+          <input id="rate_to_cad" name="rate_to_cad" type="text" placeholder="1.37" required>
+          <label class="row">
+            <input type="checkbox" name="override" value="1">
+            <span>Use instead of the shared rate</span>
+          </label>
           <div class="actions">
             <button type="submit">Save Currency Rate</button>
           </div>
           {{if .CurrencyMessage}}<div class="message">{{.CurrencyMessage}}</div>{{end}}
         </form>
+        <form method="post" action="/settings?client_id={{.ClientID}}" enctype="multipart/form-data" style="margin-top:18px;">
+          <input type="hidden" name="session_token" value="{{.SessionToken}}">
+          <input type="hidden" name="form_type" value="currency_import">
+          <label for="import_currency_code">Import from a Bank of Canada Valet file (CSV or JSON)</label>
+          <select id="import_currency_code" name="currency_code" style="width:100%; border:1px solid var(--border); border-radius:10px; padding:11px 12px; font-size:14px; margin-bottom:14px; background:#fbfcfe;">
+            <option value="">Every currency in the file</option>
+            {{range .Currencies}}
+              <option value="{{.Code}}">{{.Label}}</option>
+            {{end}}
+          </select>
+          <input id="rates_file" name="rates_file" type="file" accept=".csv,.json" required>
+          <label class="row">
+            <input type="checkbox" name="override" value="1">
+            <span>Use instead of the shared rates</span>
+          </label>
+          <div class="actions">
+            <button type="submit">Import Rates</button>
+          </div>
+        </form>
         <div style="margin-top:18px;">
+          {{if .FXStale}}<p class="hint" style="margin:0 0 14px; color:#dc2626; font-weight:600;">The rates in use for {{.FXStale}} are older than {{.FXStaleHours}} hours and are still used for checkout. Update them or check the rate feed.</p>{{end}}
           {{if .CurrencyRates}}
             <div class="table-wrap">
               <table>
@@ -1323,7 +2391,10 @@ const settingsHTML = `<!doctype html>
                   <tr>
                     <th>Currency</th>
                     <th>Rate to CAD</th>
-                    <th>Updated</th>
+                    <th>Source</th>
+                    <th>Effective</th>
+                    <th>Version</th>
+                    <th></th>
                   </tr>
                 </thead>
                 <tbody>
@@ -1331,7 +2402,19 @@ const settingsHTML = `<!doctype html>
                   <tr>
                     <td>{{.CurrencyCode}}</td>
                     <td>{{printf "%.4f" .RateToCad}}</td>
-                    <td>{{.UpdatedAt}}</td>
+                    <td>{{.Source}}{{if .Override}} (your rate){{else if .Own}} (your rate, no shared rate){{end}}</td>
+                    <td>{{.EffectiveDate.Format "2006-01-02"}}{{if .Stale}} <span style="color:#dc2626; font-weight:600;">stale</span>{{end}}</td>
+                    <td>#{{.ID}}</td>
+                    <td>
+                      {{if .Override}}
+                      <form method="post" action="/settings?client_id={{$.ClientID}}">
+                        <input type="hidden" name="session_token" value="{{$.SessionToken}}">
+                        <input type="hidden" name="form_type" value="currency_delete">
+                        <input type="hidden" name="currency_code" value="{{.CurrencyCode}}">
+                        <button type="submit">Use Shared Rate</button>
+                      </form>
+                      {{end}}
+                    </td>
                   </tr>
                   {{end}}
                 </tbody>
@@ -1341,6 +2424,35 @@ const settingsHTML = `<!doctype html>
             <div class="empty">No currency rates configured.</div>
           {{end}}
         </div>
+        {{if .RateHistory}}
+          <p class="hint" style="margin:18px 0 14px;">Rate history, latest first. Quotes and labels record the version of the rate they were converted with.</p>
+          <div class="table-wrap">
+            <table>
+              <thead>
+                <tr>
+                  <th>Version</th>
+                  <th>Currency</th>
+                  <th>Rate to CAD</th>
+                  <th>Source</th>
+                  <th>Effective</th>
+                  <th>Added</th>
+                </tr>
+              </thead>
+              <tbody>
+                {{range .RateHistory}}
+                <tr>
+                  <td>#{{.ID}}</td>
+                  <td>{{.CurrencyCode}}</td>
+                  <td>{{printf "%.4f" .RateToCad}}</td>
+                  <td>{{.Source}}{{if .Own}} (your rate){{end}}</td>
+                  <td>{{.EffectiveDate.Format "2006-01-02"}}</td>
+                  <td>{{.CreatedAt.Format "2006-01-02 15:04"}}</td>
+                </tr>
+                {{end}}
+              </tbody>
+            </table>
+          </div>
+        {{end}}
       </div>
       <div class="danger-zone">
         <h2>Danger Zone</h2>
@@ -1448,6 +2560,16 @@ const settingsHTML = `<!doctype html>
     {{if ne .Widgets 2}}
     <div class="card panel {{if eq .ActiveTab "labels"}}active{{end}}" id="labels-panel" style="margin-top:20px;">
       <h1>Created Labels</h1>
+      {{if .ContractID}}
+      <form method="post" action="/settings?client_id={{.ClientID}}" class="actions" style="margin:0 0 14px;">
+        <input type="hidden" name="session_token" value="{{.SessionToken}}">
+        <input type="hidden" name="form_type" value="manifest_transmit">
+        {{if .Widgets}}<input type="hidden" name="widgets" value="{{.Widgets}}">{{end}}
+        <button type="submit">Transmit shipments</button>
+        <span class="hint">Sends pending contract shipments to Canada Post and retrieves the manifests.</span>
+      </form>
+      {{end}}
+      {{if .LabelsMessage}}<div class="message">{{.LabelsMessage}}</div>{{end}}
       <form method="get" action="/settings" class="filters">
         <input type="hidden" name="client_id" value="{{.ClientID}}">
         <input type="hidden" name="session_token" value="{{.SessionToken}}">
@@ -1468,11 +2590,15 @@ const settingsHTML = `<!doctype html>
               <th>Service Name</th>
               <th>Weight (kg)</th>
               <th>Tracking #</th>
+              <th>Tracking Status</th>
               <th>Shipping (CAD)</th>
+              <th>Margin (CAD)</th>
               <th>Delivery Date</th>
               <th>ETA (days)</th>
               <th>Created At</th>
+              <th>Manifest</th>
               <th>Label</th>
+              <th>Return</th>
             </tr>
           </thead>
           <tbody>
@@ -1489,19 +2615,56 @@ const settingsHTML = `<!doctype html>
                 <td>{{.ShipmentID}}</td>
                 <td>{{.ServiceName}}</td>
                 <td>{{printf "%.2f" .Weight}}</td>
-                <td>{{.TrackingNumber}}</td>
+                <td>
+                  {{if .TrackingNumber}}
+                    <a href="/tracking/{{.TrackingNumber}}?client_id={{$.ClientID}}&session_token={{$.SessionToken}}" target="_blank" rel="noopener">{{.TrackingNumber}}</a>
+                  {{else}}
+                    -
+                  {{end}}
+                </td>
+                <td>{{with index $.TrackingStatus .TrackingNumber}}{{.}}{{else}}-{{end}}</td>
                 <td>{{printf "%.2f" (div100 .ShippingChargesCents)}}</td>
+                <td>{{if gt .CustomerPriceCents 0}}{{printf "%+.2f" (div100 .MarginCents)}}{{else}}-{{end}}</td>
                 <td>{{.DeliveryDate}}</td>
                 <td>{{if gt .DeliveryDays 0}}{{.DeliveryDays}}{{else}}-{{end}}</td>
                 <td>{{.CreatedAt}}</td>
                 <td>
-                  <a href="/labels/{{.ID}}.pdf" target="_blank" rel="noopener">PDF</a>
+                  {{if .ManifestID}}
+                    <a href="/manifests/{{.ManifestID}}.pdf?client_id={{$.ClientID}}&session_token={{$.SessionToken}}" target="_blank" rel="noopener">{{.ManifestStatus}}</a>
+                  {{else if .ManifestStatus}}
+                    {{.ManifestStatus}}
+                  {{else if .GroupID}}
+                    pending
+                  {{else}}
+                    -
+                  {{end}}
+                </td>
+                <td>
+                  <a href="/labels/{{.ID}}.{{.LabelFileExtension}}" target="_blank" rel="noopener">{{if eq .LabelFileExtension "zpl"}}ZPL{{else}}PDF{{end}}</a>
+                  {{$labelID := .ID}}{{range .Artifacts}}{{if ne . "label"}}
+                    <br><a href="/labels/{{$labelID}}/{{.}}?client_id={{$.ClientID}}&session_token={{$.SessionToken}}" target="_blank" rel="noopener">{{.}}</a>
+                  {{end}}{{end}}
+                </td>
+                <td>
+                  {{if .ReturnOf}}
+                    <span class="hint">Return label</span>
+                  {{else}}{{with index $.ReturnLabels .ID}}
+                    <a href="/labels/{{.}}" target="_blank" rel="noopener">Return label</a>
+                  {{else}}
+                    <form method="post" action="/settings?client_id={{$.ClientID}}">
+                      <input type="hidden" name="session_token" value="{{$.SessionToken}}">
+                      {{if $.Widgets}}<input type="hidden" name="widgets" value="{{$.Widgets}}">{{end}}
+                      <input type="hidden" name="form_type" value="return_label">
+                      <input type="hidden" name="label_id" value="{{.ID}}">
+                      <button class="button-ghost default" type="submit">Create return</button>
+                    </form>
+                  {{end}}{{end}}
                 </td>
               </tr>
               {{end}}
             {{else}}
               <tr>
-                <td colspan="10" class="empty">No labels found.</td>
+                <td colspan="14" class="empty">No labels found.</td>
               </tr>
             {{end}}
           </tbody>
@@ -1520,6 +2683,189 @@ const settingsHTML = `<!doctype html>
       </div>
     </div>
     {{end}}
+    {{if ne .Widgets 2}}
+    <div class="card panel {{if eq .ActiveTab "pickups"}}active{{end}}" id="pickups-panel" style="margin-top:20px;">
+      <h1>Pickups</h1>
+      {{if .DefaultPostal}}
+        <div class="postal-default" style="margin-bottom:14px;">
+          <div>
+            <div class="hint">Pickup origin (default postal code)</div>
+            <code>{{.DefaultPostal}}</code>
+          </div>
+          {{if .PickupAvail}}
+            <div class="hint">
+              {{if .PickupAvail.OnDemandTour}}On-demand pickup available{{else}}No on-demand pickup{{end}}
+              {{if .PickupAvail.OnDemandCutoff}} &middot; same-day cutoff {{.PickupAvail.OnDemandCutoff}}{{end}}
+              {{if .PickupAvail.ScheduledPickupAvailable}} &middot; scheduled pickups available{{end}}
+            </div>
+          {{else if .PickupAvailErr}}
+            <div class="hint">{{.PickupAvailErr}}</div>
+          {{end}}
+        </div>
+        <form method="post" action="/settings?client_id={{.ClientID}}">
+          <input type="hidden" name="session_token" value="{{.SessionToken}}">
+          {{if .Widgets}}<input type="hidden" name="widgets" value="{{.Widgets}}">{{end}}
+          <input type="hidden" name="form_type" value="pickup_create">
+          <label for="pickup_date">Pickup Date</label>
+          <input id="pickup_date" name="pickup_date" type="date" min="{{.Today}}" value="{{.Today}}" required>
+          <label for="preferred_time">Ready From</label>
+          <input id="preferred_time" name="preferred_time" type="time" value="12:00" required>
+          <label for="closing_time">Closing Time</label>
+          <input id="closing_time" name="closing_time" type="time" value="17:00" required>
+          <label for="pickup_volume">Number of Items</label>
+          <input id="pickup_volume" name="pickup_volume" type="number" min="1" value="1" required>
+          <label for="contact_name">Contact Name</label>
+          <input id="contact_name" name="contact_name" type="text" required>
+          <label for="contact_email">Contact Email</label>
+          <input id="contact_email" name="contact_email" type="email" required>
+          <label for="contact_phone">Contact Phone</label>
+          <input id="contact_phone" name="contact_phone" type="text" required>
+          <label for="pickup_instructions">Pickup Instructions (optional)</label>
+          <input id="pickup_instructions" name="pickup_instructions" type="text" maxlength="132">
+          <p class="hint" style="margin:0 0 14px;">Leave the address empty to use the business address on your Canada Post account.</p>
+          <label for="pickup_company">Company (optional)</label>
+          <input id="pickup_company" name="pickup_company" type="text">
+          <label for="pickup_address">Street Address (optional)</label>
+          <input id="pickup_address" name="pickup_address" type="text">
+          <label for="pickup_city">City</label>
+          <input id="pickup_city" name="pickup_city" type="text">
+          <label for="pickup_province">Province</label>
+          <input id="pickup_province" name="pickup_province" type="text" maxlength="2" placeholder="ON">
+          <div class="actions">
+            <button type="submit">Schedule Pickup</button>
+          </div>
+        </form>
+      {{else}}
+        <div class="empty">Set a default postal code in the Post Office Finder to schedule pickups.</div>
+      {{end}}
+      {{if .PickupMessage}}<div class="message">{{.PickupMessage}}</div>{{end}}
+      <div class="table-wrap" style="margin-top:18px;">
+        <table>
+          <thead>
+            <tr>
+              <th>Request ID</th>
+              <th>Status</th>
+              <th>Date</th>
+              <th>Window</th>
+              <th>Items</th>
+              <th>Contact</th>
+              <th>Postal Code</th>
+              <th>Actions</th>
+            </tr>
+          </thead>
+          <tbody>
+            {{if .Pickups}}
+              {{range .Pickups}}
+              <tr>
+                <td>{{.RequestID}}</td>
+                <td>{{.Status}}</td>
+                <td>{{.PickupDate}}</td>
+                <td>{{.PreferredTime}} - {{.ClosingTime}}</td>
+                <td>{{.Volume}}</td>
+                <td>{{.ContactName}}</td>
+                <td>{{.PostalCode}}</td>
+                <td>
+                  {{if ne .Status "Cancelled"}}
+                  <form method="post" action="/settings?client_id={{$.ClientID}}" style="display:flex; gap:6px; align-items:center; flex-wrap:wrap;">
+                    <input type="hidden" name="session_token" value="{{$.SessionToken}}">
+                    {{if $.Widgets}}<input type="hidden" name="widgets" value="{{$.Widgets}}">{{end}}
+                    <input type="hidden" name="form_type" value="pickup_update">
+                    <input type="hidden" name="request_id" value="{{.RequestID}}">
+                    <input name="pickup_date" type="date" min="{{$.Today}}" value="{{.PickupDate}}" required style="margin:0; width:auto;">
+                    <input name="preferred_time" type="time" value="{{.PreferredTime}}" required style="margin:0; width:auto;">
+                    <input name="closing_time" type="time" value="{{.ClosingTime}}" required style="margin:0; width:auto;">
+                    <input name="pickup_volume" type="number" min="1" value="{{.Volume}}" required style="margin:0; width:70px;">
+                    <button class="button-ghost default" type="submit">Update</button>
+                  </form>
+                  <form method="post" action="/settings?client_id={{$.ClientID}}" style="margin-top:6px;">
+                    <input type="hidden" name="session_token" value="{{$.SessionToken}}">
+                    {{if $.Widgets}}<input type="hidden" name="widgets" value="{{$.Widgets}}">{{end}}
+                    <input type="hidden" name="form_type" value="pickup_cancel">
+                    <input type="hidden" name="request_id" value="{{.RequestID}}">
+                    <button class="button-ghost remove" type="submit">Cancel</button>
+                  </form>
+                  {{else}}
+                    -
+                  {{end}}
+                </td>
+              </tr>
+              {{end}}
+            {{else}}
+              <tr>
+                <td colspan="8" class="empty">No pickups scheduled.</td>
+              </tr>
+            {{end}}
+          </tbody>
+        </table>
+      </div>
+    </div>
+
+    <div class="card panel {{if eq .ActiveTab "reconciliation"}}active{{end}}" id="reconciliation-panel" style="margin-top:20px;">
+      <h1>Charge Reconciliation</h1>
+      <form method="get" action="/settings" class="filters">
+        <input type="hidden" name="client_id" value="{{.ClientID}}">
+        <input type="hidden" name="session_token" value="{{.SessionToken}}">
+        <input type="hidden" name="tab" value="reconciliation">
+        <label style="margin:0;font-weight:600;">From</label>
+        <input type="date" name="from" value="{{.FromDate}}">
+        <label style="margin:0;font-weight:600;">To</label>
+        <input type="date" name="to" value="{{.ToDate}}">
+        <button type="submit">Filter</button>
+      </form>
+      {{with .Reconciliation}}
+      <div class="hint" style="margin-bottom:14px;">
+        {{.LabelCount}} labels, {{.BilledCount}} with a Canada Post receipt{{if .MissingCount}} ({{.MissingCount}} without){{end}}.
+        Quoted {{printf "%.2f" (div100 .QuotedCents)}} CAD, billed {{printf "%.2f" (div100 .BilledCents)}} CAD, difference {{printf "%+.2f" (div100 .DifferenceCents)}} CAD.
+      </div>
+      {{end}}
+      <div class="table-wrap">
+        <table>
+          <thead>
+            <tr>
+              <th>Invoice UUID</th>
+              <th>Tracking #</th>
+              <th>Service Name</th>
+              <th>Quoted (CAD)</th>
+              <th>Base</th>
+              <th>Options</th>
+              <th>Taxes</th>
+              <th>Billed (CAD)</th>
+              <th>Difference</th>
+              <th>Created At</th>
+            </tr>
+          </thead>
+          <tbody>
+            {{if .Reconciliation.Discrepancies}}
+              {{range .Reconciliation.Discrepancies}}
+              <tr>
+                <td>
+                  {{if .InvoiceUUID}}
+                    <a href="https://devadmin.lexmodo.com/orders/{{.InvoiceUUID}}" target="_blank" rel="noopener">{{.InvoiceUUID}}</a>
+                  {{else}}
+                    -
+                  {{end}}
+                </td>
+                <td>{{if .TrackingNumber}}{{.TrackingNumber}}{{else}}-{{end}}</td>
+                <td>{{.ServiceName}}</td>
+                <td>{{printf "%.2f" (div100 .ShippingChargesCents)}}</td>
+                <td>{{printf "%.2f" (div100 .Billed.BaseCents)}}</td>
+                <td>{{printf "%.2f" (div100 .Billed.OptionsCents)}}</td>
+                <td>{{printf "%.2f" (div100 .Billed.TaxesCents)}}</td>
+                <td>{{printf "%.2f" (div100 .Billed.DueCents)}}</td>
+                <td>{{printf "%+.2f" (div100 (sub .Billed.DueCents .ShippingChargesCents))}}</td>
+                <td>{{.CreatedAt}}</td>
+              </tr>
+              {{end}}
+            {{else}}
+              <tr>
+                <td colspan="10" class="empty">No differences between quoted and billed charges.</td>
+              </tr>
+            {{end}}
+          </tbody>
+        </table>
+      </div>
+    </div>
+    {{end}}
   </div>
   <script>
     const tabs = document.querySelectorAll('.tab');
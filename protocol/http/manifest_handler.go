@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"lexmodo-plugin/service"
+)
+
+// manifestHandler serves stored Canada Post manifest PDFs at /manifests/{id}.pdf
+// to the client whose labels are on the manifest.
+func (a *App) manifestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientID := parseClientID(r.URL.Query().Get("client_id"))
+	sessionToken := strings.TrimSpace(r.URL.Query().Get("session_token"))
+	if clientID == 0 || sessionToken == "" {
+		http.Error(w, "client_id and session_token are required", http.StatusBadRequest)
+		return
+	}
+	if !a.validateSessionToken(clientID, sessionToken) && !isValidJWTSessionForClient(clientID, sessionToken) {
+		http.Error(w, "invalid or expired session token", http.StatusUnauthorized)
+		return
+	}
+
+	manifestID := strings.Trim(strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/manifests/")), "/")
+	manifestID = strings.TrimSuffix(path.Base(manifestID), ".pdf")
+	if manifestID == "" || manifestID == "." || strings.Contains(manifestID, "\\") || strings.Contains(manifestID, "..") {
+		http.NotFound(w, r)
+		return
+	}
+	owned, err := a.Store.ManifestBelongsToClient(clientID, manifestID)
+	if err != nil {
+		log.Println("failed to load manifest labels:", err)
+		http.Error(w, "failed to load manifest", http.StatusInternalServerError)
+		return
+	}
+	// Another store's manifest is reported as missing.
+	if !owned {
+		http.NotFound(w, r)
+		return
+	}
+
+	filePath := service.ManifestFilePath(a.Config.LabelStoragePath, manifestID)
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "failed to open manifest", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"manifest-%s.pdf\"", manifestID))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, file); err != nil {
+		log.Println("failed to write manifest response:", err)
+	}
+}
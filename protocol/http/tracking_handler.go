@@ -0,0 +1,171 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"lexmodo-plugin/database"
+	"lexmodo-plugin/service"
+)
+
+type trackingEventJSON struct {
+	Identifier    string `json:"identifier"`
+	Description   string `json:"description"`
+	Site          string `json:"site,omitempty"`
+	Province      string `json:"province,omitempty"`
+	SignatoryName string `json:"signatory_name,omitempty"`
+	OccurredAt    string `json:"occurred_at,omitempty"`
+	LocalDate     string `json:"local_date"`
+	LocalTime     string `json:"local_time"`
+	TimeZone      string `json:"time_zone,omitempty"`
+}
+
+type trackingResponseJSON struct {
+	TrackingNumber       string              `json:"tracking_number"`
+	LabelID              string              `json:"label_id"`
+	InvoiceUUID          string              `json:"invoice_uuid"`
+	ServiceName          string              `json:"service_name"`
+	ExpectedDeliveryDate string              `json:"expected_delivery_date,omitempty"`
+	ChangedDeliveryDate  string              `json:"changed_delivery_date,omitempty"`
+	ChangedReason        string              `json:"changed_reason,omitempty"`
+	MailedOnDate         string              `json:"mailed_on_date,omitempty"`
+	ActualDeliveryDate   string              `json:"actual_delivery_date,omitempty"`
+	AttemptedDate        string              `json:"attempted_date,omitempty"`
+	LatestEvent          string              `json:"latest_event,omitempty"`
+	LatestEventLocation  string              `json:"latest_event_location,omitempty"`
+	Source               string              `json:"source"`
+	Warning              string              `json:"warning,omitempty"`
+	Events               []trackingEventJSON `json:"events"`
+}
+
+// trackingHandler serves GET /tracking/{pin} as JSON. Events are refreshed from
+// Canada Post on every call, along with the PIN's summary; stored events are
+// returned when the live call fails.
+func (a *App) trackingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientID := parseClientID(r.URL.Query().Get("client_id"))
+	sessionToken := strings.TrimSpace(r.URL.Query().Get("session_token"))
+	if clientID == 0 || sessionToken == "" {
+		http.Error(w, "client_id and session_token are required", http.StatusBadRequest)
+		return
+	}
+	if !a.validateSessionToken(clientID, sessionToken) && !isValidJWTSessionForClient(clientID, sessionToken) {
+		http.Error(w, "invalid or expired session token", http.StatusUnauthorized)
+		return
+	}
+
+	pin := strings.Trim(strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/tracking/")), "/")
+	if pin == "" || strings.ContainsAny(pin, "/\\.") {
+		http.NotFound(w, r)
+		return
+	}
+
+	record, err := a.Store.LoadLabelRecordByTrackingNumber(pin)
+	if err != nil {
+		log.Println("failed to load label record:", err)
+		http.Error(w, "failed to load label", http.StatusInternalServerError)
+		return
+	}
+	// Another store's label is reported as missing.
+	if record.ID == "" || !record.OwnedBy(clientID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	resp := trackingResponseJSON{
+		TrackingNumber: pin,
+		LabelID:        record.ID,
+		InvoiceUUID:    record.InvoiceUUID,
+		ServiceName:    record.ServiceName,
+		Source:         "live",
+	}
+	tracking := a.trackingService()
+	result, err := tracking.Refresh(r.Context(), pin)
+	if err != nil {
+		log.Printf("tracking refresh failed: pin=%s err=%v", pin, err)
+		events, loadErr := tracking.StoredEvents(pin)
+		if loadErr != nil {
+			log.Println("failed to load stored tracking events:", loadErr)
+			http.Error(w, "failed to load tracking", http.StatusBadGateway)
+			return
+		}
+		resp.Source = "stored"
+		resp.Warning = "Canada Post tracking is unavailable; showing last stored events."
+		result = service.TrackingResult{Events: events}
+	}
+	if result.ServiceName != "" {
+		resp.ServiceName = result.ServiceName
+	}
+	resp.ExpectedDeliveryDate = result.ExpectedDeliveryDate
+	resp.ChangedDeliveryDate = result.ChangedDeliveryDate
+	resp.ChangedReason = result.ChangedReason
+	resp.Events = trackingEventsJSON(result.Events)
+	if resp.Source == "live" {
+		if summary, err := tracking.Summary(r.Context(), pin); err != nil {
+			log.Printf("tracking summary failed: pin=%s err=%v", pin, err)
+		} else {
+			resp.MailedOnDate = summary.MailedOnDate
+			resp.ActualDeliveryDate = summary.ActualDeliveryDate
+			resp.AttemptedDate = summary.AttemptedDate
+			resp.LatestEvent = summary.EventDescription
+			resp.LatestEventLocation = summary.EventLocation
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Println("failed to write tracking response:", err)
+	}
+}
+
+func (a *App) trackingService() *service.TrackingService {
+	client := service.NewCanadaPostClient(
+		a.Config.CanadaPost.Username,
+		a.Config.CanadaPost.Password,
+		a.Config.CanadaPost.CustomerNumber,
+		a.Config.CanadaPost.BaseURL,
+	)
+	return service.NewTrackingService(client, a.Store)
+}
+
+func trackingEventsJSON(events []database.TrackingEvent) []trackingEventJSON {
+	out := make([]trackingEventJSON, 0, len(events))
+	for _, ev := range events {
+		item := trackingEventJSON{
+			Identifier:    ev.Identifier,
+			Description:   ev.Description,
+			Site:          ev.Site,
+			Province:      ev.Province,
+			SignatoryName: ev.SignatoryName,
+			LocalDate:     ev.EventDate,
+			LocalTime:     ev.EventTime,
+			TimeZone:      ev.EventTimeZone,
+		}
+		if !ev.OccurredAt.IsZero() {
+			item.OccurredAt = ev.OccurredAt.UTC().Format(time.RFC3339)
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+// trackingStatusByPin summarizes the latest stored event for each label row.
+func trackingStatusByPin(latest map[string]database.TrackingEvent) map[string]string {
+	status := make(map[string]string, len(latest))
+	for pin, ev := range latest {
+		text := ev.Description
+		if ev.EventDate != "" {
+			text += " (" + ev.EventDate + ")"
+		}
+		status[pin] = strings.TrimSpace(text)
+	}
+	return status
+}
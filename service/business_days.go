@@ -0,0 +1,262 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"lexmodo-plugin/database"
+)
+
+// holiday is a statutory holiday: a fixed date, or the nth weekday of a
+// month (n = -1 for the last one before the 25th, as for Victoria Day).
+type holiday struct {
+	name    string
+	month   time.Month
+	day     int
+	weekday time.Weekday
+	nth     int
+}
+
+// federalHolidays are the general holidays of the Canada Labour Code, which
+// Canada Post observes everywhere, except Good Friday, which moves with
+// Easter.
+var federalHolidays = []holiday{
+	{name: "New Year's Day", month: time.January, day: 1},
+	{name: "Victoria Day", month: time.May, weekday: time.Monday, nth: -1},
+	{name: "Canada Day", month: time.July, day: 1},
+	{name: "Labour Day", month: time.September, weekday: time.Monday, nth: 1},
+	{name: "National Day for Truth and Reconciliation", month: time.September, day: 30},
+	{name: "Thanksgiving", month: time.October, weekday: time.Monday, nth: 2},
+	{name: "Remembrance Day", month: time.November, day: 11},
+	{name: "Christmas Day", month: time.December, day: 25},
+	{name: "Boxing Day", month: time.December, day: 26},
+}
+
+// provincialHolidays are each province's and territory's general holidays
+// on top of the federal ones.
+var provincialHolidays = map[string][]holiday{
+	"AB": {{name: "Family Day", month: time.February, weekday: time.Monday, nth: 3}},
+	"BC": {
+		{name: "Family Day", month: time.February, weekday: time.Monday, nth: 3},
+		{name: "British Columbia Day", month: time.August, weekday: time.Monday, nth: 1},
+	},
+	"MB": {{name: "Louis Riel Day", month: time.February, weekday: time.Monday, nth: 3}},
+	"NB": {
+		{name: "Family Day", month: time.February, weekday: time.Monday, nth: 3},
+		{name: "New Brunswick Day", month: time.August, weekday: time.Monday, nth: 1},
+	},
+	"NS": {{name: "Heritage Day", month: time.February, weekday: time.Monday, nth: 3}},
+	"NT": {
+		{name: "National Indigenous Peoples Day", month: time.June, day: 21},
+		{name: "Civic Holiday", month: time.August, weekday: time.Monday, nth: 1},
+	},
+	"NU": {
+		{name: "Nunavut Day", month: time.July, day: 9},
+		{name: "Civic Holiday", month: time.August, weekday: time.Monday, nth: 1},
+	},
+	"ON": {{name: "Family Day", month: time.February, weekday: time.Monday, nth: 3}},
+	"PE": {{name: "Islander Day", month: time.February, weekday: time.Monday, nth: 3}},
+	"QC": {{name: "Fête nationale", month: time.June, day: 24}},
+	"SK": {
+		{name: "Family Day", month: time.February, weekday: time.Monday, nth: 3},
+		{name: "Saskatchewan Day", month: time.August, weekday: time.Monday, nth: 1},
+	},
+	"YT": {
+		{name: "National Indigenous Peoples Day", month: time.June, day: 21},
+		{name: "Discovery Day", month: time.August, weekday: time.Monday, nth: 3},
+	},
+}
+
+// date is the holiday's date in year.
+func (h holiday) date(year int) time.Time {
+	if h.nth == 0 {
+		return time.Date(year, h.month, h.day, 0, 0, 0, 0, time.UTC)
+	}
+	if h.nth < 0 {
+		// The last weekday before the 25th.
+		day := time.Date(year, h.month, 24, 0, 0, 0, 0, time.UTC)
+		for day.Weekday() != h.weekday {
+			day = day.AddDate(0, 0, -1)
+		}
+		return day
+	}
+	day := time.Date(year, h.month, 1, 0, 0, 0, 0, time.UTC)
+	for day.Weekday() != h.weekday {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day.AddDate(0, 0, 7*(h.nth-1))
+}
+
+// easterSunday uses the anonymous Gregorian algorithm.
+func easterSunday(year int) time.Time {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// canadianHolidays returns the days off in year for the province, empty for
+// federal holidays only, keyed by date. A fixed-date holiday on a weekend is
+// observed on the next weekday that is not already a holiday.
+func canadianHolidays(year int, province string) map[string]string {
+	list := append([]holiday{}, federalHolidays...)
+	list = append(list, provincialHolidays[strings.ToUpper(strings.TrimSpace(province))]...)
+
+	days := map[string]string{
+		easterSunday(year).AddDate(0, 0, -2).Format("2006-01-02"): "Good Friday",
+	}
+	for _, h := range list {
+		if h.nth != 0 {
+			days[h.date(year).Format("2006-01-02")] = h.name
+		}
+	}
+	for month := time.January; month <= time.December; month++ {
+		for _, h := range list {
+			if h.nth != 0 || h.month != month {
+				continue
+			}
+			day := h.date(year)
+			for isWeekend(day) || days[day.Format("2006-01-02")] != "" {
+				day = day.AddDate(0, 0, 1)
+			}
+			days[day.Format("2006-01-02")] = h.name
+		}
+	}
+	return days
+}
+
+func isWeekend(day time.Time) bool {
+	return day.Weekday() == time.Saturday || day.Weekday() == time.Sunday
+}
+
+// businessCalendar answers whether a day is a business day in a province,
+// computing each year's holidays once.
+type businessCalendar struct {
+	province string
+	years    map[int]map[string]string
+}
+
+func newBusinessCalendar(province string) *businessCalendar {
+	return &businessCalendar{province: province, years: map[int]map[string]string{}}
+}
+
+func (c *businessCalendar) isBusinessDay(day time.Time) bool {
+	if isWeekend(day) {
+		return false
+	}
+	holidays, ok := c.years[day.Year()]
+	if !ok {
+		holidays = canadianHolidays(day.Year(), c.province)
+		c.years[day.Year()] = holidays
+	}
+	return holidays[day.Format("2006-01-02")] == ""
+}
+
+// addBusinessDays is the business day n business days after day; with n of
+// 0 it is day, or the next business day if day is not one.
+func (c *businessCalendar) addBusinessDays(day time.Time, n int) time.Time {
+	for !c.isBusinessDay(day) {
+		day = day.AddDate(0, 0, 1)
+	}
+	for ; n > 0; n-- {
+		day = day.AddDate(0, 0, 1)
+		for !c.isBusinessDay(day) {
+			day = day.AddDate(0, 0, 1)
+		}
+	}
+	return day
+}
+
+// businessDaysBetween counts the business days after from, up to and
+// including to.
+func (c *businessCalendar) businessDaysBetween(from, to time.Time) int {
+	days := 0
+	for day := from.AddDate(0, 0, 1); !day.After(to); day = day.AddDate(0, 0, 1) {
+		if c.isBusinessDay(day) {
+			days++
+		}
+	}
+	return days
+}
+
+// calendarDate is the date of t in its location, at midnight UTC, so that
+// dates from different time zones compare by day.
+func calendarDate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// deliveryEstimate dates an order: the day it was placed in the merchant's
+// time zone and the day it will be mailed from the origin province.
+type deliveryEstimate struct {
+	OrderDate   time.Time
+	MailingDate time.Time
+	mailing     *businessCalendar
+	delivery    *businessCalendar
+}
+
+// newDeliveryEstimate applies the client's cutoff and handling days to an
+// order placed at now. Orders after the cutoff or on a day off count as
+// received the next business day; handling days are business days after
+// that. Delivery days are counted with the destination province's holidays
+// for Canadian addresses and federal holidays otherwise.
+func newDeliveryEstimate(now time.Time, schedule database.DeliverySchedule, originProvince string, dest canadaPostDestination) deliveryEstimate {
+	local := now.In(schedule.Location())
+	orderDate := calendarDate(local)
+	received := orderDate
+	if schedule.OrderCutoff != "" {
+		if cutoff, err := time.Parse("15:04", schedule.OrderCutoff); err == nil &&
+			local.Hour()*60+local.Minute() >= cutoff.Hour()*60+cutoff.Minute() {
+			received = received.AddDate(0, 0, 1)
+		}
+	}
+	origin := newBusinessCalendar(originProvince)
+	received = origin.addBusinessDays(received, 0)
+
+	destProvince := ""
+	if strings.EqualFold(strings.TrimSpace(dest.Country), "CA") {
+		destProvince = dest.Province
+	}
+	return deliveryEstimate{
+		OrderDate:   orderDate,
+		MailingDate: origin.addBusinessDays(received, schedule.HandlingDays),
+		mailing:     origin,
+		delivery:    newBusinessCalendar(destProvince),
+	}
+}
+
+// apply dates the candidates from the mailing date. A quote with only a
+// transit time is delivered that many business days after mailing, and
+// delivery days are the business days from the order to the delivery.
+func (e deliveryEstimate) apply(candidates []rateCandidate) []rateCandidate {
+	for i := range candidates {
+		candidate := &candidates[i]
+		delivery, ok := parseDeliveryDate(&candidate.DeliveryDate)
+		if !ok {
+			if candidate.DeliveryDays == 0 {
+				continue
+			}
+			delivery = e.delivery.addBusinessDays(e.MailingDate, int(candidate.DeliveryDays))
+			candidate.DeliveryDate = delivery.Format("2006-01-02")
+		}
+		candidate.DeliveryDays = uint32(e.delivery.businessDaysBetween(e.OrderDate, calendarDate(delivery)))
+	}
+	return candidates
+}
+
+// snapshotDeliveryDays is the delivery days the customer was shown.
+// Snapshots from before business-day estimates only have the date.
+func snapshotDeliveryDays(snapshot RateSnapshot) int {
+	if snapshot.DeliveryDays > 0 {
+		return int(snapshot.DeliveryDays)
+	}
+	return int(deliveryDaysFromDeliveryDate(&snapshot.DeliveryDate))
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"lexmodo-plugin/database"
+)
+
+func TestCanadianHolidays(t *testing.T) {
+	ontario := canadianHolidays(2026, "ON")
+	for date, name := range map[string]string{
+		"2026-02-16": "Family Day",
+		"2026-04-03": "Good Friday",
+		"2026-05-18": "Victoria Day",
+		"2026-10-12": "Thanksgiving",
+		"2026-12-25": "Christmas Day",
+		// Boxing Day is a Saturday.
+		"2026-12-28": "Boxing Day",
+	} {
+		if ontario[date] != name {
+			t.Fatalf("expected %s on %s, got %q", name, date, ontario[date])
+		}
+	}
+	if quebec := canadianHolidays(2026, "QC"); quebec["2026-06-24"] == "" || quebec["2026-02-16"] != "" {
+		t.Fatalf("unexpected Quebec holidays %v", quebec)
+	}
+	// Christmas and Boxing Day on a weekend are both moved.
+	if federal := canadianHolidays(2027, ""); federal["2027-12-27"] != "Christmas Day" || federal["2027-12-28"] != "Boxing Day" {
+		t.Fatalf("unexpected observed holidays %v", federal)
+	}
+}
+
+func TestDeliveryEstimateSkipsCutoffWeekendsAndHolidays(t *testing.T) {
+	schedule := database.DeliverySchedule{HandlingDays: 1, OrderCutoff: "14:00", TimeZone: "America/Toronto"}
+	// Thursday December 24, 15:30 in Toronto: after the cutoff, and the next
+	// three days are Christmas and a weekend, with Boxing Day moved to Monday.
+	now := time.Date(2026, time.December, 24, 20, 30, 0, 0, time.UTC)
+	estimate := newDeliveryEstimate(now, schedule, "ON", canadaPostDestination{Country: "CA", Province: "ON"})
+	if got := estimate.MailingDate.Format("2006-01-02"); got != "2026-12-30" {
+		t.Fatalf("expected mailing on December 30, got %s", got)
+	}
+
+	candidates := estimate.apply([]rateCandidate{
+		{ServiceCode: "DOM.EP", DeliveryDays: 2},
+		{ServiceCode: "DOM.RP", DeliveryDate: "2027-01-05", DeliveryDays: 4},
+		{ServiceCode: "DOM.XP"},
+	})
+	// Two business days after the 30th skip New Year's Day and the weekend.
+	if candidates[0].DeliveryDate != "2027-01-04" || candidates[0].DeliveryDays != 4 {
+		t.Fatalf("unexpected Expedited estimate %+v", candidates[0])
+	}
+	if candidates[1].DeliveryDate != "2027-01-05" || candidates[1].DeliveryDays != 5 {
+		t.Fatalf("unexpected Regular estimate %+v", candidates[1])
+	}
+	if candidates[2].DeliveryDate != "" || candidates[2].DeliveryDays != 0 {
+		t.Fatalf("expected no estimate without a service standard, got %+v", candidates[2])
+	}
+
+	// Before the cutoff on a business day, without handling days, the order
+	// is mailed the same day.
+	morning := time.Date(2026, time.December, 23, 14, 0, 0, 0, time.UTC)
+	estimate = newDeliveryEstimate(morning, database.DeliverySchedule{OrderCutoff: "14:00", TimeZone: "America/Toronto"}, "ON", canadaPostDestination{Country: "US"})
+	if got := estimate.MailingDate.Format("2006-01-02"); got != "2026-12-23" {
+		t.Fatalf("expected same-day mailing, got %s", got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -22,8 +23,17 @@ type CanadaPostClient struct {
 	HTTPClient     *http.Client
 }
 
+// canadaPostClientTimeout bounds a whole call, retries included; each
+// attempt has its own shorter timeout in canadaPostTransport.
+const canadaPostClientTimeout = 60 * time.Second
+
+// canadaPostRatesTimeout bounds a whole GetRates call, retries included.
+// Rates are quoted while the customer waits at checkout, where fallback
+// rates are better than a minute-long wait.
+const canadaPostRatesTimeout = 15 * time.Second
+
 func NewCanadaPostClient(username, password, customerNumber, baseURL string) *CanadaPostClient {
-	client := &http.Client{Timeout: 20 * time.Second}
+	client := &http.Client{Timeout: canadaPostClientTimeout, Transport: canadaPostTransport}
 	return &CanadaPostClient{
 		BaseURL:        baseURL,
 		Username:       username,
@@ -41,6 +51,8 @@ func (c *CanadaPostClient) GetRates(ctx context.Context, req *RateRequest) (*Rat
 		return nil, fmt.Errorf("rate request is nil")
 	}
 	req.XMLNS = "http://www.canadapost.ca/ws/ship/rate-v4"
+	ctx, cancel := context.WithTimeout(ctx, canadaPostRatesTimeout)
+	defer cancel()
 
 	xmlData, err := xml.MarshalIndent(req, "", "  ")
 	if err != nil {
@@ -48,8 +60,9 @@ func (c *CanadaPostClient) GetRates(ctx context.Context, req *RateRequest) (*Rat
 	}
 	log.Printf("Canada Post request XML:\n%s\n", string(xmlData))
 
+	// Rating doesn't create anything, so it is retried like a GET.
 	url := c.BaseURL + "/rs/ship/price"
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(xmlData))
+	httpReq, err := http.NewRequestWithContext(withIdempotent(ctx), http.MethodPost, url, bytes.NewBuffer(xmlData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -73,7 +86,7 @@ func (c *CanadaPostClient) GetRates(ctx context.Context, req *RateRequest) (*Rat
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		return nil, newCanadaPostError(resp.StatusCode, body)
 	}
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -127,7 +140,7 @@ func (c *CanadaPostClient) CreateShipment(ctx context.Context, req *ShipmentRequ
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		return nil, newCanadaPostError(resp.StatusCode, body)
 	}
 
 	var shipmentResp ShipmentResponse
@@ -138,6 +151,88 @@ func (c *CanadaPostClient) CreateShipment(ctx context.Context, req *ShipmentRequ
 	return &shipmentResp, nil
 }
 
+// CreateContractShipment creates a shipment under a commercial contract.
+// mailedBy is the Canada Post customer number that owns the contract.
+func (c *CanadaPostClient) CreateContractShipment(ctx context.Context, mailedBy string, req *ContractShipmentRequest) (*ShipmentResponse, error) {
+	if c == nil {
+		return nil, fmt.Errorf("canada post client is nil")
+	}
+	if req == nil {
+		return nil, fmt.Errorf("shipment request is nil")
+	}
+	mailedBy = strings.TrimSpace(mailedBy)
+	if mailedBy == "" {
+		mailedBy = strings.TrimSpace(c.CustomerNumber)
+	}
+	if mailedBy == "" {
+		return nil, fmt.Errorf("customer number is required for contract shipments")
+	}
+
+	req.XMLNS = "http://www.canadapost.ca/ws/shipment-v8"
+
+	xmlData, err := xml.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	log.Println("ContractShipmentRequest XML to Canada Post:\n", string(xmlData))
+
+	url := fmt.Sprintf("%s/rs/%s/%s/shipment", c.BaseURL, mailedBy, mailedBy)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(xmlData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/vnd.cpc.shipment-v8+xml")
+	httpReq.Header.Set("Accept", "application/vnd.cpc.shipment-v8+xml")
+	httpReq.Header.Set("Accept-Language", "en-CA")
+	httpReq.SetBasicAuth(c.Username, c.Password)
+
+	resp, err := c.httpClient().Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newCanadaPostError(resp.StatusCode, body)
+	}
+
+	var contractResp ContractShipmentResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&contractResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	shipment := &ShipmentResponse{
+		ShipmentID:  contractResp.ShipmentID,
+		TrackingPIN: contractResp.TrackingPIN,
+		GroupID:     req.GroupID,
+	}
+	shipment.Links.Link = contractResp.Links.Link
+	return shipment, nil
+}
+
+// VoidShipment voids a contract shipment that has not been transmitted yet,
+// through the self link returned when it was created.
+func (c *CanadaPostClient) VoidShipment(ctx context.Context, shipmentURL string) error {
+	if c == nil {
+		return fmt.Errorf("canada post client is nil")
+	}
+	shipmentURL = strings.TrimSpace(shipmentURL)
+	if shipmentURL == "" {
+		return fmt.Errorf("shipment url is required")
+	}
+	status, body, err := c.sendResource(ctx, http.MethodDelete, shipmentURL, "application/vnd.cpc.shipment-v8+xml", nil, "VoidShipment")
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusNoContent {
+		return newCanadaPostError(status, body)
+	}
+	return nil
+}
+
 func (c *CanadaPostClient) FindPostOffices(ctx context.Context, postalCode string) ([]PostOffice, error) {
 	if c == nil {
 		return nil, fmt.Errorf("canada post client is nil")
@@ -171,7 +266,7 @@ func (c *CanadaPostClient) FindPostOffices(ctx context.Context, postalCode strin
 	}
 	logResponseBody("FindPostOffices", resp.StatusCode, body)
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Canada Post API error %d: %s", resp.StatusCode, string(body))
+		return nil, newCanadaPostError(resp.StatusCode, body)
 	}
 
 	var postOfficeList PostOfficeListXML
@@ -198,6 +293,506 @@ func (c *CanadaPostClient) FindPostOffices(ctx context.Context, postalCode strin
 	return offices, nil
 }
 
+// DiscoverServices lists the services Canada Post currently offers. An empty
+// country returns services for every destination.
+func (c *CanadaPostClient) DiscoverServices(ctx context.Context, country string) ([]ServiceSummaryXML, error) {
+	if c == nil {
+		return nil, fmt.Errorf("canada post client is nil")
+	}
+	baseURL := strings.TrimRight(strings.TrimSpace(c.BaseURL), "/")
+	endpoint := baseURL + "/rs/ship/service"
+	if country = strings.ToUpper(strings.TrimSpace(country)); country != "" {
+		endpoint += "?country=" + url.QueryEscape(country)
+	}
+	status, body, err := c.getResource(ctx, endpoint, "application/vnd.cpc.ship.rate-v4+xml", "DiscoverServices")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, newCanadaPostError(status, body)
+	}
+	var services ServicesXML
+	if err := xml.Unmarshal(body, &services); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	return services.Services, nil
+}
+
+// GetService returns options and restrictions of a service. serviceURL is the
+// link returned by DiscoverServices.
+func (c *CanadaPostClient) GetService(ctx context.Context, serviceURL string) (*ServiceInfoXML, error) {
+	if c == nil {
+		return nil, fmt.Errorf("canada post client is nil")
+	}
+	if strings.TrimSpace(serviceURL) == "" {
+		return nil, fmt.Errorf("service url is required")
+	}
+	status, body, err := c.getResource(ctx, serviceURL, "application/vnd.cpc.ship.rate-v4+xml", "GetService")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, newCanadaPostError(status, body)
+	}
+	var info ServiceInfoXML
+	if err := xml.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	return &info, nil
+}
+
+// GetOption returns the rules of an option: the options it conflicts with,
+// the options it needs and whether it takes a qualifier. optionURL is the link
+// returned by GetService.
+func (c *CanadaPostClient) GetOption(ctx context.Context, optionURL string) (*OptionInfoXML, error) {
+	if c == nil {
+		return nil, fmt.Errorf("canada post client is nil")
+	}
+	if strings.TrimSpace(optionURL) == "" {
+		return nil, fmt.Errorf("option url is required")
+	}
+	status, body, err := c.getResource(ctx, optionURL, "application/vnd.cpc.ship.rate-v4+xml", "GetOption")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, newCanadaPostError(status, body)
+	}
+	var info OptionInfoXML
+	if err := xml.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	return &info, nil
+}
+
+// GetShipmentReceipt returns what Canada Post charged for a non-contract
+// shipment. receiptURL is the "receipt" link of the created shipment.
+func (c *CanadaPostClient) GetShipmentReceipt(ctx context.Context, receiptURL string) (*ShipmentReceiptXML, error) {
+	if c == nil {
+		return nil, fmt.Errorf("canada post client is nil")
+	}
+	if strings.TrimSpace(receiptURL) == "" {
+		return nil, fmt.Errorf("receipt url is required")
+	}
+	status, body, err := c.getResource(ctx, receiptURL, "application/vnd.cpc.ncshipment-v4+xml", "GetShipmentReceipt")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, newCanadaPostError(status, body)
+	}
+	var receipt ShipmentReceiptXML
+	if err := xml.Unmarshal(body, &receipt); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	return &receipt, nil
+}
+
+// GetShipmentPrice returns the final price of a contract shipment. priceURL
+// is the "price" link of the created shipment.
+func (c *CanadaPostClient) GetShipmentPrice(ctx context.Context, priceURL string) (*ShipmentPriceXML, error) {
+	if c == nil {
+		return nil, fmt.Errorf("canada post client is nil")
+	}
+	if strings.TrimSpace(priceURL) == "" {
+		return nil, fmt.Errorf("price url is required")
+	}
+	status, body, err := c.getResource(ctx, priceURL, "application/vnd.cpc.shipment-v8+xml", "GetShipmentPrice")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, newCanadaPostError(status, body)
+	}
+	var price ShipmentPriceXML
+	if err := xml.Unmarshal(body, &price); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	return &price, nil
+}
+
+// errTrackingNoHistory is returned when Canada Post has no scans for a PIN yet
+// (message 004), which is normal right after a label is created.
+var errTrackingNoHistory = errors.New("no tracking history for pin")
+
+func (c *CanadaPostClient) GetTrackingSummary(ctx context.Context, pin string) (*PinSummaryXML, error) {
+	body, err := c.getTracking(ctx, pin, "summary")
+	if err != nil {
+		return nil, err
+	}
+	var summary TrackingSummaryXML
+	if err := xml.Unmarshal(body, &summary); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	if len(summary.PinSummary) == 0 {
+		return nil, errTrackingNoHistory
+	}
+	return &summary.PinSummary[0], nil
+}
+
+func (c *CanadaPostClient) GetTrackingDetails(ctx context.Context, pin string) (*TrackingDetailXML, error) {
+	body, err := c.getTracking(ctx, pin, "detail")
+	if err != nil {
+		return nil, err
+	}
+	var detail TrackingDetailXML
+	if err := xml.Unmarshal(body, &detail); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	return &detail, nil
+}
+
+func (c *CanadaPostClient) getTracking(ctx context.Context, pin string, view string) ([]byte, error) {
+	if c == nil {
+		return nil, fmt.Errorf("canada post client is nil")
+	}
+	pin = strings.TrimSpace(pin)
+	if pin == "" {
+		return nil, fmt.Errorf("tracking pin is required")
+	}
+
+	baseURL := strings.TrimRight(strings.TrimSpace(c.BaseURL), "/")
+	endpoint := fmt.Sprintf("%s/vis/track/pin/%s/%s", baseURL, url.PathEscape(pin), view)
+	status, body, err := c.getResource(ctx, endpoint, "application/vnd.cpc.track-v2+xml", "Tracking")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		cpErr := newCanadaPostError(status, body)
+		if cpErr.Code == "004" {
+			return nil, errTrackingNoHistory
+		}
+		return nil, cpErr
+	}
+	return body, nil
+}
+
+// getResource performs an authenticated GET and returns the status and body.
+func (c *CanadaPostClient) getResource(ctx context.Context, endpoint, accept, tag string) (int, []byte, error) {
+	return c.sendResource(ctx, http.MethodGet, endpoint, accept, nil, tag)
+}
+
+// sendResource performs an authenticated request. A non-nil payload is sent
+// with the same media type as accept.
+func (c *CanadaPostClient) sendResource(ctx context.Context, method, endpoint, accept string, payload []byte, tag string) (int, []byte, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if payload != nil {
+		httpReq.Header.Set("Content-Type", accept)
+	}
+	httpReq.Header.Set("Accept", accept)
+	httpReq.Header.Set("Accept-Language", "en-CA")
+	httpReq.SetBasicAuth(c.Username, c.Password)
+	logRequestOut(httpReq)
+
+	resp, err := c.httpClient().Do(httpReq)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to call Canada Post: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	logResponseBody(tag, resp.StatusCode, body)
+	return resp.StatusCode, body, nil
+}
+
+// TransmitShipments transmits the given shipment groups and returns the
+// manifest links created by Canada Post.
+func (c *CanadaPostClient) TransmitShipments(ctx context.Context, mailedBy string, req *TransmitSetRequest) ([]Link, error) {
+	if c == nil {
+		return nil, fmt.Errorf("canada post client is nil")
+	}
+	if req == nil || len(req.GroupIDs) == 0 {
+		return nil, fmt.Errorf("at least one group id is required")
+	}
+	mailedBy = strings.TrimSpace(mailedBy)
+	if mailedBy == "" {
+		mailedBy = strings.TrimSpace(c.CustomerNumber)
+	}
+	if mailedBy == "" {
+		return nil, fmt.Errorf("customer number is required to transmit shipments")
+	}
+
+	req.XMLNS = "http://www.canadapost.ca/ws/manifest-v8"
+	xmlData, err := xml.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	log.Println("TransmitSet XML to Canada Post:\n", string(xmlData))
+
+	baseURL := strings.TrimRight(strings.TrimSpace(c.BaseURL), "/")
+	endpoint := fmt.Sprintf("%s/rs/%s/%s/manifest", baseURL, mailedBy, mailedBy)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(xmlData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/vnd.cpc.manifest-v8+xml")
+	httpReq.Header.Set("Accept", "application/vnd.cpc.manifest-v8+xml")
+	httpReq.Header.Set("Accept-Language", "en-CA")
+	httpReq.SetBasicAuth(c.Username, c.Password)
+
+	resp, err := c.httpClient().Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	logResponseBody("TransmitShipments", resp.StatusCode, body)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, newCanadaPostError(resp.StatusCode, body)
+	}
+
+	var manifests ManifestLinksResponse
+	if err := xml.Unmarshal(body, &manifests); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	return manifests.Links, nil
+}
+
+func (c *CanadaPostClient) GetManifest(ctx context.Context, manifestURL string) (*ManifestResponse, error) {
+	if c == nil {
+		return nil, fmt.Errorf("canada post client is nil")
+	}
+	status, body, err := c.getResource(ctx, manifestURL, "application/vnd.cpc.manifest-v8+xml", "GetManifest")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, newCanadaPostError(status, body)
+	}
+	var manifest ManifestResponse
+	if err := xml.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	return &manifest, nil
+}
+
+// GetManifestShipments returns the shipment IDs included in a manifest.
+func (c *CanadaPostClient) GetManifestShipments(ctx context.Context, shipmentsURL string) ([]string, error) {
+	if c == nil {
+		return nil, fmt.Errorf("canada post client is nil")
+	}
+	status, body, err := c.getResource(ctx, shipmentsURL, "application/vnd.cpc.shipment-v8+xml", "GetManifestShipments")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, newCanadaPostError(status, body)
+	}
+	var shipments ManifestShipmentsResponse
+	if err := xml.Unmarshal(body, &shipments); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	ids := make([]string, 0, len(shipments.Links))
+	for _, link := range shipments.Links {
+		if id := lastPathSegment(link.Href); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func lastPathSegment(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	if parsed, err := url.Parse(rawURL); err == nil {
+		rawURL = parsed.Path
+	}
+	rawURL = strings.TrimRight(rawURL, "/")
+	if idx := strings.LastIndex(rawURL, "/"); idx >= 0 {
+		return rawURL[idx+1:]
+	}
+	return rawURL
+}
+
+// CreateAuthorizedReturn creates a prepaid return label. Canada Post only
+// offers authorized returns within Canada.
+func (c *CanadaPostClient) CreateAuthorizedReturn(ctx context.Context, mailedBy string, req *AuthorizedReturnRequest) (*AuthorizedReturnInfo, error) {
+	if c == nil {
+		return nil, fmt.Errorf("canada post client is nil")
+	}
+	if req == nil {
+		return nil, fmt.Errorf("authorized return request is required")
+	}
+	mailedBy = strings.TrimSpace(mailedBy)
+	if mailedBy == "" {
+		mailedBy = strings.TrimSpace(c.CustomerNumber)
+	}
+	if mailedBy == "" {
+		return nil, fmt.Errorf("customer number is required to create return labels")
+	}
+
+	req.XMLNS = "http://www.canadapost.ca/ws/authreturn-v2"
+	xmlData, err := xml.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	log.Println("AuthorizedReturn XML to Canada Post:\n", string(xmlData))
+
+	baseURL := strings.TrimRight(strings.TrimSpace(c.BaseURL), "/")
+	endpoint := fmt.Sprintf("%s/rs/%s/%s/authorizedreturn", baseURL, mailedBy, mailedBy)
+	status, body, err := c.sendResource(ctx, http.MethodPost, endpoint, "application/vnd.cpc.authreturn-v2+xml", xmlData, "CreateAuthorizedReturn")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK && status != http.StatusCreated {
+		return nil, newCanadaPostError(status, body)
+	}
+	var info AuthorizedReturnInfo
+	if err := xml.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	return &info, nil
+}
+
+const (
+	pickupAvailabilityMediaType = "application/vnd.cpc.pickup+xml"
+	pickupRequestMediaType      = "application/vnd.cpc.pickuprequest+xml"
+	pickupRequestNamespace      = "http://www.canadapost.ca/ws/pickuprequest"
+)
+
+// GetPickupAvailability reports whether on-demand and scheduled pickups are
+// offered at the postal code, along with the same-day cutoff time.
+func (c *CanadaPostClient) GetPickupAvailability(ctx context.Context, postalCode string) (*PickupAvailabilityXML, error) {
+	if c == nil {
+		return nil, fmt.Errorf("canada post client is nil")
+	}
+	postalCode = normalizeCanadianPostalCode(postalCode)
+	if postalCode == "" {
+		return nil, fmt.Errorf("postal code is required")
+	}
+
+	baseURL := strings.TrimRight(strings.TrimSpace(c.BaseURL), "/")
+	endpoint := fmt.Sprintf("%s/ad/pickup/pickupavailability/%s", baseURL, url.PathEscape(postalCode))
+	status, body, err := c.getResource(ctx, endpoint, pickupAvailabilityMediaType, "GetPickupAvailability")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, newCanadaPostError(status, body)
+	}
+	var availability PickupAvailabilityXML
+	if err := xml.Unmarshal(body, &availability); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	return &availability, nil
+}
+
+func (c *CanadaPostClient) CreatePickupRequest(ctx context.Context, customerNumber string, req *PickupRequestDetails) (*PickupRequestInfo, error) {
+	if c == nil {
+		return nil, fmt.Errorf("canada post client is nil")
+	}
+	if req == nil {
+		return nil, fmt.Errorf("pickup request is required")
+	}
+	endpoint, err := c.pickupRequestEndpoint(customerNumber, "")
+	if err != nil {
+		return nil, err
+	}
+	req.XMLNS = pickupRequestNamespace
+	xmlData, err := xml.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	log.Println("PickupRequest XML to Canada Post:\n", string(xmlData))
+
+	status, body, err := c.sendResource(ctx, http.MethodPost, endpoint, pickupRequestMediaType, xmlData, "CreatePickupRequest")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK && status != http.StatusCreated {
+		return nil, newCanadaPostError(status, body)
+	}
+	var info PickupRequestInfo
+	if err := xml.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	if strings.TrimSpace(info.Header.RequestID) == "" {
+		return nil, fmt.Errorf("pickup request id missing in response")
+	}
+	return &info, nil
+}
+
+func (c *CanadaPostClient) UpdatePickupRequest(ctx context.Context, customerNumber, requestID string, req *PickupRequestUpdate) error {
+	if c == nil {
+		return fmt.Errorf("canada post client is nil")
+	}
+	if req == nil {
+		return fmt.Errorf("pickup request is required")
+	}
+	if strings.TrimSpace(requestID) == "" {
+		return fmt.Errorf("pickup request id is required")
+	}
+	endpoint, err := c.pickupRequestEndpoint(customerNumber, requestID)
+	if err != nil {
+		return err
+	}
+	req.XMLNS = pickupRequestNamespace
+	xmlData, err := xml.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	log.Println("PickupRequestUpdate XML to Canada Post:\n", string(xmlData))
+
+	status, body, err := c.sendResource(ctx, http.MethodPut, endpoint, pickupRequestMediaType, xmlData, "UpdatePickupRequest")
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusNoContent {
+		return newCanadaPostError(status, body)
+	}
+	return nil
+}
+
+func (c *CanadaPostClient) CancelPickupRequest(ctx context.Context, customerNumber, requestID string) error {
+	if c == nil {
+		return fmt.Errorf("canada post client is nil")
+	}
+	if strings.TrimSpace(requestID) == "" {
+		return fmt.Errorf("pickup request id is required")
+	}
+	endpoint, err := c.pickupRequestEndpoint(customerNumber, requestID)
+	if err != nil {
+		return err
+	}
+	status, body, err := c.sendResource(ctx, http.MethodDelete, endpoint, pickupRequestMediaType, nil, "CancelPickupRequest")
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusNoContent {
+		return newCanadaPostError(status, body)
+	}
+	return nil
+}
+
+func (c *CanadaPostClient) pickupRequestEndpoint(customerNumber, requestID string) (string, error) {
+	customerNumber = strings.TrimSpace(customerNumber)
+	if customerNumber == "" {
+		customerNumber = strings.TrimSpace(c.CustomerNumber)
+	}
+	if customerNumber == "" {
+		return "", fmt.Errorf("customer number is required for pickup requests")
+	}
+	baseURL := strings.TrimRight(strings.TrimSpace(c.BaseURL), "/")
+	endpoint := fmt.Sprintf("%s/enab/%s/pickuprequest", baseURL, url.PathEscape(customerNumber))
+	if requestID = strings.TrimSpace(requestID); requestID != "" {
+		endpoint += "/" + url.PathEscape(requestID)
+	}
+	return endpoint, nil
+}
+
 func logRequestOut(req *http.Request) {
 	if req == nil {
 		return
@@ -232,7 +827,9 @@ func logResponseBody(tag string, status int, body []byte) {
 	log.Printf("%s response body:\n%s\n", tag, string(body))
 }
 
-func (c *CanadaPostClient) GetArtifact(ctx context.Context, artifactURL string) ([]byte, error) {
+// GetArtifact downloads a label, invoice or manifest document. mediaType is
+// the media type of the artifact link and defaults to application/pdf.
+func (c *CanadaPostClient) GetArtifact(ctx context.Context, artifactURL string, mediaType string) ([]byte, error) {
 	if c == nil {
 		return nil, fmt.Errorf("canada post client is nil")
 	}
@@ -241,7 +838,10 @@ func (c *CanadaPostClient) GetArtifact(ctx context.Context, artifactURL string)
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	httpReq.Header.Set("Accept", "application/pdf")
+	if strings.TrimSpace(mediaType) == "" {
+		mediaType = "application/pdf"
+	}
+	httpReq.Header.Set("Accept", mediaType)
 	httpReq.SetBasicAuth(c.Username, c.Password)
 
 	resp, err := c.httpClient().Do(httpReq)
@@ -250,8 +850,12 @@ func (c *CanadaPostClient) GetArtifact(ctx context.Context, artifactURL string)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusAccepted {
+		return nil, &CanadaPostError{StatusCode: resp.StatusCode, Description: "artifact is not ready yet", Retryable: true}
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download artifact: status %d", resp.StatusCode)
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, newCanadaPostError(resp.StatusCode, body)
 	}
 
 	return io.ReadAll(resp.Body)
@@ -311,14 +915,11 @@ func (c *CanadaPostClient) RefundShipment(ctx context.Context, refundURL string,
 	log.Printf("Canada Post refund raw response:\n%s\n", string(bodyBytes))
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, &CPRefundError{StatusCode: resp.StatusCode, Code: "404", Description: "invalid shipment id or refund link"}
+		return nil, &CanadaPostError{StatusCode: resp.StatusCode, Code: "404", Description: "invalid shipment id or refund link"}
 	}
 
 	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
-		if msg := parseRefundMessage(bodyBytes); msg != nil {
-			return nil, &CPRefundError{StatusCode: resp.StatusCode, Code: msg.Code, Description: msg.Description}
-		}
-		return nil, fmt.Errorf("refund API error %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, newCanadaPostError(resp.StatusCode, bodyBytes)
 	}
 
 	refundResp, msg, err := parseRefundResponse(bodyBytes)
@@ -326,7 +927,7 @@ func (c *CanadaPostClient) RefundShipment(ctx context.Context, refundURL string,
 		return nil, err
 	}
 	if msg != nil {
-		return nil, &CPRefundError{StatusCode: resp.StatusCode, Code: msg.Code, Description: msg.Description}
+		return nil, &CanadaPostError{StatusCode: resp.StatusCode, Code: msg.Code, Description: msg.Description}
 	}
 	if refundResp == nil {
 		return nil, fmt.Errorf("refund response is empty")
@@ -334,26 +935,6 @@ func (c *CanadaPostClient) RefundShipment(ctx context.Context, refundURL string,
 	return refundResp, nil
 }
 
-// Request Non-Contract Shipment Refund – REST
-type CPRefundError struct {
-	StatusCode  int
-	Code        string
-	Description string
-}
-
-func (e *CPRefundError) Error() string {
-	if e == nil {
-		return "refund error"
-	}
-	if e.Code != "" && e.Description != "" {
-		return fmt.Sprintf("refund error code=%s: %s", e.Code, e.Description)
-	}
-	if e.Description != "" {
-		return e.Description
-	}
-	return "refund error"
-}
-
 func parseRefundResponse(body []byte) (*RefundResponse, *CPMessage, error) {
 	if len(body) == 0 {
 		return nil, nil, fmt.Errorf("refund response is empty")
@@ -364,29 +945,12 @@ func parseRefundResponse(body []byte) (*RefundResponse, *CPMessage, error) {
 			return &resp, nil, nil
 		}
 	}
-	if msg := parseRefundMessage(body); msg != nil {
-		return nil, msg, nil
+	if messages := parseCPMessages(body); len(messages) > 0 {
+		return nil, &messages[0], nil
 	}
 	return nil, nil, fmt.Errorf("unexpected refund response payload")
 }
 
-func parseRefundMessage(body []byte) *CPMessage {
-	var msgs CPMessages
-	if err := xml.Unmarshal(body, &msgs); err != nil {
-		return nil
-	}
-	if len(msgs.Messages) == 0 {
-		return nil
-	}
-	msg := msgs.Messages[0]
-	msg.Code = strings.TrimSpace(msg.Code)
-	msg.Description = strings.TrimSpace(msg.Description)
-	if msg.Code == "" && msg.Description == "" {
-		return nil
-	}
-	return &msg
-}
-
 func logRefundRequest(req *http.Request) {
 	if req == nil {
 		return
@@ -411,7 +975,7 @@ func logRefundRequest(req *http.Request) {
 
 func (c *CanadaPostClient) httpClient() *http.Client {
 	if c.HTTPClient == nil {
-		c.HTTPClient = &http.Client{Timeout: 20 * time.Second}
+		c.HTTPClient = &http.Client{Timeout: canadaPostClientTimeout, Transport: canadaPostTransport}
 	}
 	return c.HTTPClient
 }
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CanadaPostError is a Canada Post call that got an unsuccessful response.
+// Code and Description come from the <messages> body when Canada Post sent
+// one; when it sent several, Description joins them.
+type CanadaPostError struct {
+	StatusCode  int
+	Code        string
+	Description string
+	Retryable   bool // 429, 5xx and artifacts not ready yet; the same call may succeed later
+}
+
+func newCanadaPostError(statusCode int, body []byte) *CanadaPostError {
+	cpErr := &CanadaPostError{
+		StatusCode: statusCode,
+		Retryable:  statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError,
+	}
+	messages := parseCPMessages(body)
+	if len(messages) > 0 {
+		cpErr.Code = messages[0].Code
+		descriptions := make([]string, 0, len(messages))
+		for _, msg := range messages {
+			if msg.Description != "" {
+				descriptions = append(descriptions, msg.Description)
+			}
+		}
+		cpErr.Description = strings.Join(descriptions, "; ")
+	}
+	return cpErr
+}
+
+func (e *CanadaPostError) Error() string {
+	if e == nil {
+		return "Canada Post error"
+	}
+	if e.Code != "" && e.Description != "" {
+		return fmt.Sprintf("Canada Post error %s: %s", e.Code, e.Description)
+	}
+	if e.Description != "" {
+		return "Canada Post error: " + e.Description
+	}
+	return fmt.Sprintf("Canada Post API error %d", e.StatusCode)
+}
+
+// parseCPMessages returns the messages of a Canada Post <messages> body, or
+// nil when body is something else.
+func parseCPMessages(body []byte) []CPMessage {
+	var msgs CPMessages
+	if err := xml.Unmarshal(body, &msgs); err != nil {
+		return nil
+	}
+	messages := make([]CPMessage, 0, len(msgs.Messages))
+	for _, msg := range msgs.Messages {
+		msg.Code = strings.TrimSpace(msg.Code)
+		msg.Description = strings.TrimSpace(msg.Description)
+		if msg.Code == "" && msg.Description == "" {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// pluginErrorResult maps an error from a Canada Post call to the
+// ResultResponse code and a message the merchant can act on. Errors that
+// didn't come from Canada Post, such as validation errors, are returned as
+// "400" with their own message.
+func pluginErrorResult(err error) (string, string) {
+	const unavailable = "Canada Post is temporarily unavailable. Please try again in a few minutes."
+	var cpErr *CanadaPostError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &cpErr):
+		switch {
+		case cpErr.StatusCode == http.StatusUnauthorized || cpErr.StatusCode == http.StatusForbidden:
+			return "401", "Canada Post rejected the account credentials. Check the Canada Post account settings."
+		case cpErr.StatusCode == http.StatusTooManyRequests:
+			return "429", "Canada Post is receiving too many requests. Please try again in a moment."
+		case cpErr.StatusCode >= http.StatusInternalServerError:
+			return "503", unavailable
+		case cpErr.Retryable:
+			return "503", "Canada Post: " + strings.TrimSuffix(defaultValue(cpErr.Description, "the request is not ready yet"), ".") + ". Please try again in a few minutes."
+		case cpErr.StatusCode == http.StatusNotFound && cpErr.Description == "":
+			return "404", "Canada Post could not find the requested shipment."
+		case cpErr.StatusCode == http.StatusNotFound:
+			return "404", "Canada Post: " + cpErr.Description
+		case cpErr.Description != "":
+			return "422", "Canada Post: " + cpErr.Description
+		}
+		return "400", fmt.Sprintf("Canada Post rejected the request (HTTP %d).", cpErr.StatusCode)
+	case errors.Is(err, errCircuitOpen):
+		return "503", unavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return "504", "Canada Post did not respond in time. Please try again in a few minutes."
+	case errors.As(err, &urlErr):
+		if urlErr.Timeout() {
+			return "504", "Canada Post did not respond in time. Please try again in a few minutes."
+		}
+		return "502", "Could not reach Canada Post. Please try again in a few minutes."
+	}
+	return "400", err.Error()
+}
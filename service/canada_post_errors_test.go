@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateShipmentReturnsCanadaPostError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<messages xmlns="http://www.canadapost.ca/ws/messages">
+  <message><code>9111</code><description>The destination postal code is invalid.</description></message>
+  <message><code>1725</code><description>A weight is required.</description></message>
+</messages>`))
+	}))
+	defer server.Close()
+
+	client := NewCanadaPostClient("user", "pass", "0001234567", server.URL)
+	_, err := client.CreateShipment(context.Background(), &ShipmentRequest{})
+	var cpErr *CanadaPostError
+	if !errors.As(err, &cpErr) {
+		t.Fatalf("expected CanadaPostError, got %T %v", err, err)
+	}
+	if cpErr.StatusCode != http.StatusBadRequest || cpErr.Code != "9111" || cpErr.Retryable {
+		t.Fatalf("unexpected error: %+v", cpErr)
+	}
+	if cpErr.Description != "The destination postal code is invalid.; A weight is required." {
+		t.Fatalf("unexpected description %q", cpErr.Description)
+	}
+}
+
+func TestGetArtifactReturnsCanadaPostError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`<messages xmlns="http://www.canadapost.ca/ws/messages"><message><code>8580</code><description>Artifact not found.</description></message></messages>`))
+	}))
+	defer server.Close()
+
+	client := NewCanadaPostClient("user", "pass", "0001234567", server.URL)
+	_, err := client.GetArtifact(context.Background(), server.URL+"/ers/artifact/1/0", "")
+	var cpErr *CanadaPostError
+	if !errors.As(err, &cpErr) {
+		t.Fatalf("expected CanadaPostError, got %T %v", err, err)
+	}
+	if cpErr.StatusCode != http.StatusNotFound || cpErr.Code != "8580" || cpErr.Description != "Artifact not found." {
+		t.Fatalf("unexpected error: %+v", cpErr)
+	}
+	if code, _ := pluginErrorResult(err); code != "404" {
+		t.Fatalf("expected 404, got %s", code)
+	}
+}
+
+func TestPluginErrorResult(t *testing.T) {
+	cases := []struct {
+		err  error
+		code string
+		msg  string
+	}{
+		{
+			err:  newCanadaPostError(http.StatusBadRequest, []byte(`<messages><message><code>9111</code><description>The destination postal code is invalid.</description></message></messages>`)),
+			code: "422",
+			msg:  "Canada Post: The destination postal code is invalid.",
+		},
+		{err: newCanadaPostError(http.StatusUnauthorized, nil), code: "401", msg: "Canada Post rejected the account credentials. Check the Canada Post account settings."},
+		{err: fmt.Errorf("failed: %w", newCanadaPostError(http.StatusServiceUnavailable, []byte("<html>down</html>"))), code: "503", msg: "Canada Post is temporarily unavailable. Please try again in a few minutes."},
+		{err: fmt.Errorf("%w: GET soa-gw.canadapost.ca/rs/ship/price", errCircuitOpen), code: "503", msg: "Canada Post is temporarily unavailable. Please try again in a few minutes."},
+		{err: &CanadaPostError{StatusCode: http.StatusAccepted, Description: "artifact is not ready yet", Retryable: true}, code: "503", msg: "Canada Post: artifact is not ready yet. Please try again in a few minutes."},
+		{err: errors.New("customer phone required for selected service"), code: "400", msg: "customer phone required for selected service"},
+	}
+	for _, tc := range cases {
+		code, msg := pluginErrorResult(tc.err)
+		if code != tc.code || msg != tc.msg {
+			t.Fatalf("pluginErrorResult(%v) = %s %q, want %s %q", tc.err, code, msg, tc.code, tc.msg)
+		}
+	}
+}
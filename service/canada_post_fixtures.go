@@ -0,0 +1,358 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"lexmodo-plugin/config"
+)
+
+// Canada Post traffic modes, set with canadapost.traffic.
+const (
+	TrafficLive   = ""
+	TrafficRecord = "record"
+	TrafficReplay = "replay"
+)
+
+// errNoFixture is returned in replay mode for a request that was never
+// recorded.
+var errNoFixture = errors.New("no recorded canada post response")
+
+// ConfigureCanadaPostTraffic switches every CanadaPostClient to recording
+// or replaying Canada Post traffic under cfg.FixturesDir. Call it once at
+// startup, before any client is used.
+func ConfigureCanadaPostTraffic(cfg config.CanadaPostConfig) error {
+	mode := strings.ToLower(strings.TrimSpace(cfg.Traffic))
+	dir := strings.TrimSpace(cfg.FixturesDir)
+	switch mode {
+	case TrafficLive:
+		return nil
+	case TrafficRecord:
+		if dir == "" {
+			return errors.New("canadapost.fixtures_dir is required to record traffic")
+		}
+		canadaPostTransport.next = newRecordingTransport(http.DefaultTransport, dir)
+	case TrafficReplay:
+		if dir == "" {
+			return errors.New("canadapost.fixtures_dir is required to replay traffic")
+		}
+		canadaPostTransport.next = newReplayTransport(dir)
+	default:
+		return fmt.Errorf("unknown canadapost.traffic %q", cfg.Traffic)
+	}
+	log.Printf("canada post traffic: mode=%s dir=%s", mode, dir)
+	return nil
+}
+
+// trafficFixture is one recorded request and the responses it got, in order.
+// Request and responses are sanitized before they are written.
+type trafficFixture struct {
+	Method    string             `json:"method"`
+	Path      string             `json:"path"`
+	Body      string             `json:"body,omitempty"`
+	Responses []recordedResponse `json:"responses"`
+}
+
+type recordedResponse struct {
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type,omitempty"`
+	RetryAfter  string    `json:"retry_after,omitempty"`
+	Body        string    `json:"body,omitempty"` // a placeholder for non-text bodies such as label PDFs
+	RecordedAt  time.Time `json:"recorded_at"`
+}
+
+// recordingTransport passes requests on to next and writes each exchange to
+// a fixture file in dir. A request seen again in the same process adds to
+// its fixture, so retried and polled calls replay in the same order.
+type recordingTransport struct {
+	next http.RoundTripper
+	dir  string
+
+	mu       sync.Mutex
+	fixtures map[string]*trafficFixture
+}
+
+func newRecordingTransport(next http.RoundTripper, dir string) *recordingTransport {
+	return &recordingTransport{next: next, dir: dir, fixtures: map[string]*trafficFixture{}}
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fixture, err := fixtureForRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	recorded := recordedResponse{
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		RetryAfter:  resp.Header.Get("Retry-After"),
+		RecordedAt:  time.Now().UTC(),
+	}
+	if isTextContent(recorded.ContentType, body) {
+		recorded.Body = sanitizeTraffic(string(body))
+	} else {
+		recorded.Body = binaryPlaceholder(recorded.ContentType, len(body))
+	}
+	if err := t.save(fixture, recorded); err != nil {
+		log.Printf("failed to record canada post traffic: %s %s: %v", fixture.Method, fixture.Path, err)
+	}
+	return resp, nil
+}
+
+func (t *recordingTransport) save(fixture trafficFixture, recorded recordedResponse) error {
+	name := fixtureFileName(fixture)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	existing := t.fixtures[name]
+	if existing == nil {
+		existing = &fixture
+		t.fixtures[name] = existing
+	}
+	existing.Responses = append(existing.Responses, recorded)
+	data, err := json.MarshalIndent(existing, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(t.dir, name), data, 0o644)
+}
+
+// replayTransport answers requests from the fixtures in dir instead of
+// calling Canada Post. A fixture's responses are served in order and the
+// last one is repeated.
+type replayTransport struct {
+	dir string
+
+	mu     sync.Mutex
+	served map[string]int
+}
+
+func newReplayTransport(dir string) *replayTransport {
+	return &replayTransport{dir: dir, served: map[string]int{}}
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	fixture, err := fixtureForRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	name := fixtureFileName(fixture)
+	data, err := os.ReadFile(filepath.Join(t.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s %s (%s)", errNoFixture, fixture.Method, fixture.Path, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recorded trafficFixture
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", name, err)
+	}
+	if len(recorded.Responses) == 0 {
+		return nil, fmt.Errorf("%w: %s has no responses", errNoFixture, name)
+	}
+
+	t.mu.Lock()
+	index := t.served[name]
+	if index < len(recorded.Responses)-1 {
+		t.served[name] = index + 1
+	}
+	t.mu.Unlock()
+
+	response := recorded.Responses[index]
+	body := []byte(response.Body)
+	if !isTextContent(response.ContentType, body) {
+		body = replayedBinary(response.ContentType, body)
+	}
+	header := http.Header{}
+	if response.ContentType != "" {
+		header.Set("Content-Type", response.ContentType)
+	}
+	if response.RetryAfter != "" {
+		header.Set("Retry-After", response.RetryAfter)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", response.Status, http.StatusText(response.Status)),
+		StatusCode:    response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// fixtureForRequest describes req the way it is matched on replay: method,
+// path with query, and the sanitized body with whitespace between elements
+// removed. The host and customer numbers are ignored so fixtures recorded
+// against the Canada Post test environment replay against any base URL and
+// account.
+func fixtureForRequest(req *http.Request) (trafficFixture, error) {
+	fixture := trafficFixture{Method: req.Method, Path: sanitizeTrafficPath(req.URL.Path)}
+	if req.URL.RawQuery != "" {
+		fixture.Path += "?" + req.URL.Query().Encode()
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return fixture, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return trafficFixture{}, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	fixture.Body = normalizeTrafficBody(sanitizeTraffic(string(body)))
+	return fixture, nil
+}
+
+var betweenElements = regexp.MustCompile(`>\s+<`)
+
+func normalizeTrafficBody(body string) string {
+	return betweenElements.ReplaceAllString(strings.TrimSpace(body), "><")
+}
+
+func fixtureFileName(fixture trafficFixture) string {
+	sum := sha256.Sum256([]byte(fixture.Method + " " + fixture.Path + "\n" + fixture.Body))
+	path, _, _ := strings.Cut(fixture.Path, "?")
+	segments := []string{strings.ToLower(fixture.Method)}
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if segment != "" && !strings.ContainsAny(segment, "0123456789") {
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, "-") + "-" + hex.EncodeToString(sum[:6]) + ".json"
+}
+
+// redactedElements hold credentials, account numbers or personal details.
+// Postal codes, countries and parcel details are kept since they drive the
+// responses.
+var redactedElements = []string{
+	"name", "company", "contact-phone", "client-voice-number", "email",
+	"address-line-1", "address-line-2", "name-on-card", "auth-code",
+	"contact-name", "signatory-name", "manifest-company", "manifest-name",
+	"phone-number", "receiver-voice-number",
+	"username", "password", "api-key",
+	"customer-number", "contract-id", "mailed-by", "mobo", "paid-by-customer",
+	"mailed-by-customer-number",
+}
+
+// customerPath matches the customer number and optional mailed-on-behalf-of
+// number that follow /rs/ in Canada Post resource paths and links, e.g.
+// /rs/0001234567/0001234567/shipment.
+var customerPath = regexp.MustCompile(`/rs/\d+(/\d+)?(/|$)`)
+
+// artifactPath matches the account key of artifact links, e.g.
+// /ers/artifact/76108cb5192002d5/400789/0.
+var artifactPath = regexp.MustCompile(`/ers/artifact/[^/"]+/`)
+
+// sanitizeTrafficPath replaces the customer numbers of the resource and
+// artifact paths in s with "customer".
+func sanitizeTrafficPath(s string) string {
+	s = customerPath.ReplaceAllStringFunc(s, func(match string) string {
+		parts := customerPath.FindStringSubmatch(match)
+		replaced := "/rs/customer"
+		if parts[1] != "" {
+			replaced += "/customer"
+		}
+		return replaced + parts[2]
+	})
+	return artifactPath.ReplaceAllString(s, "/ers/artifact/customer/")
+}
+
+var redactPatterns = func() []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, 0, len(redactedElements))
+	for _, element := range redactedElements {
+		patterns = append(patterns, regexp.MustCompile(`(<(?:\w+:)?`+regexp.QuoteMeta(element)+`(?:\s[^>]*)?>)[^<]*(</(?:\w+:)?`+regexp.QuoteMeta(element)+`>)`))
+	}
+	return patterns
+}()
+
+// sanitizeTraffic replaces the content of redactedElements with REDACTED
+// and the customer numbers of links with "customer".
+func sanitizeTraffic(body string) string {
+	for _, pattern := range redactPatterns {
+		body = pattern.ReplaceAllString(body, "${1}REDACTED${2}")
+	}
+	return sanitizeTrafficPath(body)
+}
+
+// binaryPlaceholder stands in for a non-text body, such as a label PDF,
+// which is not recorded. Replay serves replayedBinary in its place.
+func binaryPlaceholder(contentType string, size int) string {
+	return fmt.Sprintf("binary %s response of %d bytes not recorded", defaultValue(contentType, "unknown"), size)
+}
+
+// replayedBinary stands in for a binary body that was not recorded: a blank
+// one-page PDF or an empty ZPL label, so that labels and manifests replay
+// as valid documents. Other bodies are served as recorded.
+func replayedBinary(contentType string, recorded []byte) []byte {
+	switch strings.ToLower(strings.TrimSpace(contentType)) {
+	case "application/pdf":
+		return blankPDF()
+	case "application/zpl":
+		return []byte("^XA^XZ")
+	}
+	return recorded
+}
+
+// blankPDF returns a PDF with one empty 4x6 in page, laid out like Canada
+// Post labels so that it can be merged with them.
+func blankPDF() []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 288 432] >>",
+	}
+	var doc strings.Builder
+	doc.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = doc.Len()
+		fmt.Fprintf(&doc, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := doc.Len()
+	fmt.Fprintf(&doc, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&doc, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&doc, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return []byte(doc.String())
+}
+
+func isTextContent(contentType string, body []byte) bool {
+	contentType = strings.ToLower(contentType)
+	if strings.Contains(contentType, "xml") || strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "json") {
+		return true
+	}
+	return contentType == "" && bytes.HasPrefix(bytes.TrimSpace(body), []byte("<"))
+}
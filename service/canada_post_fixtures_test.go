@@ -0,0 +1,226 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lexmodo-plugin/service/cpsim"
+)
+
+func TestRecordedTrafficReplaysWithoutCanadaPost(t *testing.T) {
+	dir := t.TempDir()
+	sim := cpsim.New()
+	sim.Inject(cpsim.Price, cpsim.Failure{Status: http.StatusServiceUnavailable})
+
+	payload := buildShipmentRequest(
+		canadaPostOrigin{PostalCode: "K1P5A0", AddressLine: "59 Sparks St", City: "Ottawa", Province: "ON", Name: "Warehouse"},
+		canadaPostDestination{Name: "Jordan Lee", AddressLine: "123 Main Street", City: "Toronto", Province: "ON", PostalCode: "M5H2N2", Country: "CA"},
+		parcelMetrics{Weight: 2},
+		"DOM.EP",
+	)
+	rateRequest := func() *RateRequest {
+		req := &RateRequest{OriginPostalCode: "K1P5A0"}
+		req.ParcelCharacteristics.Weight = 2
+		req.Destination.Domestic = &struct {
+			PostalCode string `xml:"postal-code"`
+		}{PostalCode: "M5H2N2"}
+		return req
+	}
+
+	recorder := newTestCanadaPostClient(sim.URL(), newRetryTransport(newRecordingTransport(http.DefaultTransport, dir)))
+	liveRates, err := recorder.GetRates(context.Background(), rateRequest())
+	if err != nil {
+		t.Fatalf("expected rates, got %v", err)
+	}
+	liveShipment, err := recorder.CreateShipment(context.Background(), payload)
+	if err != nil {
+		t.Fatalf("expected shipment, got %v", err)
+	}
+	sim.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 {
+		t.Fatalf("expected a fixture per request, got %v", files)
+	}
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		if strings.Contains(string(data), "Jordan Lee") || strings.Contains(string(data), "123 Main Street") || strings.Contains(string(data), "0001234567") {
+			t.Fatalf("fixture %s was not sanitized:\n%s", file, data)
+		}
+	}
+
+	replayer := newTestCanadaPostClient("https://soa-gw.canadapost.ca", newRetryTransport(newReplayTransport(dir)))
+	replayedRates, err := replayer.GetRates(context.Background(), rateRequest())
+	if err != nil {
+		t.Fatalf("expected replayed rates after the recorded 503, got %v", err)
+	}
+	if len(replayedRates.PriceQuotes) != len(liveRates.PriceQuotes) || replayedRates.PriceQuotes[0].PriceDetails.Due != liveRates.PriceQuotes[0].PriceDetails.Due {
+		t.Fatalf("replayed rates differ: %+v", replayedRates.PriceQuotes)
+	}
+	// A different recipient name still matches: names are redacted before matching.
+	payload.DeliverySpec.Destination.Name = "Sam Roy"
+	replayedShipment, err := replayer.CreateShipment(context.Background(), payload)
+	if err != nil {
+		t.Fatalf("expected replayed shipment, got %v", err)
+	}
+	if replayedShipment.TrackingPIN != liveShipment.TrackingPIN {
+		t.Fatalf("expected tracking %s, got %s", liveShipment.TrackingPIN, replayedShipment.TrackingPIN)
+	}
+
+	payload.DeliverySpec.ServiceCode = "DOM.XP"
+	if _, err := replayer.CreateShipment(context.Background(), payload); !errors.Is(err, errNoFixture) {
+		t.Fatalf("expected a missing fixture error, got %v", err)
+	}
+}
+
+func TestSanitizeTrafficRedactsPersonalDetails(t *testing.T) {
+	body := `<destination><name>Jordan Lee</name><client-voice-number>4165550199</client-voice-number><address-details><address-line-1>123 Main Street</address-line-1><postal-zip-code>M5H2N2</postal-zip-code></address-details></destination><notification><email>jordan@example.com</email></notification>`
+	got := sanitizeTraffic(body)
+	want := `<destination><name>REDACTED</name><client-voice-number>REDACTED</client-voice-number><address-details><address-line-1>REDACTED</address-line-1><postal-zip-code>M5H2N2</postal-zip-code></address-details></destination><notification><email>REDACTED</email></notification>`
+	if got != want {
+		t.Fatalf("unexpected sanitized body:\n%s", got)
+	}
+}
+
+func TestSanitizeTrafficRedactsAccountNumbers(t *testing.T) {
+	body := `<mailing-scenario><customer-number>0001234567</customer-number><contract-id>0040662521</contract-id></mailing-scenario><link rel="receipt" href="https://ct.soa-gw.canadapost.ca/rs/0001234567/0001234567/shipment/3400/receipt"/>`
+	want := `<mailing-scenario><customer-number>REDACTED</customer-number><contract-id>REDACTED</contract-id></mailing-scenario><link rel="receipt" href="https://ct.soa-gw.canadapost.ca/rs/customer/customer/shipment/3400/receipt"/>`
+	if got := sanitizeTraffic(body); got != want {
+		t.Fatalf("unexpected sanitized body:\n%s", got)
+	}
+
+	for path, want := range map[string]string{
+		"/rs/0001234567/ncshipment":           "/rs/customer/ncshipment",
+		"/rs/0001234567/0007654321/shipment":  "/rs/customer/customer/shipment",
+		"/rs/0001234567":                      "/rs/customer",
+		"/rs/ship/price":                      "/rs/ship/price",
+		"/rs/0001234567/ncshipment/12/refund": "/rs/customer/ncshipment/12/refund",
+		"/ers/artifact/0001234567/3405/0":     "/ers/artifact/customer/3405/0",
+	} {
+		if got := sanitizeTrafficPath(path); got != want {
+			t.Fatalf("%s: got %s, want %s", path, got, want)
+		}
+	}
+
+	if got := binaryPlaceholder("application/pdf", 2048); strings.Contains(got, "%") || !strings.Contains(got, "2048") {
+		t.Fatalf("unexpected placeholder %q", got)
+	}
+}
+
+// TestRecordedTrafficRedactsEveryXMLType records a body of each XML type
+// that carries personal details or account numbers, as a request and as a
+// response, and checks that none of them reach the fixtures.
+func TestRecordedTrafficRedactsEveryXMLType(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	summary := TrackingSummaryXML{PinSummary: []PinSummaryXML{{Pin: "1371134583769923", SignatoryName: "JLEE"}}}
+	detail := TrackingDetailXML{Pin: "1371134583769923", MailedByCustomerNumber: "0009876543", Events: []TrackingOccurrenceXML{{EventIdentifier: "1496", SignatoryName: "JLEE"}}}
+	pickup := PickupRequestDetails{ContactInfo: PickupContactInfo{ContactName: "Jordan Lee", Email: "jordan@example.com", ContactPhone: "6135550123"}}
+	pickup.PickupLocation.AlternateAddress = &PickupAlternateAddress{Company: "Maple Goods", AddressLine1: "59 Sparks St", PostalCode: "K1P5A0"}
+	transmit := TransmitSetRequest{GroupIDs: []string{"20260205"}}
+	transmit.ManifestAddress.ManifestCompany = "Maple Goods"
+	transmit.ManifestAddress.ManifestName = "Jordan Lee"
+	transmit.ManifestAddress.PhoneNumber = "6135550123"
+	transmit.ManifestAddress.AddressDetails.AddressLine1 = "59 Sparks St"
+	transmit.ManifestAddress.AddressDetails.AddressLine2 = "Suite 200"
+	authorized := AuthorizedReturnRequest{ServiceCode: "DOM.EP", SettlementInfo: &ReturnSettlementInfo{ContractID: "0040662521"}}
+	authorized.Returner.Name = "Sam Roy"
+	authorized.Returner.Company = "Roy Retail"
+	authorized.Returner.DomesticAddress.AddressLine1 = "123 Main Street"
+	authorized.Receiver.Name = "Jordan Lee"
+	authorized.Receiver.Company = "Maple Goods"
+	authorized.Receiver.Email = "jordan@example.com"
+	authorized.Receiver.VoiceNumber = "6135550123"
+	authorized.Receiver.DomesticAddress.AddressLine1 = "59 Sparks St"
+	shipment := buildShipmentRequest(
+		canadaPostOrigin{PostalCode: "K1P5A0", AddressLine: "59 Sparks St", City: "Ottawa", Province: "ON", Name: "Warehouse"},
+		canadaPostDestination{Name: "Jordan Lee", AddressLine: "123 Main Street", City: "Toronto", Province: "ON", PostalCode: "M5H2N2", Country: "CA"},
+		parcelMetrics{Weight: 2},
+		"DOM.EP",
+	)
+
+	personal := []string{"JLEE", "0009876543", "Jordan Lee", "jordan@example.com", "6135550123", "Maple Goods", "59 Sparks St", "Suite 200", "0040662521", "Sam Roy", "Roy Retail", "123 Main Street", "Warehouse"}
+	dir := t.TempDir()
+	client := &http.Client{Transport: newRecordingTransport(http.DefaultTransport, dir)}
+	for kind, value := range map[string]any{"summary": summary, "detail": detail, "pickup": pickup, "transmit": transmit, "return": authorized, "shipment": shipment} {
+		body, err := xml.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Post(server.URL+"/rs/0001234567/"+kind, "application/xml", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 6 {
+		t.Fatalf("expected a fixture per XML type, got %v", files)
+	}
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		for _, value := range personal {
+			if strings.Contains(string(data), value) {
+				t.Fatalf("fixture %s kept %q:\n%s", file, value, data)
+			}
+		}
+	}
+}
+
+func TestReplayServesBlankDocumentsForBinaryBodies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/zpl") {
+			w.Header().Set("Content-Type", "application/zpl")
+			_, _ = w.Write([]byte("^XA^FO50,50^FDJordan Lee^FS^XZ"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		_, _ = w.Write(blankPDF())
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorder := &http.Client{Transport: newRecordingTransport(http.DefaultTransport, dir)}
+	replayer := &http.Client{Transport: newReplayTransport(dir)}
+	for _, path := range []string{"/ers/artifact/0001234567/3405/0", "/ers/artifact/0001234567/3405/zpl"} {
+		resp, err := recorder.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	resp, err := replayer.Get(server.URL + "/ers/artifact/0001234567/3405/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdf, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if _, _, pages, err := parsePDF(pdf); err != nil || pages != 1 {
+		t.Fatalf("expected a one-page PDF, got %d pages: %v", pages, err)
+	}
+	resp, err = replayer.Get(server.URL + "/ers/artifact/0001234567/3405/zpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zpl, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(zpl) != "^XA^XZ" {
+		t.Fatalf("expected an empty ZPL label, got %q", zpl)
+	}
+}
@@ -89,14 +89,15 @@ func (s *Server) buildCreateLabelOptions(values map[string]string, rateToCad flo
 		})
 	}
 
-	delivery := resolveMappedValue(values[fieldDeliveryMethod], deliveryMethodMap)
-	d2poEnabled := isD2POEnabled(values)
-	if d2poEnabled && (delivery == "HFP" || delivery == "DNS" || delivery == "LAD") {
-		return nil, nil, fmt.Errorf("delivery method is mutually exclusive with Deliver to Post Office")
+	// Delivery methods that conflict with D2PO are sent as selected and
+	// rejected by the option rules.
+	switch delivery := resolveMappedValue(values[fieldDeliveryMethod], deliveryMethodMap); delivery {
+	case "HFP", "DNS", "LAD":
+		options = append(options, ShipmentOption{Code: delivery})
 	}
 
 	var notification *ShipmentNotification
-	if d2poEnabled {
+	if isD2POEnabled(values) {
 		officeID := strings.TrimSpace(values[fieldD2POOfficeID])
 		if officeID == "" {
 			selection := strings.TrimSpace(values[fieldD2POOfficeSelection])
@@ -129,11 +130,6 @@ func (s *Server) buildCreateLabelOptions(values map[string]string, rateToCad flo
 			OnException: true,
 			OnDelivery:  true,
 		}
-	} else {
-		switch delivery {
-		case "HFP", "DNS", "LAD":
-			options = append(options, ShipmentOption{Code: delivery})
-		}
 	}
 
 	if age == "PA18" || age == "PA19" {
@@ -183,14 +179,15 @@ func isD2POEnabled(values map[string]string) bool {
 		(selection != "" && !isNoD2POSelection(selection))
 }
 
+// validateCanadaPostOptionRules checks the selected custom options before
+// rating or labelling. Option combinations, prerequisites and amount limits
+// come from the option rules; the rest covers input the rules cannot
+// express.
 func validateCanadaPostOptionRules(values map[string]string, signatureValue string, recipientPhone string, destinationCountry string, rateToCad float64) error {
-	if err := validateSignatureRequirement(values, signatureValue); err != nil {
-		return err
-	}
-	if err := validateDeliveryMethodExclusivity(values); err != nil {
+	if err := validateOptionRules(selectedRuleOptions(values, signatureValue, rateToCad), ""); err != nil {
 		return err
 	}
-	if err := validateCODRequirements(values, destinationCountry, rateToCad); err != nil {
+	if err := validateCODRequirements(values, destinationCountry); err != nil {
 		return err
 	}
 	if err := validateD2PORequirements(values, recipientPhone, destinationCountry); err != nil {
@@ -202,50 +199,9 @@ func validateCanadaPostOptionRules(values map[string]string, signatureValue stri
 	return nil
 }
 
-func validateSignatureRequirement(values map[string]string, signatureValue string) error {
-	delivery := resolveMappedValue(values[fieldDeliveryMethod], deliveryMethodMap)
-	age := resolveMappedValue(values[fieldAgeVerification], ageVerificationMap)
-	ageRequiresSignature := age == "PA18" || age == "PA19"
-	hasSignature := signatureEnabled(signatureValue) || parseBool(values[fieldSOEnabled])
-
-	if ageRequiresSignature && !hasSignature {
-		return fmt.Errorf("age verification requires signature option. please enable signature to continue")
-	}
-	if delivery == "LAD" && hasSignature {
-		return fmt.Errorf("Leave at Door cannot be combined with signature option. please choose standard delivery or another delivery method")
-	}
-	if ageRequiresSignature {
-		if delivery == "LAD" {
-			return fmt.Errorf("Leave at Door cannot be combined with age verification. please choose standard delivery")
-		}
-	}
-	return nil
-}
-
-func validateDeliveryMethodExclusivity(values map[string]string) error {
-	selected := make([]string, 0, 2)
-	switch resolveMappedValue(values[fieldDeliveryMethod], deliveryMethodMap) {
-	case "HFP":
-		selected = append(selected, "Hold for Pickup (Pay at Post Office)")
-	case "DNS":
-		selected = append(selected, "Do Not Safe Drop")
-	case "LAD":
-		selected = append(selected, "Leave at Door")
-	}
-	if isD2POEnabled(values) {
-		selected = append(selected, "Deliver to Post Office")
-	}
-	if len(selected) <= 1 {
-		return nil
-	}
-	return fmt.Errorf("only one delivery method can be selected, but found: %s", strings.Join(selected, ", "))
-}
-
-func validateCODRequirements(values map[string]string, destinationCountry string, rateToCad float64) error {
-	codRaw := strings.TrimSpace(values[fieldCODAmount])
-	codAmount, codAmountOK := parseAmount(codRaw)
-	codEnabled := parseBool(values[fieldCODEnabled]) || codAmountOK
-	if !codEnabled {
+func validateCODRequirements(values map[string]string, destinationCountry string) error {
+	_, codAmountOK := parseAmount(values[fieldCODAmount])
+	if !isCODEnabled(values, codAmountOK) {
 		return nil
 	}
 	if !codAmountOK {
@@ -256,26 +212,6 @@ func validateCODRequirements(values map[string]string, destinationCountry string
 	if destCountry != "" && destCountry != "CA" {
 		return fmt.Errorf("COD is only available for Canadian destinations")
 	}
-
-	if rateToCad <= 0 {
-		rateToCad = 1
-	}
-	if codAmount*rateToCad > 1000 {
-		return fmt.Errorf("COD amount cannot exceed $1,000 CAD")
-	}
-
-	delivery := resolveMappedValue(values[fieldDeliveryMethod], deliveryMethodMap)
-	if delivery == "LAD" {
-		return fmt.Errorf("COD cannot be combined with Leave at Door")
-	}
-	if delivery == "DNS" {
-		return fmt.Errorf("COD cannot be combined with Do Not Safe Drop")
-	}
-
-	hasPickup := delivery == "HFP" || isD2POEnabled(values)
-	if !hasPickup {
-		return fmt.Errorf("COD requires Hold for Pickup or Deliver to Post Office to be selected")
-	}
 	return nil
 }
 
@@ -303,15 +239,6 @@ func validateD2PORequirements(values map[string]string, recipientPhone string, d
 	if strings.TrimSpace(recipientPhone) == "" {
 		return fmt.Errorf("recipient phone number is required when using Deliver to Post Office")
 	}
-
-	switch resolveMappedValue(values[fieldDeliveryMethod], deliveryMethodMap) {
-	case "HFP":
-		return fmt.Errorf("cannot select both Hold for Pickup (Pay at Post Office) and Deliver to Post Office")
-	case "LAD":
-		return fmt.Errorf("cannot select both Leave at Door and Deliver to Post Office")
-	case "DNS":
-		return fmt.Errorf("cannot select both Do Not Safe Drop and Deliver to Post Office")
-	}
 	return nil
 }
 
@@ -60,8 +60,8 @@ func TestRefundShipment404(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected error for 404")
 	}
-	if refundErr, ok := err.(*CPRefundError); !ok || refundErr.StatusCode != http.StatusNotFound {
-		t.Fatalf("expected CPRefundError 404, got %T %+v", err, err)
+	if refundErr, ok := err.(*CanadaPostError); !ok || refundErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected CanadaPostError 404, got %T %+v", err, err)
 	}
 }
 
@@ -79,3 +79,47 @@ func TestRefundShipmentUnexpectedPayload(t *testing.T) {
 		t.Fatal("expected error for unexpected payload")
 	}
 }
+
+func TestVoidShipment(t *testing.T) {
+	status := http.StatusNoContent
+	var method, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		w.WriteHeader(status)
+		if status != http.StatusNoContent {
+			_, _ = w.Write([]byte(`<messages><message><code>8062</code><description>Shipment has been transmitted</description></message></messages>`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewCanadaPostClient("user", "pass", "123", server.URL)
+	if err := client.VoidShipment(context.Background(), server.URL+"/rs/123/123/shipment/340531309186521749"); err != nil {
+		t.Fatalf("expected the void to succeed, got %v", err)
+	}
+	if method != http.MethodDelete || path != "/rs/123/123/shipment/340531309186521749" {
+		t.Fatalf("expected DELETE on the self link, got %s %s", method, path)
+	}
+
+	status = http.StatusBadRequest
+	err := client.VoidShipment(context.Background(), server.URL+"/rs/123/123/shipment/340531309186521749")
+	if cpErr, ok := err.(*CanadaPostError); !ok || cpErr.Code != "8062" {
+		t.Fatalf("expected CanadaPostError 8062, got %T %v", err, err)
+	}
+}
+
+func TestShipmentRefundLinks(t *testing.T) {
+	shipment := &ShipmentResponse{GroupID: "20261016"}
+	shipment.Links.Link = []Link{
+		{Rel: "self", Href: "https://ct.soa-gw.canadapost.ca/rs/123/123/shipment/1"},
+		{Rel: "label", Href: "https://ct.soa-gw.canadapost.ca/ers/artifact/1/0"},
+	}
+	if refundURL, shipmentURL := shipmentRefundLinks(shipment); refundURL != "" || shipmentURL != shipment.Links.Link[0].Href {
+		t.Fatalf("expected the self link of a contract shipment, got %q %q", refundURL, shipmentURL)
+	}
+
+	shipment.GroupID = ""
+	shipment.Links.Link = append(shipment.Links.Link, Link{Rel: "refund", Href: "https://ct.soa-gw.canadapost.ca/rs/123/ncshipment/1/refund"})
+	if refundURL, shipmentURL := shipmentRefundLinks(shipment); refundURL != shipment.Links.Link[2].Href || shipmentURL != "" {
+		t.Fatalf("expected only the refund link of a non-contract shipment, got %q %q", refundURL, shipmentURL)
+	}
+}
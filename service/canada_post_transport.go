@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned, wrapped, while an endpoint's circuit breaker is
+// open and calls to it are refused without reaching Canada Post.
+var errCircuitOpen = errors.New("canada post endpoint temporarily unavailable")
+
+// canadaPostTransport is shared by every CanadaPostClient, so the circuit
+// breaker of an endpoint sees all calls to it whichever client made them.
+var canadaPostTransport = newRetryTransport(http.DefaultTransport)
+
+type idempotentKey struct{}
+
+// withIdempotent marks a request that isn't a GET as safe to retry, such as
+// rating. Requests that create something, like shipments, must not be
+// marked: a retried request that did reach Canada Post buys a second label.
+func withIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(req *http.Request) bool {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return true
+	}
+	marked, _ := req.Context().Value(idempotentKey{}).(bool)
+	return marked
+}
+
+// retryTransport retries idempotent calls on network errors, 5xx and 429
+// with jittered exponential backoff, and polls artifacts that Canada Post
+// answers with 202 (not ready yet). Other calls are only retried when they
+// can't have been processed: on 429 or when the connection was never made.
+// Each attempt has its own timeout and goes through the endpoint's breaker.
+type retryTransport struct {
+	next           http.RoundTripper
+	maxAttempts    int
+	baseDelay      time.Duration
+	maxDelay       time.Duration
+	attemptTimeout time.Duration
+	breakers       *circuitBreakers
+	sleep          func(ctx context.Context, d time.Duration) error
+}
+
+func newRetryTransport(next http.RoundTripper) *retryTransport {
+	return &retryTransport{
+		next:           next,
+		maxAttempts:    4,
+		baseDelay:      300 * time.Millisecond,
+		maxDelay:       5 * time.Second,
+		attemptTimeout: 20 * time.Second,
+		breakers:       newCircuitBreakers(5, 30*time.Second),
+		sleep:          sleepContext,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := endpointKey(req)
+	idempotent := isIdempotent(req)
+	for attempt := 1; ; attempt++ {
+		if !t.breakers.allow(key) {
+			if req.Body != nil {
+				_ = req.Body.Close()
+			}
+			return nil, fmt.Errorf("%w: %s", errCircuitOpen, key)
+		}
+		attemptReq, cancel, err := t.attemptRequest(req, attempt)
+		if err != nil {
+			t.breakers.release(key)
+			return nil, err
+		}
+		resp, err := t.next.RoundTrip(attemptReq)
+		if req.Context().Err() != nil {
+			t.breakers.release(key)
+		} else {
+			t.breakers.record(key, failedAttempt(resp, err))
+		}
+
+		retry := t.shouldRetry(req, idempotent, resp, err)
+		if !retry || attempt >= t.maxAttempts || (req.Body != nil && req.GetBody == nil) {
+			if resp != nil {
+				resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			} else {
+				cancel()
+			}
+			return resp, err
+		}
+
+		delay := t.backoff(attempt, resp)
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			_ = resp.Body.Close()
+		}
+		cancel()
+		log.Printf("canada post retry: endpoint=%s attempt=%d status=%d err=%v delay=%s", key, attempt, status, err, delay)
+		if err := t.sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *retryTransport) attemptRequest(req *http.Request, attempt int) (*http.Request, context.CancelFunc, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.attemptTimeout)
+	attemptReq := req.Clone(ctx)
+	if attempt > 1 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		attemptReq.Body = body
+	}
+	return attemptReq, cancel, nil
+}
+
+func (t *retryTransport) shouldRetry(req *http.Request, idempotent bool, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		if errors.Is(err, errNoFixture) {
+			return false
+		}
+		return idempotent || isDialError(err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true
+	case resp.StatusCode >= 500:
+		return idempotent
+	case resp.StatusCode == http.StatusAccepted:
+		// Artifacts that are still being generated come back as 202.
+		return req.Method == http.MethodGet
+	}
+	return false
+}
+
+// failedAttempt reports whether an attempt counts against the breaker.
+func failedAttempt(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// backoff returns the delay before the next attempt: exponential with
+// jitter, or the response's Retry-After if that is longer, up to maxDelay.
+func (t *retryTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	delay := t.baseDelay << (attempt - 1)
+	if delay <= 0 || delay > t.maxDelay {
+		delay = t.maxDelay
+	}
+	if half := int64(delay / 2); half > 0 {
+		delay = time.Duration(half + rand.Int63n(half+1))
+	}
+	if resp != nil {
+		if seconds, err := strconv.Atoi(strings.TrimSpace(resp.Header.Get("Retry-After"))); err == nil && seconds > 0 {
+			if retryAfter := time.Duration(seconds) * time.Second; retryAfter > delay {
+				delay = retryAfter
+			}
+		}
+	}
+	if delay > t.maxDelay {
+		delay = t.maxDelay
+	}
+	return delay
+}
+
+// isDialError reports whether the request failed before a connection was
+// made, so it can't have reached Canada Post.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// endpointKey groups requests by method, host and path, with the path
+// segments that hold IDs (anything with a digit) collapsed to "*".
+func endpointKey(req *http.Request) string {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	for i, segment := range segments {
+		if strings.ContainsAny(segment, "0123456789") {
+			segments[i] = "*"
+		}
+	}
+	return req.Method + " " + req.URL.Host + "/" + strings.Join(segments, "/")
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// cancelOnClose releases the attempt's context once the body has been read.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// circuitBreakers keeps one breaker per endpoint. A breaker opens after
+// threshold consecutive failed attempts and refuses calls for cooldown; after
+// that a single probe is let through, which closes it again on success.
+type circuitBreakers struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	states    map[string]*breakerState
+}
+
+type breakerState struct {
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreakers(threshold int, cooldown time.Duration) *circuitBreakers {
+	return &circuitBreakers{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		states:    map[string]*breakerState{},
+	}
+}
+
+func (b *circuitBreakers) allow(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.states[key]
+	if state == nil || state.failures < b.threshold {
+		return true
+	}
+	if b.now().Sub(state.openedAt) < b.cooldown || state.probing {
+		return false
+	}
+	state.probing = true
+	return true
+}
+
+func (b *circuitBreakers) record(key string, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.states[key]
+	if state == nil {
+		state = &breakerState{}
+		b.states[key] = state
+	}
+	state.probing = false
+	if !failed {
+		state.failures = 0
+		return
+	}
+	state.failures++
+	if state.failures >= b.threshold {
+		state.openedAt = b.now()
+	}
+}
+
+// release ends a probe whose outcome is unknown, e.g. a cancelled call.
+func (b *circuitBreakers) release(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if state := b.states[key]; state != nil {
+		state.probing = false
+	}
+}
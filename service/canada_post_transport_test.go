@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestCanadaPostClient(baseURL string, transport *retryTransport) *CanadaPostClient {
+	transport.sleep = func(context.Context, time.Duration) error { return nil }
+	client := NewCanadaPostClient("user", "pass", "0001234567", baseURL)
+	client.HTTPClient = &http.Client{Timeout: 5 * time.Second, Transport: transport}
+	return client
+}
+
+func TestRetryTransportRetriesRatesOnServerError(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			http.Error(w, "gateway hiccup", http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`<price-quotes xmlns="http://www.canadapost.ca/ws/ship/rate-v4"></price-quotes>`))
+	}))
+	defer server.Close()
+
+	client := newTestCanadaPostClient(server.URL, newRetryTransport(http.DefaultTransport))
+	if _, err := client.GetRates(context.Background(), &RateRequest{}); err != nil {
+		t.Fatalf("expected rates after retries, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestRetryTransportDoesNotRetryShipmentCreation(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := newTestCanadaPostClient(server.URL, newRetryTransport(http.DefaultTransport))
+	if _, err := client.CreateShipment(context.Background(), &ShipmentRequest{}); err == nil {
+		t.Fatalf("expected shipment creation to fail")
+	}
+	if calls != 1 {
+		t.Fatalf("expected a single call, got %d", calls)
+	}
+}
+
+func TestRetryTransportWaitsForArtifact(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		_, _ = w.Write([]byte("%PDF"))
+	}))
+	defer server.Close()
+
+	client := newTestCanadaPostClient(server.URL, newRetryTransport(http.DefaultTransport))
+	data, err := client.GetArtifact(context.Background(), server.URL+"/ers/artifact/1/0", "")
+	if err != nil {
+		t.Fatalf("expected artifact, got %v", err)
+	}
+	if string(data) != "%PDF" || calls != 2 {
+		t.Fatalf("unexpected artifact %q after %d calls", data, calls)
+	}
+}
+
+func TestRetryTransportOpensCircuit(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	now := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	transport := newRetryTransport(http.DefaultTransport)
+	transport.maxAttempts = 1
+	transport.breakers = newCircuitBreakers(2, time.Minute)
+	transport.breakers.now = func() time.Time { return now }
+	client := newTestCanadaPostClient(server.URL, transport)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.FindPostOffices(context.Background(), "K1A0B1"); err == nil {
+			t.Fatalf("expected call %d to fail", i)
+		}
+	}
+	_, err := client.FindPostOffices(context.Background(), "K1A0B1")
+	if !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected the open circuit to skip the call, got %d calls", calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := client.FindPostOffices(context.Background(), "K1A0B1"); errors.Is(err, errCircuitOpen) {
+		t.Fatalf("expected a probe after the cooldown, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected the probe to reach the server, got %d calls", calls)
+	}
+}
@@ -6,8 +6,10 @@ type RateRequest struct {
 	XMLName               xml.Name `xml:"mailing-scenario"`
 	XMLNS                 string   `xml:"xmlns,attr"`
 	CustomerNumber        string   `xml:"customer-number,omitempty"`
+	ContractID            string   `xml:"contract-id,omitempty"`
+	ExpectedMailingDate   string   `xml:"expected-mailing-date,omitempty"`
 	ParcelCharacteristics struct {
-		Weight     float64     `xml:"weight"`
+		Weight     kilograms   `xml:"weight"`
 		Dimensions *Dimensions `xml:"dimensions,omitempty"`
 	} `xml:"parcel-characteristics"`
 	OriginPostalCode string `xml:"origin-postal-code"`
@@ -110,56 +112,99 @@ type ShipmentRequest struct {
 	XMLNS                  string   `xml:"xmlns,attr"`
 	RequestedShippingPoint string   `xml:"requested-shipping-point,omitempty"`
 
-	DeliverySpec struct {
-		ServiceCode string `xml:"service-code"`
-
-		Sender struct {
-			Name           string `xml:"name,omitempty"`
-			Company        string `xml:"company"`
-			ContactPhone   string `xml:"contact-phone"`
-			AddressDetails struct {
-				AddressLine1 string `xml:"address-line-1"`
-				AddressLine2 string `xml:"address-line-2,omitempty"`
-				City         string `xml:"city"`
-				ProvState    string `xml:"prov-state"`
-				PostalCode   string `xml:"postal-zip-code"`
-			} `xml:"address-details"`
-		} `xml:"sender"`
-
-		Destination struct {
-			Name              string `xml:"name"`
-			Company           string `xml:"company,omitempty"`
-			ClientVoiceNumber string `xml:"client-voice-number,omitempty"`
-			AddressDetails    struct {
-				AddressLine1 string `xml:"address-line-1"`
-				AddressLine2 string `xml:"address-line-2,omitempty"`
-				City         string `xml:"city"`
-				ProvState    string `xml:"prov-state"`
-				CountryCode  string `xml:"country-code"`
-				PostalCode   string `xml:"postal-zip-code"`
-			} `xml:"address-details"`
-		} `xml:"destination"`
-
-		ParcelCharacteristics struct {
-			Weight     float64     `xml:"weight"`
-			Dimensions *Dimensions `xml:"dimensions,omitempty"`
-		} `xml:"parcel-characteristics"`
-
-		Notification *ShipmentNotification `xml:"notification,omitempty"`
-
-		Preferences struct {
-			ShowPackingInstructions bool `xml:"show-packing-instructions"`
-		} `xml:"preferences"`
-
-		Options *ShipmentOptions `xml:"options,omitempty"`
-		Customs *ShipmentCustoms `xml:"customs,omitempty"`
-	} `xml:"delivery-spec"`
+	DeliverySpec ShipmentDeliverySpec `xml:"delivery-spec"`
+}
+
+type ShipmentDeliverySpec struct {
+	ServiceCode string `xml:"service-code"`
+
+	Sender struct {
+		Name           string `xml:"name,omitempty"`
+		Company        string `xml:"company"`
+		ContactPhone   string `xml:"contact-phone"`
+		AddressDetails struct {
+			AddressLine1 string `xml:"address-line-1"`
+			AddressLine2 string `xml:"address-line-2,omitempty"`
+			City         string `xml:"city"`
+			ProvState    string `xml:"prov-state"`
+			CountryCode  string `xml:"country-code,omitempty"`
+			PostalCode   string `xml:"postal-zip-code"`
+		} `xml:"address-details"`
+	} `xml:"sender"`
+
+	Destination struct {
+		Name              string `xml:"name"`
+		Company           string `xml:"company,omitempty"`
+		ClientVoiceNumber string `xml:"client-voice-number,omitempty"`
+		AddressDetails    struct {
+			AddressLine1 string `xml:"address-line-1"`
+			AddressLine2 string `xml:"address-line-2,omitempty"`
+			City         string `xml:"city"`
+			ProvState    string `xml:"prov-state"`
+			CountryCode  string `xml:"country-code"`
+			PostalCode   string `xml:"postal-zip-code"`
+		} `xml:"address-details"`
+	} `xml:"destination"`
+
+	ParcelCharacteristics struct {
+		Weight     kilograms   `xml:"weight"`
+		Dimensions *Dimensions `xml:"dimensions,omitempty"`
+	} `xml:"parcel-characteristics"`
+
+	Notification *ShipmentNotification `xml:"notification,omitempty"`
+
+	PrintPreferences *PrintPreferences `xml:"print-preferences,omitempty"`
+
+	Preferences struct {
+		ShowPackingInstructions bool `xml:"show-packing-instructions"`
+	} `xml:"preferences"`
+
+	Options *ShipmentOptions `xml:"options,omitempty"`
+	Customs *ShipmentCustoms `xml:"customs,omitempty"`
+}
+
+// Create Shipment (contract) – REST
+type ContractShipmentRequest struct {
+	XMLName                xml.Name `xml:"shipment"`
+	XMLNS                  string   `xml:"xmlns,attr"`
+	GroupID                string   `xml:"group-id"`
+	RequestedShippingPoint string   `xml:"requested-shipping-point,omitempty"`
+	ExpectedMailingDate    string   `xml:"expected-mailing-date,omitempty"`
+
+	DeliverySpec ContractDeliverySpec `xml:"delivery-spec"`
+}
+
+type ContractDeliverySpec struct {
+	ShipmentDeliverySpec
+	SettlementInfo ContractSettlementInfo `xml:"settlement-info"`
+}
+
+type ContractSettlementInfo struct {
+	ContractID              string `xml:"contract-id"`
+	IntendedMethodOfPayment string `xml:"intended-method-of-payment"`
+}
+
+type ContractShipmentResponse struct {
+	XMLName        xml.Name `xml:"shipment-info"`
+	ShipmentID     string   `xml:"shipment-id"`
+	ShipmentStatus string   `xml:"shipment-status"`
+	TrackingPIN    string   `xml:"tracking-pin"`
+	Links          struct {
+		Link []Link `xml:"link"`
+	} `xml:"links"`
+}
+
+// PrintPreferences selects the label paper size (8.5x11 or 4x6) and
+// encoding (PDF or ZPL).
+type PrintPreferences struct {
+	OutputFormat string `xml:"output-format"`
+	Encoding     string `xml:"encoding"`
 }
 
 type Dimensions struct {
-	Length float64 `xml:"length"`
-	Width  float64 `xml:"width"`
-	Height float64 `xml:"height"`
+	Length centimetres `xml:"length"`
+	Width  centimetres `xml:"width"`
+	Height centimetres `xml:"height"`
 }
 
 type ShipmentOptions struct {
@@ -202,21 +247,23 @@ type ShipmentCustomsList struct {
 }
 
 type ShipmentCustomsItem struct {
-	CustomsNumberOfUnits int     `xml:"customs-number-of-units,omitempty"`
-	CustomsDescription   string  `xml:"customs-description,omitempty"`
-	UnitWeight           float64 `xml:"unit-weight,omitempty"`
-	CustomsValuePerUnit  float64 `xml:"customs-value-per-unit,omitempty"`
-	HSTariffCode         string  `xml:"hs-tariff-code,omitempty"`
-	SKU                  string  `xml:"sku,omitempty"`
-	CountryOfOrigin      string  `xml:"country-of-origin,omitempty"`
-	ProvinceOfOrigin     string  `xml:"province-of-origin,omitempty"`
+	CustomsNumberOfUnits int       `xml:"customs-number-of-units,omitempty"`
+	CustomsDescription   string    `xml:"customs-description,omitempty"`
+	UnitWeight           kilograms `xml:"unit-weight,omitempty"`
+	CustomsValuePerUnit  float64   `xml:"customs-value-per-unit,omitempty"`
+	HSTariffCode         string    `xml:"hs-tariff-code,omitempty"`
+	SKU                  string    `xml:"sku,omitempty"`
+	CountryOfOrigin      string    `xml:"country-of-origin,omitempty"`
+	ProvinceOfOrigin     string    `xml:"province-of-origin,omitempty"`
 }
 
 type ShipmentResponse struct {
 	XMLName     xml.Name `xml:"non-contract-shipment-info"`
 	ShipmentID  string   `xml:"shipment-id"`
 	TrackingPIN string   `xml:"tracking-pin"`
-	Links       struct {
+	// GroupID is set when the shipment was created through the contract API.
+	GroupID string `xml:"-"`
+	Links   struct {
 		Link []Link `xml:"link"`
 	} `xml:"links"`
 }
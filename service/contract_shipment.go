@@ -0,0 +1,75 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"lexmodo-plugin/database"
+)
+
+const (
+	paymentMethodAccount    = "Account"
+	paymentMethodCreditCard = "CreditCard"
+)
+
+// contractShippingEnabled reports whether the client ships under a commercial
+// Canada Post contract instead of the non-contract (counter) API.
+func contractShippingEnabled(settings database.ShippingSettings) bool {
+	return strings.TrimSpace(settings.ContractID) != ""
+}
+
+// contractCustomerNumber is the mailed-by customer number used for contract
+// rating and shipment creation.
+func (s *Server) contractCustomerNumber(settings database.ShippingSettings) string {
+	if accountNumber := strings.TrimSpace(settings.AccountNumber); accountNumber != "" {
+		return accountNumber
+	}
+	return strings.TrimSpace(s.Config.CanadaPost.CustomerNumber)
+}
+
+// contractGroupID returns the configured group, or a per-day group so each
+// day's shipments can be transmitted together.
+func contractGroupID(settings database.ShippingSettings, now time.Time) string {
+	if groupID := strings.TrimSpace(settings.GroupID); groupID != "" {
+		return groupID
+	}
+	return now.Format("20060102")
+}
+
+func normalizePaymentMethod(value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "creditcard", "credit_card", "credit card":
+		return paymentMethodCreditCard
+	default:
+		return paymentMethodAccount
+	}
+}
+
+func buildContractShipmentRequest(payload *ShipmentRequest, settings database.ShippingSettings, now time.Time) *ContractShipmentRequest {
+	contract := &ContractShipmentRequest{
+		GroupID:                contractGroupID(settings, now),
+		RequestedShippingPoint: payload.RequestedShippingPoint,
+	}
+	contract.DeliverySpec.ShipmentDeliverySpec = payload.DeliverySpec
+	contract.DeliverySpec.Sender.AddressDetails.CountryCode = "CA"
+	contract.DeliverySpec.SettlementInfo = ContractSettlementInfo{
+		ContractID:              strings.TrimSpace(settings.ContractID),
+		IntendedMethodOfPayment: normalizePaymentMethod(settings.PaymentMethod),
+	}
+	return contract
+}
+
+// shipmentRefundLinks returns the refund link of a non-contract shipment and
+// the self link of a contract shipment. Contract shipments are voided
+// through their self link until they are transmitted.
+func shipmentRefundLinks(shipment *ShipmentResponse) (refundURL, shipmentURL string) {
+	for _, link := range shipment.Links.Link {
+		switch {
+		case link.Rel == "refund":
+			refundURL = link.Href
+		case link.Rel == "self" && shipment.GroupID != "":
+			shipmentURL = link.Href
+		}
+	}
+	return refundURL, shipmentURL
+}
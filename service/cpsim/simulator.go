@@ -0,0 +1,572 @@
+// Package cpsim is an in-process Canada Post API simulator for tests. It
+// serves the rating, non-contract shipment, artifact, receipt, refund and
+// post office endpoints with the XML documented in docs/canada_post_xml.md,
+// and can be told to fail calls to any endpoint.
+package cpsim
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoint names a simulated Canada Post endpoint for failure injection and
+// call counting.
+type Endpoint string
+
+const (
+	Price      Endpoint = "price"
+	Shipment   Endpoint = "shipment"
+	Artifact   Endpoint = "artifact"
+	Receipt    Endpoint = "receipt"
+	Refund     Endpoint = "refund"
+	PostOffice Endpoint = "postoffice"
+)
+
+// Failure makes the next Times calls to an endpoint wait Delay and then
+// answer Status. With a Code the body is a Canada Post <messages> block.
+// A Status of 202 on Artifact simulates a label that isn't generated yet; a
+// zero Status only adds the delay.
+type Failure struct {
+	Status      int
+	Code        string
+	Description string
+	Times       int
+	Delay       time.Duration
+}
+
+// CreatedShipment is a shipment created through the simulator.
+type CreatedShipment struct {
+	ID          string
+	TrackingPIN string
+	ServiceCode string
+	Country     string
+	Encoding    string
+	Quote       Quote
+	RefundURL   string
+	RefundEmail string
+}
+
+// Quote is the price of one service, in CAD.
+type Quote struct {
+	ServiceCode  string
+	ServiceName  string
+	Base         float64
+	Tax          float64
+	Due          float64
+	TransitDays  int
+	Guaranteed   bool
+	DeliveryDate string
+}
+
+type Simulator struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	failures  map[Endpoint][]Failure
+	calls     map[Endpoint]int
+	shipments map[string]*CreatedShipment
+	order     []string
+	nextID    int64
+}
+
+// New starts a simulator. Point a CanadaPostClient at URL and Close it when
+// the test ends.
+func New() *Simulator {
+	sim := &Simulator{
+		failures:  map[Endpoint][]Failure{},
+		calls:     map[Endpoint]int{},
+		shipments: map[string]*CreatedShipment{},
+		nextID:    340531309186521749,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /rs/ship/price", sim.handle(Price, sim.price))
+	mux.HandleFunc("POST /rs/{customer}/ncshipment", sim.handle(Shipment, sim.createShipment))
+	mux.HandleFunc("GET /rs/{customer}/ncshipment/{id}/receipt", sim.handle(Receipt, sim.receipt))
+	mux.HandleFunc("POST /rs/{customer}/ncshipment/{id}/refund", sim.handle(Refund, sim.refund))
+	mux.HandleFunc("GET /ers/artifact/{key}/{id}/{index}", sim.handle(Artifact, sim.artifact))
+	mux.HandleFunc("GET /rs/postoffice", sim.handle(PostOffice, sim.postOffices))
+	sim.server = httptest.NewServer(mux)
+	return sim
+}
+
+func (s *Simulator) URL() string {
+	return s.server.URL
+}
+
+func (s *Simulator) Close() {
+	s.server.Close()
+}
+
+// Inject queues a failure for endpoint. Failures are used in the order they
+// were injected.
+func (s *Simulator) Inject(endpoint Endpoint, failure Failure) {
+	if failure.Times <= 0 {
+		failure.Times = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[endpoint] = append(s.failures[endpoint], failure)
+}
+
+// Calls returns how many requests reached endpoint, failed ones included.
+func (s *Simulator) Calls(endpoint Endpoint) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[endpoint]
+}
+
+// Shipments returns the created shipments, oldest first.
+func (s *Simulator) Shipments() []CreatedShipment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shipments := make([]CreatedShipment, 0, len(s.order))
+	for _, id := range s.order {
+		shipments = append(shipments, *s.shipments[id])
+	}
+	return shipments
+}
+
+func (s *Simulator) handle(endpoint Endpoint, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.calls[endpoint]++
+		failure, failing := s.takeFailure(endpoint)
+		s.mu.Unlock()
+
+		if user, pass, ok := r.BasicAuth(); !ok || user == "" || pass == "" {
+			writeMessages(w, http.StatusUnauthorized, "E002", "AAA Authentication Failure")
+			return
+		}
+		if failing {
+			if failure.Delay > 0 {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(failure.Delay):
+				}
+			}
+			if failure.Status != 0 {
+				writeFailure(w, failure)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func (s *Simulator) takeFailure(endpoint Endpoint) (Failure, bool) {
+	queue := s.failures[endpoint]
+	if len(queue) == 0 {
+		return Failure{}, false
+	}
+	failure := queue[0]
+	queue[0].Times--
+	if queue[0].Times <= 0 {
+		s.failures[endpoint] = queue[1:]
+	}
+	return failure, true
+}
+
+func writeFailure(w http.ResponseWriter, failure Failure) {
+	if failure.Code != "" || failure.Description != "" {
+		writeMessages(w, failure.Status, failure.Code, failure.Description)
+		return
+	}
+	if failure.Status == http.StatusAccepted {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	http.Error(w, http.StatusText(failure.Status), failure.Status)
+}
+
+func writeMessages(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/vnd.cpc.messages+xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<messages xmlns="http://www.canadapost.ca/ws/messages">
+  <message>
+    <code>%s</code>
+    <description>%s</description>
+  </message>
+</messages>`, xmlText(code), xmlText(description))
+}
+
+func writeXML(w http.ResponseWriter, status int, mediaType string, body string) {
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + body))
+}
+
+func xmlText(value string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
+
+type mailingScenario struct {
+	MailingDate   string    `xml:"expected-mailing-date"`
+	Weight        float64   `xml:"parcel-characteristics>weight"`
+	OriginPostal  string    `xml:"origin-postal-code"`
+	UnitedStates  *struct{} `xml:"destination>united-states"`
+	International *struct {
+		CountryCode string `xml:"country-code"`
+	} `xml:"destination>international"`
+}
+
+func (s *Simulator) price(w http.ResponseWriter, r *http.Request) {
+	var req mailingScenario
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeMessages(w, http.StatusBadRequest, "Server", "Invalid XML: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(req.OriginPostal) == "" {
+		writeMessages(w, http.StatusBadRequest, "Server", "/mailing-scenario/origin-postal-code is required")
+		return
+	}
+	if req.Weight <= 0 {
+		writeMessages(w, http.StatusBadRequest, "9111", "The weight must be greater than zero.")
+		return
+	}
+	country := "CA"
+	if req.UnitedStates != nil {
+		country = "US"
+	} else if req.International != nil {
+		country = strings.ToUpper(req.International.CountryCode)
+	}
+
+	mailed := time.Now()
+	if date, err := time.Parse("2006-01-02", strings.TrimSpace(req.MailingDate)); err == nil {
+		mailed = date
+	}
+
+	var b strings.Builder
+	b.WriteString(`<price-quotes xmlns="http://www.canadapost.ca/ws/ship/rate-v4">`)
+	for _, quote := range quotes(country, req.Weight, mailed) {
+		fmt.Fprintf(&b, `
+  <price-quote>
+    <service-code>%s</service-code>
+    <service-link rel="service" href="%s/rs/ship/service/%s?country=%s" media-type="application/vnd.cpc.ship.rate-v4+xml"/>
+    <service-name>%s</service-name>
+    <price-details>
+      <base>%.2f</base>
+      <taxes>
+        <gst percent="0">0.00</gst>
+        <pst percent="0">0.00</pst>
+        <hst percent="%s">%.2f</hst>
+      </taxes>
+      <due>%.2f</due>
+      <options/>
+      <adjustments/>
+    </price-details>
+    <weight-details/>
+    <service-standard>
+      <am-delivery>false</am-delivery>
+      <guaranteed-delivery>%t</guaranteed-delivery>
+      <expected-transit-time>%d</expected-transit-time>
+      <expected-delivery-date>%s</expected-delivery-date>
+    </service-standard>
+  </price-quote>`,
+			quote.ServiceCode, s.server.URL, quote.ServiceCode, country, xmlText(quote.ServiceName),
+			quote.Base, hstPercent(country), quote.Tax, quote.Due,
+			quote.Guaranteed, quote.TransitDays, quote.DeliveryDate)
+	}
+	b.WriteString("\n</price-quotes>")
+	writeXML(w, http.StatusOK, "application/vnd.cpc.ship.rate-v4+xml", b.String())
+}
+
+type serviceRate struct {
+	code        string
+	name        string
+	base        float64
+	perKg       float64
+	transitDays int
+	guaranteed  bool
+}
+
+var serviceRates = map[string][]serviceRate{
+	"CA": {
+		{code: "DOM.RP", name: "Regular Parcel", base: 9.50, perKg: 1.80, transitDays: 4},
+		{code: "DOM.EP", name: "Expedited Parcel", base: 11.20, perKg: 2.10, transitDays: 2},
+		{code: "DOM.XP", name: "Xpresspost", base: 16.40, perKg: 3.20, transitDays: 1, guaranteed: true},
+	},
+	"US": {
+		{code: "USA.EP", name: "Expedited Parcel USA", base: 19.90, perKg: 4.10, transitDays: 5},
+		{code: "USA.XP", name: "Xpresspost USA", base: 31.50, perKg: 6.00, transitDays: 3, guaranteed: true},
+	},
+	"INT": {
+		{code: "INT.IP.SURF", name: "International Parcel Surface", base: 28.00, perKg: 7.00, transitDays: 30},
+		{code: "INT.XP", name: "Xpresspost International", base: 55.00, perKg: 12.00, transitDays: 6, guaranteed: true},
+	},
+}
+
+// quotes prices every service to country for a parcel of weight kg.
+// Domestic quotes include 13% HST; others are untaxed.
+func quotes(country string, weight float64, now time.Time) []Quote {
+	zone := country
+	if zone != "CA" && zone != "US" {
+		zone = "INT"
+	}
+	out := make([]Quote, 0, len(serviceRates[zone]))
+	for _, rate := range serviceRates[zone] {
+		base := roundCents(rate.base + rate.perKg*weight)
+		tax := 0.0
+		if zone == "CA" {
+			tax = roundCents(base * 0.13)
+		}
+		out = append(out, Quote{
+			ServiceCode:  rate.code,
+			ServiceName:  rate.name,
+			Base:         base,
+			Tax:          tax,
+			Due:          roundCents(base + tax),
+			TransitDays:  rate.transitDays,
+			Guaranteed:   rate.guaranteed,
+			DeliveryDate: now.AddDate(0, 0, rate.transitDays).Format("2006-01-02"),
+		})
+	}
+	return out
+}
+
+func quoteFor(serviceCode, country string, weight float64) (Quote, bool) {
+	for _, quote := range quotes(country, weight, time.Now()) {
+		if quote.ServiceCode == serviceCode {
+			return quote, true
+		}
+	}
+	return Quote{}, false
+}
+
+func hstPercent(country string) string {
+	if country == "CA" {
+		return "13"
+	}
+	return "0"
+}
+
+func roundCents(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+type nonContractShipment struct {
+	ServiceCode string  `xml:"delivery-spec>service-code"`
+	Country     string  `xml:"delivery-spec>destination>address-details>country-code"`
+	Weight      float64 `xml:"delivery-spec>parcel-characteristics>weight"`
+	Encoding    string  `xml:"delivery-spec>print-preferences>encoding"`
+}
+
+func (s *Simulator) createShipment(w http.ResponseWriter, r *http.Request) {
+	var req nonContractShipment
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeMessages(w, http.StatusBadRequest, "Server", "Invalid XML: "+err.Error())
+		return
+	}
+	country := strings.ToUpper(strings.TrimSpace(req.Country))
+	if country == "" {
+		country = "CA"
+	}
+	quote, ok := quoteFor(strings.TrimSpace(req.ServiceCode), country, req.Weight)
+	if !ok {
+		writeMessages(w, http.StatusBadRequest, "8511", "The service "+req.ServiceCode+" is not available for the destination.")
+		return
+	}
+	encoding := strings.ToUpper(strings.TrimSpace(req.Encoding))
+	if encoding == "" {
+		encoding = "PDF"
+	}
+
+	customer := r.PathValue("customer")
+	s.mu.Lock()
+	s.nextID++
+	id := strconv.FormatInt(s.nextID, 10)
+	shipment := &CreatedShipment{
+		ID:          id,
+		TrackingPIN: fmt.Sprintf("%016d", s.nextID%10000000000000000),
+		ServiceCode: quote.ServiceCode,
+		Country:     country,
+		Encoding:    encoding,
+		Quote:       quote,
+		RefundURL:   fmt.Sprintf("%s/rs/%s/ncshipment/%s/refund", s.server.URL, customer, id),
+	}
+	s.shipments[id] = shipment
+	s.order = append(s.order, id)
+	s.mu.Unlock()
+
+	labelMediaType := "application/pdf"
+	if encoding == "ZPL" {
+		labelMediaType = "application/zpl"
+	}
+	shipmentURL := fmt.Sprintf("%s/rs/%s/ncshipment/%s", s.server.URL, customer, id)
+	var links strings.Builder
+	fmt.Fprintf(&links, `
+    <link rel="self" href="%s" media-type="application/vnd.cpc.ncshipment-v4+xml"/>
+    <link rel="details" href="%s/details" media-type="application/vnd.cpc.ncshipment-v4+xml"/>
+    <link rel="receipt" href="%s/receipt" media-type="application/vnd.cpc.ncshipment-v4+xml"/>
+    <link rel="refund" href="%s" media-type="application/vnd.cpc.ncshipment-v4+xml"/>
+    <link rel="label" href="%s/ers/artifact/%s/%s/0" media-type="%s" index="0"/>`,
+		shipmentURL, shipmentURL, shipmentURL, shipment.RefundURL, s.server.URL, customer, id, labelMediaType)
+	if country != "CA" {
+		fmt.Fprintf(&links, `
+    <link rel="commercialInvoice" href="%s/ers/artifact/%s/%s-ci/0" media-type="application/pdf" index="0"/>`,
+			s.server.URL, customer, id)
+	}
+	writeXML(w, http.StatusOK, "application/vnd.cpc.ncshipment-v4+xml", fmt.Sprintf(`<non-contract-shipment-info xmlns="http://www.canadapost.ca/ws/ncshipment-v4">
+  <shipment-id>%s</shipment-id>
+  <tracking-pin>%s</tracking-pin>
+  <links>%s
+  </links>
+</non-contract-shipment-info>`, id, shipment.TrackingPIN, links.String()))
+}
+
+func (s *Simulator) shipment(id string) (CreatedShipment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shipment, ok := s.shipments[id]
+	if !ok {
+		return CreatedShipment{}, false
+	}
+	return *shipment, true
+}
+
+func (s *Simulator) receipt(w http.ResponseWriter, r *http.Request) {
+	shipment, ok := s.shipment(r.PathValue("id"))
+	if !ok {
+		writeMessages(w, http.StatusNotFound, "8000", "The shipment was not found.")
+		return
+	}
+	quote := shipment.Quote
+	writeXML(w, http.StatusOK, "application/vnd.cpc.ncshipment-v4+xml", fmt.Sprintf(`<non-contract-shipment-receipt xmlns="http://www.canadapost.ca/ws/ncshipment-v4">
+  <service-code>%s</service-code>
+  <base-amount>%.2f</base-amount>
+  <pre-tax-amount>%.2f</pre-tax-amount>
+  <gst-amount>0.00</gst-amount>
+  <pst-amount>0.00</pst-amount>
+  <hst-amount>%.2f</hst-amount>
+  <priced-options/>
+  <adjustments/>
+  <cc-receipt-details>
+    <charge-amount>%.2f</charge-amount>
+    <currency>CAD</currency>
+  </cc-receipt-details>
+</non-contract-shipment-receipt>`, quote.ServiceCode, quote.Base, quote.Base, quote.Tax, quote.Due))
+}
+
+type refundRequest struct {
+	Email string `xml:"email"`
+}
+
+func (s *Simulator) refund(w http.ResponseWriter, r *http.Request) {
+	var req refundRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeMessages(w, http.StatusBadRequest, "Server", "Invalid XML: "+err.Error())
+		return
+	}
+	s.mu.Lock()
+	shipment, ok := s.shipments[r.PathValue("id")]
+	alreadyRefunded := ok && shipment.RefundEmail != ""
+	if ok && !alreadyRefunded {
+		shipment.RefundEmail = strings.TrimSpace(req.Email)
+	}
+	s.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if alreadyRefunded {
+		writeMessages(w, http.StatusBadRequest, "7292", "Refund already submitted")
+		return
+	}
+	writeXML(w, http.StatusOK, "application/vnd.cpc.ncshipment-v4+xml", fmt.Sprintf(`<non-contract-shipment-refund-request-info xmlns="http://www.canadapost.ca/ws/ncshipment-v4">
+  <service-ticket-date>%s</service-ticket-date>
+  <service-ticket-id>%s</service-ticket-id>
+</non-contract-shipment-refund-request-info>`, time.Now().Format("2006-01-02"), "0"+shipment.ID[len(shipment.ID)-9:]))
+}
+
+func (s *Simulator) artifact(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(r.PathValue("id"), "-ci")
+	shipment, ok := s.shipment(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if shipment.Encoding == "ZPL" && !strings.HasSuffix(r.PathValue("id"), "-ci") {
+		w.Header().Set("Content-Type", "application/zpl")
+		fmt.Fprintf(w, "^XA^FO50,50^A0N,40,40^FD%s^FS^XZ", shipment.TrackingPIN)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	_, _ = w.Write(labelPDF(shipment.ServiceCode + " " + shipment.TrackingPIN))
+}
+
+// labelPDF returns a one-page PDF showing text, laid out like Canada Post
+// labels: uncompressed objects and a classic cross-reference table.
+func labelPDF(text string) []byte {
+	content := fmt.Sprintf("BT /F1 18 Tf 36 360 Td (%s) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 288 432] /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+	var doc strings.Builder
+	doc.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = doc.Len()
+		fmt.Fprintf(&doc, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := doc.Len()
+	fmt.Fprintf(&doc, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&doc, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&doc, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return []byte(doc.String())
+}
+
+func (s *Simulator) postOffices(w http.ResponseWriter, r *http.Request) {
+	postalCode := strings.ToUpper(strings.ReplaceAll(r.URL.Query().Get("postalCode"), " ", ""))
+	if postalCode == "" {
+		writeMessages(w, http.StatusBadRequest, "1502", "A postal code is required.")
+		return
+	}
+	writeXML(w, http.StatusOK, "application/vnd.cpc.postoffice+xml", fmt.Sprintf(`<post-office-list xmlns="http://www.canadapost.ca/ws/postoffice">
+  <post-office>
+    <address>
+      <city>OTTAWA</city>
+      <latitude>45.4215</latitude>
+      <longitude>-75.6972</longitude>
+      <office-address>59 SPARKS ST</office-address>
+      <postal-code>%s</postal-code>
+      <province>ON</province>
+    </address>
+    <distance>0.24</distance>
+    <location>SHOPPERS DRUG MART</location>
+    <name>SPARKS ST PO</name>
+    <office-id>0000102134</office-id>
+    <bilingual-designation>true</bilingual-designation>
+  </post-office>
+  <post-office>
+    <address>
+      <city>OTTAWA</city>
+      <latitude>45.4189</latitude>
+      <longitude>-75.6931</longitude>
+      <office-address>50 RIDEAU ST</office-address>
+      <postal-code>K1N9J7</postal-code>
+      <province>ON</province>
+    </address>
+    <distance>0.88</distance>
+    <location>RIDEAU CENTRE</location>
+    <name>RIDEAU PO</name>
+    <office-id>0000309852</office-id>
+    <bilingual-designation>false</bilingual-designation>
+  </post-office>
+</post-office-list>`, xmlText(postalCode)))
+}
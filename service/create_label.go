@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -71,7 +72,7 @@ func (s *Server) CreateLabel(
 	log.Printf("✅ Snapshot loaded: rate_id=%s service_code=%s", selectedRateID, snapshot.ServiceCode)
 	// CreateLabel customs are dynamic and should override cached snapshot customs when provided.
 	if customs := shipRequest.GetCustomsInfo(); customs != nil {
-		snapshot.CustomsInfo = snapshotCustoms(customs)
+		snapshot.CustomsInfo = snapshotCustoms(customs, s.requestUnits())
 	}
 	log.Printf("📦 CreateLabel XML includes: customs=%v phone=%s client_voice=%s",
 		snapshot.CustomsInfo != nil,
@@ -100,7 +101,7 @@ func (s *Server) CreateLabel(
 				Message: "client_id required for currency conversion",
 			}, nil
 		}
-		rate, ok, err := s.Store.LoadCurrencyRate(clientID, requestCurrency)
+		rate, ok, err := s.Store.ResolveExchangeRate(clientID, requestCurrency)
 		if err != nil {
 			return &shippingpluginpb.ResultResponse{
 				Success: false,
@@ -117,7 +118,10 @@ func (s *Server) CreateLabel(
 				Message: "missing conversion rate for " + requestCurrency,
 			}, nil
 		}
-		snapshot.RateToCad = rate
+		s.warnStaleExchangeRate(clientID, rate)
+		snapshot.RateToCad = rate.RateToCad
+		snapshot.FXRateID = rate.ID
+		snapshot.FXRateDate = fxRateDate(rate)
 	}
 
 	labelID := shipRequest.GetLabelId()
@@ -165,7 +169,7 @@ func (s *Server) CreateLabel(
 		return resp, nil
 	}
 	options = append(options, buildSnapshotOptions(snapshot)...)
-	options = dedupeShipmentOptions(options)
+	options = withMandatoryOptions(dedupeShipmentOptions(options), snapshot.ServiceCode)
 	if err := s.validateOptions(options, snapshot.ServiceCode, destCountry); err != nil {
 		resp := &shippingpluginpb.ResultResponse{
 			Success: false,
@@ -177,90 +181,90 @@ func (s *Server) CreateLabel(
 		return resp, nil
 	}
 
-	shipment, err := s.createShipmentFromSnapshot(ctx, snapshot, options, notification)
+	schedule := s.deliverySchedule(clientID)
+	shipDate, err := shipDateFromRequest(ctx, incomingCustomValues)
+	if err == nil && !shipDate.IsZero() {
+		var estimate deliveryEstimate
+		estimate, err = newDeliveryEstimate(time.Now(), schedule, snapshot.Origin.Province, snapshot.Destination).shipOn(shipDate)
+		snapshot.MailingDate = estimate.MailingDate.Format("2006-01-02")
+	}
 	if err != nil {
-		return &shippingpluginpb.ResultResponse{
+		resp := &shippingpluginpb.ResultResponse{
 			Success: false,
 			Failure: true,
 			Code:    "400",
 			Message: err.Error(),
-		}, nil
-	}
-
-	labelURL := ""
-	refundURL := ""
-	for _, link := range shipment.Links.Link {
-		if link.Rel == "label" {
-			labelURL = link.Href
-			continue
-		}
-		if link.Rel == "refund" {
-			refundURL = link.Href
 		}
+		logPluginResponse("CreateLabel", resp)
+		return resp, nil
 	}
-	if labelURL == "" {
-		return &shippingpluginpb.ResultResponse{
+
+	if err := s.requoteEstimatedRate(ctx, &snapshot); err != nil {
+		log.Println("❌ CreateLabel re-quote error:", err)
+		code, message := pluginErrorResult(err)
+		resp := &shippingpluginpb.ResultResponse{
 			Success: false,
 			Failure: true,
-			Code:    "500",
-			Message: "label URL not found in response",
-		}, nil
+			Code:    code,
+			Message: message,
+		}
+		logPluginResponse("CreateLabel", resp)
+		return resp, nil
 	}
 
-	labelPDF, err := s.CanadaPost.GetArtifact(ctx, labelURL)
+	shipments, err := s.createPieceShipments(ctx, snapshot, options, notification)
 	if err != nil {
+		log.Println("❌ CreateLabel shipment error:", err)
+		code, message := pluginErrorResult(err)
+		var rollbackErr *pieceRollbackError
+		if errors.As(err, &rollbackErr) {
+			message = fmt.Sprintf("%s Shipments %s were created for the other pieces and could not be cancelled; refund them from Canada Post.", message, strings.Join(rollbackErr.Remaining, ","))
+		}
 		return &shippingpluginpb.ResultResponse{
 			Success: false,
 			Failure: true,
-			Code:    "500",
-			Message: err.Error(),
+			Code:    code,
+			Message: message,
 		}, nil
 	}
+	shipment := shipments[0]
 
-	tracking := shipment.TrackingPIN
-	if err := s.saveLabelPDF(labelID, labelPDF); err != nil {
-		return &shippingpluginpb.ResultResponse{
-			Success: false,
-			Failure: true,
-			Code:    "500",
-			Message: err.Error(),
-		}, nil
+	refundURL, shipmentURL := shipmentRefundLinks(shipment)
+	artifactLinks := labelArtifactLinks(shipment.Links.Link)
+	if _, ok := labelArtifactOf(artifactLinks); !ok {
+		return s.cancelCreatedLabel(ctx, snapshot, shipments, errors.New("label URL not found in response")), nil
+	}
+
+	artifacts, err := s.saveLabelArtifacts(ctx, labelID, artifactLinks)
+	if err != nil {
+		return s.cancelCreatedLabel(ctx, snapshot, shipments, err), nil
 	}
 
+	encoding := labelEncoding(artifacts)
+	tracking := shipment.TrackingPIN
 	returnData.Label = &labels.LabelResponse{
 		LabelId:     labelID,
-		LabelUrl:    s.buildLabelURL(labelID),
+		LabelUrl:    s.buildLabelURL(labelID, strings.ToLower(encoding)),
 		TackingCode: tracking,
 		Carrier:     "Canada Post",
 		Method:      camelCaseSpace(defaultValue(snapshot.ServiceName, "STANDARD")),
-		ShipDate:    uint32(time.Now().Unix()),
+		ShipDate:    labelShipDate(snapshot.MailingDate, time.Now(), schedule),
 		InvoiceUuid: defaultValue(snapshot.InvoiceUUID, shipRequest.GetInvoiceUuid()),
 		DelayTask:   shipRequest.GetDelayTask(),
 	}
 
 	invoiceUUID := defaultValue(snapshot.InvoiceUUID, shipRequest.GetInvoiceUuid())
-	if invoiceUUID != "" && shipRequest.GetShippingRateId() != "" {
-		if err := s.Store.SaveChosenRateID(invoiceUUID, shipRequest.GetShippingRateId()); err != nil {
-			log.Println("❌ Failed to store chosen rate:", err)
-		} else {
-			log.Printf("✅ Stored rate %s for invoice %s\n", shipRequest.GetShippingRateId(), invoiceUUID)
-		}
-	}
-	if invoiceUUID != "" && tracking != "" {
-		if err := s.Store.SaveTrackingNumber(invoiceUUID, tracking); err != nil {
-			log.Println("❌ Failed to store tracking number:", err)
-		} else {
-			log.Printf("✅ Stored tracking number %s for invoice %s\n", tracking, invoiceUUID)
-		}
-	}
-
 	totalWeight := snapshot.Parcel.Weight
+	if len(snapshot.Pieces) > 1 {
+		totalWeight = snapshot.Pieces[0].Weight
+	}
 
 	serviceName := strings.TrimSpace(snapshot.ServiceName)
 	if serviceName == "" {
 		serviceName = fallbackServiceName(snapshot.ServiceCode)
 	}
 
+	sender, destination := labelAddressesFromSnapshot(snapshot)
 	record := database.LabelRecord{
 		ID:                   labelID,
 		ShipmentID:           shipment.ShipmentID,
@@ -270,26 +274,103 @@ func (s *Server) CreateLabel(
 		Carrier:              "Canada Post",
 		ServiceCode:          resolveServiceCode(snapshot.ServiceCode),
 		ServiceName:          serviceName,
-		ShippingChargesCents: snapshot.PriceCents,
+		ShippingChargesCents: piecePrice(snapshot, 0),
+		CustomerPriceCents:   pieceCustomerPrice(snapshot, 0),
+		CurrencyCode:         normalizeCurrencyCode(snapshot.CurrencyCode),
+		RateToCad:            snapshot.RateToCad,
+		FXRateID:             snapshot.FXRateID,
 		DeliveryDate:         snapshot.DeliveryDate,
-		DeliveryDays:         int(deliveryDaysFromDeliveryDate(&snapshot.DeliveryDate)),
+		DeliveryDays:         snapshotDeliveryDays(snapshot),
 		RefundLink:           refundURL,
+		ShipmentLink:         shipmentURL,
+		ChargesLink:          shipmentChargesLink(shipment),
 		Weight:               totalWeight,
+		ClientID:             clientID,
+		GroupID:              shipment.GroupID,
+		Sender:               sender,
+		Destination:          destination,
+		Artifacts:            artifactNames(artifacts),
+		LabelEncoding:        encoding,
+	}
+
+	// Every piece's label is stored before anything is recorded, so a label
+	// that can't be stored cancels the whole shipment.
+	var pieceRecords []database.LabelRecord
+	var pieceLabels []string
+	if len(shipments) > 1 {
+		pieceRecords, pieceLabels, err = s.savePieceLabels(ctx, record, snapshot, shipments[1:])
+		if err != nil {
+			return s.cancelCreatedLabel(ctx, snapshot, shipments, err), nil
+		}
+	}
+	setLabelMetadata(ctx, labelArtifactsMetadataKey, s.labelArtifactMetadata(labelID, artifacts, time.Now()))
+
+	if invoiceUUID != "" && shipRequest.GetShippingRateId() != "" {
+		if err := s.Store.SaveChosenRateID(invoiceUUID, shipRequest.GetShippingRateId()); err != nil {
+			log.Println("❌ Failed to store chosen rate:", err)
+		} else {
+			log.Printf("✅ Stored rate %s for invoice %s\n", shipRequest.GetShippingRateId(), invoiceUUID)
+		}
+	}
+	if invoiceUUID != "" && tracking != "" {
+		if err := s.Store.SaveTrackingNumber(invoiceUUID, tracking); err != nil {
+			log.Println("❌ Failed to store tracking number:", err)
+		} else {
+			log.Printf("✅ Stored tracking number %s for invoice %s\n", tracking, invoiceUUID)
+		}
+	}
+
+	if charges, err := s.fetchShipmentCharges(ctx, shipment); err != nil {
+		log.Printf("failed to fetch shipment charges: label_id=%s err=%v", labelID, err)
+	} else {
+		record.Billed = charges
+		if charges.DueCents != record.ShippingChargesCents {
+			log.Printf("shipment charges differ from quote: label_id=%s quoted_cents=%d billed_cents=%d", labelID, record.ShippingChargesCents, charges.DueCents)
+		}
 	}
 
 	if err := s.Store.SaveLabelRecord(record); err != nil {
 		log.Println("❌ Failed to store label record:", err)
 	}
+	if len(pieceRecords) > 0 {
+		pins := []string{record.TrackingNumber}
+		for _, pieceRecord := range pieceRecords {
+			if err := s.Store.SaveLabelRecord(pieceRecord); err != nil {
+				log.Println("❌ Failed to store piece label record:", err)
+			}
+			pins = append(pins, pieceRecord.TrackingNumber)
+		}
+		setLabelMetadata(ctx, trackingPinsMetadataKey, pins)
+		setLabelMetadata(ctx, pieceLabelsMetadataKey, pieceLabels)
+	}
 
 	logPluginResponse("CreateLabel", returnData)
 	return returnData, nil
 }
 
-func (s *Server) saveLabelPDF(labelID string, data []byte) error {
-	storagePath := strings.TrimSpace(s.Config.LabelStoragePath)
-	if storagePath == "" {
-		storagePath = "files/labels"
+// cancelCreatedLabel cancels the shipments of a label that could not be
+// stored, so that none is billed without a recorded label, and returns err
+// as the CreateLabel result.
+func (s *Server) cancelCreatedLabel(ctx context.Context, snapshot RateSnapshot, shipments []*ShipmentResponse, err error) *shippingpluginpb.ResultResponse {
+	log.Println("❌ CreateLabel label error:", err)
+	code, message := pluginErrorResult(err)
+	if remaining := s.rollbackPieceShipments(ctx, snapshot, shipments); len(remaining) > 0 {
+		message = fmt.Sprintf("%s Shipments %s were created and could not be cancelled; refund them from Canada Post.", message, strings.Join(remaining, ","))
+	}
+	resp := &shippingpluginpb.ResultResponse{
+		Success: false,
+		Failure: true,
+		Code:    code,
+		Message: message,
 	}
+	logPluginResponse("CreateLabel", resp)
+	return resp
+}
+
+// saveLabelFile stores the first label page as {labelID}.{extension}, which
+// is what /labels/{id} serves.
+func (s *Server) saveLabelFile(labelID string, extension string, data []byte) error {
+	storagePath := s.labelStoragePath()
 
 	if strings.Contains(labelID, "/") || strings.Contains(labelID, "\\") || strings.Contains(labelID, "..") {
 		return fmt.Errorf("invalid label id")
@@ -299,7 +380,7 @@ func (s *Server) saveLabelPDF(labelID string, data []byte) error {
 		return err
 	}
 
-	filename := labelID + ".pdf"
+	filename := labelID + "." + extension
 	filePath := filepath.Join(storagePath, filename)
 	if err := os.WriteFile(filePath, data, 0o644); err != nil {
 		return err
@@ -0,0 +1,612 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	address "bitbucket.org/lexmodo/proto/address"
+	labels "bitbucket.org/lexmodo/proto/labels"
+	shippingpluginpb "bitbucket.org/lexmodo/proto/shipping_plugin"
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"lexmodo-plugin/config"
+	"lexmodo-plugin/database"
+	"lexmodo-plugin/service/cpsim"
+)
+
+// memorySnapshotCache stands in for Redis in tests.
+type memorySnapshotCache struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func (c *memorySnapshotCache) set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (c *memorySnapshotCache) get(_ context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.values[key]
+	if !ok {
+		return nil, errRedisNil
+	}
+	return value, nil
+}
+
+// headerStream records the response headers a handler sets, standing in for
+// the gRPC server stream.
+type headerStream struct {
+	header metadata.MD
+}
+
+func (s *headerStream) Method() string { return "" }
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *headerStream) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+
+func (s *headerStream) SetTrailer(metadata.MD) error { return nil }
+
+// staticCustomerEmail stands in for the orders service.
+type staticCustomerEmail string
+
+func (e staticCustomerEmail) CustomerEmail(context.Context, string, int64, string) (string, error) {
+	return string(e), nil
+}
+
+// newSimulatedServer returns a Server that talks to sim, with a sqlmock
+// database for the label records.
+func newSimulatedServer(t *testing.T, sim *cpsim.Simulator) (*Server, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return &Server{
+		Store: &database.Store{DB: db},
+		Config: config.Config{
+			PublicBaseURL:    "https://plugin.example.com",
+			LabelStoragePath: t.TempDir(),
+		},
+		CanadaPost:     newTestCanadaPostClient(sim.URL(), newRetryTransport(http.DefaultTransport)),
+		RateSnapshots:  &RateSnapshotStore{client: &memorySnapshotCache{values: map[string][]byte{}}, ttl: time.Minute},
+		CustomerEmails: staticCustomerEmail("jordan@example.com"),
+	}, mock
+}
+
+// expectLabelSaved expects CreateLabel to store the chosen rate, the
+// tracking number and a label record per piece.
+func expectLabelSaved(mock sqlmock.Sqlmock, pieces int) {
+	mock.ExpectExec("INSERT INTO chosen_shipping_rates").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO tracking_numbers").WillReturnResult(sqlmock.NewResult(0, 1))
+	for i := 0; i < pieces; i++ {
+		mock.ExpectExec("INSERT INTO label_records").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+}
+
+// labelRecordColumns are the label_records columns the store selects.
+var labelRecordColumns = strings.Split("id, shipment_id, tracking_number, invoice_uuid, rate_id, carrier, service_code, service_name, shipping_charges_cents, delivery_date, delivery_days, refund_link, weight, client_id, tracking_status, tracking_status_reported, group_id, manifest_status, manifest_id, sender_address, destination_address, return_of, billed_base_cents, billed_taxes_cents, billed_options_cents, billed_due_cents, billed_at, artifacts, label_encoding, piece_of, customer_price_cents, currency_code, rate_to_cad, fx_rate_id, shipment_link, charges_link, refunded_at, created_at", ", ")
+
+// labelRecordRows returns a label_records row with the given values and
+// empty values for the other columns.
+func labelRecordRows(values map[string]driver.Value) *sqlmock.Rows {
+	row := make([]driver.Value, len(labelRecordColumns))
+	for i, column := range labelRecordColumns {
+		switch {
+		case values[column] != nil:
+			row[i] = values[column]
+		case column == "created_at":
+			row[i] = time.Now()
+		case strings.HasSuffix(column, "_cents") || strings.HasSuffix(column, "_days") || column == "client_id" || column == "fx_rate_id" || column == "weight" || column == "rate_to_cad":
+			row[i] = 0
+		case column == "billed_at" || column == "refunded_at" || strings.HasSuffix(column, "_address") || strings.HasSuffix(column, "_link") || column == "artifacts":
+			row[i] = nil
+		default:
+			row[i] = ""
+		}
+	}
+	return sqlmock.NewRows(labelRecordColumns).AddRow(row...)
+}
+
+func simulatedShipRequest() *labels.LabelRequest {
+	return &labels.LabelRequest{
+		Shipper: &address.Address{
+			Street1:      wrapperspb.String("59 Sparks St"),
+			City:         wrapperspb.String("Ottawa"),
+			ProvinceCode: wrapperspb.String("ON"),
+			Zip:          wrapperspb.String("K1P5A0"),
+			CountryCode:  wrapperspb.String("CA"),
+			Phone:        wrapperspb.String("6135550123"),
+			FullName:     wrapperspb.String("Warehouse"),
+			Company:      wrapperspb.String("Maple Goods"),
+			Email:        wrapperspb.String("shipping@maplegoods.example"),
+		},
+		Customer: &address.Address{
+			Street1:      wrapperspb.String("123 Main Street"),
+			City:         wrapperspb.String("Toronto"),
+			ProvinceCode: wrapperspb.String("ON"),
+			Zip:          wrapperspb.String("M5H2N2"),
+			CountryCode:  wrapperspb.String("CA"),
+			Phone:        wrapperspb.String("4165550199"),
+			FullName:     wrapperspb.String("Jordan Lee"),
+		},
+		// 2 kg
+		Parcel: &labels.Parcel{
+			Weight:           70.54792,
+			ParcelDimensions: &labels.ParcelDimensions{Length: 20, Width: 15, Height: 10},
+		},
+		InvoiceUuid: "invoice-e2e",
+	}
+}
+
+func TestSimulatedRateLabelRefund(t *testing.T) {
+	sim := cpsim.New()
+	defer sim.Close()
+	server, mock := newSimulatedServer(t, sim)
+	ctx := context.Background()
+
+	ratesResp, err := server.GetShippingRate(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: simulatedShipRequest()})
+	if err != nil || !ratesResp.Success {
+		t.Fatalf("expected rates, got %+v %v", ratesResp, err)
+	}
+	var chosen *shippingpluginpb.ShippingRate
+	for _, rate := range ratesResp.ShippingRates {
+		if rate.ShippingrateServiceName == "Expedited Parcel" {
+			chosen = rate
+		}
+	}
+	if len(ratesResp.ShippingRates) != 3 || chosen == nil {
+		t.Fatalf("expected the three domestic services, got %+v", ratesResp.ShippingRates)
+	}
+	// 11.20 + 2 kg * 2.10 = 15.40, plus 13% HST.
+	if chosen.ShippingratePrice != 1740 {
+		t.Fatalf("expected Expedited Parcel at 1740 cents, got %d", chosen.ShippingratePrice)
+	}
+
+	shipRequest := simulatedShipRequest()
+	shipRequest.ShippingRateId = chosen.ShippingrateId
+	expectLabelSaved(mock, 1)
+	labelResp, err := server.CreateLabel(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: shipRequest})
+	if err != nil || !labelResp.Success {
+		t.Fatalf("expected a label, got %+v %v", labelResp, err)
+	}
+	shipments := sim.Shipments()
+	if len(shipments) != 1 || shipments[0].ServiceCode != "DOM.EP" {
+		t.Fatalf("expected one DOM.EP shipment, got %+v", shipments)
+	}
+	if labelResp.Label.TackingCode != shipments[0].TrackingPIN {
+		t.Fatalf("expected tracking %s, got %s", shipments[0].TrackingPIN, labelResp.Label.TackingCode)
+	}
+	labelPath := filepath.Join(server.Config.LabelStoragePath, labelResp.Label.LabelId+".pdf")
+	if data, err := os.ReadFile(labelPath); err != nil || len(data) == 0 {
+		t.Fatalf("expected the label PDF at %s: %v", labelPath, err)
+	}
+	if sim.Calls(cpsim.Receipt) != 1 {
+		t.Fatalf("expected the receipt to be fetched once, got %d", sim.Calls(cpsim.Receipt))
+	}
+
+	// The refund goes through the stored label record. The second one is
+	// rejected by Canada Post before the other pieces are loaded.
+	labelID := labelResp.Label.LabelId
+	refundReq := &shippingpluginpb.ShippingRateRequest{ShipRequest: &labels.LabelRequest{LabelId: labelID}}
+	stored := map[string]driver.Value{
+		"id":              labelID,
+		"tracking_number": shipments[0].TrackingPIN,
+		"invoice_uuid":    "invoice-e2e",
+		"refund_link":     shipments[0].RefundURL,
+	}
+	mock.ExpectQuery(`FROM label_records\s+WHERE id = \?`).WithArgs(labelID).WillReturnRows(labelRecordRows(stored))
+	mock.ExpectExec(`SET refunded_at = UTC_TIMESTAMP\(\)`).WithArgs(int64(0), labelID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM label_records\s+WHERE piece_of = \?`).WithArgs(labelID).WillReturnRows(sqlmock.NewRows(labelRecordColumns))
+	if resp, err := server.RefundShipment(ctx, refundReq); err != nil || !resp.Success {
+		t.Fatalf("expected refund, got %+v %v", resp, err)
+	}
+	mock.ExpectQuery(`FROM label_records\s+WHERE id = \?`).WithArgs(labelID).WillReturnRows(labelRecordRows(stored))
+	resp, err := server.RefundShipment(ctx, refundReq)
+	if err != nil || resp.Success || !strings.Contains(resp.Message, "already") {
+		t.Fatalf("expected a duplicate refund error, got %+v %v", resp, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimulatedRatesRetryServerErrors(t *testing.T) {
+	sim := cpsim.New()
+	defer sim.Close()
+	sim.Inject(cpsim.Price, cpsim.Failure{Status: http.StatusServiceUnavailable, Times: 2})
+	server, _ := newSimulatedServer(t, sim)
+
+	resp, err := server.GetShippingRate(context.Background(), &shippingpluginpb.ShippingRateRequest{ShipRequest: simulatedShipRequest()})
+	if err != nil || !resp.Success {
+		t.Fatalf("expected rates after retries, got %+v %v", resp, err)
+	}
+	if sim.Calls(cpsim.Price) != 3 {
+		t.Fatalf("expected 3 price calls, got %d", sim.Calls(cpsim.Price))
+	}
+}
+
+func TestSimulatedRatesUseQuoteCache(t *testing.T) {
+	sim := cpsim.New()
+	defer sim.Close()
+	server, _ := newSimulatedServer(t, sim)
+	server.RateQuotes = &RateQuoteCache{client: &memorySnapshotCache{values: map[string][]byte{}}, ttl: time.Minute}
+	ctx := context.Background()
+
+	first, err := server.GetShippingRate(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: simulatedShipRequest()})
+	if err != nil || !first.Success {
+		t.Fatalf("expected rates, got %+v %v", first, err)
+	}
+	second, err := server.GetShippingRate(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: simulatedShipRequest()})
+	if err != nil || !second.Success {
+		t.Fatalf("expected cached rates, got %+v %v", second, err)
+	}
+	if sim.Calls(cpsim.Price) != 1 {
+		t.Fatalf("expected 1 price call, got %d", sim.Calls(cpsim.Price))
+	}
+	if len(second.ShippingRates) != len(first.ShippingRates) {
+		t.Fatalf("expected the same services, got %+v", second.ShippingRates)
+	}
+	for _, rate := range second.ShippingRates {
+		for _, earlier := range first.ShippingRates {
+			if rate.ShippingrateId == earlier.ShippingrateId {
+				t.Fatalf("expected a new rate ID for the cached quote %s", rate.ShippingrateId)
+			}
+		}
+		if _, err := server.RateSnapshots.Load(ctx, rate.ShippingrateId); err != nil {
+			t.Fatalf("expected a snapshot for %s: %v", rate.ShippingrateId, err)
+		}
+	}
+}
+
+func TestSimulatedEstimatedRateIsRequotedAtLabel(t *testing.T) {
+	sim := cpsim.New()
+	defer sim.Close()
+	server, _ := newSimulatedServer(t, sim)
+	ctx := context.Background()
+
+	ratesResp, err := server.GetShippingRate(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: simulatedShipRequest()})
+	if err != nil || !ratesResp.Success {
+		t.Fatalf("expected rates, got %+v %v", ratesResp, err)
+	}
+	var snapshot RateSnapshot
+	for _, rate := range ratesResp.ShippingRates {
+		if rate.ShippingrateServiceName == "Expedited Parcel" {
+			snapshot, _ = server.RateSnapshots.Load(ctx, rate.ShippingrateId)
+		}
+	}
+	// Turn it into a rate served from the fallback table.
+	snapshot.ServiceName = "Expedited Parcel" + estimatedRateSuffix
+	snapshot.PriceCents = 1999
+	snapshot.CarrierCents = 1999
+	snapshot.Estimated = true
+	if err := server.RateSnapshots.Save(ctx, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	shipRequest := simulatedShipRequest()
+	shipRequest.ShippingRateId = snapshot.RateID
+
+	sim.Inject(cpsim.Price, cpsim.Failure{Status: http.StatusServiceUnavailable, Times: 4})
+	resp, _ := server.CreateLabel(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: shipRequest})
+	if resp.Success || resp.Code != "503" || len(sim.Shipments()) != 0 {
+		t.Fatalf("expected no label while Canada Post is down, got %+v", resp)
+	}
+
+	calls := sim.Calls(cpsim.Price)
+	labelResp, err := server.CreateLabel(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: shipRequest})
+	if err != nil || !labelResp.Success {
+		t.Fatalf("expected a label, got %+v %v", labelResp, err)
+	}
+	if sim.Calls(cpsim.Price) != calls+1 {
+		t.Fatalf("expected a live re-quote, got %d price calls", sim.Calls(cpsim.Price)-calls)
+	}
+	if labelResp.Label.Method != "Expedited Parcel" {
+		t.Fatalf("expected the live service name, got %q", labelResp.Label.Method)
+	}
+	if shipments := sim.Shipments(); len(shipments) != 1 || shipments[0].ServiceCode != "DOM.EP" {
+		t.Fatalf("expected one DOM.EP shipment, got %+v", shipments)
+	}
+}
+
+func TestSimulatedRatesForFutureShipDate(t *testing.T) {
+	sim := cpsim.New()
+	defer sim.Close()
+	server, _ := newSimulatedServer(t, sim)
+
+	// A business day a week or so out.
+	calendar := newBusinessCalendar("ON")
+	shipDate := calendar.addBusinessDays(calendarDate(time.Now().In(database.DeliverySchedule{TimeZone: database.DefaultTimeZone}.Location())).AddDate(0, 0, 7), 0)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(shipDateMetadataKey, shipDate.Format("2006-01-02")))
+
+	ratesResp, err := server.GetShippingRate(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: simulatedShipRequest()})
+	if err != nil || !ratesResp.Success {
+		t.Fatalf("expected rates, got %+v %v", ratesResp, err)
+	}
+	var chosen *shippingpluginpb.ShippingRate
+	for _, rate := range ratesResp.ShippingRates {
+		if rate.ShippingrateServiceName == "Expedited Parcel" {
+			chosen = rate
+		}
+	}
+	// The simulator delivers Expedited Parcel two days after mailing.
+	if want := shipDate.AddDate(0, 0, 2).Format("2006-01-02"); chosen == nil || chosen.ShippingrateDeliveryDate != want {
+		t.Fatalf("expected delivery on %s, got %+v", want, chosen)
+	}
+	snapshot, err := server.RateSnapshots.Load(ctx, chosen.ShippingrateId)
+	if err != nil || snapshot.MailingDate != shipDate.Format("2006-01-02") {
+		t.Fatalf("expected the ship date in the snapshot, got %q %v", snapshot.MailingDate, err)
+	}
+
+	shipRequest := simulatedShipRequest()
+	shipRequest.ShippingRateId = chosen.ShippingrateId
+	labelResp, err := server.CreateLabel(context.Background(), &shippingpluginpb.ShippingRateRequest{ShipRequest: shipRequest})
+	if err != nil || !labelResp.Success {
+		t.Fatalf("expected a label, got %+v %v", labelResp, err)
+	}
+	if got := time.Unix(int64(labelResp.Label.ShipDate), 0).In(database.DeliverySchedule{TimeZone: database.DefaultTimeZone}.Location()); got.Format("2006-01-02") != shipDate.Format("2006-01-02") {
+		t.Fatalf("expected the label to ship on %s, got %s", shipDate.Format("2006-01-02"), got)
+	}
+}
+
+func TestSimulatedRatesReportCanadaPostMessage(t *testing.T) {
+	sim := cpsim.New()
+	defer sim.Close()
+	sim.Inject(cpsim.Price, cpsim.Failure{Status: http.StatusBadRequest, Code: "9111", Description: "The destination postal code is invalid."})
+	server, _ := newSimulatedServer(t, sim)
+
+	resp, _ := server.GetShippingRate(context.Background(), &shippingpluginpb.ShippingRateRequest{ShipRequest: simulatedShipRequest()})
+	if resp.Success || resp.Code != "422" || resp.Message != "Canada Post: The destination postal code is invalid." {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
+
+func TestSimulatedShipmentFailureIsNotRetried(t *testing.T) {
+	sim := cpsim.New()
+	defer sim.Close()
+	server, _ := newSimulatedServer(t, sim)
+	ctx := context.Background()
+
+	ratesResp, _ := server.GetShippingRate(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: simulatedShipRequest()})
+	if !ratesResp.Success || len(ratesResp.ShippingRates) == 0 {
+		t.Fatalf("expected rates, got %+v", ratesResp)
+	}
+	sim.Inject(cpsim.Shipment, cpsim.Failure{Status: http.StatusInternalServerError})
+
+	shipRequest := simulatedShipRequest()
+	shipRequest.ShippingRateId = ratesResp.ShippingRates[0].ShippingrateId
+	resp, _ := server.CreateLabel(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: shipRequest})
+	if resp.Success || resp.Code != "503" {
+		t.Fatalf("expected a 503 result, got %+v", resp)
+	}
+	if sim.Calls(cpsim.Shipment) != 1 || len(sim.Shipments()) != 0 {
+		t.Fatalf("expected a single failed create call, got %d calls", sim.Calls(cpsim.Shipment))
+	}
+}
+
+func TestSimulatedPostOffices(t *testing.T) {
+	sim := cpsim.New()
+	defer sim.Close()
+	server, _ := newSimulatedServer(t, sim)
+
+	offices, err := server.CanadaPost.FindPostOffices(context.Background(), "K1P 5A0")
+	if err != nil {
+		t.Fatalf("expected post offices, got %v", err)
+	}
+	if len(offices) != 2 || offices[0].OfficeID != "0000102134" {
+		t.Fatalf("unexpected post offices %+v", offices)
+	}
+}
+
+func TestSimulatedMultiPieceShipment(t *testing.T) {
+	sim := cpsim.New()
+	defer sim.Close()
+	server, mock := newSimulatedServer(t, sim)
+	ctx := context.Background()
+	// 2 kg and 1 kg.
+	pieces := []*shippingpluginpb.ShippingDynamicData{
+		buildField(fieldParcelPieces, "Parcel pieces", shippingpluginpb.FIELD_TYPE_text, "70.54792 20x15x10; 35.27396"),
+	}
+
+	ratesResp, err := server.GetShippingRate(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: simulatedShipRequest(), ShippingpluginreqeustCustomInfo: pieces})
+	if err != nil || !ratesResp.Success {
+		t.Fatalf("expected rates, got %+v %v", ratesResp, err)
+	}
+	if sim.Calls(cpsim.Price) != 2 {
+		t.Fatalf("expected a price call per piece, got %d", sim.Calls(cpsim.Price))
+	}
+	var chosen *shippingpluginpb.ShippingRate
+	for _, rate := range ratesResp.ShippingRates {
+		if rate.ShippingrateServiceName == "Expedited Parcel" {
+			chosen = rate
+		}
+	}
+	// 17.40 for 2 kg plus (11.20 + 2.10) * 1.13 = 15.03 for 1 kg.
+	if chosen == nil || chosen.ShippingratePrice != 3243 {
+		t.Fatalf("expected Expedited Parcel at 3243 cents, got %+v", ratesResp.ShippingRates)
+	}
+
+	shipRequest := simulatedShipRequest()
+	shipRequest.ShippingRateId = chosen.ShippingrateId
+	expectLabelSaved(mock, 2)
+	stream := &headerStream{}
+	labelResp, err := server.CreateLabel(grpc.NewContextWithServerTransportStream(ctx, stream), &shippingpluginpb.ShippingRateRequest{ShipRequest: shipRequest})
+	if err != nil || !labelResp.Success {
+		t.Fatalf("expected a label, got %+v %v", labelResp, err)
+	}
+	shipments := sim.Shipments()
+	if len(shipments) != 2 {
+		t.Fatalf("expected a shipment per piece, got %+v", shipments)
+	}
+	if labelResp.ShippingMethod != nil {
+		t.Fatalf("expected no fields in the credentials slot, got %+v", labelResp.ShippingMethod)
+	}
+	pins := stream.header.Get(trackingPinsMetadataKey)
+	if len(pins) != 2 || pins[0] != shipments[0].TrackingPIN || pins[1] != shipments[1].TrackingPIN {
+		t.Fatalf("expected both tracking PINs, got %q", pins)
+	}
+	if separate := stream.header.Get(pieceLabelsMetadataKey); len(separate) != 0 {
+		t.Fatalf("expected merged labels only, got %q", separate)
+	}
+
+	merged, err := os.ReadFile(filepath.Join(server.Config.LabelStoragePath, labelResp.Label.LabelId+".pdf"))
+	if err != nil {
+		t.Fatalf("expected the merged label: %v", err)
+	}
+	_, _, count, err := parsePDF(merged)
+	if err != nil || count != 2 {
+		t.Fatalf("expected a two-page label, got %d pages: %v", count, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimulatedMultiPieceShipmentRollsBackOnFailure(t *testing.T) {
+	sim := cpsim.New()
+	defer sim.Close()
+	server, mock := newSimulatedServer(t, sim)
+	ctx := context.Background()
+	pieces := []*shippingpluginpb.ShippingDynamicData{
+		buildField(fieldParcelPieces, "Parcel pieces", shippingpluginpb.FIELD_TYPE_text, "70.54792 20x15x10; 35.27396"),
+	}
+
+	ratesResp, err := server.GetShippingRate(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: simulatedShipRequest(), ShippingpluginreqeustCustomInfo: pieces})
+	if err != nil || !ratesResp.Success || len(ratesResp.ShippingRates) == 0 {
+		t.Fatalf("expected rates, got %+v %v", ratesResp, err)
+	}
+	// The first piece is created, the second fails.
+	sim.Inject(cpsim.Shipment, cpsim.Failure{})
+	sim.Inject(cpsim.Shipment, cpsim.Failure{Status: http.StatusBadRequest, Code: "9111", Description: "Invalid parcel"})
+
+	shipRequest := simulatedShipRequest()
+	shipRequest.ShippingRateId = ratesResp.ShippingRates[0].ShippingrateId
+	resp, _ := server.CreateLabel(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: shipRequest})
+	if resp.Success || resp.Message != "Canada Post: Invalid parcel" {
+		t.Fatalf("expected the second piece's error, got %+v", resp)
+	}
+	shipments := sim.Shipments()
+	if len(shipments) != 1 || sim.Calls(cpsim.Refund) != 1 {
+		t.Fatalf("expected the first piece to be refunded, got %d refunds of %+v", sim.Calls(cpsim.Refund), shipments)
+	}
+	if shipments[0].RefundEmail != "shipping@maplegoods.example" {
+		t.Fatalf("expected the refund confirmation to go to the shipper, got %q", shipments[0].RefundEmail)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimulatedMultiPieceShipmentRollsBackOnLabelFailure(t *testing.T) {
+	sim := cpsim.New()
+	defer sim.Close()
+	server, mock := newSimulatedServer(t, sim)
+	ctx := context.Background()
+	pieces := []*shippingpluginpb.ShippingDynamicData{
+		buildField(fieldParcelPieces, "Parcel pieces", shippingpluginpb.FIELD_TYPE_text, "70.54792 20x15x10; 35.27396"),
+	}
+
+	ratesResp, err := server.GetShippingRate(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: simulatedShipRequest(), ShippingpluginreqeustCustomInfo: pieces})
+	if err != nil || !ratesResp.Success || len(ratesResp.ShippingRates) == 0 {
+		t.Fatalf("expected rates, got %+v %v", ratesResp, err)
+	}
+	// Both pieces are created but the first piece's label can't be fetched.
+	sim.Inject(cpsim.Artifact, cpsim.Failure{Status: http.StatusNotFound, Times: 10})
+
+	shipRequest := simulatedShipRequest()
+	shipRequest.ShippingRateId = ratesResp.ShippingRates[0].ShippingrateId
+	resp, _ := server.CreateLabel(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: shipRequest})
+	if resp.Success {
+		t.Fatalf("expected the label to fail, got %+v", resp)
+	}
+	shipments := sim.Shipments()
+	if len(shipments) != 2 || sim.Calls(cpsim.Refund) != 2 {
+		t.Fatalf("expected both pieces to be refunded, got %d refunds of %+v", sim.Calls(cpsim.Refund), shipments)
+	}
+	// Nothing is recorded for the cancelled shipments.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimulatedMultiPieceRefundRetriesFailedPieces(t *testing.T) {
+	sim := cpsim.New()
+	defer sim.Close()
+	server, mock := newSimulatedServer(t, sim)
+	ctx := context.Background()
+	pieces := []*shippingpluginpb.ShippingDynamicData{
+		buildField(fieldParcelPieces, "Parcel pieces", shippingpluginpb.FIELD_TYPE_text, "70.54792 20x15x10; 35.27396"),
+	}
+
+	ratesResp, err := server.GetShippingRate(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: simulatedShipRequest(), ShippingpluginreqeustCustomInfo: pieces})
+	if err != nil || !ratesResp.Success || len(ratesResp.ShippingRates) == 0 {
+		t.Fatalf("expected rates, got %+v %v", ratesResp, err)
+	}
+	shipRequest := simulatedShipRequest()
+	shipRequest.ShippingRateId = ratesResp.ShippingRates[0].ShippingrateId
+	expectLabelSaved(mock, 2)
+	labelResp, err := server.CreateLabel(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: shipRequest})
+	if err != nil || !labelResp.Success {
+		t.Fatalf("expected a label, got %+v %v", labelResp, err)
+	}
+	shipments := sim.Shipments()
+	labelID := labelResp.Label.LabelId
+	pieceID := pieceLabelID(labelID, 1)
+	first := map[string]driver.Value{
+		"id":              labelID,
+		"tracking_number": shipments[0].TrackingPIN,
+		"invoice_uuid":    "invoice-e2e",
+		"refund_link":     shipments[0].RefundURL,
+	}
+	piece := map[string]driver.Value{
+		"id":              pieceID,
+		"tracking_number": shipments[1].TrackingPIN,
+		"invoice_uuid":    "invoice-e2e",
+		"refund_link":     shipments[1].RefundURL,
+		"piece_of":        labelID,
+	}
+	refundReq := &shippingpluginpb.ShippingRateRequest{ShipRequest: &labels.LabelRequest{LabelId: labelID}}
+
+	// The first piece is refunded, the second fails.
+	sim.Inject(cpsim.Refund, cpsim.Failure{})
+	sim.Inject(cpsim.Refund, cpsim.Failure{Status: http.StatusBadRequest, Code: "8062", Description: "Refund request failed"})
+	mock.ExpectQuery(`FROM label_records\s+WHERE id = \?`).WithArgs(labelID).WillReturnRows(labelRecordRows(first))
+	mock.ExpectExec(`SET refunded_at = UTC_TIMESTAMP\(\)`).WithArgs(int64(0), labelID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM label_records\s+WHERE piece_of = \?`).WithArgs(labelID).WillReturnRows(labelRecordRows(piece))
+	resp, err := server.RefundShipment(ctx, refundReq)
+	if err != nil || resp.Success || !strings.Contains(resp.Message, shipments[1].TrackingPIN) {
+		t.Fatalf("expected the second piece's refund to fail, got %+v %v", resp, err)
+	}
+
+	// The retry only refunds the piece that failed.
+	first["refunded_at"] = time.Now()
+	mock.ExpectQuery(`FROM label_records\s+WHERE id = \?`).WithArgs(labelID).WillReturnRows(labelRecordRows(first))
+	mock.ExpectQuery(`FROM label_records\s+WHERE piece_of = \?`).WithArgs(labelID).WillReturnRows(labelRecordRows(piece))
+	mock.ExpectExec(`SET refunded_at = UTC_TIMESTAMP\(\)`).WithArgs(int64(0), pieceID).WillReturnResult(sqlmock.NewResult(0, 1))
+	resp, err = server.RefundShipment(ctx, refundReq)
+	if err != nil || !resp.Success {
+		t.Fatalf("expected the retry to refund the second piece, got %+v %v", resp, err)
+	}
+	if sim.Calls(cpsim.Refund) != 3 {
+		t.Fatalf("expected the first piece to be refunded once, got %d refund calls", sim.Calls(cpsim.Refund))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,372 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"lexmodo-plugin/config"
+	"lexmodo-plugin/database"
+)
+
+// defaultValetURL is the Bank of Canada's daily exchange rates for the last
+// week, so that a refresh after an outage still fills in the missed days.
+const defaultValetURL = "https://www.bankofcanada.ca/valet/observations/group/FX_RATES_DAILY/json?recent_weeks=1"
+
+// maxRateDocumentBytes bounds the documents read from rate sources.
+const maxRateDocumentBytes = 4 << 20
+
+// valetSeries matches the Valet series of a currency to CAD, e.g. FXUSDCAD.
+var valetSeries = regexp.MustCompile(`^FX([A-Z]{3})CAD$`)
+
+// ExchangeRateSource fetches the shared rates to CAD.
+type ExchangeRateSource interface {
+	// Name is stored as the source of the rates.
+	Name() string
+	FetchRates(ctx context.Context) ([]database.ExchangeRate, error)
+}
+
+// NewExchangeRateSource returns the source set by exchange_rates.source, or
+// nil when rates are only entered or imported by clients.
+func NewExchangeRateSource(cfg config.ExchangeRatesConfig) (ExchangeRateSource, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch source := strings.ToLower(strings.TrimSpace(cfg.Source)); source {
+	case "", database.FXSourceManual:
+		return nil, nil
+	case database.FXSourceValet:
+		return &httpRateSource{name: source, url: defaultValue(cfg.URL, defaultValetURL), client: client}, nil
+	case database.FXSourceHTTP:
+		if strings.TrimSpace(cfg.URL) == "" {
+			return nil, errors.New("exchange_rates.url is required for the http source")
+		}
+		return &httpRateSource{name: source, url: strings.TrimSpace(cfg.URL), client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown exchange_rates.source %q: use valet, http or manual", cfg.Source)
+	}
+}
+
+// httpRateSource fetches a rate document over HTTP: a Valet JSON or CSV
+// document, or a JSON document with a base currency and rates.
+type httpRateSource struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+func (s *httpRateSource) Name() string {
+	return s.name
+}
+
+func (s *httpRateSource) FetchRates(ctx context.Context) ([]database.ExchangeRate, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json, text/csv")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRateDocumentBytes))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s returned %s", s.url, resp.Status)
+	}
+	return parseExchangeRates(body)
+}
+
+// parseExchangeRates reads a Valet document, or a JSON document with base
+// and rates.
+func parseExchangeRates(data []byte) ([]database.ExchangeRate, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' && !bytes.Contains(trimmed, []byte(`"observations"`)) {
+		return parseBaseRatesJSON(trimmed)
+	}
+	return ParseValetRates(data)
+}
+
+// ParseValetRates reads the observations of a Bank of Canada Valet JSON or
+// CSV document. Each FX<currency>CAD series gives that currency's rate to
+// CAD on the observation's date; other series and empty values are skipped.
+func ParseValetRates(data []byte) ([]database.ExchangeRate, error) {
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	if len(data) > 0 && data[0] == '{' {
+		return parseValetJSON(data)
+	}
+	return parseValetCSV(data)
+}
+
+func parseValetJSON(data []byte) ([]database.ExchangeRate, error) {
+	var doc struct {
+		Observations []map[string]json.RawMessage `json:"observations"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid Valet JSON: %w", err)
+	}
+	rates := []database.ExchangeRate{}
+	for _, observation := range doc.Observations {
+		var day string
+		if err := json.Unmarshal(observation["d"], &day); err != nil {
+			return nil, errors.New("invalid Valet JSON: observation without a date")
+		}
+		for series, raw := range observation {
+			var value struct {
+				V string `json:"v"`
+			}
+			if series == "d" || json.Unmarshal(raw, &value) != nil {
+				continue
+			}
+			rate, ok, err := valetRate(day, series, value.V)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				rates = append(rates, rate)
+			}
+		}
+	}
+	return finishParsedRates(rates)
+}
+
+// parseValetCSV reads the OBSERVATIONS section of a Valet CSV document: a
+// "date" header naming the series, then a row per day.
+func parseValetCSV(data []byte) ([]database.ExchangeRate, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var header []string
+	rates := []database.ExchangeRate{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid Valet CSV: %w", err)
+		}
+		if len(record) == 0 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(record[0]), "date") {
+			header = record
+			continue
+		}
+		if header == nil {
+			continue
+		}
+		for i := 1; i < len(record) && i < len(header); i++ {
+			rate, ok, err := valetRate(record[0], header[i], record[i])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				rates = append(rates, rate)
+			}
+		}
+	}
+	if header == nil {
+		return nil, errors.New("invalid Valet CSV: no observations")
+	}
+	return finishParsedRates(rates)
+}
+
+// valetRate reads one observation; ok is false for other series and
+// missing values.
+func valetRate(day, series, value string) (database.ExchangeRate, bool, error) {
+	match := valetSeries.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(series)))
+	value = strings.TrimSpace(value)
+	if match == nil || value == "" {
+		return database.ExchangeRate{}, false, nil
+	}
+	date, err := time.Parse("2006-01-02", strings.TrimSpace(day))
+	if err != nil {
+		return database.ExchangeRate{}, false, fmt.Errorf("invalid observation date %q", day)
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate <= 0 {
+		return database.ExchangeRate{}, false, fmt.Errorf("invalid %s value %q on %s", series, value, day)
+	}
+	return database.ExchangeRate{CurrencyCode: match[1], RateToCad: rate, EffectiveDate: date}, true, nil
+}
+
+// parseBaseRatesJSON reads {"base": "USD", "date": "2026-10-15", "rates":
+// {"CAD": 1.37, "EUR": 0.86}}, where each rate is units per 1 base. Without
+// a base of CAD the document needs a CAD rate to convert through.
+func parseBaseRatesJSON(data []byte) ([]database.ExchangeRate, error) {
+	var doc struct {
+		Base  string             `json:"base"`
+		Date  string             `json:"date"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid rates JSON: %w", err)
+	}
+	base := strings.ToUpper(strings.TrimSpace(doc.Base))
+	if base == "" {
+		return nil, errors.New("invalid rates JSON: base is required")
+	}
+	date := calendarDate(time.Now().UTC())
+	if raw := strings.TrimSpace(doc.Date); raw != "" {
+		// Timestamps are cut to their date.
+		parsed, err := time.Parse("2006-01-02", raw[:min(len(raw), 10)])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rates JSON: date %q", doc.Date)
+		}
+		date = parsed
+	}
+	cadPerBase := 1.0
+	if base != "CAD" {
+		cadPerBase = doc.Rates["CAD"]
+		if cadPerBase <= 0 {
+			return nil, fmt.Errorf("invalid rates JSON: no CAD rate for base %s", base)
+		}
+	}
+
+	rates := []database.ExchangeRate{}
+	if base != "CAD" {
+		rates = append(rates, database.ExchangeRate{CurrencyCode: base, RateToCad: cadPerBase, EffectiveDate: date})
+	}
+	for code, perBase := range doc.Rates {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "CAD" || code == base || len(code) != 3 || perBase <= 0 {
+			continue
+		}
+		rates = append(rates, database.ExchangeRate{CurrencyCode: code, RateToCad: cadPerBase / perBase, EffectiveDate: date})
+	}
+	return finishParsedRates(rates)
+}
+
+// finishParsedRates orders rates by date and currency, and fails when a
+// document has none.
+func finishParsedRates(rates []database.ExchangeRate) ([]database.ExchangeRate, error) {
+	if len(rates) == 0 {
+		return nil, errors.New("no exchange rates found")
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		if !rates[i].EffectiveDate.Equal(rates[j].EffectiveDate) {
+			return rates[i].EffectiveDate.Before(rates[j].EffectiveDate)
+		}
+		return rates[i].CurrencyCode < rates[j].CurrencyCode
+	})
+	return rates, nil
+}
+
+// exchangeRate returns the rate the client converts currency to CAD with.
+// A stale rate is still used, so that checkout keeps working while the
+// source is down, but it is logged.
+func (s *Server) exchangeRate(clientID int64, currency string) (database.ExchangeRate, error) {
+	if clientID == 0 {
+		return database.ExchangeRate{}, errors.New("client_id required for currency conversion")
+	}
+	if s.Store == nil {
+		return database.ExchangeRate{}, errors.New("currency rates store not configured")
+	}
+	rate, ok, err := s.Store.ResolveExchangeRate(clientID, currency)
+	if err != nil {
+		return database.ExchangeRate{}, fmt.Errorf("failed to load currency rate: %w", err)
+	}
+	if !ok {
+		return database.ExchangeRate{}, fmt.Errorf("missing conversion rate for %s. set it in settings", currency)
+	}
+	s.warnStaleExchangeRate(clientID, rate)
+	return rate, nil
+}
+
+// warnStaleExchangeRate logs the rate about to be used if it is older than
+// exchange_rates.stale_hours.
+func (s *Server) warnStaleExchangeRate(clientID int64, rate database.ExchangeRate) {
+	if rate.Stale(time.Now(), time.Duration(s.Config.ExchangeRates.StaleHours)*time.Hour) {
+		log.Printf("⚠️ stale exchange rate: client_id=%d currency=%s rate_to_cad=%.6f source=%s effective=%s fx_rate_id=%d override=%t",
+			clientID, rate.CurrencyCode, rate.RateToCad, rate.Source, rate.EffectiveDate.Format("2006-01-02"), rate.ID, rate.Override)
+	}
+}
+
+// fxRateDate is the effective date recorded with a quote's rate, "" for CAD.
+func fxRateDate(rate database.ExchangeRate) string {
+	if rate.EffectiveDate.IsZero() {
+		return ""
+	}
+	return rate.EffectiveDate.Format("2006-01-02")
+}
+
+// ExchangeRateUpdater keeps the shared rates in the exchange_rates history
+// up to date from the configured source.
+type ExchangeRateUpdater struct {
+	source   ExchangeRateSource
+	store    *database.Store
+	interval time.Duration
+}
+
+func NewExchangeRateUpdater(store *database.Store, cfg config.Config) (*ExchangeRateUpdater, error) {
+	source, err := NewExchangeRateSource(cfg.ExchangeRates)
+	if err != nil {
+		return nil, err
+	}
+	return &ExchangeRateUpdater{
+		source:   source,
+		store:    store,
+		interval: time.Duration(cfg.ExchangeRates.RefreshHours) * time.Hour,
+	}, nil
+}
+
+// Start refreshes the rates right away and then on the configured interval
+// until ctx is cancelled.
+func (u *ExchangeRateUpdater) Start(ctx context.Context) {
+	if u == nil || u.store == nil {
+		return
+	}
+	if u.source == nil || u.interval <= 0 {
+		log.Println("exchange rate refresh disabled")
+		return
+	}
+	log.Printf("exchange rate updater started: source=%s interval=%s", u.source.Name(), u.interval)
+
+	wait := time.Duration(0)
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := u.Refresh(ctx); err != nil {
+			log.Println("exchange rates: refresh failed:", err)
+		}
+		wait = u.interval
+	}
+}
+
+// Refresh fetches the source's rates and adds the new ones to the history.
+func (u *ExchangeRateUpdater) Refresh(ctx context.Context) error {
+	callCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	rates, err := u.source.FetchRates(callCtx)
+	if err != nil {
+		return err
+	}
+	for i := range rates {
+		rates[i].Source = u.source.Name()
+	}
+	added, err := u.store.SaveExchangeRates(rates)
+	if err != nil {
+		return err
+	}
+	log.Printf("exchange rates: refreshed from %s, %d of %d rates new", u.source.Name(), added, len(rates))
+	return nil
+}
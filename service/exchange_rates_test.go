@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"lexmodo-plugin/config"
+	"lexmodo-plugin/database"
+)
+
+const valetJSONFixture = `{
+  "terms": {"url": "https://www.bankofcanada.ca/terms/"},
+  "seriesDetail": {"FXUSDCAD": {"label": "USD/CAD"}, "FXEURCAD": {"label": "EUR/CAD"}},
+  "observations": [
+    {"d": "2026-10-14", "FXUSDCAD": {"v": "1.3702"}, "FXEURCAD": {"v": "1.4890"}},
+    {"d": "2026-10-15", "FXUSDCAD": {"v": "1.3745"}, "FXEURCAD": {"v": ""}, "INDINF_CPI_M": {"v": "2.1"}}
+  ]
+}`
+
+const valetCSVFixture = "\ufeff\"TERMS AND CONDITIONS\"\n" +
+	"\"https://www.bankofcanada.ca/terms/\"\n\n" +
+	"\"SERIES\"\n" +
+	"id,label,description\n" +
+	"\"FXUSDCAD\",\"USD/CAD\",\"US dollar to Canadian dollar daily exchange rate\"\n" +
+	"\"FXJPYCAD\",\"JPY/CAD\",\"Japanese yen to Canadian dollar daily exchange rate\"\n\n" +
+	"\"OBSERVATIONS\"\n" +
+	"\"date\",\"FXUSDCAD\",\"FXJPYCAD\"\n" +
+	"\"2026-10-14\",\"1.3702\",\"0.009101\"\n" +
+	"\"2026-10-15\",\"1.3745\",\"\"\n"
+
+func formatRates(rates []database.ExchangeRate) string {
+	parts := make([]string, 0, len(rates))
+	for _, rate := range rates {
+		parts = append(parts, fmt.Sprintf("%s %s %.6f", rate.EffectiveDate.Format("2006-01-02"), rate.CurrencyCode, rate.RateToCad))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func TestParseValetRates(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{"json", valetJSONFixture, "2026-10-14 EUR 1.489000; 2026-10-14 USD 1.370200; 2026-10-15 USD 1.374500"},
+		{"csv", valetCSVFixture, "2026-10-14 JPY 0.009101; 2026-10-14 USD 1.370200; 2026-10-15 USD 1.374500"},
+	} {
+		rates, err := ParseValetRates([]byte(tc.doc))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := formatRates(rates); got != tc.want {
+			t.Fatalf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	for _, doc := range []string{
+		`{"observations": []}`,
+		`{"observations": [{"d": "2026-10-15", "FXUSDCAD": {"v": "abc"}}]}`,
+		"\"date\",\"FXUSDCAD\"\n\"15/10/2026\",\"1.37\"\n",
+		"not a valet document",
+	} {
+		if _, err := ParseValetRates([]byte(doc)); err == nil {
+			t.Fatalf("expected an error for %q", doc)
+		}
+	}
+}
+
+func TestParseBaseRatesJSON(t *testing.T) {
+	rates, err := parseExchangeRates([]byte(`{"base": "USD", "date": "2026-10-15T16:00:00Z", "rates": {"CAD": 1.375, "EUR": 0.92, "USD": 1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatRates(rates); got != "2026-10-15 EUR 1.494565; 2026-10-15 USD 1.375000" {
+		t.Fatalf("unexpected rates %s", got)
+	}
+
+	rates, err = parseExchangeRates([]byte(`{"base": "CAD", "date": "2026-10-15", "rates": {"USD": 0.8}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 1 || math.Abs(rates[0].RateToCad-1.25) > 1e-9 {
+		t.Fatalf("expected USD at 1.25 CAD, got %s", formatRates(rates))
+	}
+
+	if _, err := parseExchangeRates([]byte(`{"base": "USD", "rates": {"EUR": 0.92}}`)); err == nil {
+		t.Fatal("expected an error without a CAD rate")
+	}
+	// A Valet document is recognised by its observations.
+	if rates, err := parseExchangeRates([]byte(valetJSONFixture)); err != nil || len(rates) != 3 {
+		t.Fatalf("expected the Valet rates, got %v %v", rates, err)
+	}
+}
+
+func TestNewExchangeRateSource(t *testing.T) {
+	for _, tc := range []struct {
+		cfg     config.ExchangeRatesConfig
+		want    string
+		wantErr bool
+	}{
+		{cfg: config.ExchangeRatesConfig{}},
+		{cfg: config.ExchangeRatesConfig{Source: "manual"}},
+		{cfg: config.ExchangeRatesConfig{Source: "Valet"}, want: "valet"},
+		{cfg: config.ExchangeRatesConfig{Source: "http", URL: "https://rates.example/latest"}, want: "http"},
+		{cfg: config.ExchangeRatesConfig{Source: "http"}, wantErr: true},
+		{cfg: config.ExchangeRatesConfig{Source: "ecb"}, wantErr: true},
+	} {
+		source, err := NewExchangeRateSource(tc.cfg)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%+v: unexpected error %v", tc.cfg, err)
+		}
+		name := ""
+		if source != nil {
+			name = source.Name()
+		}
+		if name != tc.want {
+			t.Fatalf("%+v: got source %q, want %q", tc.cfg, name, tc.want)
+		}
+	}
+}
+
+func TestHTTPRateSourceFetchesRates(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(valetCSVFixture))
+	}))
+	defer server.Close()
+
+	source, err := NewExchangeRateSource(config.ExchangeRatesConfig{Source: "valet", URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates, err := source.FetchRates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 3 {
+		t.Fatalf("expected 3 rates, got %s", formatRates(rates))
+	}
+
+	status = http.StatusServiceUnavailable
+	if _, err := source.FetchRates(context.Background()); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("expected the status in the error, got %v", err)
+	}
+}
+
+func TestExchangeRateStale(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	rate := database.ExchangeRate{EffectiveDate: time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)}
+	if rate.Stale(now, 0) {
+		t.Fatal("a max age of 0 should never be stale")
+	}
+	// Effective 108 hours ago.
+	if !rate.Stale(now, 96*time.Hour) {
+		t.Fatal("expected the rate to be stale after 96 hours")
+	}
+	if rate.Stale(now, 120*time.Hour) {
+		t.Fatal("expected the rate to be fresh for 120 hours")
+	}
+}
+
+func TestExchangeRateUsesClientRatesOnlyWithOptIn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	server := &Server{Store: &database.Store{DB: db}}
+	columns := []string{"id", "client_id", "currency_code", "rate_to_cad", "source", "effective_date", "created_at"}
+	effective := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	override := `FROM currency_rates c.*c\.override`
+	shared := `FROM exchange_rates r\s+WHERE r\.client_id = 0`
+	own := `FROM exchange_rates r\s+WHERE r\.client_id = \?`
+
+	// A legacy rate without the opt-in: the shared rate wins.
+	mock.ExpectQuery(override).WithArgs(int64(7), "USD").WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectQuery(shared).WithArgs("USD").WillReturnRows(sqlmock.NewRows(columns).AddRow(11, 0, "USD", 1.3745, database.FXSourceValet, effective, effective))
+	rate, err := server.exchangeRate(7, "usd")
+	if err != nil || rate.ID != 11 || rate.Override {
+		t.Fatalf("expected the shared rate, got %+v %v", rate, err)
+	}
+
+	// Opted in: the client's rate.
+	mock.ExpectQuery(override).WithArgs(int64(7), "USD").WillReturnRows(sqlmock.NewRows(columns).AddRow(12, 7, "USD", 1.35, database.FXSourceManual, effective, effective))
+	rate, err = server.exchangeRate(7, "USD")
+	if err != nil || rate.ID != 12 || !rate.Override {
+		t.Fatalf("expected the client's rate, got %+v %v", rate, err)
+	}
+
+	// No shared rate: the client's latest rate without the opt-in.
+	mock.ExpectQuery(override).WithArgs(int64(7), "JPY").WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectQuery(shared).WithArgs("JPY").WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectQuery(own).WithArgs(int64(7), "JPY").WillReturnRows(sqlmock.NewRows(columns).AddRow(13, 7, "JPY", 0.0091, database.FXSourceManual, effective, effective))
+	rate, err = server.exchangeRate(7, "JPY")
+	if err != nil || rate.ID != 13 || rate.Override {
+		t.Fatalf("expected the client's rate as a fallback, got %+v %v", rate, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
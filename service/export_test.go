@@ -0,0 +1,21 @@
+package service
+
+import "google.golang.org/grpc/metadata"
+
+// The simulator helpers of the e2e tests, for the tests in package
+// service_test, which can import the packages that serve what the Server
+// creates.
+var (
+	NewSimulatedServer   = newSimulatedServer
+	SimulatedShipRequest = simulatedShipRequest
+	ExpectLabelSaved     = expectLabelSaved
+)
+
+const LabelArtifactsMetadataKey = labelArtifactsMetadataKey
+
+type HeaderStream = headerStream
+
+// Header returns the response headers set so far.
+func (s *headerStream) Header() metadata.MD {
+	return s.header
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"lexmodo-plugin/database"
+)
+
+// estimatedRateSuffix is added to the name of rates from the fallback table
+// so that the storefront shows they are estimates.
+const estimatedRateSuffix = " (estimate)"
+
+// canadaPostUnavailable reports whether err means Canada Post couldn't be
+// reached or didn't answer, as opposed to rejecting the request, so that a
+// fallback rate can be served instead.
+func canadaPostUnavailable(err error) bool {
+	switch code, _ := pluginErrorResult(err); code {
+	case "429", "502", "503", "504":
+		return true
+	}
+	return false
+}
+
+// fallbackZoneRank is how specifically zone matches the destination: 4 for
+// its forward sortation area, 3 for its province, 2 for its country, 1 for
+// "*" and 0 when it does not match.
+func fallbackZoneRank(zone string, dest canadaPostDestination) int {
+	country := strings.ToUpper(strings.TrimSpace(dest.Country))
+	province := strings.ToUpper(strings.TrimSpace(dest.Province))
+	postalCode := strings.ToUpper(strings.ReplaceAll(dest.PostalCode, " ", ""))
+	zone = strings.ToUpper(strings.TrimSpace(zone))
+	switch {
+	case zone == "*":
+		return 1
+	case len(zone) == 2:
+		if zone == country {
+			return 2
+		}
+	case strings.Contains(zone, "-"):
+		if zone == country+"-"+province {
+			return 3
+		}
+	case len(zone) == 3:
+		if country == "CA" && strings.HasPrefix(postalCode, zone) {
+			return 4
+		}
+	}
+	return 0
+}
+
+// fallbackRateCandidates prices each service of the table for the
+// destination and weight in kg: the most specific zone wins, then the
+// smallest weight bracket the shipment fits in. Services without such a row
+// are not offered.
+func fallbackRateCandidates(table []database.FallbackRate, dest canadaPostDestination, weight float64) []rateCandidate {
+	best := map[string]database.FallbackRate{}
+	bestRank := map[string]int{}
+	order := []string{}
+	for _, row := range table {
+		rank := fallbackZoneRank(row.Zone, dest)
+		if rank == 0 || row.MaxWeight < weight {
+			continue
+		}
+		code := strings.ToUpper(strings.TrimSpace(row.ServiceCode))
+		current, ok := best[code]
+		if !ok {
+			order = append(order, code)
+		}
+		if !ok || rank > bestRank[code] || (rank == bestRank[code] && row.MaxWeight < current.MaxWeight) {
+			best[code] = row
+			bestRank[code] = rank
+		}
+	}
+
+	candidates := make([]rateCandidate, 0, len(order))
+	for _, code := range order {
+		row := best[code]
+		candidates = append(candidates, rateCandidate{
+			ServiceCode:  code,
+			ServiceName:  fallbackServiceName(code) + estimatedRateSuffix,
+			PriceCents:   int64(math.Round(row.Price * 100)),
+			DeliveryDays: uint32(max(row.DeliveryDays, 0)),
+			Estimated:    true,
+		})
+	}
+	return candidates
+}
+
+// loadFallbackRates returns the client's fallback table; without a store or
+// client, or if it can't be loaded, there is no fallback.
+func (s *Server) loadFallbackRates(clientID int64) []database.FallbackRate {
+	if clientID <= 0 || s.Store == nil {
+		return nil
+	}
+	table, err := s.Store.LoadFallbackRates(clientID)
+	if err != nil {
+		log.Println("failed to load fallback rates:", err)
+		return nil
+	}
+	return table
+}
+
+// requoteEstimatedRate prices an estimated rate with Canada Post before its
+// label is bought, so that the label records the real carrier cost. The
+// customer keeps the price they were shown. It fails when Canada Post is
+// still unavailable or no longer offers the service.
+func (s *Server) requoteEstimatedRate(ctx context.Context, snapshot *RateSnapshot) error {
+	if !snapshot.Estimated {
+		return nil
+	}
+	settings := database.ShippingSettings{}
+	if snapshot.ClientID > 0 && s.Store != nil {
+		loaded, err := s.Store.LoadShippingSettings(snapshot.ClientID)
+		if err != nil {
+			log.Println("failed to load shipping settings:", err)
+		} else {
+			settings = loaded
+		}
+	}
+	pieces := snapshot.Pieces
+	if len(pieces) == 0 {
+		pieces = []parcelMetrics{snapshot.Parcel}
+	}
+	payload := s.newRateRequest(settings, snapshot.Origin, snapshot.Destination, pieces[0])
+	estimate := newDeliveryEstimate(time.Now(), settings.DeliverySchedule(), snapshot.Origin.Province, snapshot.Destination)
+	if mailingDate := upcomingMailingDate(snapshot.MailingDate, time.Now(), settings.DeliverySchedule()); mailingDate != "" {
+		estimate.MailingDate, _ = time.Parse("2006-01-02", mailingDate)
+	}
+	payload.ExpectedMailingDate = estimate.MailingDate.Format("2006-01-02")
+	rateOptions, err := buildGetRatesOptions(snapshot.CustomOptions, snapshot.RateToCad, snapshot.Signature)
+	if err != nil {
+		return err
+	}
+	if len(rateOptions) > 0 {
+		payload.Options = &RateOptions{Option: rateOptions}
+	}
+
+	apiRates, piecePrices, err := s.getCachedPieceRates(ctx, payload, pieces)
+	if err != nil {
+		return err
+	}
+	for _, candidate := range estimate.apply(mapAPIRates(apiRates)) {
+		if !strings.EqualFold(candidate.ServiceCode, snapshot.ServiceCode) {
+			continue
+		}
+		log.Printf("estimated rate re-quoted: rate_id=%s service=%s price_cents=%d carrier_cents=%d",
+			snapshot.RateID, snapshot.ServiceCode, snapshot.PriceCents, candidate.PriceCents)
+		snapshot.ServiceName = candidate.ServiceName
+		snapshot.CarrierCents = candidate.PriceCents
+		snapshot.DeliveryDate = candidate.DeliveryDate
+		snapshot.DeliveryDays = candidate.DeliveryDays
+		snapshot.MailingDate = payload.ExpectedMailingDate
+		if len(snapshot.Pieces) > 1 {
+			snapshot.PiecePrices = piecePrices[candidate.ServiceCode]
+		}
+		snapshot.Estimated = false
+		return nil
+	}
+	return fmt.Errorf("%s is not available for this shipment", defaultValue(strings.TrimSuffix(snapshot.ServiceName, estimatedRateSuffix), snapshot.ServiceCode))
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"lexmodo-plugin/database"
+)
+
+func TestFallbackRateCandidatesPickZoneAndBracket(t *testing.T) {
+	table := []database.FallbackRate{
+		{ServiceCode: "DOM.RP", Zone: "*", MaxWeight: 30, Price: 40},
+		{ServiceCode: "DOM.RP", Zone: "CA", MaxWeight: 5, Price: 20},
+		{ServiceCode: "DOM.RP", Zone: "CA", MaxWeight: 2, Price: 15},
+		{ServiceCode: "DOM.RP", Zone: "CA-ON", MaxWeight: 1, Price: 9},
+		{ServiceCode: "DOM.EP", Zone: "CA-ON", MaxWeight: 5, Price: 22.5, DeliveryDays: 2},
+		{ServiceCode: "DOM.EP", Zone: "K1A", MaxWeight: 5, Price: 18},
+		{ServiceCode: "USA.EP", Zone: "US", MaxWeight: 5, Price: 30},
+	}
+	prices := func(dest canadaPostDestination, weight float64) map[string]int64 {
+		got := map[string]int64{}
+		for _, candidate := range fallbackRateCandidates(table, dest, weight) {
+			if !candidate.Estimated {
+				t.Fatalf("expected an estimated rate, got %+v", candidate)
+			}
+			got[candidate.ServiceCode] = candidate.PriceCents
+		}
+		return got
+	}
+
+	// Ontario fits the province bracket for Regular Parcel only up to 1 kg.
+	toronto := canadaPostDestination{Country: "CA", Province: "ON", PostalCode: "M5H 2N2"}
+	if got := prices(toronto, 1.5); fmt.Sprint(got) != "map[DOM.EP:2250 DOM.RP:1500]" {
+		t.Fatalf("unexpected Toronto rates %v", got)
+	}
+	if got := prices(toronto, 0.5); got["DOM.RP"] != 900 {
+		t.Fatalf("expected the Ontario bracket, got %v", got)
+	}
+	ottawa := canadaPostDestination{Country: "CA", Province: "ON", PostalCode: "K1A0B1"}
+	if got := prices(ottawa, 3); fmt.Sprint(got) != "map[DOM.EP:1800 DOM.RP:2000]" {
+		t.Fatalf("unexpected Ottawa rates %v", got)
+	}
+	// Too heavy for every Canadian bracket but the one for everywhere.
+	if got := prices(ottawa, 12); fmt.Sprint(got) != "map[DOM.RP:4000]" {
+		t.Fatalf("unexpected heavy rates %v", got)
+	}
+	if got := prices(canadaPostDestination{Country: "US", Province: "NY"}, 1); got["USA.EP"] != 3000 || got["DOM.EP"] != 0 {
+		t.Fatalf("unexpected US rates %v", got)
+	}
+}
+
+func TestCanadaPostUnavailable(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{&CanadaPostError{StatusCode: 503}, true},
+		{&CanadaPostError{StatusCode: 429}, true},
+		{errCircuitOpen, true},
+		{fmt.Errorf("piece 2: %w", context.DeadlineExceeded), true},
+		{&CanadaPostError{StatusCode: 400, Description: "invalid postal code"}, false},
+		{errors.New("missing ship_request"), false},
+	} {
+		if got := canadaPostUnavailable(tc.err); got != tc.want {
+			t.Fatalf("%v: got %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
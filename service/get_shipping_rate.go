@@ -19,11 +19,13 @@ func (s *Server) GetShippingRate(
 
 	rates, err := s.fetchRatesFromAPI(ctx, req)
 	if err != nil {
+		log.Println("❌ GetShippingRate error:", err)
+		code, message := pluginErrorResult(err)
 		resp := &shippingpluginpb.ResultResponse{
 			Success: false,
 			Failure: true,
-			Code:    "400",
-			Message: err.Error(),
+			Code:    code,
+			Message: message,
 		}
 		logPluginResponse("GetShippingRate", resp)
 		return resp, nil
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"lexmodo-plugin/database"
+)
+
+// labelArtifactName is the artifact name of the first label page.
+const labelArtifactName = "label"
+
+type labelArtifact struct {
+	Name      string
+	URL       string
+	MediaType string
+}
+
+// Extension is the file extension the artifact is stored and served with.
+func (a labelArtifact) Extension() string {
+	return artifactExtension(a.MediaType)
+}
+
+func artifactExtension(mediaType string) string {
+	if strings.EqualFold(strings.TrimSpace(mediaType), "application/zpl") {
+		return "zpl"
+	}
+	return "pdf"
+}
+
+// labelArtifactLinks returns every printable document of a created shipment:
+// all label pages, the commercial invoice and any other PDF or ZPL link.
+// Names are the kebab-cased rel with the page index appended from the second
+// page on, e.g. "label", "label-1", "commercial-invoice".
+func labelArtifactLinks(links []Link) []labelArtifact {
+	artifacts := make([]labelArtifact, 0, 2)
+	seen := map[string]bool{}
+	for _, link := range links {
+		rel := strings.TrimSpace(link.Rel)
+		href := strings.TrimSpace(link.Href)
+		if rel == "" || href == "" {
+			continue
+		}
+		if rel != "label" && rel != "commercialInvoice" && link.MediaType != "application/pdf" && link.MediaType != "application/zpl" {
+			continue
+		}
+		name := kebabCase(rel)
+		if index, err := strconv.Atoi(strings.TrimSpace(link.Index)); err == nil && index > 0 {
+			name += "-" + strconv.Itoa(index)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		artifacts = append(artifacts, labelArtifact{Name: name, URL: href, MediaType: strings.TrimSpace(link.MediaType)})
+	}
+	return artifacts
+}
+
+// labelArtifactOf returns the first label page among artifacts.
+func labelArtifactOf(artifacts []labelArtifact) (labelArtifact, bool) {
+	for _, artifact := range artifacts {
+		if artifact.Name == labelArtifactName {
+			return artifact, true
+		}
+	}
+	return labelArtifact{}, false
+}
+
+// labelEncoding is the encoding of the first label page, PDF or ZPL.
+func labelEncoding(artifacts []labelArtifact) string {
+	if artifact, ok := labelArtifactOf(artifacts); ok && artifact.Extension() == "zpl" {
+		return database.LabelEncodingZPL
+	}
+	return database.LabelEncodingPDF
+}
+
+// saveLabelArtifacts downloads the artifacts in the media type Canada Post
+// linked them with and stores them under the label ID. The first label page
+// is also stored as {labelID}.pdf or {labelID}.zpl, which is what
+// /labels/{id} serves. Only a failed first label page fails the call; other
+// artifacts are logged and skipped so the created shipment is kept.
+func (s *Server) saveLabelArtifacts(ctx context.Context, labelID string, artifacts []labelArtifact) ([]labelArtifact, error) {
+	saved := make([]labelArtifact, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		data, err := s.CanadaPost.GetArtifact(ctx, artifact.URL, artifact.MediaType)
+		if err == nil {
+			err = s.saveLabelArtifact(labelID, artifact, data)
+		}
+		if err == nil && artifact.Name == labelArtifactName {
+			err = s.saveLabelFile(labelID, artifact.Extension(), data)
+		}
+		if err != nil {
+			if artifact.Name == labelArtifactName {
+				return saved, err
+			}
+			log.Printf("failed to save label artifact: label_id=%s artifact=%s err=%v", labelID, artifact.Name, err)
+			continue
+		}
+		saved = append(saved, artifact)
+	}
+	return saved, nil
+}
+
+func (s *Server) saveLabelArtifact(labelID string, artifact labelArtifact, data []byte) error {
+	if !validLabelFileName(labelID) || !validLabelFileName(artifact.Name) {
+		return fmt.Errorf("invalid label artifact")
+	}
+	dir := filepath.Join(s.labelStoragePath(), labelID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, artifact.Name+"."+artifact.Extension()), data, 0o644)
+}
+
+// labelArtifactTokenTTL is how long a signed artifact URL can be fetched.
+const labelArtifactTokenTTL = 7 * 24 * time.Hour
+
+// buildLabelArtifactURL returns the URL an artifact is served at, signed
+// with a token for the label that expires labelArtifactTokenTTL after now,
+// so that whoever received it from CreateLabel can fetch it without a
+// settings session. Without an app secret nothing can be signed.
+func (s *Server) buildLabelArtifactURL(labelID string, artifact labelArtifact, now time.Time) (string, error) {
+	if strings.TrimSpace(s.Config.AppSecret) == "" {
+		return "", errors.New("oauth.app_secret is required to sign label artifact URLs")
+	}
+	baseURL := strings.TrimRight(s.Config.PublicBaseURL, "/")
+	if baseURL == "" {
+		baseURL = "http://localhost:50050"
+	}
+	labelID = strings.TrimSpace(labelID)
+	token := LabelArtifactToken(s.Config.AppSecret, labelID, now.Add(labelArtifactTokenTTL))
+	return fmt.Sprintf("%s/labels/%s/%s.%s?token=%s", baseURL, labelID, artifact.Name, artifact.Extension(), token), nil
+}
+
+// LabelArtifactToken signs a label ID and an expiry with the app secret, as
+// "{expiry unix time}.{signature}". It grants access to the artifacts of
+// that label only, until expires.
+func LabelArtifactToken(secret string, labelID string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return expiry + "." + labelArtifactSignature(secret, labelID, expiry)
+}
+
+func labelArtifactSignature(secret string, labelID string, expiry string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.TrimSpace(labelID) + "." + expiry))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidLabelArtifactToken reports whether token is a LabelArtifactToken of
+// labelID that has not expired at now. Without a secret no token is valid.
+func ValidLabelArtifactToken(secret string, labelID string, token string, now time.Time) bool {
+	token = strings.TrimSpace(token)
+	if strings.TrimSpace(secret) == "" || token == "" {
+		return false
+	}
+	expiry, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || now.Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(labelArtifactSignature(secret, labelID, expiry)), []byte(signature))
+}
+
+func artifactNames(artifacts []labelArtifact) []string {
+	names := make([]string, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		names = append(names, artifact.Name)
+	}
+	return names
+}
+
+// CreateLabel response header metadata. The plugin proto's label response
+// has one URL, so the other artifacts and separate piece labels are sent as
+// gRPC response headers; the keys are documented as part of the CreateLabel
+// contract in docs/canada_post_xml.md.
+const (
+	labelArtifactsMetadataKey = "x-label-artifacts" // a "name=url" value per artifact other than the first label page
+	pieceLabelsMetadataKey    = "x-piece-labels"    // a label URL per piece, first piece first, when they could not be merged
+	trackingPinsMetadataKey   = "x-tracking-pins"   // the tracking PIN of every piece of a multi-piece label, first piece first
+)
+
+// labelArtifactMetadata returns the URLs of the artifacts other than the
+// first label page as labelArtifactsMetadataKey values, signed at now. URLs
+// that can't be signed are left out, since they could not be fetched.
+func (s *Server) labelArtifactMetadata(labelID string, artifacts []labelArtifact, now time.Time) []string {
+	values := make([]string, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		if artifact.Name == labelArtifactName {
+			continue
+		}
+		artifactURL, err := s.buildLabelArtifactURL(labelID, artifact, now)
+		if err != nil {
+			log.Printf("❌ label artifact URL not returned: label_id=%s artifact=%s err=%v", labelID, artifact.Name, err)
+			continue
+		}
+		values = append(values, artifact.Name+"="+artifactURL)
+	}
+	return values
+}
+
+// setLabelMetadata adds values under key to the response headers of the
+// gRPC call in ctx. Outside a gRPC call it only logs.
+func setLabelMetadata(ctx context.Context, key string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	pairs := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		pairs = append(pairs, key, value)
+	}
+	if err := grpc.SetHeader(ctx, metadata.Pairs(pairs...)); err != nil {
+		log.Printf("failed to set response metadata: key=%s err=%v", key, err)
+	}
+}
+
+func (s *Server) labelStoragePath() string {
+	if storagePath := strings.TrimSpace(s.Config.LabelStoragePath); storagePath != "" {
+		return storagePath
+	}
+	return "files/labels"
+}
+
+func validLabelFileName(name string) bool {
+	return strings.TrimSpace(name) != "" && !strings.Contains(name, "/") && !strings.Contains(name, "\\") && !strings.Contains(name, "..")
+}
+
+func kebabCase(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
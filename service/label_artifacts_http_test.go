@@ -0,0 +1,106 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	address "bitbucket.org/lexmodo/proto/address"
+	labels "bitbucket.org/lexmodo/proto/labels"
+	money "bitbucket.org/lexmodo/proto/money"
+	shippingpluginpb "bitbucket.org/lexmodo/proto/shipping_plugin"
+	"github.com/DATA-DOG/go-sqlmock"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	httpapi "lexmodo-plugin/protocol/http"
+	"lexmodo-plugin/service"
+	"lexmodo-plugin/service/cpsim"
+)
+
+// TestCreateLabelArtifactURLIsServed fetches the commercial invoice URL that
+// CreateLabel returns from the label handler, as the caller would.
+func TestCreateLabelArtifactURLIsServed(t *testing.T) {
+	sim := cpsim.New()
+	defer sim.Close()
+	server, mock := service.NewSimulatedServer(t, sim)
+	mux := http.NewServeMux()
+	labelServer := httptest.NewServer(mux)
+	defer labelServer.Close()
+	server.Config.PublicBaseURL = labelServer.URL
+	server.Config.AppSecret = "app-secret"
+	httpapi.NewApp(server.Config, server.Store).RegisterRoutes(mux)
+	ctx := context.Background()
+
+	shipRequest := service.SimulatedShipRequest()
+	shipRequest.Customer = &address.Address{
+		Street1:      wrapperspb.String("350 Fifth Avenue"),
+		City:         wrapperspb.String("New York"),
+		ProvinceCode: wrapperspb.String("NY"),
+		Zip:          wrapperspb.String("10118"),
+		CountryCode:  wrapperspb.String("US"),
+		Phone:        wrapperspb.String("2125550199"),
+		FullName:     wrapperspb.String("Jordan Lee"),
+	}
+	shipRequest.CustomsInfo = &labels.CustomsInfo{
+		CustomItems: []*labels.CustomItem{{
+			Description:   "Maple syrup",
+			Quantity:      1,
+			Weight:        35,
+			TotalValue:    &money.Money{Amount: 2500, CurrencyCode: "CAD"},
+			OriginCountry: "CA",
+		}},
+	}
+	ratesResp, err := server.GetShippingRate(ctx, &shippingpluginpb.ShippingRateRequest{ShipRequest: shipRequest})
+	if err != nil || !ratesResp.Success {
+		t.Fatalf("expected rates, got %+v %v", ratesResp, err)
+	}
+	for _, rate := range ratesResp.ShippingRates {
+		if rate.ShippingrateServiceName == "Expedited Parcel USA" {
+			shipRequest.ShippingRateId = rate.ShippingrateId
+		}
+	}
+	if shipRequest.ShippingRateId == "" {
+		t.Fatalf("expected Expedited Parcel USA, got %+v", ratesResp.ShippingRates)
+	}
+
+	service.ExpectLabelSaved(mock, 1)
+	stream := &service.HeaderStream{}
+	labelResp, err := server.CreateLabel(grpc.NewContextWithServerTransportStream(ctx, stream), &shippingpluginpb.ShippingRateRequest{ShipRequest: shipRequest})
+	if err != nil || !labelResp.Success {
+		t.Fatalf("expected a label, got %+v %v", labelResp, err)
+	}
+	artifacts := stream.Header().Get(service.LabelArtifactsMetadataKey)
+	if len(artifacts) != 1 || !strings.HasPrefix(artifacts[0], "commercial-invoice=") {
+		t.Fatalf("expected the commercial invoice URL, got %q", artifacts)
+	}
+	invoiceURL := strings.TrimPrefix(artifacts[0], "commercial-invoice=")
+
+	// The label's output preference is looked up before it is served.
+	mock.ExpectQuery("FROM label_records").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	resp, err := http.Get(invoiceURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/pdf" || !strings.HasPrefix(string(body), "%PDF") {
+		t.Fatalf("expected the commercial invoice, got %d %q", resp.StatusCode, body)
+	}
+
+	// The token only opens the label it was issued for.
+	otherURL := strings.Replace(invoiceURL, labelResp.Label.LabelId, labelResp.Label.LabelId+"-2", 1)
+	resp, err = http.Get(otherURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected another label's artifact to be refused, got %d", resp.StatusCode)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"lexmodo-plugin/config"
+)
+
+func TestLabelArtifactLinksNamesEveryArtifact(t *testing.T) {
+	links := []Link{
+		{Rel: "self", Href: "https://ct.soa-gw.canadapost.ca/rs/1/ncshipment/1", MediaType: "application/vnd.cpc.ncshipment-v4+xml"},
+		{Rel: "label", Href: "https://example.com/label/0", MediaType: "application/pdf", Index: "0"},
+		{Rel: "label", Href: "https://example.com/label/1", MediaType: "application/pdf", Index: "1"},
+		{Rel: "commercialInvoice", Href: "https://example.com/ci/0", MediaType: "application/pdf", Index: "0"},
+		{Rel: "refund", Href: "https://example.com/refund", MediaType: "application/vnd.cpc.ncshipment-v4+xml"},
+	}
+	artifacts := labelArtifactLinks(links)
+	want := []string{"label", "label-1", "commercial-invoice"}
+	if len(artifacts) != len(want) {
+		t.Fatalf("unexpected artifacts: %+v", artifacts)
+	}
+	for i, name := range want {
+		if artifacts[i].Name != name {
+			t.Fatalf("expected artifact %d to be %s, got %s", i, name, artifacts[i].Name)
+		}
+	}
+}
+
+func TestSaveLabelArtifactsStoresEveryDocument(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("%PDF " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	s := &Server{
+		Config:     config.Config{LabelStoragePath: dir},
+		CanadaPost: NewCanadaPostClient("user", "pass", "0001234567", server.URL),
+	}
+	saved, err := s.saveLabelArtifacts(context.Background(), "LBL1", []labelArtifact{
+		{Name: "label", URL: server.URL + "/label"},
+		{Name: "commercial-invoice", URL: server.URL + "/ci"},
+		{Name: "label-1", URL: server.URL + "/missing"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if names := artifactNames(saved); len(names) != 2 || names[0] != "label" || names[1] != "commercial-invoice" {
+		t.Fatalf("unexpected saved artifacts: %v", names)
+	}
+	for _, file := range []string{"LBL1.pdf", filepath.Join("LBL1", "label.pdf"), filepath.Join("LBL1", "commercial-invoice.pdf")} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Fatalf("expected %s to be stored: %v", file, err)
+		}
+	}
+}
+
+func TestSaveLabelArtifactsStoresZPLLabel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Accept"); got != "application/zpl" {
+			t.Fatalf("unexpected accept %q", got)
+		}
+		_, _ = w.Write([]byte("^XA^XZ"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	s := &Server{
+		Config:     config.Config{LabelStoragePath: dir, PublicBaseURL: "https://labels.example.com"},
+		CanadaPost: NewCanadaPostClient("user", "pass", "0001234567", server.URL),
+	}
+	artifacts := labelArtifactLinks([]Link{{Rel: "label", Href: server.URL + "/label", MediaType: "application/zpl", Index: "0"}})
+	saved, err := s.saveLabelArtifacts(context.Background(), "LBL2", artifacts)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if encoding := labelEncoding(saved); encoding != "ZPL" {
+		t.Fatalf("expected ZPL encoding, got %s", encoding)
+	}
+	for _, file := range []string{"LBL2.zpl", filepath.Join("LBL2", "label.zpl")} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Fatalf("expected %s to be stored: %v", file, err)
+		}
+	}
+	if got := s.buildLabelURL("LBL2", "zpl"); got != "https://labels.example.com/labels/LBL2.zpl" {
+		t.Fatalf("unexpected label url %s", got)
+	}
+}
+
+func TestLabelArtifactMetadata(t *testing.T) {
+	now := time.Date(2026, 2, 5, 12, 0, 0, 0, time.UTC)
+	s := &Server{Config: config.Config{PublicBaseURL: "https://labels.example.com", AppSecret: "app-secret"}}
+	stream := &headerStream{}
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	artifacts := []labelArtifact{
+		{Name: "label", MediaType: "application/pdf"},
+		{Name: "commercial-invoice", MediaType: "application/pdf"},
+	}
+	setLabelMetadata(ctx, labelArtifactsMetadataKey, s.labelArtifactMetadata("LBL3", artifacts, now))
+	got := stream.header.Get(labelArtifactsMetadataKey)
+	if len(got) != 1 || got[0] != "commercial-invoice=https://labels.example.com/labels/LBL3/commercial-invoice.pdf?token="+LabelArtifactToken("app-secret", "LBL3", now.Add(labelArtifactTokenTTL)) {
+		t.Fatalf("unexpected artifact metadata %q", got)
+	}
+
+	// Unsigned URLs could not be fetched, so none are returned.
+	s.Config.AppSecret = ""
+	if got := s.labelArtifactMetadata("LBL3", artifacts, now); len(got) != 0 {
+		t.Fatalf("expected no artifact URLs without a secret, got %q", got)
+	}
+}
+
+func TestValidLabelArtifactToken(t *testing.T) {
+	now := time.Date(2026, 2, 5, 12, 0, 0, 0, time.UTC)
+	token := LabelArtifactToken("app-secret", "LBL3", now.Add(time.Hour))
+	if !ValidLabelArtifactToken("app-secret", "LBL3", token, now) {
+		t.Fatal("expected the label's own token to be valid")
+	}
+	if ValidLabelArtifactToken("app-secret", "LBL4", token, now) || ValidLabelArtifactToken("other-secret", "LBL3", token, now) {
+		t.Fatal("expected the token to be tied to the label and the secret")
+	}
+	if ValidLabelArtifactToken("app-secret", "LBL3", token, now.Add(2*time.Hour)) {
+		t.Fatal("expected the token to expire")
+	}
+	// Moving the expiry invalidates the signature.
+	_, signature, _ := strings.Cut(token, ".")
+	later := strconv.FormatInt(now.Add(48*time.Hour).Unix(), 10)
+	if ValidLabelArtifactToken("app-secret", "LBL3", later+"."+signature, now.Add(2*time.Hour)) {
+		t.Fatal("expected a token with a changed expiry to be invalid")
+	}
+	if ValidLabelArtifactToken("", "LBL3", LabelArtifactToken("", "LBL3", now.Add(time.Hour)), now) {
+		t.Fatal("expected no token to be valid without a secret")
+	}
+}
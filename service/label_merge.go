@@ -0,0 +1,223 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"lexmodo-plugin/database"
+)
+
+// errUnsupportedPDF is returned for label PDFs mergePDFs can't combine, such
+// as encrypted files or files that keep objects in compressed streams.
+var errUnsupportedPDF = errors.New("unsupported label PDF")
+
+// mergeLabels combines the labels of the pieces of a shipment into one file:
+// ZPL labels are concatenated, PDF labels become one document with every
+// page in piece order.
+func mergeLabels(encoding string, labels [][]byte) ([]byte, error) {
+	if len(labels) == 1 {
+		return labels[0], nil
+	}
+	if encoding == database.LabelEncodingZPL {
+		var merged bytes.Buffer
+		for _, label := range labels {
+			merged.Write(bytes.TrimSpace(label))
+			merged.WriteByte('\n')
+		}
+		return merged.Bytes(), nil
+	}
+	return mergePDFs(labels)
+}
+
+var (
+	pdfObjectHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	pdfReference    = regexp.MustCompile(`(\d+)\s+(\d+)\s+R\b`)
+	pdfRoot         = regexp.MustCompile(`/Root\s+(\d+)\s+\d+\s+R`)
+	pdfPages        = regexp.MustCompile(`/Pages\s+(\d+)\s+\d+\s+R`)
+	pdfCount        = regexp.MustCompile(`/Count\s+(\d+)`)
+)
+
+// pdfObject is an indirect object of a PDF: its dictionary or value, and the
+// raw stream that follows it, if any.
+type pdfObject struct {
+	number int
+	head   []byte
+	stream []byte // from "stream" to "endstream" inclusive
+}
+
+// mergePDFs puts the page trees of docs under one new page tree. Objects are
+// renumbered so the documents don't collide; their content is copied as is.
+// It handles the classic xref layout Canada Post labels use and returns
+// errUnsupportedPDF for anything else.
+func mergePDFs(docs [][]byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := map[int]int{}
+	writeObject := func(number int, head []byte, stream []byte) {
+		offsets[number] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n", number)
+		out.Write(bytes.TrimSpace(head))
+		if stream != nil {
+			out.WriteByte('\n')
+			out.Write(stream)
+		}
+		out.WriteString("\nendobj\n")
+	}
+
+	next := 1
+	kids := make([]int, 0, len(docs))
+	pageCount := 0
+	// Each document's page tree root is written last, once the number of the
+	// new root it hangs from is known.
+	parents := make([]pdfObject, 0, len(docs))
+	for i, doc := range docs {
+		objects, pagesNumber, count, err := parsePDF(doc)
+		if err != nil {
+			return nil, fmt.Errorf("label %d: %w", i+1, err)
+		}
+		offset := next - 1
+		maxNumber := 0
+		for _, object := range objects {
+			if object.number > maxNumber {
+				maxNumber = object.number
+			}
+			head := renumberPDFReferences(object.head, offset)
+			if object.number == pagesNumber {
+				parents = append(parents, pdfObject{number: object.number + offset, head: head, stream: object.stream})
+				continue
+			}
+			writeObject(object.number+offset, head, object.stream)
+		}
+		kids = append(kids, pagesNumber+offset)
+		pageCount += count
+		next += maxNumber
+	}
+
+	rootPages := next
+	catalog := next + 1
+	for _, parent := range parents {
+		head := bytes.Replace(parent.head, []byte("<<"), []byte(fmt.Sprintf("<< /Parent %d 0 R ", rootPages)), 1)
+		writeObject(parent.number, head, parent.stream)
+	}
+	var kidsList bytes.Buffer
+	for i, kid := range kids {
+		if i > 0 {
+			kidsList.WriteByte(' ')
+		}
+		fmt.Fprintf(&kidsList, "%d 0 R", kid)
+	}
+	writeObject(rootPages, []byte(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kidsList.String(), pageCount)), nil)
+	writeObject(catalog, []byte(fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", rootPages)), nil)
+
+	size := catalog + 1
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", size)
+	for number := 1; number < size; number++ {
+		if offset, ok := offsets[number]; ok {
+			fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+		} else {
+			out.WriteString("0000000000 65535 f \n")
+		}
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", size, catalog, xref)
+	return out.Bytes(), nil
+}
+
+// parsePDF returns the objects of doc, the number of its page tree root and
+// its page count. Later definitions of an object (incremental updates)
+// replace earlier ones.
+func parsePDF(doc []byte) ([]pdfObject, int, int, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(doc), []byte("%PDF-")) {
+		return nil, 0, 0, fmt.Errorf("%w: not a PDF", errUnsupportedPDF)
+	}
+	if bytes.Contains(doc, []byte("/Encrypt")) || bytes.Contains(doc, []byte("/ObjStm")) {
+		return nil, 0, 0, fmt.Errorf("%w: encrypted or compressed objects", errUnsupportedPDF)
+	}
+
+	byNumber := map[int]pdfObject{}
+	pos := 0
+	for {
+		loc := pdfObjectHeader.FindSubmatchIndex(doc[pos:])
+		if loc == nil {
+			break
+		}
+		number, _ := strconv.Atoi(string(doc[pos+loc[2] : pos+loc[3]]))
+		start := pos + loc[1]
+		object := pdfObject{number: number}
+		end := bytes.Index(doc[start:], []byte("endobj"))
+		if end < 0 {
+			return nil, 0, 0, fmt.Errorf("%w: object %d has no endobj", errUnsupportedPDF, number)
+		}
+		body := doc[start : start+end]
+		if streamAt := bytes.Index(body, []byte("stream")); streamAt >= 0 {
+			// The stream may contain "endobj"; look for the end of the stream first.
+			streamEnd := bytes.Index(doc[start+streamAt:], []byte("endstream"))
+			if streamEnd < 0 {
+				return nil, 0, 0, fmt.Errorf("%w: object %d has no endstream", errUnsupportedPDF, number)
+			}
+			streamEnd += start + streamAt + len("endstream")
+			object.head = doc[start : start+streamAt]
+			object.stream = doc[start+streamAt : streamEnd]
+			end = bytes.Index(doc[streamEnd:], []byte("endobj"))
+			if end < 0 {
+				return nil, 0, 0, fmt.Errorf("%w: object %d has no endobj", errUnsupportedPDF, number)
+			}
+			pos = streamEnd + end + len("endobj")
+		} else {
+			object.head = body
+			pos = start + end + len("endobj")
+		}
+		byNumber[number] = object
+	}
+
+	roots := pdfRoot.FindAllSubmatch(doc, -1)
+	if len(roots) == 0 {
+		return nil, 0, 0, fmt.Errorf("%w: no document catalog", errUnsupportedPDF)
+	}
+	rootNumber, _ := strconv.Atoi(string(roots[len(roots)-1][1]))
+	catalog, ok := byNumber[rootNumber]
+	if !ok {
+		return nil, 0, 0, fmt.Errorf("%w: missing catalog object %d", errUnsupportedPDF, rootNumber)
+	}
+	pagesMatch := pdfPages.FindSubmatch(catalog.head)
+	if pagesMatch == nil {
+		return nil, 0, 0, fmt.Errorf("%w: catalog has no pages", errUnsupportedPDF)
+	}
+	pagesNumber, _ := strconv.Atoi(string(pagesMatch[1]))
+	pages, ok := byNumber[pagesNumber]
+	if !ok {
+		return nil, 0, 0, fmt.Errorf("%w: missing pages object %d", errUnsupportedPDF, pagesNumber)
+	}
+	countMatch := pdfCount.FindSubmatch(pages.head)
+	if countMatch == nil {
+		return nil, 0, 0, fmt.Errorf("%w: page tree has no count", errUnsupportedPDF)
+	}
+	count, _ := strconv.Atoi(string(countMatch[1]))
+
+	// The old catalog and any xref streams aren't needed in the merged file.
+	delete(byNumber, rootNumber)
+	objects := make([]pdfObject, 0, len(byNumber))
+	for _, object := range byNumber {
+		if bytes.Contains(object.head, []byte("/Type /XRef")) || bytes.Contains(object.head, []byte("/Type/XRef")) {
+			continue
+		}
+		objects = append(objects, object)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].number < objects[j].number })
+	return objects, pagesNumber, count, nil
+}
+
+func renumberPDFReferences(head []byte, offset int) []byte {
+	if offset == 0 {
+		return head
+	}
+	return pdfReference.ReplaceAllFunc(head, func(ref []byte) []byte {
+		match := pdfReference.FindSubmatch(ref)
+		number, _ := strconv.Atoi(string(match[1]))
+		return []byte(fmt.Sprintf("%d 0 R", number+offset))
+	})
+}
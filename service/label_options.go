@@ -114,6 +114,7 @@ func (s *Server) buildLabelOptionsCredentials(ctx context.Context) []*shippingpl
 		buildField(fieldD2POOfficeSelection, "Select post office for delivery (Canada only)", shippingpluginpb.FIELD_TYPE_radio, "", officeOptions...),
 		buildField(fieldD2PONotificationEmail, "Email for pickup notification", shippingpluginpb.FIELD_TYPE_text, ""),
 		buildField(fieldNonDeliveryHandling, "What should happen if delivery fails? (USA/International only)", shippingpluginpb.FIELD_TYPE_radio, "", nonDeliveryLabels...),
+		buildField(fieldShipDate, "Ship date (YYYY-MM-DD, optional)", shippingpluginpb.FIELD_TYPE_text, ""),
 	}
 }
 
@@ -227,18 +228,19 @@ func (s *Server) validateCustomInfoMapValues(values map[string]string) error {
 	return nil
 }
 
-func (s *Server) validateOptions(options []ShipmentOption, _ string, destination string) error {
+// validateOptions checks the final label options against the option rules of
+// the selected service, then the destination and amount requirements.
+func (s *Server) validateOptions(options []ShipmentOption, serviceCode string, destination string) error {
 	if len(options) == 0 {
 		return nil
 	}
+	if err := validateOptionRules(options, serviceCode); err != nil {
+		return err
+	}
+	if err := validateOptionQualifiers(options, serviceCode); err != nil {
+		return err
+	}
 
-	ageCount := 0
-	deliveryCount := 0
-	nonDeliveryCount := 0
-
-	hasCOD := false
-	hasHFP := false
-	hasD2PO := false
 	destCountry := strings.ToUpper(strings.TrimSpace(destination))
 	isCanada := destCountry == "CA"
 	enforceGeo := destCountry != ""
@@ -246,29 +248,17 @@ func (s *Server) validateOptions(options []ShipmentOption, _ string, destination
 	for _, opt := range options {
 		code := strings.ToUpper(strings.TrimSpace(opt.Code))
 		switch code {
-		case "PA18", "PA19":
-			ageCount++
-		case "HFP":
-			deliveryCount++
-			hasHFP = true
-		case "DNS", "LAD":
-			deliveryCount++
 		case "RASE", "RTS", "ABAN":
 			if enforceGeo && isCanada {
 				return fmt.Errorf("non-delivery handling options are only valid for USA/International shipments")
 			}
-			nonDeliveryCount++
 		case "COD":
 			if enforceGeo && !isCanada {
 				return fmt.Errorf("COD is only available for Canada destinations")
 			}
-			hasCOD = true
 			if opt.OptionAmount <= 0 {
 				return fmt.Errorf("COD amount must be greater than zero")
 			}
-			if strings.TrimSpace(opt.OptionQualifier1) == "" {
-				return fmt.Errorf("COD includes_shipping must be provided")
-			}
 		case "COV":
 			if opt.OptionAmount <= 0 {
 				return fmt.Errorf("coverage amount must be greater than zero")
@@ -277,30 +267,8 @@ func (s *Server) validateOptions(options []ShipmentOption, _ string, destination
 			if enforceGeo && !isCanada {
 				return fmt.Errorf("D2PO is only available for Canada destinations")
 			}
-			hasD2PO = true
-			if strings.TrimSpace(opt.OptionQualifier2) == "" {
-				return fmt.Errorf("D2PO office_id is required")
-			}
 		}
 	}
-
-	if ageCount > 1 {
-		return fmt.Errorf("only one age verification option can be selected")
-	}
-	if deliveryCount > 1 {
-		return fmt.Errorf("only one delivery method option can be selected")
-	}
-	if nonDeliveryCount > 1 {
-		return fmt.Errorf("only one non-delivery handling option can be selected")
-	}
-	if hasD2PO && deliveryCount > 0 {
-		return fmt.Errorf("delivery method is mutually exclusive with Deliver to Post Office")
-	}
-
-	if hasCOD && !(hasHFP || hasD2PO) {
-		return fmt.Errorf("COD requires Hold for Pickup or Deliver to Post Office to be selected")
-	}
-
 	return nil
 }
 
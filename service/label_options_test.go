@@ -176,37 +176,37 @@ func TestValidateCustomInfoForDestination_NonDeliveryInCanada(t *testing.T) {
 	}
 }
 
-func TestValidateSignatureRequirement_RequiresSignatureForAge(t *testing.T) {
+func TestValidateCanadaPostOptionRules_AgeRequiresSignature(t *testing.T) {
 	values := map[string]string{
 		fieldAgeVerification: "Proof of Age 18+",
 	}
-	err := validateSignatureRequirement(values, "NO_SIGNATURE")
+	err := validateCanadaPostOptionRules(values, "NO_SIGNATURE", "+12015550123", "CA", 1)
 	if err == nil {
 		t.Fatalf("expected signature requirement error")
 	}
-	if got := err.Error(); got != "age verification requires signature option. please enable signature to continue" {
+	if got := err.Error(); got != "Proof of Age 18+ requires Signature to be selected" {
 		t.Fatalf("unexpected error message: %s", got)
 	}
 }
 
-func TestValidateSignatureRequirement_RejectsLeaveAtDoorWithSignature(t *testing.T) {
+func TestValidateCanadaPostOptionRules_RejectsLeaveAtDoorWithSignature(t *testing.T) {
 	values := map[string]string{
 		fieldDeliveryMethod: "Leave at Door",
 	}
-	err := validateSignatureRequirement(values, "ADULT_SIGNATURE")
+	err := validateCanadaPostOptionRules(values, "ADULT_SIGNATURE", "+12015550123", "CA", 1)
 	if err == nil {
 		t.Fatalf("expected conflict for Leave at Door with signature")
 	}
-	if got := err.Error(); got != "Leave at Door cannot be combined with signature option. please choose standard delivery or another delivery method" {
+	if got := err.Error(); got != "Signature cannot be combined with Leave at Door" {
 		t.Fatalf("unexpected error message: %s", got)
 	}
 }
 
-func TestValidateSignatureRequirement_AllowsAgeWhenSignatureEnabled(t *testing.T) {
+func TestValidateCanadaPostOptionRules_AllowsAgeWhenSignatureEnabled(t *testing.T) {
 	values := map[string]string{
 		fieldAgeVerification: "Proof of Age 19+",
 	}
-	if err := validateSignatureRequirement(values, "ADULT_SIGNATURE"); err != nil {
+	if err := validateCanadaPostOptionRules(values, "ADULT_SIGNATURE", "+12015550123", "CA", 1); err != nil {
 		t.Fatalf("expected no error when signature enabled, got: %v", err)
 	}
 }
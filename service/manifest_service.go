@@ -0,0 +1,300 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lexmodo-plugin/config"
+	"lexmodo-plugin/database"
+)
+
+type ManifestService struct {
+	cpClient    *CanadaPostClient
+	store       *database.Store
+	storagePath string
+	customerNo  string
+}
+
+func NewManifestService(store *database.Store, cfg config.Config) *ManifestService {
+	return &ManifestService{
+		cpClient: NewCanadaPostClient(
+			cfg.CanadaPost.Username,
+			cfg.CanadaPost.Password,
+			cfg.CanadaPost.CustomerNumber,
+			cfg.CanadaPost.BaseURL,
+		),
+		store:       store,
+		storagePath: cfg.LabelStoragePath,
+		customerNo:  cfg.CanadaPost.CustomerNumber,
+	}
+}
+
+type ManifestResult struct {
+	GroupIDs    []string
+	ManifestIDs []string
+}
+
+// manifestRetryInterval is how often manifests that failed to download or
+// weren't ready yet are downloaded again.
+const manifestRetryInterval = time.Hour
+
+// maxManifestDownloadAttempts is how many times a manifest is downloaded
+// before it is given up on.
+const maxManifestDownloadAttempts = 8
+
+// TransmitClient transmits every pending contract group of a client, then
+// downloads the resulting manifests, and any earlier ones not downloaded
+// yet, and links them to their labels.
+func (s *ManifestService) TransmitClient(ctx context.Context, clientID int64) (ManifestResult, error) {
+	result := ManifestResult{}
+	if s == nil || s.store == nil {
+		return result, fmt.Errorf("manifest store not configured")
+	}
+	if clientID <= 0 {
+		return result, fmt.Errorf("client id required")
+	}
+
+	settings, err := s.store.LoadShippingSettings(clientID)
+	if err != nil {
+		return result, err
+	}
+	if !contractShippingEnabled(settings) {
+		return result, fmt.Errorf("contract shipping is not configured")
+	}
+	groups, err := s.store.LoadPendingManifestGroups(clientID)
+	if err != nil {
+		return result, err
+	}
+	if len(groups) > 0 {
+		if err := s.transmitGroups(ctx, clientID, settings, groups); err != nil {
+			return result, err
+		}
+		result.GroupIDs = groups
+	}
+	result.ManifestIDs = s.DownloadPendingManifests(ctx, clientID)
+	return result, nil
+}
+
+// transmitGroups transmits the groups and stores the returned manifest links
+// with the groups' transmitted status.
+func (s *ManifestService) transmitGroups(ctx context.Context, clientID int64, settings database.ShippingSettings, groups []string) error {
+	address := settings.ManifestAddress
+	if strings.TrimSpace(address.PostalCode) == "" || strings.TrimSpace(address.AddressLine1) == "" {
+		return fmt.Errorf("manifest address is missing; create a contract label first")
+	}
+
+	mailedBy := strings.TrimSpace(settings.AccountNumber)
+	if mailedBy == "" {
+		mailedBy = s.customerNo
+	}
+	req := buildTransmitSetRequest(groups, settings)
+	links, err := s.cpClient.TransmitShipments(ctx, mailedBy, req)
+	if err != nil {
+		return err
+	}
+	manifestURLs := []string{}
+	for _, link := range links {
+		if strings.EqualFold(link.Rel, "manifest") {
+			manifestURLs = append(manifestURLs, link.Href)
+		}
+	}
+	if err := s.store.MarkGroupsTransmitted(clientID, groups, manifestURLs); err != nil {
+		log.Printf("❌ manifest: groups transmitted but not recorded client_id=%d groups=%v manifests=%v", clientID, groups, manifestURLs)
+		return err
+	}
+	log.Printf("manifest: transmitted client_id=%d groups=%v manifests=%d", clientID, groups, len(manifestURLs))
+	return nil
+}
+
+// DownloadPendingManifests downloads the manifests not downloaded yet, of
+// every client when clientID is 0, and returns the IDs of those saved. A
+// failed download is recorded and tried again on a later run, up to
+// maxManifestDownloadAttempts times.
+func (s *ManifestService) DownloadPendingManifests(ctx context.Context, clientID int64) []string {
+	links, err := s.store.LoadPendingManifestLinks(clientID, maxManifestDownloadAttempts)
+	if err != nil {
+		log.Println("manifest: failed to load pending manifests:", err)
+		return nil
+	}
+	manifestIDs := []string{}
+	for _, link := range links {
+		manifestID, err := s.saveManifest(ctx, link.ClientID, link.ManifestURL)
+		if err != nil {
+			log.Printf("manifest: failed to retrieve %s (attempt %d): %v", link.ManifestURL, link.Attempts+1, err)
+			if err := s.store.RecordManifestLinkError(link.ID, err.Error()); err != nil {
+				log.Println("manifest: failed to record download error:", err)
+			}
+			if link.Attempts+1 >= maxManifestDownloadAttempts {
+				log.Printf("⚠️ manifest: giving up on %s for client_id=%d after %d attempts", link.ManifestURL, link.ClientID, link.Attempts+1)
+			}
+			continue
+		}
+		if err := s.store.MarkManifestLinkSaved(link.ID, manifestID); err != nil {
+			log.Println("manifest: failed to record download:", err)
+		}
+		manifestIDs = append(manifestIDs, manifestID)
+	}
+	return manifestIDs
+}
+
+// TransmitAll downloads the manifests still pending, then runs
+// TransmitClient for every client with pending groups.
+func (s *ManifestService) TransmitAll(ctx context.Context) {
+	s.DownloadPendingManifests(ctx, 0)
+	clientIDs, err := s.store.LoadClientsWithPendingManifests()
+	if err != nil {
+		log.Println("manifest: failed to load pending clients:", err)
+		return
+	}
+	for _, clientID := range clientIDs {
+		if _, err := s.TransmitClient(ctx, clientID); err != nil {
+			log.Printf("manifest: transmit failed client_id=%d err=%v", clientID, err)
+		}
+	}
+}
+
+func (s *ManifestService) saveManifest(ctx context.Context, clientID int64, manifestURL string) (string, error) {
+	manifestID := lastPathSegment(manifestURL)
+	if manifestID == "" {
+		return "", fmt.Errorf("invalid manifest link")
+	}
+	manifest, err := s.cpClient.GetManifest(ctx, manifestURL)
+	if err != nil {
+		return "", err
+	}
+
+	for _, link := range manifest.Links.Link {
+		switch link.Rel {
+		case "artifact":
+			data, err := s.cpClient.GetArtifact(ctx, link.Href, link.MediaType)
+			if err != nil {
+				return "", err
+			}
+			if err := writeManifestPDF(s.storagePath, manifestID, data); err != nil {
+				return "", err
+			}
+		case "manifestShipments":
+			shipmentIDs, err := s.cpClient.GetManifestShipments(ctx, link.Href)
+			if err != nil {
+				return "", err
+			}
+			if err := s.store.SaveLabelManifest(clientID, shipmentIDs, manifestID); err != nil {
+				return "", err
+			}
+		}
+	}
+	log.Printf("manifest: saved manifest_id=%s po_number=%s", manifestID, manifest.PONumber)
+	return manifestID, nil
+}
+
+func buildTransmitSetRequest(groups []string, settings database.ShippingSettings) *TransmitSetRequest {
+	address := settings.ManifestAddress
+	req := &TransmitSetRequest{
+		GroupIDs:               groups,
+		RequestedShippingPoint: normalizeCanadianPostalCode(address.PostalCode),
+		DetailedManifests:      true,
+		MethodOfPayment:        normalizePaymentMethod(settings.PaymentMethod),
+	}
+	req.ManifestAddress.ManifestCompany = defaultValue(address.Company, address.Name)
+	req.ManifestAddress.ManifestName = strings.TrimSpace(address.Name)
+	req.ManifestAddress.PhoneNumber = defaultValue(address.Phone, "0000000000")
+	req.ManifestAddress.AddressDetails.AddressLine1 = address.AddressLine1
+	req.ManifestAddress.AddressDetails.AddressLine2 = address.AddressLine2
+	req.ManifestAddress.AddressDetails.City = address.City
+	req.ManifestAddress.AddressDetails.ProvState = address.Province
+	req.ManifestAddress.AddressDetails.PostalCode = normalizeCanadianPostalCode(address.PostalCode)
+	return req
+}
+
+func manifestAddressFromSender(spec ShipmentDeliverySpec) database.ManifestAddress {
+	return database.ManifestAddress{
+		Company:      spec.Sender.Company,
+		Name:         spec.Sender.Name,
+		Phone:        spec.Sender.ContactPhone,
+		AddressLine1: spec.Sender.AddressDetails.AddressLine1,
+		AddressLine2: spec.Sender.AddressDetails.AddressLine2,
+		City:         spec.Sender.AddressDetails.City,
+		Province:     spec.Sender.AddressDetails.ProvState,
+		PostalCode:   spec.Sender.AddressDetails.PostalCode,
+	}
+}
+
+func ManifestFilePath(storagePath, manifestID string) string {
+	storagePath = strings.TrimSpace(storagePath)
+	if storagePath == "" {
+		storagePath = "files/labels"
+	}
+	return filepath.Join(storagePath, "manifests", manifestID+".pdf")
+}
+
+func writeManifestPDF(storagePath, manifestID string, data []byte) error {
+	if strings.Contains(manifestID, "/") || strings.Contains(manifestID, "\\") || strings.Contains(manifestID, "..") {
+		return fmt.Errorf("invalid manifest id")
+	}
+	filePath := ManifestFilePath(storagePath, manifestID)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0o644)
+}
+
+// ManifestScheduler transmits pending contract shipments once a day at the
+// configured local time (HH:MM), and retries pending manifest downloads in
+// between.
+type ManifestScheduler struct {
+	manifests *ManifestService
+	at        string
+}
+
+func NewManifestScheduler(store *database.Store, cfg config.Config) *ManifestScheduler {
+	return &ManifestScheduler{
+		manifests: NewManifestService(store, cfg),
+		at:        strings.TrimSpace(cfg.Manifest.TransmitTime),
+	}
+}
+
+func (m *ManifestScheduler) Start(ctx context.Context) {
+	if m == nil || m.at == "" {
+		log.Println("manifest scheduler disabled")
+		return
+	}
+	if _, err := time.Parse("15:04", m.at); err != nil {
+		log.Printf("manifest scheduler disabled: invalid transmit time %q", m.at)
+		return
+	}
+	log.Printf("manifest scheduler started: daily at %s", m.at)
+	retry := time.NewTicker(manifestRetryInterval)
+	defer retry.Stop()
+	for {
+		wait := time.Until(nextDailyRun(time.Now(), m.at))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-retry.C:
+			timer.Stop()
+			m.manifests.DownloadPendingManifests(ctx, 0)
+			continue
+		case <-timer.C:
+		}
+		m.manifests.TransmitAll(ctx)
+	}
+}
+
+func nextDailyRun(now time.Time, at string) time.Time {
+	clock, err := time.Parse("15:04", at)
+	if err != nil {
+		return now.Add(24 * time.Hour)
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"lexmodo-plugin/database"
+)
+
+func TestTransmitShipmentsReturnsManifestLinks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rs/0001234567/0001234567/manifest" {
+			t.Fatalf("unexpected path %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), "<group-id>20260203</group-id>") {
+			t.Fatalf("expected group id in body, got %s", body)
+		}
+		_, _ = w.Write([]byte(`<manifests xmlns="http://www.canadapost.ca/ws/manifest-v8">
+  <link rel="manifest" href="https://ct.soa-gw.canadapost.ca/rs/0001234567/0001234567/manifest/76108444" media-type="application/vnd.cpc.manifest-v8+xml"/>
+</manifests>`))
+	}))
+	defer server.Close()
+
+	client := NewCanadaPostClient("user", "pass", "0001234567", server.URL)
+	links, err := client.TransmitShipments(context.Background(), "", &TransmitSetRequest{GroupIDs: []string{"20260203"}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(links) != 1 || lastPathSegment(links[0].Href) != "76108444" {
+		t.Fatalf("unexpected manifest links: %+v", links)
+	}
+}
+
+func TestNextDailyRun(t *testing.T) {
+	now := time.Date(2026, 2, 3, 17, 30, 0, 0, time.UTC)
+	if got := nextDailyRun(now, "18:00"); !got.Equal(time.Date(2026, 2, 3, 18, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected same-day run, got %s", got)
+	}
+	if got := nextDailyRun(now, "09:15"); !got.Equal(time.Date(2026, 2, 4, 9, 15, 0, 0, time.UTC)) {
+		t.Fatalf("expected next-day run, got %s", got)
+	}
+}
+
+func TestDownloadPendingManifestsRecordsFailedAttempt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`<messages xmlns="http://www.canadapost.ca/ws/messages"><message><code>9999</code><description>Manifest not found</description></message></messages>`))
+	}))
+	defer server.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	manifests := &ManifestService{
+		cpClient:    NewCanadaPostClient("user", "pass", "0001234567", server.URL),
+		store:       &database.Store{DB: db},
+		storagePath: t.TempDir(),
+	}
+
+	// Only links tried fewer than maxManifestDownloadAttempts times are
+	// loaded; the last allowed attempt still records its error.
+	manifestURL := server.URL + "/rs/0001234567/0001234567/manifest/76108444"
+	mock.ExpectQuery("FROM manifest_links").WithArgs(int64(0), int64(0), maxManifestDownloadAttempts).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "client_id", "manifest_url", "attempts", "last_error"}).
+			AddRow(int64(3), int64(7), manifestURL, maxManifestDownloadAttempts-1, "not ready"),
+	)
+	mock.ExpectExec("SET attempts = attempts \\+ 1, last_error = \\?, last_attempt_at").WithArgs(sqlmock.AnyArg(), int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if saved := manifests.DownloadPendingManifests(context.Background(), 0); len(saved) != 0 {
+		t.Fatalf("expected no manifests saved, got %v", saved)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import "encoding/xml"
+
+// Transmit Shipments – REST
+type TransmitSetRequest struct {
+	XMLName                xml.Name `xml:"transmit-set"`
+	XMLNS                  string   `xml:"xmlns,attr"`
+	GroupIDs               []string `xml:"group-ids>group-id"`
+	RequestedShippingPoint string   `xml:"requested-shipping-point,omitempty"`
+	CPCPickupIndicator     bool     `xml:"cpc-pickup-indicator,omitempty"`
+	DetailedManifests      bool     `xml:"detailed-manifests"`
+	MethodOfPayment        string   `xml:"method-of-payment"`
+	ManifestAddress        struct {
+		ManifestCompany string `xml:"manifest-company"`
+		ManifestName    string `xml:"manifest-name,omitempty"`
+		PhoneNumber     string `xml:"phone-number"`
+		AddressDetails  struct {
+			AddressLine1 string `xml:"address-line-1"`
+			AddressLine2 string `xml:"address-line-2,omitempty"`
+			City         string `xml:"city"`
+			ProvState    string `xml:"prov-state"`
+			PostalCode   string `xml:"postal-zip-code"`
+		} `xml:"address-details"`
+	} `xml:"manifest-address"`
+}
+
+type ManifestLinksResponse struct {
+	XMLName xml.Name `xml:"manifests"`
+	Links   []Link   `xml:"link"`
+}
+
+// Get Manifest – REST
+type ManifestResponse struct {
+	XMLName  xml.Name `xml:"manifest"`
+	PONumber string   `xml:"po-number"`
+	Links    struct {
+		Link []Link `xml:"link"`
+	} `xml:"links"`
+}
+
+type ManifestShipmentsResponse struct {
+	XMLName xml.Name `xml:"shipments"`
+	Links   []Link   `xml:"link"`
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"lexmodo-plugin/config"
+)
+
+type weightUnit string
+
+type lengthUnit string
+
+const (
+	unitOunces    weightUnit = "oz"
+	unitPounds    weightUnit = "lb"
+	unitGrams     weightUnit = "g"
+	unitKilograms weightUnit = "kg"
+
+	unitInches      lengthUnit = "in"
+	unitCentimetres lengthUnit = "cm"
+)
+
+var kilogramsPer = map[weightUnit]float64{
+	unitOunces:    0.028349523125,
+	unitPounds:    0.45359237,
+	unitGrams:     0.001,
+	unitKilograms: 1,
+}
+
+var centimetresPer = map[lengthUnit]float64{
+	unitInches:      2.54,
+	unitCentimetres: 1,
+}
+
+// measuredWeight is a weight in the unit it was given in.
+type measuredWeight struct {
+	Value float64
+	Unit  weightUnit
+}
+
+func (w measuredWeight) kilograms() float64 {
+	if w.Value <= 0 {
+		return 0
+	}
+	return w.Value * kilogramsPer[w.Unit]
+}
+
+// measuredLength is a length in the unit it was given in.
+type measuredLength struct {
+	Value float64
+	Unit  lengthUnit
+}
+
+func (l measuredLength) centimetres() float64 {
+	if l.Value <= 0 {
+		return 0
+	}
+	return l.Value * centimetresPer[l.Unit]
+}
+
+func parseWeightUnit(value string) (weightUnit, error) {
+	unit := weightUnit(strings.ToLower(strings.TrimSpace(value)))
+	switch unit {
+	case "ounce", "ounces":
+		unit = unitOunces
+	case "lbs", "pound", "pounds":
+		unit = unitPounds
+	case "gram", "grams":
+		unit = unitGrams
+	case "kgs", "kilogram", "kilograms":
+		unit = unitKilograms
+	}
+	if _, ok := kilogramsPer[unit]; !ok {
+		return "", fmt.Errorf("unknown weight unit %q: use oz, lb, g or kg", value)
+	}
+	return unit, nil
+}
+
+func parseLengthUnit(value string) (lengthUnit, error) {
+	unit := lengthUnit(strings.ToLower(strings.TrimSpace(value)))
+	switch unit {
+	case "inch", "inches":
+		unit = unitInches
+	case "centimetre", "centimetres", "centimeter", "centimeters":
+		unit = unitCentimetres
+	}
+	if _, ok := centimetresPer[unit]; !ok {
+		return "", fmt.Errorf("unknown dimension unit %q: use in or cm", value)
+	}
+	return unit, nil
+}
+
+// requestUnits are the units of weights and dimensions in plugin requests.
+type requestUnits struct {
+	Weight weightUnit
+	Length lengthUnit
+}
+
+// defaultRequestUnits are the platform's units: ounces, and centimetres,
+// which dimensions were always sent to Canada Post as.
+var defaultRequestUnits = requestUnits{Weight: unitOunces, Length: unitCentimetres}
+
+// CheckMeasurementUnits reports whether the configured request units are
+// known, so a typo fails at startup rather than on every rate.
+func CheckMeasurementUnits(cfg config.CanadaPostConfig) error {
+	_, err := unitsFromConfig(cfg)
+	return err
+}
+
+func unitsFromConfig(cfg config.CanadaPostConfig) (requestUnits, error) {
+	units := defaultRequestUnits
+	if strings.TrimSpace(cfg.WeightUnit) != "" {
+		unit, err := parseWeightUnit(cfg.WeightUnit)
+		if err != nil {
+			return requestUnits{}, fmt.Errorf("canadapost.weight_unit: %w", err)
+		}
+		units.Weight = unit
+	}
+	if strings.TrimSpace(cfg.DimensionUnit) != "" {
+		unit, err := parseLengthUnit(cfg.DimensionUnit)
+		if err != nil {
+			return requestUnits{}, fmt.Errorf("canadapost.dimension_unit: %w", err)
+		}
+		units.Length = unit
+	}
+	return units, nil
+}
+
+// requestUnits returns the configured units, or the platform's defaults if
+// they are invalid.
+func (s *Server) requestUnits() requestUnits {
+	units, err := unitsFromConfig(s.Config.CanadaPost)
+	if err != nil {
+		return defaultRequestUnits
+	}
+	return units
+}
+
+// parseWeight reads a weight such as "320", "320oz" or "1.5 kg"; a bare
+// number is in unit.
+func parseWeight(value string, unit weightUnit) (measuredWeight, error) {
+	number, suffix := splitMeasurement(value)
+	parsed, err := strconv.ParseFloat(number, 64)
+	if err != nil || parsed <= 0 {
+		return measuredWeight{}, fmt.Errorf("invalid weight %q", value)
+	}
+	if suffix != "" {
+		if unit, err = parseWeightUnit(suffix); err != nil {
+			return measuredWeight{}, err
+		}
+	}
+	return measuredWeight{Value: parsed, Unit: unit}, nil
+}
+
+// parseDimensions reads LxWxH such as "40x30x20" or "16x12x8in"; bare
+// numbers are in unit. It returns the dimensions in cm.
+func parseDimensions(value string, unit lengthUnit) (float64, float64, float64, error) {
+	numbers, suffix := splitMeasurement(value)
+	if suffix != "" {
+		var err error
+		if unit, err = parseLengthUnit(suffix); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	parts := strings.Split(strings.ToLower(numbers), "x")
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf("invalid dimensions %q: use LxWxH", value)
+	}
+	dims := [3]float64{}
+	for i, part := range parts {
+		parsed, err := strconv.ParseFloat(part, 64)
+		if err != nil || parsed <= 0 {
+			return 0, 0, 0, fmt.Errorf("invalid dimensions %q", value)
+		}
+		dims[i] = measuredLength{Value: parsed, Unit: unit}.centimetres()
+	}
+	return dims[0], dims[1], dims[2], nil
+}
+
+// splitMeasurement splits a trailing unit such as "kg" or "in" from value.
+func splitMeasurement(value string) (string, string) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	end := len(value)
+	for end > 0 && value[end-1] >= 'a' && value[end-1] <= 'z' {
+		end--
+	}
+	return strings.TrimSpace(value[:end]), strings.TrimSpace(value[end:])
+}
+
+// kilograms is a weight in kg as Canada Post takes it: at most 3 decimals.
+// A positive weight is never rounded down to 0.
+type kilograms float64
+
+func (k kilograms) MarshalText() ([]byte, error) {
+	return []byte(formatCanadaPostDecimal(float64(k), 3)), nil
+}
+
+func (k *kilograms) UnmarshalText(text []byte) error {
+	parsed, err := strconv.ParseFloat(strings.TrimSpace(string(text)), 64)
+	*k = kilograms(parsed)
+	return err
+}
+
+// centimetres is a dimension in cm as Canada Post takes it: at most 1
+// decimal. A positive dimension is never rounded down to 0.
+type centimetres float64
+
+func (c centimetres) MarshalText() ([]byte, error) {
+	return []byte(formatCanadaPostDecimal(float64(c), 1)), nil
+}
+
+func (c *centimetres) UnmarshalText(text []byte) error {
+	parsed, err := strconv.ParseFloat(strings.TrimSpace(string(text)), 64)
+	*c = centimetres(parsed)
+	return err
+}
+
+func formatCanadaPostDecimal(value float64, decimals int) string {
+	scale := math.Pow(10, float64(decimals))
+	rounded := math.Round(value*scale) / scale
+	if value > 0 && rounded <= 0 {
+		rounded = 1 / scale
+	}
+	return strconv.FormatFloat(rounded, 'f', -1, 64)
+}
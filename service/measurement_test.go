@@ -0,0 +1,79 @@
+package service
+
+import (
+	"encoding/xml"
+	"math"
+	"strings"
+	"testing"
+
+	labels "bitbucket.org/lexmodo/proto/labels"
+	"lexmodo-plugin/config"
+)
+
+func TestBuildParcelConvertsRequestUnits(t *testing.T) {
+	parcel := &labels.Parcel{
+		Weight:           0.1,
+		ParcelDimensions: &labels.ParcelDimensions{Length: 10, Width: 8, Height: 4},
+	}
+	metrics, err := buildParcelsFromLabelRequest(parcel, requestUnits{Weight: unitOunces, Length: unitInches})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := &RateRequest{}
+	req.ParcelCharacteristics.Weight = kilograms(metrics.Weight)
+	req.ParcelCharacteristics.Dimensions = parcelDimensions(metrics)
+	body, err := xml.Marshal(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 0.1 oz is 0.0028 kg, which used to round to 0; 10x8x4 in is 25.4x20.3x10.2 cm.
+	want := "<parcel-characteristics><weight>0.003</weight><dimensions><length>25.4</length><width>20.3</width><height>10.2</height></dimensions></parcel-characteristics>"
+	if !strings.Contains(string(body), want) {
+		t.Fatalf("expected %s in\n%s", want, body)
+	}
+
+	// Dimensions are in cm unless configured otherwise, as they always were.
+	metrics, _ = buildParcelsFromLabelRequest(parcel, defaultRequestUnits)
+	if metrics.Length != 10 || metrics.Width != 8 || metrics.Height != 4 {
+		t.Fatalf("expected default dimensions in cm, got %+v", metrics)
+	}
+
+	parcel.Weight = 500
+	metrics, _ = buildParcelsFromLabelRequest(parcel, requestUnits{Weight: unitGrams, Length: unitCentimetres})
+	if math.Abs(metrics.Weight-0.5) > 1e-9 || metrics.Length != 10 {
+		t.Fatalf("expected grams and cm to convert as is, got %+v", metrics)
+	}
+}
+
+func TestCanadaPostPrecision(t *testing.T) {
+	for _, tc := range []struct {
+		value    float64
+		decimals int
+		want     string
+	}{
+		{0.0004, 3, "0.001"},
+		{1.99999989, 3, "2"},
+		{12.3456, 3, "12.346"},
+		{0.02, 1, "0.1"},
+		{25.44, 1, "25.4"},
+		{0, 3, "0"},
+	} {
+		if got := formatCanadaPostDecimal(tc.value, tc.decimals); got != tc.want {
+			t.Fatalf("formatCanadaPostDecimal(%v, %d) = %s, want %s", tc.value, tc.decimals, got, tc.want)
+		}
+	}
+}
+
+func TestMeasurementUnitsFromConfig(t *testing.T) {
+	units, err := unitsFromConfig(config.CanadaPostConfig{WeightUnit: "LBS", DimensionUnit: "cm"})
+	if err != nil || units != (requestUnits{Weight: unitPounds, Length: unitCentimetres}) {
+		t.Fatalf("unexpected units %+v %v", units, err)
+	}
+	if units, _ := unitsFromConfig(config.CanadaPostConfig{}); units != defaultRequestUnits {
+		t.Fatalf("expected oz and in by default, got %+v", units)
+	}
+	if err := CheckMeasurementUnits(config.CanadaPostConfig{DimensionUnit: "mm"}); err == nil {
+		t.Fatal("expected an error for an unknown unit")
+	}
+}
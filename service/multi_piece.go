@@ -0,0 +1,402 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	labels "bitbucket.org/lexmodo/proto/labels"
+	"lexmodo-plugin/database"
+)
+
+const (
+	// fieldParcelPieces lists the pieces of a shipment sent as several
+	// parcels, e.g. "320 40x30x20; 2kg 60x40x40cm": one entry per piece with
+	// the weight and optional LxWxH, in the request units unless suffixed.
+	fieldParcelPieces = "parcel_pieces"
+	maxParcelPieces   = 10
+)
+
+// parcelPiecesFromRequest returns the pieces a shipment is sent in: the
+// parcel_pieces value if set, else the parcel_items packed into the client's
+// packaging boxes, else the request's parcel as the only piece.
+func (s *Server) parcelPiecesFromRequest(clientID int64, parcel *labels.Parcel, values map[string]string) ([]parcelMetrics, error) {
+	units := s.requestUnits()
+	if strings.TrimSpace(values[fieldParcelPieces]) != "" {
+		return parseParcelPieces(values[fieldParcelPieces], units)
+	}
+	if strings.TrimSpace(values[fieldParcelItems]) != "" {
+		boxes := []database.PackagingBox{}
+		if clientID > 0 && s.Store != nil {
+			loaded, err := s.Store.LoadPackagingBoxes(clientID)
+			if err != nil {
+				log.Println("failed to load packaging boxes:", err)
+			} else {
+				boxes = loaded
+			}
+		}
+		if len(boxes) > 0 {
+			items, err := parseParcelItems(values[fieldParcelItems], units)
+			if err != nil {
+				return nil, err
+			}
+			packed, err := packItems(items, boxes)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("packed %d items into %d boxes: client_id=%d", len(items), len(packed), clientID)
+			return packed, nil
+		}
+		log.Printf("no packaging boxes for client_id=%d; rating the request parcel", clientID)
+	}
+	single, err := buildParcelsFromLabelRequest(parcel, units)
+	if err != nil {
+		return nil, err
+	}
+	return []parcelMetrics{single}, nil
+}
+
+func parseParcelPieces(value string, units requestUnits) ([]parcelMetrics, error) {
+	entries := strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '\n' })
+	pieces := make([]parcelMetrics, 0, len(entries))
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("invalid parcel piece %q: use weight and LxWxH", strings.TrimSpace(entry))
+		}
+		weight, err := parseWeight(fields[0], units.Weight)
+		if err != nil {
+			return nil, fmt.Errorf("parcel piece: %w", err)
+		}
+		piece := parcelMetrics{Weight: weight.kilograms()}
+		if len(fields) == 2 {
+			if piece.Length, piece.Width, piece.Height, err = parseDimensions(fields[1], units.Length); err != nil {
+				return nil, fmt.Errorf("parcel piece: %w", err)
+			}
+		}
+		pieces = append(pieces, piece)
+	}
+	if len(pieces) == 0 {
+		return nil, errors.New("parcel_pieces has no pieces")
+	}
+	if len(pieces) > maxParcelPieces {
+		return nil, fmt.Errorf("a shipment can have at most %d pieces", maxParcelPieces)
+	}
+	return pieces, nil
+}
+
+// combinedParcel is the parcel a multi-piece shipment is stored and
+// validated as: the total weight, and the dimensions only when there is a
+// single piece.
+func combinedParcel(pieces []parcelMetrics) parcelMetrics {
+	if len(pieces) == 1 {
+		return pieces[0]
+	}
+	total := parcelMetrics{}
+	for _, piece := range pieces {
+		total.Weight += piece.Weight
+	}
+	return total
+}
+
+// withoutAmountOptions drops COD and coverage. On a multi-piece shipment
+// they are only added to the first piece so the amount isn't collected or
+// insured once per box.
+func withoutAmountOptions[T RateOption | ShipmentOption](options []T) []T {
+	kept := make([]T, 0, len(options))
+	for _, option := range options {
+		var code string
+		switch opt := any(option).(type) {
+		case RateOption:
+			code = opt.Code
+		case ShipmentOption:
+			code = opt.Code
+		}
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "COD" || code == "COV" {
+			continue
+		}
+		kept = append(kept, option)
+	}
+	return kept
+}
+
+// getPieceRates rates every piece of a shipment concurrently. With one piece
+// it is a plain GetRates call. With several, the result only has the
+// services available for every piece, priced as the sum of the pieces, and
+// piecePrices holds each piece's price per service in cents.
+func (s *Server) getPieceRates(ctx context.Context, payload *RateRequest, pieces []parcelMetrics) (*RateResponse, map[string][]int64, error) {
+	if len(pieces) <= 1 {
+		rates, err := s.CanadaPost.GetRates(ctx, payload)
+		return rates, nil, err
+	}
+
+	responses := make([]*RateResponse, len(pieces))
+	errs := make([]error, len(pieces))
+	var wg sync.WaitGroup
+	for i, piece := range pieces {
+		piecePayload := *payload
+		piecePayload.ParcelCharacteristics.Weight = kilograms(piece.Weight)
+		piecePayload.ParcelCharacteristics.Dimensions = parcelDimensions(piece)
+		if payload.Options != nil && i > 0 {
+			piecePayload.Options = &RateOptions{Option: withoutAmountOptions(payload.Options.Option)}
+			if len(piecePayload.Options.Option) == 0 {
+				piecePayload.Options = nil
+			}
+		}
+		wg.Add(1)
+		go func(i int, req *RateRequest) {
+			defer wg.Done()
+			responses[i], errs[i] = s.CanadaPost.GetRates(ctx, req)
+		}(i, &piecePayload)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, nil, fmt.Errorf("piece %d: %w", i+1, err)
+		}
+	}
+	merged, piecePrices := mergePieceRates(responses)
+	return merged, piecePrices, nil
+}
+
+// mergePieceRates sums the quotes of each service across pieces. Services
+// missing for any piece are dropped. The delivery estimate is the slowest
+// piece's, and the delivery is only guaranteed if it is for every piece.
+func mergePieceRates(responses []*RateResponse) (*RateResponse, map[string][]int64) {
+	merged := &RateResponse{}
+	piecePrices := map[string][]int64{}
+	if len(responses) == 0 {
+		return merged, piecePrices
+	}
+	byPiece := make([]map[string]PriceQuote, len(responses))
+	for i, response := range responses {
+		byPiece[i] = map[string]PriceQuote{}
+		if response == nil {
+			continue
+		}
+		for _, quote := range response.PriceQuotes {
+			code := strings.TrimSpace(quote.ServiceCode)
+			if best, ok := byPiece[i][code]; !ok || quote.PriceDetails.Due < best.PriceDetails.Due {
+				byPiece[i][code] = quote
+			}
+		}
+	}
+	if responses[0] == nil {
+		return merged, piecePrices
+	}
+
+	for _, first := range responses[0].PriceQuotes {
+		code := strings.TrimSpace(first.ServiceCode)
+		if _, done := piecePrices[code]; done || code == "" {
+			continue
+		}
+		total := byPiece[0][code]
+		prices := []int64{int64(math.Round(total.PriceDetails.Due * 100))}
+		available := true
+		for _, quotes := range byPiece[1:] {
+			quote, ok := quotes[code]
+			if !ok {
+				available = false
+				break
+			}
+			prices = append(prices, int64(math.Round(quote.PriceDetails.Due*100)))
+			total.PriceDetails.Base += quote.PriceDetails.Base
+			total.PriceDetails.Due += quote.PriceDetails.Due
+			total.PriceDetails.Taxes.GST.Value += quote.PriceDetails.Taxes.GST.Value
+			total.PriceDetails.Taxes.PST.Value += quote.PriceDetails.Taxes.PST.Value
+			total.PriceDetails.Taxes.HST.Value += quote.PriceDetails.Taxes.HST.Value
+			standard := quote.ServiceStandard
+			if standard.ExpectedTransitTime > total.ServiceStandard.ExpectedTransitTime {
+				total.ServiceStandard.ExpectedTransitTime = standard.ExpectedTransitTime
+			}
+			if standard.ExpectedDeliveryDate > total.ServiceStandard.ExpectedDeliveryDate {
+				total.ServiceStandard.ExpectedDeliveryDate = standard.ExpectedDeliveryDate
+			}
+			total.ServiceStandard.GuaranteedDelivery = total.ServiceStandard.GuaranteedDelivery && standard.GuaranteedDelivery
+			total.ServiceStandard.AMDelivery = total.ServiceStandard.AMDelivery && standard.AMDelivery
+		}
+		if !available {
+			continue
+		}
+		// Rounding each piece keeps the checkout price equal to the sum of
+		// what each piece's shipment is billed.
+		var cents int64
+		for _, price := range prices {
+			cents += price
+		}
+		total.PriceDetails.Due = float64(cents) / 100
+		piecePrices[code] = prices
+		merged.PriceQuotes = append(merged.PriceQuotes, total)
+	}
+	return merged, piecePrices
+}
+
+// createPieceShipments creates one Canada Post shipment per piece of the
+// snapshot, in order. If a piece fails the shipments already created are
+// rolled back and the error is returned.
+func (s *Server) createPieceShipments(ctx context.Context, snapshot RateSnapshot, options []ShipmentOption, notification *ShipmentNotification) ([]*ShipmentResponse, error) {
+	if len(snapshot.Pieces) <= 1 {
+		shipment, err := s.createShipmentFromSnapshot(ctx, snapshot, options, notification)
+		if err != nil {
+			return nil, err
+		}
+		return []*ShipmentResponse{shipment}, nil
+	}
+
+	shipments := make([]*ShipmentResponse, 0, len(snapshot.Pieces))
+	for i, piece := range snapshot.Pieces {
+		pieceSnapshot := snapshot
+		pieceSnapshot.Parcel = piece
+		pieceSnapshot.Pieces = nil
+		pieceOptions := options
+		if i > 0 {
+			pieceOptions = withoutAmountOptions(options)
+		}
+		shipment, err := s.createShipmentFromSnapshot(ctx, pieceSnapshot, pieceOptions, notification)
+		if err != nil {
+			err = fmt.Errorf("piece %d of %d: %w", i+1, len(snapshot.Pieces), err)
+			if remaining := s.rollbackPieceShipments(ctx, snapshot, shipments); len(remaining) > 0 {
+				return nil, &pieceRollbackError{err: err, Remaining: remaining}
+			}
+			return nil, err
+		}
+		shipments = append(shipments, shipment)
+	}
+	return shipments, nil
+}
+
+// pieceRollbackError is the failure of a piece after which some of the
+// shipments of the earlier pieces could not be cancelled.
+type pieceRollbackError struct {
+	err       error
+	Remaining []string // tracking PINs
+}
+
+func (e *pieceRollbackError) Error() string {
+	return fmt.Sprintf("%v; shipments %s could not be cancelled", e.err, strings.Join(e.Remaining, ","))
+}
+
+func (e *pieceRollbackError) Unwrap() error {
+	return e.err
+}
+
+// rollbackPieceShipments cancels the shipments created for a label that
+// failed: contract shipments are voided and the others refunded, with the
+// confirmation sent to the shipper. It returns the tracking PINs of the
+// shipments it could not cancel, which are logged to be refunded by hand.
+func (s *Server) rollbackPieceShipments(ctx context.Context, snapshot RateSnapshot, shipments []*ShipmentResponse) []string {
+	email := strings.TrimSpace(snapshot.Shipper.Email)
+	remaining := []string{}
+	for _, shipment := range shipments {
+		refundURL, shipmentURL := shipmentRefundLinks(shipment)
+		var err error
+		switch {
+		case shipment.GroupID != "" && shipmentURL != "":
+			err = s.CanadaPost.VoidShipment(ctx, shipmentURL)
+		case shipment.GroupID == "" && refundURL != "" && email != "":
+			_, err = s.CanadaPost.RefundShipment(ctx, refundURL, email)
+		default:
+			err = errors.New("no void or refund link, or no shipper email")
+		}
+		if err != nil {
+			log.Printf("❌ multi-piece shipment incomplete; refund shipment_id=%s tracking=%s err=%v", shipment.ShipmentID, shipment.TrackingPIN, err)
+			remaining = append(remaining, shipment.TrackingPIN)
+			continue
+		}
+		log.Printf("multi-piece shipment incomplete; cancelled shipment_id=%s tracking=%s", shipment.ShipmentID, shipment.TrackingPIN)
+	}
+	return remaining
+}
+
+// piecePrice is what Canada Post quoted for piece index of the snapshot.
+func piecePrice(snapshot RateSnapshot, index int) int64 {
+	if len(snapshot.Pieces) <= 1 || index >= len(snapshot.PiecePrices) {
+		return snapshotCarrierCents(snapshot)
+	}
+	return snapshot.PiecePrices[index]
+}
+
+// pieceLabelID is the label ID of the piece at index; the first piece uses
+// the shipment's label ID.
+func pieceLabelID(labelID string, index int) string {
+	if index == 0 {
+		return labelID
+	}
+	return fmt.Sprintf("%s-%d", labelID, index+1)
+}
+
+// savePieceLabels stores the labels of the pieces after the first and
+// returns their records, then replaces the first piece's label file with one
+// that has every piece's label. When the labels could not be merged it also
+// returns the URL of each piece's own label. It stops at the first piece
+// whose label can't be saved, leaving the caller to cancel the shipments.
+func (s *Server) savePieceLabels(ctx context.Context, first database.LabelRecord, snapshot RateSnapshot, shipments []*ShipmentResponse) ([]database.LabelRecord, []string, error) {
+	extension := strings.ToLower(first.LabelEncoding)
+	pieceLabels := []string{s.buildLabelURL(first.ID, extension)}
+	labelFiles := make([][]byte, 0, len(shipments)+1)
+	if data, err := os.ReadFile(filepath.Join(s.labelStoragePath(), first.ID+"."+extension)); err == nil {
+		labelFiles = append(labelFiles, data)
+	}
+
+	records := make([]database.LabelRecord, 0, len(shipments))
+	for i, shipment := range shipments {
+		index := i + 1
+		record := first
+		record.ID = pieceLabelID(first.ID, index)
+		record.PieceOf = first.ID
+		record.ShipmentID = shipment.ShipmentID
+		record.TrackingNumber = shipment.TrackingPIN
+		record.GroupID = shipment.GroupID
+		record.RefundLink, record.ShipmentLink = shipmentRefundLinks(shipment)
+		record.ChargesLink = shipmentChargesLink(shipment)
+		if index < len(snapshot.Pieces) {
+			record.Weight = snapshot.Pieces[index].Weight
+		}
+		record.ShippingChargesCents = piecePrice(snapshot, index)
+		record.CustomerPriceCents = pieceCustomerPrice(snapshot, index)
+		record.Billed = database.LabelCharges{}
+
+		artifacts, err := s.saveLabelArtifacts(ctx, record.ID, labelArtifactLinks(shipment.Links.Link))
+		if err != nil {
+			log.Printf("failed to save piece label: label_id=%s err=%v", record.ID, err)
+			return nil, nil, fmt.Errorf("label of piece %d of %d not saved: %w", index+1, len(shipments)+1, err)
+		}
+		record.Artifacts = artifactNames(artifacts)
+		record.LabelEncoding = labelEncoding(artifacts)
+		pieceExtension := strings.ToLower(record.LabelEncoding)
+		pieceLabels = append(pieceLabels, s.buildLabelURL(record.ID, pieceExtension))
+		if data, err := os.ReadFile(filepath.Join(s.labelStoragePath(), record.ID+"."+pieceExtension)); err == nil && record.LabelEncoding == first.LabelEncoding {
+			labelFiles = append(labelFiles, data)
+		}
+		if charges, err := s.fetchShipmentCharges(ctx, shipment); err != nil {
+			log.Printf("failed to fetch shipment charges: label_id=%s err=%v", record.ID, err)
+		} else {
+			record.Billed = charges
+		}
+		records = append(records, record)
+	}
+
+	if len(labelFiles) != len(shipments)+1 {
+		log.Printf("multi-piece labels not merged: label_id=%s saved=%d pieces=%d", first.ID, len(labelFiles), len(shipments)+1)
+		return records, pieceLabels, nil
+	}
+	merged, err := mergeLabels(first.LabelEncoding, labelFiles)
+	if err == nil {
+		err = s.saveLabelFile(first.ID, extension, merged)
+	}
+	if err != nil {
+		log.Printf("multi-piece labels not merged: label_id=%s err=%v", first.ID, err)
+		return records, pieceLabels, nil
+	}
+	return records, nil, nil
+}
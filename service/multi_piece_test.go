@@ -0,0 +1,83 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseParcelPieces(t *testing.T) {
+	pieces, err := parseParcelPieces("2kg 20x15x10cm;\n1000g 1x2x4", requestUnits{Weight: unitOunces, Length: unitInches})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []parcelMetrics{{Weight: 2, Length: 20, Width: 15, Height: 10}, {Weight: 1, Length: 2.54, Width: 5.08, Height: 10.16}}
+	if len(pieces) != len(want) || pieces[0] != want[0] || pieces[1] != want[1] {
+		t.Fatalf("expected %+v, got %+v", want, pieces)
+	}
+
+	for _, value := range []string{"", "heavy", "32 20x15", "32 20x15x10 extra", "-4", "3st"} {
+		if _, err := parseParcelPieces(value, defaultRequestUnits); err == nil {
+			t.Fatalf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestMergePieceRatesSumsCommonServices(t *testing.T) {
+	quote := func(code string, due float64, days int, guaranteed bool) PriceQuote {
+		q := PriceQuote{ServiceCode: code}
+		q.PriceDetails.Due = due
+		q.ServiceStandard.ExpectedTransitTime = days
+		q.ServiceStandard.GuaranteedDelivery = guaranteed
+		return q
+	}
+	merged, prices := mergePieceRates([]*RateResponse{
+		{PriceQuotes: []PriceQuote{quote("DOM.EP", 17.402, 2, true), quote("DOM.XP", 25.10, 1, true)}},
+		{PriceQuotes: []PriceQuote{quote("DOM.EP", 15.029, 3, false)}},
+	})
+	if len(merged.PriceQuotes) != 1 {
+		t.Fatalf("expected only the service available for every piece, got %+v", merged.PriceQuotes)
+	}
+	got := merged.PriceQuotes[0]
+	if got.PriceDetails.Due != 32.43 || got.ServiceStandard.ExpectedTransitTime != 3 || got.ServiceStandard.GuaranteedDelivery {
+		t.Fatalf("unexpected merged quote %+v", got)
+	}
+	if p := prices["DOM.EP"]; len(p) != 2 || p[0] != 1740 || p[1] != 1503 {
+		t.Fatalf("unexpected piece prices %v", prices)
+	}
+}
+
+func TestMergeLabelsCombinesPages(t *testing.T) {
+	page := func(text string) []byte {
+		return []byte("%PDF-1.4\n" +
+			"1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n" +
+			"2 0 obj\n<< /Type /Pages /Kids [3 0 R] /Count 1 >>\nendobj\n" +
+			"3 0 obj\n<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>\nendobj\n" +
+			"4 0 obj\n<< /Length 14 >>\nstream\n(" + text + ") Tj\nendstream\nendobj\n" +
+			"trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	}
+	merged, err := mergeLabels("PDF", [][]byte{page("one"), page("two"), page("six")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	objects, _, count, err := parsePDF(merged)
+	if err != nil || count != 3 {
+		t.Fatalf("expected 3 pages, got %d: %v", count, err)
+	}
+	for _, text := range []string{"(one)", "(two)", "(six)"} {
+		if !bytes.Contains(merged, []byte(text)) {
+			t.Fatalf("merged label is missing %s", text)
+		}
+	}
+	// Three documents of 3 objects each, less their catalogs, plus the new page tree.
+	if len(objects) != 10 {
+		t.Fatalf("expected 10 objects, got %d", len(objects))
+	}
+
+	if _, err := mergeLabels("PDF", [][]byte{page("one"), []byte("not a pdf")}); err == nil {
+		t.Fatal("expected an error for an invalid label")
+	}
+	zpl, _ := mergeLabels("ZPL", [][]byte{[]byte("^XA^XZ\n"), []byte("^XA^XZ")})
+	if string(zpl) != "^XA^XZ\n^XA^XZ\n" {
+		t.Fatalf("unexpected ZPL %q", zpl)
+	}
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"lexmodo-plugin/database"
+)
+
+// seedOptionRules is used until Get Option results have been stored, and
+// never alongside them. It holds the option combinations Canada Post rejects
+// for the options this plugin sends.
+var seedOptionRules = []database.CatalogOptionRule{
+	{OptionCode: "SO", Name: "Signature", Conflicts: []string{"LAD"}},
+	{OptionCode: "PA18", Name: "Proof of Age 18+", Conflicts: []string{"PA19", "LAD"}, Prerequisites: []string{"SO"}},
+	{OptionCode: "PA19", Name: "Proof of Age 19+", Conflicts: []string{"PA18", "LAD"}, Prerequisites: []string{"SO"}},
+	{OptionCode: "COV", Name: "Coverage"},
+	{OptionCode: "COD", Name: "COD", QualifierRequired: true, QualifierMax: 1000, Conflicts: []string{"LAD", "DNS"}, Prerequisites: []string{"HFP", "D2PO"}},
+	{OptionCode: "HFP", Name: "Hold for Pickup (Pay at Post Office)", Conflicts: []string{"DNS", "LAD", "D2PO"}},
+	{OptionCode: "DNS", Name: "Do Not Safe Drop", Conflicts: []string{"HFP", "LAD", "D2PO"}},
+	{OptionCode: "LAD", Name: "Leave at Door", Conflicts: []string{"HFP", "DNS", "D2PO"}},
+	{OptionCode: "D2PO", Name: "Deliver to Post Office", QualifierRequired: true, Conflicts: []string{"HFP", "DNS", "LAD"}},
+	{OptionCode: "RASE", Name: "Return at Sender's Expense", Conflicts: []string{"RTS", "ABAN"}},
+	{OptionCode: "RTS", Name: "Return to Sender", Conflicts: []string{"RASE", "ABAN"}},
+	{OptionCode: "ABAN", Name: "Abandon Shipment", Conflicts: []string{"RASE", "RTS"}},
+}
+
+type optionRuleCache struct {
+	mu sync.RWMutex
+	// global has each option's rules merged across services, for checks made
+	// before a service is chosen.
+	global    map[string]database.CatalogOptionRule
+	byService map[string]map[string]database.CatalogOptionRule
+}
+
+var optionRules = newOptionRuleCache()
+
+func newOptionRuleCache() *optionRuleCache {
+	cache := &optionRuleCache{}
+	cache.set(nil)
+	return cache
+}
+
+// set replaces the cached rules. Without any stored rules the seed rules
+// are used.
+func (c *optionRuleCache) set(rules []database.CatalogOptionRule) {
+	global := map[string]database.CatalogOptionRule{}
+	byService := map[string]map[string]database.CatalogOptionRule{}
+	for _, rule := range rules {
+		rule.ServiceCode = strings.ToUpper(strings.TrimSpace(rule.ServiceCode))
+		rule.OptionCode = strings.ToUpper(strings.TrimSpace(rule.OptionCode))
+		if rule.ServiceCode == "" || rule.OptionCode == "" {
+			continue
+		}
+		if byService[rule.ServiceCode] == nil {
+			byService[rule.ServiceCode] = map[string]database.CatalogOptionRule{}
+		}
+		byService[rule.ServiceCode][rule.OptionCode] = rule
+		if merged, ok := global[rule.OptionCode]; ok {
+			global[rule.OptionCode] = mergeOptionRules(merged, rule)
+		} else {
+			rule.ServiceCode = ""
+			global[rule.OptionCode] = rule
+		}
+	}
+	if len(byService) == 0 {
+		for _, rule := range seedOptionRules {
+			global[rule.OptionCode] = rule
+		}
+	}
+
+	c.mu.Lock()
+	c.global = global
+	c.byService = byService
+	c.mu.Unlock()
+}
+
+// rulesFor returns the option rules for serviceCode. restricted reports
+// whether the rules come from that service, in which case options missing
+// from them are not offered by the service.
+func (c *optionRuleCache) rulesFor(serviceCode string) (rules map[string]database.CatalogOptionRule, restricted bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if rules, ok := c.byService[strings.ToUpper(strings.TrimSpace(serviceCode))]; ok && len(rules) > 0 {
+		return rules, true
+	}
+	return c.global, false
+}
+
+// serviceRule returns the stored rule of optionCode for serviceCode.
+func (c *optionRuleCache) serviceRule(serviceCode, optionCode string) (database.CatalogOptionRule, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rule, ok := c.byService[strings.ToUpper(strings.TrimSpace(serviceCode))][strings.ToUpper(strings.TrimSpace(optionCode))]
+	return rule, ok
+}
+
+// mergeOptionRules combines the rules of an option for two services. Get
+// Option returns the same conflicts and prerequisites for every service, so
+// those are combined; a qualifier is only required when both services
+// require it, and the higher maximum applies.
+func mergeOptionRules(a, b database.CatalogOptionRule) database.CatalogOptionRule {
+	merged := a
+	merged.Name = defaultValue(a.Name, b.Name)
+	merged.Conflicts = mergeOptionCodes(a.Conflicts, b.Conflicts)
+	merged.Prerequisites = mergeOptionCodes(a.Prerequisites, b.Prerequisites)
+	merged.QualifierRequired = a.QualifierRequired && b.QualifierRequired
+	if a.QualifierMax == 0 || b.QualifierMax == 0 {
+		merged.QualifierMax = 0
+	} else if b.QualifierMax > a.QualifierMax {
+		merged.QualifierMax = b.QualifierMax
+	}
+	return merged
+}
+
+func mergeOptionCodes(a, b []string) []string {
+	merged := append([]string{}, a...)
+	for _, code := range b {
+		if !slices.Contains(merged, code) {
+			merged = append(merged, code)
+		}
+	}
+	return merged
+}
+
+// validateOptionRules checks a set of options against the Canada Post option
+// rules for serviceCode: every option must be offered by the service, must not
+// conflict with another selected option, must have at least one of its
+// prerequisite options selected and must not exceed its maximum amount.
+// Amounts are expected in CAD. An empty serviceCode checks the combination
+// only.
+func validateOptionRules(options []ShipmentOption, serviceCode string) error {
+	rules, restricted := optionRules.rulesFor(serviceCode)
+	selected := make(map[string]struct{}, len(options))
+	for _, opt := range options {
+		if code := strings.ToUpper(strings.TrimSpace(opt.Code)); code != "" {
+			selected[code] = struct{}{}
+		}
+	}
+
+	for _, opt := range options {
+		code := strings.ToUpper(strings.TrimSpace(opt.Code))
+		if code == "" {
+			continue
+		}
+		rule, ok := rules[code]
+		if !ok {
+			if restricted {
+				return fmt.Errorf("%s is not available for %s", optionDisplayName(rules, code), fallbackServiceName(serviceCode))
+			}
+			continue
+		}
+		for _, other := range options {
+			other := strings.ToUpper(strings.TrimSpace(other.Code))
+			if other != code && optionsConflict(rules, code, other) {
+				return fmt.Errorf("%s cannot be combined with %s", optionDisplayName(rules, code), optionDisplayName(rules, other))
+			}
+		}
+		if len(rule.Prerequisites) > 0 && !hasAnyOption(selected, rule.Prerequisites) {
+			names := make([]string, 0, len(rule.Prerequisites))
+			for _, prerequisite := range rule.Prerequisites {
+				names = append(names, optionDisplayName(rules, prerequisite))
+			}
+			return fmt.Errorf("%s requires %s to be selected", optionDisplayName(rules, code), strings.Join(names, " or "))
+		}
+		if rule.QualifierMax > 0 && opt.OptionAmount > rule.QualifierMax {
+			return fmt.Errorf("%s amount cannot exceed %s CAD", optionDisplayName(rules, code), formatCADLimit(rule.QualifierMax))
+		}
+	}
+	return nil
+}
+
+// validateOptionQualifiers checks that options which take a qualifier have
+// one. It only applies to fully built label options.
+func validateOptionQualifiers(options []ShipmentOption, serviceCode string) error {
+	rules, _ := optionRules.rulesFor(serviceCode)
+	for _, opt := range options {
+		code := strings.ToUpper(strings.TrimSpace(opt.Code))
+		rule, ok := rules[code]
+		if !ok || !rule.QualifierRequired {
+			continue
+		}
+		if strings.TrimSpace(opt.OptionQualifier1) == "" && strings.TrimSpace(opt.OptionQualifier2) == "" {
+			return fmt.Errorf("%s requires a qualifier", optionDisplayName(rules, code))
+		}
+	}
+	return nil
+}
+
+// selectedRuleOptions turns the custom option values into the option codes
+// they will be sent as, so they can be checked before the label is built.
+func selectedRuleOptions(values map[string]string, signatureValue string, rateToCad float64) []ShipmentOption {
+	if rateToCad <= 0 {
+		rateToCad = 1
+	}
+	options := make([]ShipmentOption, 0, 6)
+	if parseBool(values[fieldSOEnabled]) || signatureEnabled(signatureValue) {
+		options = append(options, ShipmentOption{Code: "SO"})
+	}
+	if age := resolveMappedValue(values[fieldAgeVerification], ageVerificationMap); age == "PA18" || age == "PA19" {
+		options = append(options, ShipmentOption{Code: age})
+	}
+	covAmount, covAmountOK := parseAmount(values[fieldCOVAmount])
+	if isCOVEnabled(values, covAmountOK) {
+		options = append(options, ShipmentOption{Code: "COV", OptionAmount: covAmount * rateToCad})
+	}
+	codAmount, codAmountOK := parseAmount(values[fieldCODAmount])
+	if isCODEnabled(values, codAmountOK) {
+		options = append(options, ShipmentOption{Code: "COD", OptionAmount: codAmount * rateToCad})
+	}
+	switch delivery := resolveMappedValue(values[fieldDeliveryMethod], deliveryMethodMap); delivery {
+	case "HFP", "DNS", "LAD":
+		options = append(options, ShipmentOption{Code: delivery})
+	}
+	if isD2POEnabled(values) {
+		options = append(options, ShipmentOption{Code: "D2PO"})
+	}
+	if nonDelivery := resolveMappedValue(values[fieldNonDeliveryHandling], nonDeliveryMap); nonDelivery != "" {
+		options = append(options, ShipmentOption{Code: nonDelivery})
+	}
+	return options
+}
+
+func optionsConflict(rules map[string]database.CatalogOptionRule, a, b string) bool {
+	for _, code := range rules[a].Conflicts {
+		if code == b {
+			return true
+		}
+	}
+	for _, code := range rules[b].Conflicts {
+		if code == a {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyOption(selected map[string]struct{}, codes []string) bool {
+	for _, code := range codes {
+		if _, ok := selected[strings.ToUpper(strings.TrimSpace(code))]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// optionDisplayName prefers the names used in the label options form so
+// errors match what the merchant selected.
+func optionDisplayName(rules map[string]database.CatalogOptionRule, code string) string {
+	for _, rule := range seedOptionRules {
+		if rule.OptionCode == code {
+			return rule.Name
+		}
+	}
+	if name := strings.TrimSpace(rules[code].Name); name != "" {
+		return name
+	}
+	return code
+}
+
+func formatCADLimit(amount float64) string {
+	if amount != float64(int64(amount)) {
+		return "$" + strconv.FormatFloat(amount, 'f', 2, 64)
+	}
+	digits := strconv.FormatInt(int64(amount), 10)
+	var b strings.Builder
+	for i, r := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	return "$" + b.String()
+}
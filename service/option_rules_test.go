@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lexmodo-plugin/database"
+)
+
+func TestGetOptionParsesRules(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rs/ship/option/COD" {
+			t.Fatalf("unexpected request %s", r.URL.Path)
+		}
+		_, _ = w.Write([]byte(`<option xmlns="http://www.canadapost.ca/ws/ship/rate-v4">
+  <option-code>COD</option-code>
+  <option-name>Collect on delivery</option-name>
+  <option-class>FS</option-class>
+  <prints-on-label>true</prints-on-label>
+  <qualifier-required>true</qualifier-required>
+  <qualifier-max>1000</qualifier-max>
+  <conflicting-options><option-code>LAD</option-code></conflicting-options>
+  <prerequisite-options><option-code>HFP</option-code><option-code>D2PO</option-code></prerequisite-options>
+</option>`))
+	}))
+	defer server.Close()
+
+	client := NewCanadaPostClient("user", "pass", "0001234567", server.URL)
+	info, err := client.GetOption(context.Background(), server.URL+"/rs/ship/option/COD")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	rule := optionRuleFromInfo("DOM.EP", "COD", ServiceOptionXML{OptionCode: "COD"}, info)
+	if !rule.QualifierRequired || rule.QualifierMax != 1000 {
+		t.Fatalf("unexpected qualifier rules: %+v", rule)
+	}
+	if len(rule.Conflicts) != 1 || rule.Conflicts[0] != "LAD" || len(rule.Prerequisites) != 2 {
+		t.Fatalf("unexpected option rules: %+v", rule)
+	}
+}
+
+func TestValidateOptionRulesUsesServiceRules(t *testing.T) {
+	previous := optionRules
+	defer func() { optionRules = previous }()
+
+	optionRules = newOptionRuleCache()
+	optionRules.set([]database.CatalogOptionRule{
+		{ServiceCode: "DOM.EP", OptionCode: "SO", Name: "Signature"},
+		{ServiceCode: "DOM.EP", OptionCode: "COV", Name: "Coverage", QualifierMax: 5000},
+		{ServiceCode: "DOM.EP", OptionCode: "DNS", Name: "Do not safe drop", Conflicts: []string{"COV"}},
+	})
+
+	if err := validateOptionRules([]ShipmentOption{{Code: "SO"}, {Code: "COV", OptionAmount: 100}}, "DOM.EP"); err != nil {
+		t.Fatalf("expected options to be valid, got %v", err)
+	}
+	err := validateOptionRules([]ShipmentOption{{Code: "COV", OptionAmount: 100}, {Code: "DNS"}}, "DOM.EP")
+	if err == nil || err.Error() != "Coverage cannot be combined with Do Not Safe Drop" {
+		t.Fatalf("expected conflict from Canada Post rules, got %v", err)
+	}
+	err = validateOptionRules([]ShipmentOption{{Code: "COV", OptionAmount: 6000}}, "DOM.EP")
+	if err == nil || err.Error() != "Coverage amount cannot exceed $5,000 CAD" {
+		t.Fatalf("expected coverage maximum error, got %v", err)
+	}
+	if err := validateOptionRules([]ShipmentOption{{Code: "D2PO", OptionQualifier2: "123"}}, "DOM.EP"); err == nil {
+		t.Fatalf("expected option not offered by the service to be rejected")
+	}
+	if err := validateOptionRules([]ShipmentOption{{Code: "COV", OptionAmount: 100}, {Code: "DNS"}}, "DOM.XP"); err == nil {
+		t.Fatalf("expected the stored rules for services without their own")
+	}
+	if err := validateOptionRules([]ShipmentOption{{Code: "PA18"}, {Code: "PA19"}, {Code: "SO"}}, "DOM.XP"); err != nil {
+		t.Fatalf("expected no seed rules once rules are stored, got %v", err)
+	}
+}
+
+func TestOptionRulesAreKeptPerService(t *testing.T) {
+	previous := optionRules
+	defer func() { optionRules = previous }()
+
+	optionRules = newOptionRuleCache()
+	if err := validateOptionRules([]ShipmentOption{{Code: "PA18"}, {Code: "PA19"}, {Code: "SO"}}, ""); err == nil {
+		t.Fatalf("expected seed rules before any rules are stored")
+	}
+
+	optionRules.set([]database.CatalogOptionRule{
+		{ServiceCode: "DOM.EP", OptionCode: "COV", Name: "Coverage", QualifierMax: 5000},
+		{ServiceCode: "DOM.PC", OptionCode: "COV", Name: "Coverage", QualifierMax: 1000},
+		{ServiceCode: "DOM.PC", OptionCode: "D2PO", Name: "Deliver to Post Office", QualifierRequired: true},
+		{ServiceCode: "DOM.XP", OptionCode: "D2PO", Name: "Deliver to Post Office"},
+	})
+	if err := validateOptionRules([]ShipmentOption{{Code: "COV", OptionAmount: 3000}}, "DOM.EP"); err != nil {
+		t.Fatalf("expected the DOM.EP maximum, got %v", err)
+	}
+	if err := validateOptionRules([]ShipmentOption{{Code: "COV", OptionAmount: 3000}}, "DOM.PC"); err == nil {
+		t.Fatalf("expected the DOM.PC maximum")
+	}
+	if err := validateOptionRules([]ShipmentOption{{Code: "COV", OptionAmount: 3000}}, ""); err != nil {
+		t.Fatalf("expected the highest maximum before a service is chosen, got %v", err)
+	}
+	if err := validateOptionQualifiers([]ShipmentOption{{Code: "D2PO"}}, "DOM.PC"); err == nil {
+		t.Fatalf("expected DOM.PC to require a D2PO qualifier")
+	}
+	if err := validateOptionQualifiers([]ShipmentOption{{Code: "D2PO"}}, ""); err != nil {
+		t.Fatalf("expected no qualifier before a service is chosen, got %v", err)
+	}
+}
+
+func TestFallbackOptionRuleKeepsKnownRules(t *testing.T) {
+	previous := optionRules
+	defer func() { optionRules = previous }()
+
+	optionRules = newOptionRuleCache()
+	rule := fallbackOptionRule("DOM.EP", "COD", ServiceOptionXML{OptionCode: "COD"})
+	if rule.ServiceCode != "DOM.EP" || rule.QualifierMax != 1000 || len(rule.Conflicts) == 0 {
+		t.Fatalf("expected the seed COD rule for DOM.EP, got %+v", rule)
+	}
+
+	optionRules.set([]database.CatalogOptionRule{
+		{ServiceCode: "DOM.EP", OptionCode: "COD", Name: "Collect on Delivery", QualifierMax: 500, Conflicts: []string{"LAD"}},
+	})
+	if rule := fallbackOptionRule("DOM.EP", "COD", ServiceOptionXML{OptionCode: "COD"}); rule.QualifierMax != 500 {
+		t.Fatalf("expected the stored COD rule, got %+v", rule)
+	}
+	if rule := fallbackOptionRule("DOM.EP", "XYZ", ServiceOptionXML{OptionCode: "XYZ"}); len(rule.Conflicts) != 0 || rule.QualifierMax != 0 {
+		t.Fatalf("expected an unknown option without rules, got %+v", rule)
+	}
+}
+
+func TestValidateCanadaPostOptionRulesUsesStoredConflicts(t *testing.T) {
+	previous := optionRules
+	defer func() { optionRules = previous }()
+
+	values := map[string]string{
+		fieldD2POOfficeSelection:   "EATON CENTRE PO",
+		fieldD2PONotificationEmail: "test@example.com",
+		fieldDeliveryMethod:        "Leave at Door",
+	}
+	optionRules = newOptionRuleCache()
+	if err := validateCanadaPostOptionRules(values, "NO_SIGNATURE", "+12015550123", "CA", 1); err == nil {
+		t.Fatalf("expected the seed rules to reject Leave at Door with D2PO")
+	}
+
+	optionRules.set([]database.CatalogOptionRule{
+		{ServiceCode: "DOM.EP", OptionCode: "D2PO", Name: "Deliver to Post Office"},
+		{ServiceCode: "DOM.EP", OptionCode: "LAD", Name: "Leave at door - do not card"},
+	})
+	if err := validateCanadaPostOptionRules(values, "NO_SIGNATURE", "+12015550123", "CA", 1); err != nil {
+		t.Fatalf("expected no conflict when the stored rules have none, got %v", err)
+	}
+}
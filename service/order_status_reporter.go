@@ -0,0 +1,24 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+type ShipmentStatusUpdate struct {
+	ClientID       int64
+	InvoiceUUID    string
+	LabelID        string
+	TrackingNumber string
+	Status         string
+	Description    string
+	OccurredAt     time.Time
+}
+
+// OrderStatusReporter pushes shipment status changes back to Lexmodo orders.
+// The orders proto has no RPC that takes a shipment status yet (its only
+// call is Invoice), so the poller has no reporter and only stores statuses
+// until one is added.
+type OrderStatusReporter interface {
+	ReportShipmentStatus(ctx context.Context, update ShipmentStatusUpdate) error
+}
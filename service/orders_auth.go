@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// callOrdersWithAuthFallbacks runs call against the orders service with the
+// stored access token first, then the raw incoming authorization header, then
+// the incoming header as a bearer token when the raw form is rejected.
+func callOrdersWithAuthFallbacks(ctx context.Context, accessToken string, call func(authHeader string) error) error {
+	incomingAuth := ""
+	if incoming, ok := metadata.FromIncomingContext(ctx); ok {
+		if auths := incoming.Get("authorization"); len(auths) > 0 {
+			incomingAuth = strings.TrimSpace(auths[0])
+		}
+	}
+	accessToken = strings.TrimSpace(accessToken)
+	if incomingAuth == "" && accessToken == "" {
+		return fmt.Errorf("authorization is missing for orders request")
+	}
+
+	if accessToken != "" {
+		err := call("Bearer " + accessToken)
+		if err == nil {
+			return nil
+		}
+		if st, ok := status.FromError(err); ok && st.Code() != codes.Unauthenticated {
+			return err
+		}
+	}
+
+	if incomingAuth == "" {
+		return fmt.Errorf("authorization is missing for orders request")
+	}
+
+	rawAuth := incomingAuth
+	if strings.HasPrefix(strings.ToLower(rawAuth), "bearer ") {
+		rawAuth = strings.TrimSpace(rawAuth[7:])
+	}
+	err := call(rawAuth)
+	if err == nil {
+		return nil
+	}
+	if st, ok := status.FromError(err); ok && st.Code() == codes.Unauthenticated {
+		bearerAuth := incomingAuth
+		if !strings.HasPrefix(strings.ToLower(bearerAuth), "bearer ") {
+			bearerAuth = "Bearer " + bearerAuth
+		}
+		return call(bearerAuth)
+	}
+	return err
+}
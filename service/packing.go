@@ -0,0 +1,226 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"lexmodo-plugin/database"
+)
+
+const (
+	// fieldParcelItems lists the items of the order for box packing, e.g.
+	// "2 12x8x4 16; 1 15x15x15cm 250g": one entry per item with the
+	// quantity, LxWxH and the weight of one unit, in the request units unless
+	// suffixed. The plugin proto's ParcelItem only carries prices, so sizes
+	// come in this field.
+	fieldParcelItems = "parcel_items"
+	maxPackedUnits   = 200
+	// maxParcelWeight is Canada Post's weight limit for a parcel, in kg.
+	maxParcelWeight = 30.0
+)
+
+// packItem is one unit to pack, in cm and kg.
+type packItem struct {
+	Length float64
+	Width  float64
+	Height float64
+	Weight float64
+}
+
+func (i packItem) volume() float64 {
+	return i.Length * i.Width * i.Height
+}
+
+func parseParcelItems(value string, units requestUnits) ([]packItem, error) {
+	entries := strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '\n' })
+	items := []packItem{}
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid parcel item %q: use quantity, LxWxH and weight", strings.TrimSpace(entry))
+		}
+		quantity, err := strconv.Atoi(fields[0])
+		if err != nil || quantity <= 0 {
+			return nil, fmt.Errorf("invalid parcel item quantity %q", fields[0])
+		}
+		var item packItem
+		if item.Length, item.Width, item.Height, err = parseDimensions(fields[1], units.Length); err != nil {
+			return nil, fmt.Errorf("parcel item: %w", err)
+		}
+		weight, err := parseWeight(fields[2], units.Weight)
+		if err != nil {
+			return nil, fmt.Errorf("parcel item: %w", err)
+		}
+		item.Weight = weight.kilograms()
+		if len(items)+quantity > maxPackedUnits {
+			return nil, fmt.Errorf("at most %d units can be packed", maxPackedUnits)
+		}
+		for n := 0; n < quantity; n++ {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return nil, errors.New("parcel_items has no items")
+	}
+	return items, nil
+}
+
+// packSpace is an empty cuboid left in a box.
+type packSpace struct {
+	Length float64
+	Width  float64
+	Height float64
+}
+
+// packedParcel is a box with the items placed in it so far.
+type packedParcel struct {
+	box    database.PackagingBox
+	spaces []packSpace
+	items  []packItem
+	weight float64
+}
+
+func newPackedParcel(box database.PackagingBox) *packedParcel {
+	return &packedParcel{
+		box:    box,
+		spaces: []packSpace{{Length: box.Length, Width: box.Width, Height: box.Height}},
+		weight: box.TareWeight,
+	}
+}
+
+func boxMaxWeight(box database.PackagingBox) float64 {
+	if box.MaxWeight > 0 && box.MaxWeight < maxParcelWeight {
+		return box.MaxWeight
+	}
+	return maxParcelWeight
+}
+
+// place puts item in the tightest empty space it fits in, in any of its six
+// orientations, and splits what is left of that space into three. It
+// reports false when the item doesn't fit or would make the box too heavy.
+func (p *packedParcel) place(item packItem) bool {
+	if p.weight+item.Weight > boxMaxWeight(p.box) {
+		return false
+	}
+	best, bestWaste := -1, math.Inf(1)
+	var bestSize packSpace
+	for i, space := range p.spaces {
+		for _, size := range orientations(item) {
+			if size.Length > space.Length || size.Width > space.Width || size.Height > space.Height {
+				continue
+			}
+			waste := space.Length*space.Width*space.Height - item.volume()
+			if waste < bestWaste {
+				best, bestWaste, bestSize = i, waste, size
+			}
+		}
+	}
+	if best < 0 {
+		return false
+	}
+	space := p.spaces[best]
+	p.spaces = append(p.spaces[:best], p.spaces[best+1:]...)
+	for _, rest := range []packSpace{
+		{Length: space.Length - bestSize.Length, Width: space.Width, Height: space.Height},
+		{Length: bestSize.Length, Width: space.Width - bestSize.Width, Height: space.Height},
+		{Length: bestSize.Length, Width: bestSize.Width, Height: space.Height - bestSize.Height},
+	} {
+		if rest.Length > 0 && rest.Width > 0 && rest.Height > 0 {
+			p.spaces = append(p.spaces, rest)
+		}
+	}
+	p.items = append(p.items, item)
+	p.weight += item.Weight
+	return true
+}
+
+func orientations(item packItem) []packSpace {
+	l, w, h := item.Length, item.Width, item.Height
+	return []packSpace{
+		{l, w, h}, {l, h, w}, {w, l, h},
+		{w, h, l}, {h, l, w}, {h, w, l},
+	}
+}
+
+// packInto reports whether all items fit in one box, largest first.
+func packInto(box database.PackagingBox, items []packItem) (*packedParcel, bool) {
+	parcel := newPackedParcel(box)
+	for _, item := range items {
+		if !parcel.place(item) {
+			return nil, false
+		}
+	}
+	return parcel, true
+}
+
+// packItems packs items into boxes from the library, largest items first.
+// Each new box is the one that takes the most of the items left, the
+// smallest on ties, and is then swapped for the smallest box that still
+// holds what went in it. The parcels are the outer dimensions of the boxes
+// with the weight of the box and its items.
+func packItems(items []packItem, boxes []database.PackagingBox) ([]parcelMetrics, error) {
+	if len(boxes) == 0 {
+		return nil, errors.New("no packaging boxes configured")
+	}
+	boxes = append([]database.PackagingBox(nil), boxes...)
+	sort.SliceStable(boxes, func(i, j int) bool {
+		return boxes[i].Length*boxes[i].Width*boxes[i].Height < boxes[j].Length*boxes[j].Width*boxes[j].Height
+	})
+	remaining := append([]packItem(nil), items...)
+	sort.SliceStable(remaining, func(i, j int) bool { return remaining[i].volume() > remaining[j].volume() })
+
+	parcels := []*packedParcel{}
+	for len(remaining) > 0 {
+		var best *packedParcel
+		var bestRest []packItem
+		for _, box := range boxes {
+			parcel := newPackedParcel(box)
+			rest := []packItem{}
+			for _, item := range remaining {
+				if !parcel.place(item) {
+					rest = append(rest, item)
+				}
+			}
+			if len(parcel.items) > 0 && (best == nil || len(parcel.items) > len(best.items)) {
+				best, bestRest = parcel, rest
+			}
+		}
+		if best == nil {
+			item := remaining[0]
+			return nil, fmt.Errorf("an item of %gx%gx%g cm and %.2f kg fits in none of the packaging boxes", item.Length, item.Width, item.Height, item.Weight)
+		}
+		parcels = append(parcels, best)
+		remaining = bestRest
+	}
+	if len(parcels) > maxParcelPieces {
+		return nil, fmt.Errorf("the items need %d boxes; a shipment can have at most %d pieces", len(parcels), maxParcelPieces)
+	}
+
+	packed := make([]parcelMetrics, 0, len(parcels))
+	for _, parcel := range parcels {
+		for _, box := range boxes {
+			if box.ID == parcel.box.ID && box.Name == parcel.box.Name {
+				break
+			}
+			if smaller, ok := packInto(box, parcel.items); ok {
+				parcel = smaller
+				break
+			}
+		}
+		length, width, height := parcel.box.OuterDimensions()
+		packed = append(packed, parcelMetrics{
+			Weight: parcel.weight,
+			Length: length,
+			Width:  width,
+			Height: height,
+		})
+	}
+	return packed, nil
+}
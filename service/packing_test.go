@@ -0,0 +1,83 @@
+package service
+
+import (
+	"testing"
+
+	"lexmodo-plugin/database"
+)
+
+func TestParseParcelItems(t *testing.T) {
+	units := requestUnits{Weight: unitOunces, Length: unitCentimetres}
+	items, err := parseParcelItems("2 30x20x10 16; 1 15x15x15 8", units)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(items) != 3 || items[0].Length != 30 || items[2].Height != 15 {
+		t.Fatalf("unexpected items %+v", items)
+	}
+	if got := items[0].Weight; got < 0.4535 || got > 0.4536 {
+		t.Fatalf("expected 16 oz as 0.4536 kg, got %v", got)
+	}
+
+	for _, value := range []string{"", "2 30x20x10", "0 30x20x10 16", "1 30x20 16", "1 30x20x10 heavy", "201 1x1x1 1"} {
+		if _, err := parseParcelItems(value, units); err == nil {
+			t.Fatalf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestPackItemsUsesSmallestBoxes(t *testing.T) {
+	boxes := []database.PackagingBox{
+		{ID: 3, Name: "Large", Length: 60, Width: 40, Height: 40, TareWeight: 0.8},
+		{ID: 1, Name: "Small", Length: 20, Width: 20, Height: 10, TareWeight: 0.1},
+		{ID: 2, Name: "Medium", Length: 40, Width: 30, Height: 20, TareWeight: 0.3},
+	}
+	// Two 20x20x10 items fit a medium box side by side; a rotated item fits
+	// the small box.
+	packed, err := packItems([]packItem{
+		{Length: 20, Width: 20, Height: 10, Weight: 1},
+		{Length: 20, Width: 10, Height: 20, Weight: 1},
+	}, boxes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packed) != 1 || packed[0] != (parcelMetrics{Weight: 2.3, Length: 40, Width: 30, Height: 20}) {
+		t.Fatalf("expected one medium box, got %+v", packed)
+	}
+
+	packed, _ = packItems([]packItem{{Length: 10, Width: 20, Height: 20, Weight: 0.5}}, boxes)
+	if len(packed) != 1 || packed[0].Length != 20 || packed[0].Height != 10 || packed[0].Weight != 0.6 {
+		t.Fatalf("expected the small box, got %+v", packed)
+	}
+
+	if _, err := packItems([]packItem{{Length: 70, Width: 10, Height: 10, Weight: 1}}, boxes); err == nil {
+		t.Fatal("expected an error for an item larger than every box")
+	}
+}
+
+func TestPackItemsSplitsByMaxWeight(t *testing.T) {
+	boxes := []database.PackagingBox{{ID: 1, Name: "Crate", Length: 50, Width: 50, Height: 50, TareWeight: 1, MaxWeight: 11}}
+	items := []packItem{}
+	for n := 0; n < 3; n++ {
+		items = append(items, packItem{Length: 10, Width: 10, Height: 10, Weight: 4})
+	}
+	packed, err := packItems(items, boxes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packed) != 2 || packed[0].Weight != 9 || packed[1].Weight != 5 {
+		t.Fatalf("expected two boxes of 9 and 5 kg, got %+v", packed)
+	}
+}
+
+func TestPackItemsRatesOuterDimensions(t *testing.T) {
+	boxes := []database.PackagingBox{{ID: 1, Name: "Mailer", Length: 30, Width: 20, Height: 10, WallThickness: 0.5, TareWeight: 0.2}}
+	// The item fills the inside of the box exactly.
+	packed, err := packItems([]packItem{{Length: 30, Width: 20, Height: 10, Weight: 1}}, boxes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(packed) != 1 || packed[0] != (parcelMetrics{Weight: 1.2, Length: 31, Width: 21, Height: 11}) {
+		t.Fatalf("expected the outside of the box, got %+v", packed)
+	}
+}
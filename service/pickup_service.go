@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"lexmodo-plugin/config"
+	"lexmodo-plugin/database"
+)
+
+const pickupTypeOnDemand = "OnDemand"
+
+type PickupService struct {
+	cpClient   *CanadaPostClient
+	store      *database.Store
+	customerNo string
+}
+
+func NewPickupService(store *database.Store, cfg config.Config) *PickupService {
+	return &PickupService{
+		cpClient: NewCanadaPostClient(
+			cfg.CanadaPost.Username,
+			cfg.CanadaPost.Password,
+			cfg.CanadaPost.CustomerNumber,
+			cfg.CanadaPost.BaseURL,
+		),
+		store:      store,
+		customerNo: cfg.CanadaPost.CustomerNumber,
+	}
+}
+
+// PickupInput is what the merchant enters when scheduling a pickup. The
+// address fields are optional; without them Canada Post uses the business
+// address on the customer account.
+type PickupInput struct {
+	PickupDate    string
+	PreferredTime string
+	ClosingTime   string
+	Volume        int
+	ContactName   string
+	ContactEmail  string
+	ContactPhone  string
+	Instructions  string
+	Company       string
+	AddressLine1  string
+	City          string
+	Province      string
+}
+
+// Availability looks up pickup availability for the client's default postal
+// code and returns that postal code with the result.
+func (s *PickupService) Availability(ctx context.Context, clientID int64) (string, *PickupAvailabilityXML, error) {
+	if s == nil || s.store == nil {
+		return "", nil, fmt.Errorf("pickup store not configured")
+	}
+	settings, err := s.store.LoadShippingSettings(clientID)
+	if err != nil {
+		return "", nil, err
+	}
+	origin := normalizeCanadianPostalCode(settings.DefaultPostalCode)
+	if origin == "" {
+		return "", nil, fmt.Errorf("default postal code is not set")
+	}
+	availability, err := s.cpClient.GetPickupAvailability(ctx, origin)
+	if err != nil {
+		return origin, nil, err
+	}
+	return origin, availability, nil
+}
+
+func (s *PickupService) Schedule(ctx context.Context, clientID int64, input PickupInput) (database.ClientPickup, error) {
+	if s == nil || s.store == nil {
+		return database.ClientPickup{}, fmt.Errorf("pickup store not configured")
+	}
+	if clientID <= 0 {
+		return database.ClientPickup{}, fmt.Errorf("client id required")
+	}
+	if err := validatePickupInput(input, time.Now()); err != nil {
+		return database.ClientPickup{}, err
+	}
+	settings, err := s.store.LoadShippingSettings(clientID)
+	if err != nil {
+		return database.ClientPickup{}, err
+	}
+	origin := normalizeCanadianPostalCode(settings.DefaultPostalCode)
+	if origin == "" {
+		return database.ClientPickup{}, fmt.Errorf("default postal code is not set")
+	}
+
+	req := buildPickupRequestDetails(input, origin)
+	info, err := s.cpClient.CreatePickupRequest(ctx, s.customerNumber(settings), req)
+	if err != nil {
+		return database.ClientPickup{}, err
+	}
+	pickup := pickupRecord(clientID, input, origin)
+	pickup.RequestID = strings.TrimSpace(info.Header.RequestID)
+	pickup.Status = defaultValue(info.Header.RequestStatus, database.PickupStatusActive)
+	pickup.PickupType = defaultValue(info.Header.PickupType, pickupTypeOnDemand)
+	if err := s.store.SaveClientPickup(pickup); err != nil {
+		return pickup, err
+	}
+	log.Printf("pickup scheduled: client_id=%d request_id=%s date=%s", clientID, pickup.RequestID, pickup.PickupDate)
+	return pickup, nil
+}
+
+// Reschedule updates an existing pickup. Contact fields left empty keep their
+// stored values.
+func (s *PickupService) Reschedule(ctx context.Context, clientID int64, requestID string, input PickupInput) (database.ClientPickup, error) {
+	if s == nil || s.store == nil {
+		return database.ClientPickup{}, fmt.Errorf("pickup store not configured")
+	}
+	existing, err := s.store.LoadClientPickup(clientID, requestID)
+	if err != nil {
+		return database.ClientPickup{}, err
+	}
+	if existing.RequestID == "" {
+		return database.ClientPickup{}, fmt.Errorf("pickup request not found")
+	}
+	if existing.Status == database.PickupStatusCancelled {
+		return database.ClientPickup{}, fmt.Errorf("pickup request is cancelled")
+	}
+	input.ContactName = defaultValue(input.ContactName, existing.ContactName)
+	input.ContactEmail = defaultValue(input.ContactEmail, existing.ContactEmail)
+	input.ContactPhone = defaultValue(input.ContactPhone, existing.ContactPhone)
+	input.Instructions = defaultValue(input.Instructions, existing.Instructions)
+	if input.Volume <= 0 {
+		input.Volume = existing.Volume
+	}
+	if err := validatePickupInput(input, time.Now()); err != nil {
+		return database.ClientPickup{}, err
+	}
+	settings, err := s.store.LoadShippingSettings(clientID)
+	if err != nil {
+		return database.ClientPickup{}, err
+	}
+
+	req := &PickupRequestUpdate{
+		PickupType:      defaultValue(existing.PickupType, pickupTypeOnDemand),
+		ContactInfo:     pickupContactInfo(input),
+		LocationDetails: PickupLocationDetails{PickupInstructions: strings.TrimSpace(input.Instructions)},
+		PickupVolume:    strconv.Itoa(input.Volume),
+		PickupTimes:     pickupTimes(input),
+	}
+	if err := s.cpClient.UpdatePickupRequest(ctx, s.customerNumber(settings), existing.RequestID, req); err != nil {
+		return database.ClientPickup{}, err
+	}
+	pickup := pickupRecord(clientID, input, existing.PostalCode)
+	pickup.RequestID = existing.RequestID
+	pickup.Status = existing.Status
+	pickup.PickupType = existing.PickupType
+	if err := s.store.SaveClientPickup(pickup); err != nil {
+		return pickup, err
+	}
+	log.Printf("pickup updated: client_id=%d request_id=%s date=%s", clientID, pickup.RequestID, pickup.PickupDate)
+	return pickup, nil
+}
+
+func (s *PickupService) Cancel(ctx context.Context, clientID int64, requestID string) error {
+	if s == nil || s.store == nil {
+		return fmt.Errorf("pickup store not configured")
+	}
+	existing, err := s.store.LoadClientPickup(clientID, requestID)
+	if err != nil {
+		return err
+	}
+	if existing.RequestID == "" {
+		return fmt.Errorf("pickup request not found")
+	}
+	settings, err := s.store.LoadShippingSettings(clientID)
+	if err != nil {
+		return err
+	}
+	if err := s.cpClient.CancelPickupRequest(ctx, s.customerNumber(settings), existing.RequestID); err != nil {
+		return err
+	}
+	log.Printf("pickup cancelled: client_id=%d request_id=%s", clientID, existing.RequestID)
+	return s.store.UpdateClientPickupStatus(clientID, existing.RequestID, database.PickupStatusCancelled)
+}
+
+func (s *PickupService) customerNumber(settings database.ShippingSettings) string {
+	return defaultValue(settings.AccountNumber, s.customerNo)
+}
+
+func validatePickupInput(input PickupInput, now time.Time) error {
+	date, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(input.PickupDate), now.Location())
+	if err != nil {
+		return fmt.Errorf("pickup date must be YYYY-MM-DD")
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if date.Before(today) {
+		return fmt.Errorf("pickup date cannot be in the past")
+	}
+	preferred, err := time.Parse("15:04", strings.TrimSpace(input.PreferredTime))
+	if err != nil {
+		return fmt.Errorf("preferred time must be HH:MM")
+	}
+	closing, err := time.Parse("15:04", strings.TrimSpace(input.ClosingTime))
+	if err != nil {
+		return fmt.Errorf("closing time must be HH:MM")
+	}
+	if !preferred.Before(closing) {
+		return fmt.Errorf("preferred time must be before closing time")
+	}
+	if input.Volume <= 0 {
+		return fmt.Errorf("number of items must be greater than zero")
+	}
+	if strings.TrimSpace(input.ContactName) == "" || strings.TrimSpace(input.ContactPhone) == "" || strings.TrimSpace(input.ContactEmail) == "" {
+		return fmt.Errorf("contact name, phone and email are required")
+	}
+	return nil
+}
+
+func buildPickupRequestDetails(input PickupInput, origin string) *PickupRequestDetails {
+	req := &PickupRequestDetails{
+		PickupType:      pickupTypeOnDemand,
+		ContactInfo:     pickupContactInfo(input),
+		LocationDetails: PickupLocationDetails{PickupInstructions: strings.TrimSpace(input.Instructions)},
+		PickupVolume:    strconv.Itoa(input.Volume),
+		PickupTimes:     pickupTimes(input),
+	}
+	if strings.TrimSpace(input.AddressLine1) == "" {
+		req.PickupLocation.BusinessAddressFlag = true
+		return req
+	}
+	req.PickupLocation.AlternateAddress = &PickupAlternateAddress{
+		Company:      strings.TrimSpace(input.Company),
+		AddressLine1: strings.TrimSpace(input.AddressLine1),
+		City:         strings.TrimSpace(input.City),
+		Province:     strings.ToUpper(strings.TrimSpace(input.Province)),
+		PostalCode:   origin,
+	}
+	return req
+}
+
+func pickupContactInfo(input PickupInput) PickupContactInfo {
+	return PickupContactInfo{
+		ContactName:         strings.TrimSpace(input.ContactName),
+		Email:               strings.TrimSpace(input.ContactEmail),
+		ContactPhone:        strings.TrimSpace(input.ContactPhone),
+		ReceiveEmailUpdates: true,
+	}
+}
+
+func pickupTimes(input PickupInput) PickupTimes {
+	var times PickupTimes
+	times.OnDemandPickupTime.Date = strings.TrimSpace(input.PickupDate)
+	times.OnDemandPickupTime.PreferredTime = strings.TrimSpace(input.PreferredTime)
+	times.OnDemandPickupTime.ClosingTime = strings.TrimSpace(input.ClosingTime)
+	return times
+}
+
+func pickupRecord(clientID int64, input PickupInput, origin string) database.ClientPickup {
+	return database.ClientPickup{
+		ClientID:      clientID,
+		PostalCode:    origin,
+		PickupDate:    strings.TrimSpace(input.PickupDate),
+		PreferredTime: strings.TrimSpace(input.PreferredTime),
+		ClosingTime:   strings.TrimSpace(input.ClosingTime),
+		ContactName:   strings.TrimSpace(input.ContactName),
+		ContactEmail:  strings.TrimSpace(input.ContactEmail),
+		ContactPhone:  strings.TrimSpace(input.ContactPhone),
+		Volume:        input.Volume,
+		Instructions:  strings.TrimSpace(input.Instructions),
+	}
+}
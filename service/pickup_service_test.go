@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreatePickupRequestParsesRequestID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/enab/0001234567/pickuprequest" {
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/vnd.cpc.pickuprequest+xml" {
+			t.Fatalf("unexpected content type %q", got)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), "<business-address-flag>true</business-address-flag>") {
+			t.Fatalf("expected business address pickup, got %s", body)
+		}
+		_, _ = w.Write([]byte(`<pickup-request-info xmlns="http://www.canadapost.ca/ws/pickuprequest">
+  <pickup-request-header>
+    <request-id>38564041</request-id>
+    <request-status>Active</request-status>
+    <pickup-type>OnDemand</pickup-type>
+    <request-date>2026-02-03</request-date>
+  </pickup-request-header>
+</pickup-request-info>`))
+	}))
+	defer server.Close()
+
+	input := PickupInput{PickupDate: "2026-02-03", PreferredTime: "12:00", ClosingTime: "17:00", Volume: 2}
+	client := NewCanadaPostClient("user", "pass", "0001234567", server.URL)
+	info, err := client.CreatePickupRequest(context.Background(), "", buildPickupRequestDetails(input, "K1A0B1"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if info.Header.RequestID != "38564041" || info.Header.RequestStatus != "Active" {
+		t.Fatalf("unexpected pickup info: %+v", info.Header)
+	}
+}
+
+func TestValidatePickupInput(t *testing.T) {
+	now := time.Date(2026, 2, 3, 9, 0, 0, 0, time.UTC)
+	valid := PickupInput{
+		PickupDate:    "2026-02-03",
+		PreferredTime: "12:00",
+		ClosingTime:   "17:00",
+		Volume:        1,
+		ContactName:   "Jane",
+		ContactEmail:  "jane@example.com",
+		ContactPhone:  "6135550000",
+	}
+	if err := validatePickupInput(valid, now); err != nil {
+		t.Fatalf("expected valid input, got %v", err)
+	}
+	past := valid
+	past.PickupDate = "2026-02-02"
+	if err := validatePickupInput(past, now); err == nil {
+		t.Fatalf("expected past date to be rejected")
+	}
+	window := valid
+	window.PreferredTime = "18:00"
+	if err := validatePickupInput(window, now); err == nil {
+		t.Fatalf("expected preferred time after closing to be rejected")
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import "encoding/xml"
+
+// Get Pickup Availability – REST
+type PickupAvailabilityXML struct {
+	XMLName                  xml.Name `xml:"pickup-availability"`
+	PostalCode               string   `xml:"postal-code"`
+	OnDemandCutoff           string   `xml:"on-demand-cutoff"`
+	OnDemandTour             bool     `xml:"on-demand-tour"`
+	PriorityWorldCutoff      string   `xml:"prority-world-cutoff"`
+	ScheduledPickupAvailable bool     `xml:"scheduled-pickups-available"`
+}
+
+type PickupContactInfo struct {
+	ContactName         string `xml:"contact-name"`
+	Email               string `xml:"email"`
+	ContactPhone        string `xml:"contact-phone"`
+	TelephoneExt        string `xml:"telephone-ext,omitempty"`
+	ReceiveEmailUpdates bool   `xml:"receive-email-updates-flag"`
+}
+
+type PickupLocationDetails struct {
+	FiveTonFlag        bool   `xml:"five-ton-flag"`
+	LoadingDockFlag    bool   `xml:"loading-dock-flag"`
+	PickupInstructions string `xml:"pickup-instructions,omitempty"`
+}
+
+type PickupItemsCharacteristics struct {
+	PWWFlag       bool `xml:"pww-flag"`
+	PriorityFlag  bool `xml:"priority-flag"`
+	ReturnsFlag   bool `xml:"returns-flag"`
+	HeavyItemFlag bool `xml:"heavy-item-flag"`
+}
+
+type PickupTimes struct {
+	OnDemandPickupTime struct {
+		Date          string `xml:"date"`
+		PreferredTime string `xml:"preferred-time"`
+		ClosingTime   string `xml:"closing-time"`
+	} `xml:"on-demand-pickup-time"`
+}
+
+type PickupAlternateAddress struct {
+	Company      string `xml:"company,omitempty"`
+	AddressLine1 string `xml:"address-line-1"`
+	City         string `xml:"city"`
+	Province     string `xml:"province"`
+	PostalCode   string `xml:"postal-code"`
+}
+
+// Create Pickup Request – REST
+type PickupRequestDetails struct {
+	XMLName        xml.Name `xml:"pickup-request-details"`
+	XMLNS          string   `xml:"xmlns,attr"`
+	PickupType     string   `xml:"pickup-type"`
+	PickupLocation struct {
+		BusinessAddressFlag bool                    `xml:"business-address-flag"`
+		AlternateAddress    *PickupAlternateAddress `xml:"alternate-address,omitempty"`
+	} `xml:"pickup-location"`
+	ContactInfo          PickupContactInfo          `xml:"contact-info"`
+	LocationDetails      PickupLocationDetails      `xml:"location-details"`
+	ItemsCharacteristics PickupItemsCharacteristics `xml:"items-characteristics"`
+	PickupVolume         string                     `xml:"pickup-volume"`
+	PickupTimes          PickupTimes                `xml:"pickup-times"`
+}
+
+// Update Pickup Request – REST
+type PickupRequestUpdate struct {
+	XMLName              xml.Name                   `xml:"pickup-request-update"`
+	XMLNS                string                     `xml:"xmlns,attr"`
+	PickupType           string                     `xml:"pickup-type"`
+	ContactInfo          PickupContactInfo          `xml:"contact-info"`
+	LocationDetails      PickupLocationDetails      `xml:"location-details"`
+	ItemsCharacteristics PickupItemsCharacteristics `xml:"items-characteristics"`
+	PickupVolume         string                     `xml:"pickup-volume"`
+	PickupTimes          PickupTimes                `xml:"pickup-times"`
+}
+
+type PickupRequestInfo struct {
+	XMLName xml.Name `xml:"pickup-request-info"`
+	Header  struct {
+		RequestID     string `xml:"request-id"`
+		RequestStatus string `xml:"request-status"`
+		PickupType    string `xml:"pickup-type"`
+		RequestDate   string `xml:"request-date"`
+	} `xml:"pickup-request-header"`
+	Price struct {
+		PreTaxAmount float64 `xml:"pre-tax-amount"`
+		DueAmount    float64 `xml:"due-amount"`
+	} `xml:"pickup-request-price"`
+}
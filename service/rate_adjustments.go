@@ -0,0 +1,167 @@
+package service
+
+import (
+	"log"
+	"math"
+	"strings"
+
+	"lexmodo-plugin/database"
+)
+
+// rateAdjustments holds a client's rules by kind, with the rule for every
+// service under "" and surcharges keyed by option code.
+type rateAdjustments struct {
+	byKind     map[string]map[string]float64
+	surcharges map[string]map[string]float64
+}
+
+func newRateAdjustments(rules []database.RateAdjustment) rateAdjustments {
+	adjustments := rateAdjustments{
+		byKind:     map[string]map[string]float64{},
+		surcharges: map[string]map[string]float64{},
+	}
+	for _, rule := range rules {
+		service := strings.ToUpper(strings.TrimSpace(rule.ServiceCode))
+		if rule.Kind == database.AdjustOptionSurcharge {
+			option := strings.ToUpper(strings.TrimSpace(rule.OptionCode))
+			if adjustments.surcharges[option] == nil {
+				adjustments.surcharges[option] = map[string]float64{}
+			}
+			adjustments.surcharges[option][service] = rule.Amount
+			continue
+		}
+		if adjustments.byKind[rule.Kind] == nil {
+			adjustments.byKind[rule.Kind] = map[string]float64{}
+		}
+		adjustments.byKind[rule.Kind][service] = rule.Amount
+	}
+	return adjustments
+}
+
+func (a rateAdjustments) empty() bool {
+	return len(a.byKind) == 0 && len(a.surcharges) == 0
+}
+
+// lookupAdjustment returns the service's rule, else the rule for every
+// service.
+func lookupAdjustment(rules map[string]float64, serviceCode string) (float64, bool) {
+	if amount, ok := rules[serviceCode]; ok {
+		return amount, true
+	}
+	amount, ok := rules[""]
+	return amount, ok
+}
+
+// price turns a carrier price into the customer price, in CAD cents: the
+// percentage markup, then the flat markup, handling fee and surcharges of
+// the requested options, then charm rounding, then the minimum and maximum.
+// The price is never negative.
+func (a rateAdjustments) price(carrierCents int64, serviceCode string, optionCodes []string) int64 {
+	serviceCode = strings.ToUpper(strings.TrimSpace(serviceCode))
+	cents := float64(carrierCents)
+	if percent, ok := lookupAdjustment(a.byKind[database.AdjustMarkupPercent], serviceCode); ok {
+		cents += cents * percent / 100
+	}
+	for _, kind := range []string{database.AdjustMarkupFlat, database.AdjustHandlingFee} {
+		if amount, ok := lookupAdjustment(a.byKind[kind], serviceCode); ok {
+			cents += amount * 100
+		}
+	}
+	for _, option := range optionCodes {
+		if amount, ok := lookupAdjustment(a.surcharges[strings.ToUpper(strings.TrimSpace(option))], serviceCode); ok {
+			cents += amount * 100
+		}
+	}
+	price := int64(math.Round(cents))
+	if ending, ok := lookupAdjustment(a.byKind[database.AdjustCharmRounding], serviceCode); ok {
+		price = charmRound(price, int64(math.Round(ending*100)))
+	}
+	if minimum, ok := lookupAdjustment(a.byKind[database.AdjustMinPrice], serviceCode); ok {
+		price = max(price, int64(math.Round(minimum*100)))
+	}
+	if maximum, ok := lookupAdjustment(a.byKind[database.AdjustMaxPrice], serviceCode); ok {
+		price = min(price, int64(math.Round(maximum*100)))
+	}
+	return max(price, 0)
+}
+
+// charmRound rounds cents up to the nearest price whose cents are ending,
+// e.g. 15.40 to 15.99 for an ending of 99.
+func charmRound(cents int64, ending int64) int64 {
+	if cents <= 0 {
+		return cents
+	}
+	dollars := (cents - ending + 99) / 100
+	return dollars*100 + ending
+}
+
+// applyRateAdjustments sets each candidate's price to what the customer
+// pays and keeps Canada Post's price as its carrier cost. It runs on CAD
+// prices, before currency conversion.
+func applyRateAdjustments(candidates []rateCandidate, adjustments rateAdjustments, options []RateOption) []rateCandidate {
+	optionCodes := make([]string, 0, len(options))
+	for _, option := range options {
+		optionCodes = append(optionCodes, option.Code)
+	}
+	for i := range candidates {
+		candidate := &candidates[i]
+		candidate.CarrierCents = candidate.PriceCents
+		if adjustments.empty() {
+			continue
+		}
+		candidate.PriceCents = adjustments.price(candidate.CarrierCents, candidate.ServiceCode, optionCodes)
+		if candidate.PriceCents != candidate.CarrierCents {
+			log.Printf("rate adjusted: service=%s carrier_cents=%d price_cents=%d", candidate.ServiceCode, candidate.CarrierCents, candidate.PriceCents)
+		}
+	}
+	return candidates
+}
+
+// loadRateAdjustments returns the client's rules; without a store or
+// client, or if they can't be loaded, rates are not adjusted.
+func (s *Server) loadRateAdjustments(clientID int64) rateAdjustments {
+	if clientID <= 0 || s.Store == nil {
+		return newRateAdjustments(nil)
+	}
+	rules, err := s.Store.LoadRateAdjustments(clientID)
+	if err != nil {
+		log.Println("failed to load rate adjustments:", err)
+		return newRateAdjustments(nil)
+	}
+	return newRateAdjustments(rules)
+}
+
+// snapshotCarrierCents is Canada Post's price for the snapshot. Snapshots
+// from before rate adjustments only have the price.
+func snapshotCarrierCents(snapshot RateSnapshot) int64 {
+	if snapshot.CarrierCents > 0 {
+		return snapshot.CarrierCents
+	}
+	return snapshot.PriceCents
+}
+
+// pieceCustomerPrice is the part of the customer price recorded on the
+// piece at index. Pieces are charged at cost with the adjustment on the
+// first piece, so that the pieces add up to the price. A discount larger
+// than the first piece, such as free shipping, takes it to 0 and the rest
+// of the discount goes on the next pieces.
+func pieceCustomerPrice(snapshot RateSnapshot, index int) int64 {
+	if len(snapshot.Pieces) <= 1 {
+		return snapshot.PriceCents
+	}
+	prices := make([]int64, len(snapshot.Pieces))
+	adjustment := snapshot.PriceCents
+	for i := range prices {
+		prices[i] = piecePrice(snapshot, i)
+		adjustment -= prices[i]
+	}
+	for i := 0; i < len(prices) && adjustment != 0; i++ {
+		adjusted := max(prices[i]+adjustment, 0)
+		adjustment -= adjusted - prices[i]
+		prices[i] = adjusted
+	}
+	if index >= len(prices) {
+		return 0
+	}
+	return prices[index]
+}
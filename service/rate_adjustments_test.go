@@ -0,0 +1,78 @@
+package service
+
+import (
+	"testing"
+
+	"lexmodo-plugin/database"
+)
+
+func TestRateAdjustmentsPriceInOrder(t *testing.T) {
+	adjustments := newRateAdjustments([]database.RateAdjustment{
+		{Kind: database.AdjustMarkupPercent, Amount: 10},
+		{Kind: database.AdjustMarkupPercent, ServiceCode: "DOM.PC", Amount: -20},
+		{Kind: database.AdjustHandlingFee, ServiceCode: "DOM.EP", Amount: 1.5},
+		{Kind: database.AdjustOptionSurcharge, OptionCode: "SO", Amount: 2},
+		{Kind: database.AdjustCharmRounding, Amount: 0.99},
+		{Kind: database.AdjustMinPrice, Amount: 12},
+		{Kind: database.AdjustMaxPrice, ServiceCode: "DOM.XP", Amount: 20},
+	})
+
+	for _, tc := range []struct {
+		service string
+		carrier int64
+		options []string
+		want    int64
+	}{
+		// 17.40 + 10% + 1.50 handling = 20.64, rounded up to 20.99.
+		{"DOM.EP", 1740, nil, 2099},
+		// 17.40 - 20% + 2.00 for SO = 15.92, rounded up to 15.99.
+		{"DOM.PC", 1740, []string{"so"}, 1599},
+		// 9.00 - 20% = 7.20, rounded to 7.99, then raised to the 12.00 minimum.
+		{"DOM.PC", 900, nil, 1200},
+		// 19.00 + 10% = 20.90, rounded to 20.99, then capped at 20.00.
+		{"DOM.XP", 1900, nil, 2000},
+	} {
+		if got := adjustments.price(tc.carrier, tc.service, tc.options); got != tc.want {
+			t.Fatalf("%s at %d with %v: got %d, want %d", tc.service, tc.carrier, tc.options, got, tc.want)
+		}
+	}
+}
+
+func TestApplyRateAdjustmentsKeepsCarrierCost(t *testing.T) {
+	candidates := []rateCandidate{{ServiceCode: "DOM.EP", PriceCents: 1740}}
+	candidates = applyRateAdjustments(candidates, newRateAdjustments(nil), nil)
+	if candidates[0].PriceCents != 1740 || candidates[0].CarrierCents != 1740 {
+		t.Fatalf("expected no change without rules, got %+v", candidates[0])
+	}
+
+	rules := newRateAdjustments([]database.RateAdjustment{{Kind: database.AdjustMarkupFlat, Amount: -20}})
+	candidates = applyRateAdjustments([]rateCandidate{{ServiceCode: "DOM.EP", PriceCents: 1740}}, rules, nil)
+	if candidates[0].PriceCents != 0 || candidates[0].CarrierCents != 1740 {
+		t.Fatalf("expected a discount to stop at 0 and keep the cost, got %+v", candidates[0])
+	}
+}
+
+func TestPieceCustomerPriceAddsUpToPrice(t *testing.T) {
+	snapshot := RateSnapshot{
+		PriceCents:   3999,
+		CarrierCents: 3243,
+		Pieces:       []parcelMetrics{{Weight: 2}, {Weight: 1}},
+		PiecePrices:  []int64{1740, 1503},
+	}
+	first, second := pieceCustomerPrice(snapshot, 0), pieceCustomerPrice(snapshot, 1)
+	if first+second != 3999 || second != 1503 {
+		t.Fatalf("expected the adjustment on the first piece, got %d and %d", first, second)
+	}
+
+	// Free shipping and a discount larger than the first piece.
+	for price, want := range map[int64][2]int64{0: {0, 0}, 1000: {0, 1000}} {
+		snapshot.PriceCents = price
+		first, second = pieceCustomerPrice(snapshot, 0), pieceCustomerPrice(snapshot, 1)
+		if first != want[0] || second != want[1] {
+			t.Fatalf("price %d: expected %v, got %d and %d", price, want, first, second)
+		}
+	}
+	if got := snapshotCarrierCents(RateSnapshot{PriceCents: 1740}); got != 1740 {
+		t.Fatalf("expected older snapshots to use the price as cost, got %d", got)
+	}
+}
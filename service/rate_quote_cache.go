@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"lexmodo-plugin/config"
+)
+
+// RateQuoteCache keeps Canada Post's quotes for a mailing scenario for a
+// short time, so a cart refreshed with the same inputs doesn't call Get
+// Rates again. Only the quotes are cached; every rate request still gets
+// its own snapshots and rate IDs.
+type RateQuoteCache struct {
+	client snapshotCache
+	ttl    time.Duration
+}
+
+// NewRateQuoteCache returns nil when Redis isn't configured or the TTL is 0.
+func NewRateQuoteCache(cfg config.RedisConfig) *RateQuoteCache {
+	client := newRedisClient(cfg)
+	if client == nil || cfg.RateQuoteTTLSeconds <= 0 {
+		return nil
+	}
+	return &RateQuoteCache{
+		client: client,
+		ttl:    time.Duration(cfg.RateQuoteTTLSeconds) * time.Second,
+	}
+}
+
+// cachedRateQuote is what getPieceRates returned for a scenario.
+type cachedRateQuote struct {
+	Rates       *RateResponse      `json:"rates"`
+	PiecePrices map[string][]int64 `json:"piece_prices,omitempty"`
+}
+
+func (c *RateQuoteCache) load(ctx context.Context, key string) (cachedRateQuote, bool) {
+	if c == nil || c.client == nil {
+		return cachedRateQuote{}, false
+	}
+	payload, err := c.client.get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, errRedisNil) {
+			log.Printf("failed to load rate quote %s: %v", key, err)
+		}
+		return cachedRateQuote{}, false
+	}
+	var quote cachedRateQuote
+	if err := json.Unmarshal(payload, &quote); err != nil || quote.Rates == nil {
+		return cachedRateQuote{}, false
+	}
+	return quote, true
+}
+
+func (c *RateQuoteCache) save(ctx context.Context, key string, quote cachedRateQuote) {
+	if c == nil || c.client == nil {
+		return
+	}
+	payload, err := json.Marshal(quote)
+	if err == nil {
+		err = c.client.set(ctx, key, payload, c.ttl)
+	}
+	if err != nil {
+		log.Printf("failed to store rate quote %s: %v", key, err)
+	}
+}
+
+// rateQuoteWeightBracket is the weight step, in kg, that pieces are keyed
+// by, so that carts a few grams apart share a quote.
+const rateQuoteWeightBracket = 0.1
+
+// rateQuoteKey is a hash of what Canada Post prices a scenario on, in a
+// canonical form: postal codes without spaces, each piece's weight bracket
+// and dimensions at the precision sent in the XML, and the options sorted.
+func rateQuoteKey(payload *RateRequest, pieces []parcelMetrics) string {
+	fields := []string{
+		"customer=" + strings.TrimSpace(payload.CustomerNumber),
+		"contract=" + strings.TrimSpace(payload.ContractID),
+		"origin=" + normalizeCanadianPostalCode(payload.OriginPostalCode),
+		"mailing=" + payload.ExpectedMailingDate,
+	}
+	switch {
+	case payload.Destination.Domestic != nil:
+		fields = append(fields, "destination=CA:"+normalizeCanadianPostalCode(payload.Destination.Domestic.PostalCode))
+	case payload.Destination.UnitedStates != nil:
+		fields = append(fields, "destination=US:"+normalizeCanadianPostalCode(payload.Destination.UnitedStates.ZipCode))
+	case payload.Destination.International != nil:
+		fields = append(fields, "destination="+strings.ToUpper(strings.TrimSpace(payload.Destination.International.CountryCode)))
+	}
+
+	if len(pieces) == 0 {
+		pieces = []parcelMetrics{{Weight: float64(payload.ParcelCharacteristics.Weight)}}
+		if dims := payload.ParcelCharacteristics.Dimensions; dims != nil {
+			pieces[0].Length, pieces[0].Width, pieces[0].Height = float64(dims.Length), float64(dims.Width), float64(dims.Height)
+		}
+	}
+	for _, piece := range pieces {
+		field := "piece=" + formatCanadaPostDecimal(rateQuoteWeight(piece.Weight), 1)
+		if dims := parcelDimensions(piece); dims != nil {
+			field += fmt.Sprintf(" %sx%sx%s",
+				formatCanadaPostDecimal(float64(dims.Length), 1),
+				formatCanadaPostDecimal(float64(dims.Width), 1),
+				formatCanadaPostDecimal(float64(dims.Height), 1),
+			)
+		}
+		fields = append(fields, field)
+	}
+
+	if payload.Options != nil {
+		options := make([]string, 0, len(payload.Options.Option))
+		for _, option := range payload.Options.Option {
+			options = append(options, fmt.Sprintf("option=%s:%s",
+				strings.ToUpper(strings.TrimSpace(option.Code)),
+				formatCanadaPostDecimal(option.OptionAmount, 2),
+			))
+		}
+		sort.Strings(options)
+		fields = append(fields, options...)
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return "rate_quote:" + hex.EncodeToString(sum[:])
+}
+
+// rateQuoteWeight is the top of the weight bracket a piece of kg falls in,
+// taken at the gram precision sent in the XML.
+func rateQuoteWeight(kg float64) float64 {
+	grams := math.Round(kg * 1000)
+	return math.Ceil(grams/(rateQuoteWeightBracket*1000)) * rateQuoteWeightBracket
+}
+
+// getCachedPieceRates is getPieceRates behind the quote cache.
+func (s *Server) getCachedPieceRates(ctx context.Context, payload *RateRequest, pieces []parcelMetrics) (*RateResponse, map[string][]int64, error) {
+	if s.RateQuotes == nil {
+		return s.getPieceRates(ctx, payload, pieces)
+	}
+	key := rateQuoteKey(payload, pieces)
+	if quote, ok := s.RateQuotes.load(ctx, key); ok {
+		log.Printf("rate quote cache hit: key=%s services=%d", key, len(quote.Rates.PriceQuotes))
+		return quote.Rates, quote.PiecePrices, nil
+	}
+	rates, piecePrices, err := s.getPieceRates(ctx, payload, pieces)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.RateQuotes.save(ctx, key, cachedRateQuote{Rates: rates, PiecePrices: piecePrices})
+	return rates, piecePrices, nil
+}
@@ -0,0 +1,46 @@
+package service
+
+import "testing"
+
+func TestRateQuoteKeyIsCanonical(t *testing.T) {
+	scenario := func(postal string, weight float64, codes ...string) *RateRequest {
+		req := &RateRequest{CustomerNumber: "0001234567", OriginPostalCode: "K1P 5A0"}
+		req.ParcelCharacteristics.Weight = kilograms(weight)
+		req.Destination.Domestic = &struct {
+			PostalCode string `xml:"postal-code"`
+		}{PostalCode: postal}
+		if len(codes) > 0 {
+			req.Options = &RateOptions{}
+			for _, code := range codes {
+				req.Options.Option = append(req.Options.Option, RateOption{Code: code})
+			}
+		}
+		return req
+	}
+	pieces := func(weight float64) []parcelMetrics {
+		return []parcelMetrics{{Weight: weight, Length: 50.8, Width: 38.1, Height: 25.4}}
+	}
+
+	key := rateQuoteKey(scenario("M5H2N2", 2, "SO", "DC"), pieces(2))
+	if same := rateQuoteKey(scenario("m5h 2n2", 2, "DC", "so"), pieces(2.0000001)); same != key {
+		t.Fatal("expected postal code spacing, option order and sub-gram weight noise to share a key")
+	}
+	// Weights in the same 100 g bracket share a key.
+	bracket := rateQuoteKey(scenario("M5H2N2", 2.01, "SO", "DC"), pieces(2.01))
+	if same := rateQuoteKey(scenario("M5H2N2", 2.1, "SO", "DC"), pieces(2.1)); same != bracket {
+		t.Fatal("expected weights in the same bracket to share a key")
+	}
+	if next := rateQuoteKey(scenario("M5H2N2", 2.101, "SO", "DC"), pieces(2.101)); next == bracket {
+		t.Fatal("expected the next bracket to change the key")
+	}
+	for name, other := range map[string]string{
+		"weight":      rateQuoteKey(scenario("M5H2N2", 2.5, "SO", "DC"), pieces(2.5)),
+		"destination": rateQuoteKey(scenario("V6B1A1", 2, "SO", "DC"), pieces(2)),
+		"options":     rateQuoteKey(scenario("M5H2N2", 2, "SO"), pieces(2)),
+		"pieces":      rateQuoteKey(scenario("M5H2N2", 2, "SO", "DC"), append(pieces(1), pieces(1)...)),
+	} {
+		if other == key {
+			t.Fatalf("expected a different %s to change the key", name)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	labels "bitbucket.org/lexmodo/proto/labels"
+	"lexmodo-plugin/database"
+)
+
+// rateRuleContext is what the conditions of rate rules are checked against.
+type rateRuleContext struct {
+	SubtotalCents int64   // order subtotal in CAD cents
+	Weight        float64 // total weight in kg
+	Country       string
+	Province      string
+}
+
+// rateCondition is one condition of a rate rule, such as "subtotal >= 100"
+// or "province in NU,NT,YT".
+type rateCondition struct {
+	Field  string
+	Op     string
+	Number float64
+	Values []string
+}
+
+// rateConditionFields maps each field to whether it is a number.
+var rateConditionFields = map[string]bool{
+	"subtotal": true, // CAD
+	"weight":   true, // kg
+	"country":  false,
+	"province": false,
+}
+
+var rateConditionPattern = regexp.MustCompile(`(?i)^([a-z]+)\s*(>=|<=|!=|=|>|<|not\s+in\b|in\b)\s*(.*)$`)
+
+// parseRateConditions reads conditions separated by ";". All of them have
+// to match for the rule to apply.
+func parseRateConditions(value string) ([]rateCondition, error) {
+	conditions := []rateCondition{}
+	for _, part := range strings.Split(value, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		match := rateConditionPattern.FindStringSubmatch(part)
+		if match == nil {
+			return nil, fmt.Errorf("invalid condition %q: use field, operator and value", part)
+		}
+		condition := rateCondition{
+			Field: strings.ToLower(match[1]),
+			Op:    strings.Join(strings.Fields(strings.ToLower(match[2])), " "),
+		}
+		numeric, ok := rateConditionFields[condition.Field]
+		if !ok {
+			return nil, fmt.Errorf("unknown condition field %q: use subtotal, weight, country or province", match[1])
+		}
+		raw := strings.TrimSpace(match[3])
+		if numeric {
+			if condition.Op == "in" || condition.Op == "not in" {
+				return nil, fmt.Errorf("%s is compared with >, >=, <, <=, = or !=", condition.Field)
+			}
+			number, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be compared with a number", condition.Field)
+			}
+			condition.Number = number
+		} else {
+			if condition.Op != "=" && condition.Op != "!=" && condition.Op != "in" && condition.Op != "not in" {
+				return nil, fmt.Errorf("%s is compared with =, !=, in or not in", condition.Field)
+			}
+			for _, code := range strings.Split(raw, ",") {
+				if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+					condition.Values = append(condition.Values, code)
+				}
+			}
+			if len(condition.Values) == 0 {
+				return nil, fmt.Errorf("%s needs a value", condition.Field)
+			}
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, nil
+}
+
+// ValidateRateRuleConditions reports whether the conditions of a rate rule
+// can be parsed, for the settings page.
+func ValidateRateRuleConditions(conditions string) error {
+	_, err := parseRateConditions(conditions)
+	return err
+}
+
+func (c rateCondition) matches(ctx rateRuleContext) bool {
+	if rateConditionFields[c.Field] {
+		value := ctx.Weight
+		if c.Field == "subtotal" {
+			value = float64(ctx.SubtotalCents) / 100
+		}
+		switch c.Op {
+		case ">=":
+			return value >= c.Number
+		case "<=":
+			return value <= c.Number
+		case ">":
+			return value > c.Number
+		case "<":
+			return value < c.Number
+		case "=":
+			return value == c.Number
+		default:
+			return value != c.Number
+		}
+	}
+	value := strings.ToUpper(strings.TrimSpace(ctx.Country))
+	if c.Field == "province" {
+		value = strings.ToUpper(strings.TrimSpace(ctx.Province))
+	}
+	found := false
+	for _, candidate := range c.Values {
+		found = found || candidate == value
+	}
+	if c.Op == "!=" || c.Op == "not in" {
+		return !found
+	}
+	return found
+}
+
+// applyRateRules runs the client's rules in order on the candidates. A rule
+// whose conditions can't be parsed is skipped.
+func applyRateRules(candidates []rateCandidate, rules []database.RateRule, ctx rateRuleContext) []rateCandidate {
+	for _, rule := range rules {
+		conditions, err := parseRateConditions(rule.Conditions)
+		if err != nil {
+			log.Printf("rate rule skipped: name=%s err=%v", rule.Name, err)
+			continue
+		}
+		matched := true
+		for _, condition := range conditions {
+			matched = matched && condition.matches(ctx)
+		}
+		if !matched {
+			continue
+		}
+		targets := map[string]bool{}
+		for _, code := range rule.Services {
+			targets[strings.ToUpper(strings.TrimSpace(code))] = true
+		}
+		applies := func(candidate rateCandidate) bool {
+			return len(targets) == 0 || targets[strings.ToUpper(candidate.ServiceCode)]
+		}
+
+		switch rule.Action {
+		case database.RuleFreeShipping:
+			for i := range candidates {
+				if applies(candidates[i]) {
+					candidates[i].PriceCents = 0
+				}
+			}
+		case database.RuleHideService:
+			kept := candidates[:0]
+			for _, candidate := range candidates {
+				if !applies(candidate) {
+					kept = append(kept, candidate)
+				}
+			}
+			candidates = kept
+		case database.RuleHideSlower:
+			candidates = hideSlowerServices(candidates, applies, rule.Amount)
+		}
+		log.Printf("rate rule applied: name=%s action=%s services=%d", rule.Name, rule.Action, len(candidates))
+	}
+	return candidates
+}
+
+// hideSlowerServices drops each service that a faster one beats by costing
+// at most percent more. Services without a transit time are kept.
+func hideSlowerServices(candidates []rateCandidate, applies func(rateCandidate) bool, percent float64) []rateCandidate {
+	kept := make([]rateCandidate, 0, len(candidates))
+	for _, slow := range candidates {
+		hidden := false
+		if applies(slow) && slow.DeliveryDays > 0 {
+			for _, fast := range candidates {
+				if applies(fast) && fast.DeliveryDays > 0 && fast.DeliveryDays < slow.DeliveryDays &&
+					float64(fast.PriceCents) <= float64(slow.PriceCents)*(1+percent/100) {
+					hidden = true
+					break
+				}
+			}
+		}
+		if !hidden {
+			kept = append(kept, slow)
+		}
+	}
+	return kept
+}
+
+// orderSubtotalCents adds up the line totals of the parcel's items, or
+// their prices when there is no total, in the request currency's cents.
+func orderSubtotalCents(parcel *labels.Parcel) int64 {
+	var subtotal int64
+	for _, item := range parcel.GetParcelItems() {
+		if total := item.GetItemsRequestTotalPrice(); total.GetAmount() > 0 {
+			subtotal += total.GetAmount()
+		} else {
+			subtotal += item.GetItemsRequestPrice().GetAmount()
+		}
+	}
+	return subtotal
+}
+
+// loadRateRules returns the client's rules; without a store or client, or
+// if they can't be loaded, no rules run.
+func (s *Server) loadRateRules(clientID int64) []database.RateRule {
+	if clientID <= 0 || s.Store == nil {
+		return nil
+	}
+	rules, err := s.Store.LoadRateRules(clientID)
+	if err != nil {
+		log.Println("failed to load rate rules:", err)
+		return nil
+	}
+	return rules
+}
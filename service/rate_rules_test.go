@@ -0,0 +1,71 @@
+package service
+
+import (
+	"testing"
+
+	labels "bitbucket.org/lexmodo/proto/labels"
+	money "bitbucket.org/lexmodo/proto/money"
+	"lexmodo-plugin/database"
+)
+
+func TestParseRateConditions(t *testing.T) {
+	conditions, err := parseRateConditions("subtotal >= 100; Province NOT IN nu, nt ;weight<2.5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(conditions) != 3 || conditions[1].Op != "not in" || len(conditions[1].Values) != 2 || conditions[2].Number != 2.5 {
+		t.Fatalf("unexpected conditions %+v", conditions)
+	}
+	for _, value := range []string{"subtotal", "colour = red", "weight in 2,3", "country > CA", "subtotal >= lots", "country ="} {
+		if err := ValidateRateRuleConditions(value); err == nil {
+			t.Fatalf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestApplyRateRules(t *testing.T) {
+	candidates := func() []rateCandidate {
+		return []rateCandidate{
+			{ServiceCode: "DOM.RP", PriceCents: 1200, DeliveryDays: 5},
+			{ServiceCode: "DOM.EP", PriceCents: 1300, DeliveryDays: 3},
+			{ServiceCode: "DOM.XP", PriceCents: 2600, DeliveryDays: 1},
+		}
+	}
+	codes := func(list []rateCandidate) map[string]int64 {
+		prices := map[string]int64{}
+		for _, candidate := range list {
+			prices[candidate.ServiceCode] = candidate.PriceCents
+		}
+		return prices
+	}
+	rules := []database.RateRule{
+		{Name: "free over 100", Conditions: "subtotal >= 100; country = CA", Action: database.RuleFreeShipping, Services: []string{"DOM.RP"}},
+		{Name: "no express north", Conditions: "province in NU,NT,YT", Action: database.RuleHideService, Services: []string{"DOM.XP"}},
+		{Name: "faster within 10%", Action: database.RuleHideSlower, Amount: 10},
+		{Name: "broken", Conditions: "colour = red", Action: database.RuleHideService},
+	}
+
+	// Free Regular Parcel makes it the cheapest, so nothing is slower and
+	// within 10%: Expedited still costs more than Regular.
+	got := codes(applyRateRules(candidates(), rules, rateRuleContext{SubtotalCents: 15000, Country: "CA", Province: "ON"}))
+	if len(got) != 3 || got["DOM.RP"] != 0 {
+		t.Fatalf("expected free Regular Parcel and every service, got %v", got)
+	}
+
+	// Without free shipping, Expedited is faster and within 10% of Regular.
+	got = codes(applyRateRules(candidates(), rules, rateRuleContext{SubtotalCents: 5000, Country: "CA", Province: "NU"}))
+	if len(got) != 1 || got["DOM.EP"] != 1300 {
+		t.Fatalf("expected only Expedited in Nunavut, got %v", got)
+	}
+}
+
+func TestOrderSubtotalCents(t *testing.T) {
+	parcel := &labels.Parcel{ParcelItems: []*labels.ParcelItem{
+		{ItemsRequestPrice: &money.Money{Amount: 1000}, ItemsRequestTotalPrice: &money.Money{Amount: 3000}},
+		{ItemsRequestPrice: &money.Money{Amount: 2500}},
+		nil,
+	}}
+	if got := orderSubtotalCents(parcel); got != 5500 {
+		t.Fatalf("expected 5500 cents, got %d", got)
+	}
+}
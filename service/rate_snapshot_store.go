@@ -222,14 +222,22 @@ type RateSnapshot struct {
 	ServiceCode   string                `json:"service_code"`
 	ServiceName   string                `json:"service_name"`
 	PriceCents    int64                 `json:"price_cents"`
+	CarrierCents  int64                 `json:"carrier_cents"`
 	CurrencyCode  string                `json:"currency_code"`
 	RateToCad     float64               `json:"rate_to_cad"`
+	FXRateID      int64                 `json:"fx_rate_id,omitempty"`
+	FXRateDate    string                `json:"fx_rate_date,omitempty"`
 	DeliveryDate  string                `json:"delivery_date"`
+	DeliveryDays  uint32                `json:"delivery_days,omitempty"`
+	MailingDate   string                `json:"mailing_date,omitempty"`
+	Estimated     bool                  `json:"estimated,omitempty"`
 	Signature     string                `json:"signature"`
 	CustomOptions map[string]string     `json:"custom_options,omitempty"`
 	Shipper       addressSnapshot       `json:"shipper"`
 	Customer      addressSnapshot       `json:"customer"`
 	Parcel        parcelMetrics         `json:"parcel"`
+	Pieces        []parcelMetrics       `json:"pieces,omitempty"`
+	PiecePrices   []int64               `json:"piece_prices,omitempty"`
 	CustomsInfo   *customsSnapshot      `json:"customs_info,omitempty"`
 	Insurance     insuranceSnapshot     `json:"insurance"`
 	Origin        canadaPostOrigin      `json:"origin"`
@@ -305,7 +313,7 @@ type insuranceSnapshot struct {
 	Amount       int64  `json:"amount"`
 }
 
-func snapshotCustoms(info *labels.CustomsInfo) *customsSnapshot {
+func snapshotCustoms(info *labels.CustomsInfo, units requestUnits) *customsSnapshot {
 	if info == nil {
 		return nil
 	}
@@ -327,7 +335,7 @@ func snapshotCustoms(info *labels.CustomsInfo) *customsSnapshot {
 			Description:     strings.TrimSpace(item.GetDescription()),
 			Quantity:        quantity,
 			TotalValueCents: int64(value.GetAmount()),
-			Weight:          ouncesToKilograms(float64(item.GetWeight())),
+			Weight:          measuredWeight{Value: float64(item.GetWeight()), Unit: units.Weight}.kilograms(),
 			HSTariffNumber:  strings.TrimSpace(item.GetHsTariffNumber()),
 			Code:            strings.TrimSpace(item.GetCode()),
 			OriginCountry:   strings.TrimSpace(item.GetOriginCountry()),
@@ -354,8 +362,14 @@ func snapshotInsurance(value *money.Money) insuranceSnapshot {
 	}
 }
 
+// snapshotCache is the part of redisClient the snapshot store uses.
+type snapshotCache interface {
+	set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	get(ctx context.Context, key string) ([]byte, error)
+}
+
 type RateSnapshotStore struct {
-	client *redisClient
+	client snapshotCache
 	ttl    time.Duration
 }
 
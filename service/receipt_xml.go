@@ -0,0 +1,54 @@
+package service
+
+import "encoding/xml"
+
+type PricedOption struct {
+	OptionCode  string  `xml:"option-code"`
+	OptionPrice float64 `xml:"option-price"`
+}
+
+type PriceAdjustment struct {
+	AdjustmentCode   string  `xml:"adjustment-code"`
+	AdjustmentAmount float64 `xml:"adjustment-amount"`
+}
+
+// Get Shipment Receipt (non-contract) – REST
+type ShipmentReceiptXML struct {
+	XMLName       xml.Name `xml:"non-contract-shipment-receipt"`
+	ServiceCode   string   `xml:"service-code"`
+	RatedWeight   float64  `xml:"rated-weight"`
+	BaseAmount    float64  `xml:"base-amount"`
+	PreTaxAmount  float64  `xml:"pre-tax-amount"`
+	GSTAmount     float64  `xml:"gst-amount"`
+	PSTAmount     float64  `xml:"pst-amount"`
+	HSTAmount     float64  `xml:"hst-amount"`
+	PricedOptions struct {
+		PricedOption []PricedOption `xml:"priced-option"`
+	} `xml:"priced-options"`
+	Adjustments struct {
+		Adjustment []PriceAdjustment `xml:"adjustment"`
+	} `xml:"adjustments"`
+	CCReceiptDetails struct {
+		ChargeAmount float64 `xml:"charge-amount"`
+		Currency     string  `xml:"currency"`
+	} `xml:"cc-receipt-details"`
+}
+
+// Get Shipment Price (contract) – REST
+type ShipmentPriceXML struct {
+	XMLName       xml.Name `xml:"shipment-price"`
+	ServiceCode   string   `xml:"service-code"`
+	RatedWeight   float64  `xml:"rated-weight"`
+	BaseAmount    float64  `xml:"base-amount"`
+	PreTaxAmount  float64  `xml:"pre-tax-amount"`
+	GSTAmount     float64  `xml:"gst-amount"`
+	PSTAmount     float64  `xml:"pst-amount"`
+	HSTAmount     float64  `xml:"hst-amount"`
+	DueAmount     float64  `xml:"due-amount"`
+	PricedOptions struct {
+		PricedOption []PricedOption `xml:"priced-option"`
+	} `xml:"priced-options"`
+	Adjustments struct {
+		Adjustment []PriceAdjustment `xml:"adjustment"`
+	} `xml:"adjustments"`
+}
@@ -9,13 +9,14 @@ import (
 
 	orderspb "bitbucket.org/lexmodo/proto/orders"
 	shippingpluginpb "bitbucket.org/lexmodo/proto/shipping_plugin"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
+
+	"lexmodo-plugin/database"
 )
 
-// RefundShipment submits a Canada Post non-contract shipment refund request.
+// RefundShipment submits a Canada Post non-contract shipment refund request,
+// or voids a contract shipment that has not been transmitted.
 // Request Non-Contract Shipment Refund – REST
 func (s *Server) RefundShipment(
 	ctx context.Context,
@@ -63,6 +64,9 @@ func (s *Server) RefundShipment(
 			Message: "RefundShipment label not found",
 		}, nil
 	}
+	if record.GroupID != "" {
+		return s.voidContractShipment(ctx, record), nil
+	}
 	if strings.TrimSpace(record.RefundLink) == "" {
 		return &shippingpluginpb.ResultResponse{
 			Success: false,
@@ -90,7 +94,7 @@ func (s *Server) RefundShipment(
 	if clientID > 0 {
 		ordersToken = strings.TrimSpace(s.Store.GetAccessToken(int(clientID)))
 	}
-	email, err := fetchCustomerEmailFromOrders(ctx, s.Config.OrdersGRPCAddr, record.InvoiceUUID, clientID, ordersToken)
+	email, err := s.CustomerEmails.CustomerEmail(ctx, record.InvoiceUUID, clientID, ordersToken)
 	if err != nil {
 		log.Println("❌ Failed to fetch customer email from orders:", err)
 		return &shippingpluginpb.ResultResponse{
@@ -109,25 +113,173 @@ func (s *Server) RefundShipment(
 		}, nil
 	}
 
-	refundResp, err := s.CanadaPost.RefundShipment(ctx, record.RefundLink, email)
+	// A label already refunded is one whose pieces failed to refund; only
+	// the pieces not refunded yet are retried.
+	message := "RefundShipment OK label already refunded"
+	if record.RefundedAt.IsZero() {
+		refundResp, err := s.CanadaPost.RefundShipment(ctx, record.RefundLink, email)
+		if err != nil {
+			log.Println("❌ RefundShipment error:", err)
+			code, message := pluginErrorResult(err)
+			return &shippingpluginpb.ResultResponse{
+				Success: false,
+				Failure: true,
+				Code:    code,
+				Message: message,
+			}, nil
+		}
+
+		log.Printf("✅ RefundShipment ticket id=%s date=%s\n", strings.TrimSpace(refundResp.ServiceTicketID), strings.TrimSpace(refundResp.ServiceTicketDate))
+		message = fmt.Sprintf("RefundShipment OK ticket_id=%s ticket_date=%s", strings.TrimSpace(refundResp.ServiceTicketID), strings.TrimSpace(refundResp.ServiceTicketDate))
+		if err := s.Store.MarkLabelsRefunded(record.ClientID, []string{record.ID}); err != nil {
+			log.Println("❌ Failed to mark label refunded:", err)
+		}
+	}
+
+	// The other pieces of a multi-piece shipment are refunded with the first.
+	pieces, err := s.Store.LoadLabelPieces(record.ID)
 	if err != nil {
-		log.Println("❌ RefundShipment error:", err)
+		log.Println("❌ Failed to load label pieces:", err)
 		return &shippingpluginpb.ResultResponse{
 			Success: false,
 			Failure: true,
-			Code:    "400",
-			Message: err.Error(),
+			Code:    "500",
+			Message: message + "; failed to load the other pieces of the shipment",
 		}, nil
 	}
-
-	log.Printf("✅ RefundShipment ticket id=%s date=%s\n", strings.TrimSpace(refundResp.ServiceTicketID), strings.TrimSpace(refundResp.ServiceTicketDate))
+	failed := []string{}
+	for _, piece := range pieces {
+		if !piece.RefundedAt.IsZero() {
+			continue
+		}
+		if strings.TrimSpace(piece.RefundLink) == "" {
+			failed = append(failed, piece.TrackingNumber)
+			continue
+		}
+		pieceResp, err := s.CanadaPost.RefundShipment(ctx, piece.RefundLink, email)
+		if err != nil {
+			log.Printf("❌ RefundShipment piece error: label_id=%s err=%v", piece.ID, err)
+			failed = append(failed, piece.TrackingNumber)
+			continue
+		}
+		log.Printf("✅ RefundShipment piece label_id=%s ticket id=%s", piece.ID, strings.TrimSpace(pieceResp.ServiceTicketID))
+		if err := s.Store.MarkLabelsRefunded(record.ClientID, []string{piece.ID}); err != nil {
+			log.Println("❌ Failed to mark label piece refunded:", err)
+		}
+	}
+	if len(failed) > 0 {
+		return &shippingpluginpb.ResultResponse{
+			Success: false,
+			Failure: true,
+			Code:    "502",
+			Message: fmt.Sprintf("%s; refund failed for pieces %s", message, strings.Join(failed, ",")),
+		}, nil
+	}
+	if len(pieces) > 0 {
+		message = fmt.Sprintf("%s pieces=%d", message, len(pieces)+1)
+	}
 	return &shippingpluginpb.ResultResponse{
 		Success: true,
 		Code:    "200",
-		Message: fmt.Sprintf("RefundShipment OK ticket_id=%s ticket_date=%s", strings.TrimSpace(refundResp.ServiceTicketID), strings.TrimSpace(refundResp.ServiceTicketDate)),
+		Message: message,
 	}, nil
 }
 
+// voidContractShipment voids the contract shipment of record and its other
+// pieces. Once transmitted a contract shipment is on a manifest and can't be
+// voided, and refunds of transmitted shipments go through Canada Post.
+func (s *Server) voidContractShipment(ctx context.Context, record database.LabelRecord) *shippingpluginpb.ResultResponse {
+	if record.ManifestStatus == database.ManifestStatusVoided {
+		return &shippingpluginpb.ResultResponse{
+			Success: false,
+			Failure: true,
+			Code:    "400",
+			Message: "RefundShipment contract shipment already voided",
+		}
+	}
+	if record.ManifestStatus != "" {
+		return &shippingpluginpb.ResultResponse{
+			Success: false,
+			Failure: true,
+			Code:    "400",
+			Message: "cannot refund transmitted contract shipment; request the refund from Canada Post",
+		}
+	}
+	pieces, err := s.Store.LoadLabelPieces(record.ID)
+	if err != nil {
+		log.Println("❌ Failed to load label pieces:", err)
+		return &shippingpluginpb.ResultResponse{
+			Success: false,
+			Failure: true,
+			Code:    "500",
+			Message: "RefundShipment failed to load the pieces of the shipment",
+		}
+	}
+
+	voided := []string{}
+	failed := []string{}
+	var voidErr error
+	for _, label := range append([]database.LabelRecord{record}, pieces...) {
+		if strings.TrimSpace(label.ShipmentLink) == "" {
+			failed = append(failed, label.TrackingNumber)
+			continue
+		}
+		if err := s.CanadaPost.VoidShipment(ctx, label.ShipmentLink); err != nil {
+			log.Printf("❌ VoidShipment error: label_id=%s err=%v", label.ID, err)
+			failed = append(failed, label.TrackingNumber)
+			voidErr = err
+			continue
+		}
+		log.Printf("✅ VoidShipment label_id=%s shipment_id=%s", label.ID, label.ShipmentID)
+		voided = append(voided, label.ID)
+	}
+	if err := s.Store.MarkLabelsVoided(record.ClientID, voided); err != nil {
+		log.Println("❌ Failed to mark labels voided:", err)
+	}
+
+	switch {
+	case len(voided) == 0 && voidErr != nil:
+		code, message := pluginErrorResult(voidErr)
+		return &shippingpluginpb.ResultResponse{
+			Success: false,
+			Failure: true,
+			Code:    code,
+			Message: message,
+		}
+	case len(failed) > 0:
+		return &shippingpluginpb.ResultResponse{
+			Success: false,
+			Failure: true,
+			Code:    "502",
+			Message: fmt.Sprintf("RefundShipment void failed for %s", strings.Join(failed, ",")),
+		}
+	}
+	message := "RefundShipment OK contract shipment voided"
+	if len(pieces) > 0 {
+		message = fmt.Sprintf("%s pieces=%d", message, len(pieces)+1)
+	}
+	return &shippingpluginpb.ResultResponse{
+		Success: true,
+		Code:    "200",
+		Message: message,
+	}
+}
+
+// CustomerEmailLookup finds the email of an invoice's customer, which Canada
+// Post requires with a refund request.
+type CustomerEmailLookup interface {
+	CustomerEmail(ctx context.Context, invoiceUUID string, clientID int64, accessToken string) (string, error)
+}
+
+// ordersCustomerEmails looks customer emails up in the orders service.
+type ordersCustomerEmails struct {
+	addr string
+}
+
+func (o ordersCustomerEmails) CustomerEmail(ctx context.Context, invoiceUUID string, clientID int64, accessToken string) (string, error) {
+	return fetchCustomerEmailFromOrders(ctx, o.addr, invoiceUUID, clientID, accessToken)
+}
+
 func fetchCustomerEmailFromOrders(ctx context.Context, addr string, invoiceUUID string, clientID int64, accessToken string) (string, error) {
 	addr = strings.TrimSpace(addr)
 	invoiceUUID = strings.TrimSpace(invoiceUUID)
@@ -143,13 +295,9 @@ func fetchCustomerEmailFromOrders(ctx context.Context, addr string, invoiceUUID
 	}
 	defer conn.Close()
 
-	incomingAuth := ""
 	incomingClientID := ""
 	incomingSource := ""
 	if incoming, ok := metadata.FromIncomingContext(ctx); ok {
-		if auths := incoming.Get("authorization"); len(auths) > 0 {
-			incomingAuth = strings.TrimSpace(auths[0])
-		}
 		if ids := incoming.Get("x-client-id"); len(ids) > 0 {
 			incomingClientID = strings.TrimSpace(ids[0])
 		}
@@ -157,12 +305,9 @@ func fetchCustomerEmailFromOrders(ctx context.Context, addr string, invoiceUUID
 			incomingSource = strings.TrimSpace(sources[0])
 		}
 	}
-	accessToken = strings.TrimSpace(accessToken)
-	if incomingAuth == "" && accessToken == "" {
-		return "", fmt.Errorf("authorization is missing for orders request")
-	}
 
-	callOrders := func(authHeader string) (string, error) {
+	email := ""
+	err = callOrdersWithAuthFallbacks(ctx, accessToken, func(authHeader string) error {
 		md := metadata.New(map[string]string{
 			"x-force-auth": "true",
 		})
@@ -187,46 +332,20 @@ func fetchCustomerEmailFromOrders(ctx context.Context, addr string, invoiceUUID
 		}
 		resp, err := orderspb.NewOrdersClient(conn).Invoice(outCtx, req)
 		if err != nil {
-			return "", err
+			return err
 		}
 		if resp.GetInvoice() == nil {
-			return "", nil
+			email = ""
+			return nil
 		}
-		email := strings.TrimSpace(resp.GetInvoice().GetCustomersEmailAddress())
+		email = strings.TrimSpace(resp.GetInvoice().GetCustomersEmailAddress())
 		log.Printf("orders grpc: invoice_uuid=%s customer_email=%s", invoiceUUID, redactEmail(email))
-		return email, nil
-	}
-
-	if accessToken != "" {
-		email, err := callOrders("Bearer " + accessToken)
-		if err == nil {
-			return email, nil
-		}
-		if st, ok := status.FromError(err); ok && st.Code() != codes.Unauthenticated {
-			return "", err
-		}
-	}
-
-	if incomingAuth == "" {
-		return "", fmt.Errorf("authorization is missing for orders request")
-	}
-
-	rawAuth := incomingAuth
-	if strings.HasPrefix(strings.ToLower(rawAuth), "bearer ") {
-		rawAuth = strings.TrimSpace(rawAuth[7:])
-	}
-	email, err := callOrders(rawAuth)
-	if err == nil {
-		return email, nil
-	}
-	if st, ok := status.FromError(err); ok && st.Code() == codes.Unauthenticated {
-		bearerAuth := incomingAuth
-		if !strings.HasPrefix(strings.ToLower(bearerAuth), "bearer ") {
-			bearerAuth = "Bearer " + bearerAuth
-		}
-		return callOrders(bearerAuth)
+		return nil
+	})
+	if err != nil {
+		return "", err
 	}
-	return "", err
+	return email, nil
 }
 
 func redactEmail(value string) string {
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"lexmodo-plugin/database"
+)
+
+// CreateReturnLabel issues an authorized return for an existing label. The
+// customer who received the parcel becomes the returner and the original
+// sender receives it. The label is printed with the client's label
+// preferences and stored next to the outbound labels. A label that already
+// has a return gets that return back.
+func (s *Server) CreateReturnLabel(ctx context.Context, clientID int64, labelID string) (database.LabelRecord, error) {
+	if s == nil || s.Store == nil {
+		return database.LabelRecord{}, fmt.Errorf("label store not configured")
+	}
+	original, err := s.Store.LoadLabelRecordByLabelID(labelID)
+	if err != nil {
+		return database.LabelRecord{}, err
+	}
+	// Another store's label is reported as missing.
+	if original.ID == "" || !original.OwnedBy(clientID) {
+		return database.LabelRecord{}, fmt.Errorf("label not found")
+	}
+	if original.ReturnOf != "" {
+		return database.LabelRecord{}, fmt.Errorf("label is already a return label")
+	}
+	// A label gets one return; asking again hands back the same one.
+	returns, err := s.Store.LoadReturnLabelIDs([]string{original.ID})
+	if err != nil {
+		return database.LabelRecord{}, err
+	}
+	if returnID := returns[original.ID]; returnID != "" {
+		return s.Store.LoadLabelRecordByLabelID(returnID)
+	}
+	if original.Sender.IsZero() || original.Destination.IsZero() {
+		return database.LabelRecord{}, fmt.Errorf("label has no stored addresses")
+	}
+	if country := strings.ToUpper(strings.TrimSpace(original.Destination.CountryCode)); country != "" && country != "CA" {
+		return database.LabelRecord{}, fmt.Errorf("return labels are only available for shipments within Canada")
+	}
+
+	settings, err := s.Store.LoadShippingSettings(clientID)
+	if err != nil {
+		return database.LabelRecord{}, err
+	}
+	serviceCode, err := returnServiceCode(original.ServiceCode)
+	if err != nil {
+		return database.LabelRecord{}, err
+	}
+	req := buildAuthorizedReturnRequest(original, settings)
+	req.ServiceCode = serviceCode
+	info, err := s.CanadaPost.CreateAuthorizedReturn(ctx, defaultValue(settings.AccountNumber, s.Config.CanadaPost.CustomerNumber), req)
+	if err != nil {
+		return database.LabelRecord{}, err
+	}
+
+	var label *labelArtifact
+	for _, link := range info.Links.Link {
+		if link.Rel == "returnLabel" && strings.TrimSpace(link.Href) != "" {
+			label = &labelArtifact{Name: labelArtifactName, URL: strings.TrimSpace(link.Href), MediaType: returnLabelMediaType(link.MediaType, req.PrintPreferences.Encoding)}
+			break
+		}
+	}
+	if label == nil {
+		return database.LabelRecord{}, fmt.Errorf("return label URL not found in response")
+	}
+	data, err := s.CanadaPost.GetArtifact(ctx, label.URL, label.MediaType)
+	if err != nil {
+		return database.LabelRecord{}, err
+	}
+	returnID := generateLabelID()
+	if err := s.saveLabelFile(returnID, label.Extension(), data); err != nil {
+		return database.LabelRecord{}, err
+	}
+
+	record := database.LabelRecord{
+		ID:             returnID,
+		TrackingNumber: strings.TrimSpace(info.TrackingPIN),
+		InvoiceUUID:    original.InvoiceUUID,
+		Carrier:        "Canada Post",
+		ServiceCode:    req.ServiceCode,
+		ServiceName:    "Return - " + defaultValue(original.ServiceName, fallbackServiceName(req.ServiceCode)),
+		Weight:         original.Weight,
+		ClientID:       clientID,
+		Sender:         original.Destination,
+		Destination:    original.Sender,
+		ReturnOf:       original.ID,
+		LabelEncoding:  labelEncoding([]labelArtifact{*label}),
+	}
+	if err := s.Store.SaveLabelRecord(record); err != nil {
+		return record, err
+	}
+	log.Printf("return label created: label_id=%s return_of=%s pin=%s", record.ID, original.ID, record.TrackingNumber)
+	return record, nil
+}
+
+func buildAuthorizedReturnRequest(original database.LabelRecord, settings database.ShippingSettings) *AuthorizedReturnRequest {
+	req := &AuthorizedReturnRequest{}
+	// Authorized returns are domestic; keep the outbound service when it is one.
+	req.ServiceCode = "DOM.EP"
+	if code := strings.ToUpper(strings.TrimSpace(original.ServiceCode)); strings.HasPrefix(code, "DOM.") {
+		req.ServiceCode = code
+	}
+
+	returner := original.Destination
+	req.Returner.Name = defaultValue(returner.Name, "Customer")
+	req.Returner.Company = strings.TrimSpace(returner.Company)
+	req.Returner.DomesticAddress = returnDomesticAddress(returner)
+
+	receiver := original.Sender
+	req.Receiver.Name = defaultValue(receiver.Name, receiver.Company)
+	req.Receiver.Company = strings.TrimSpace(receiver.Company)
+	req.Receiver.Email = strings.TrimSpace(receiver.Email)
+	req.Receiver.VoiceNumber = strings.TrimSpace(receiver.Phone)
+	req.Receiver.DomesticAddress = returnDomesticAddress(receiver)
+
+	req.ParcelCharacteristics.Weight = kilograms(original.Weight)
+	req.PrintPreferences = *buildPrintPreferences(settings.LabelPreferences())
+	if contractID := strings.TrimSpace(settings.ContractID); contractID != "" {
+		req.SettlementInfo = &ReturnSettlementInfo{ContractID: contractID}
+	}
+	return req
+}
+
+// returnLabelMediaType is the media type the return label link was given
+// with, or the one of the requested encoding.
+func returnLabelMediaType(linkMediaType, encoding string) string {
+	if mediaType := strings.TrimSpace(linkMediaType); mediaType != "" {
+		return mediaType
+	}
+	if strings.EqualFold(encoding, database.LabelEncodingZPL) {
+		return "application/zpl"
+	}
+	return "application/pdf"
+}
+
+func returnDomesticAddress(address database.LabelAddress) ReturnDomesticAddress {
+	return ReturnDomesticAddress{
+		AddressLine1: sanitizeAddressLine(address.AddressLine1),
+		AddressLine2: sanitizeAddressLine(address.AddressLine2),
+		City:         strings.TrimSpace(address.City),
+		Province:     strings.ToUpper(strings.TrimSpace(address.Province)),
+		PostalCode:   normalizeCanadianPostalCode(address.PostalCode),
+	}
+}
+
+// labelAddressesFromSnapshot returns the sender and destination exactly as
+// they are sent to Canada Post for the outbound shipment.
+func labelAddressesFromSnapshot(snapshot RateSnapshot) (database.LabelAddress, database.LabelAddress) {
+	spec := buildShipmentRequestFromSnapshot(snapshot, resolveDestinationCountry(snapshot), nil, nil).DeliverySpec
+	sender := database.LabelAddress{
+		Name:         spec.Sender.Name,
+		Company:      spec.Sender.Company,
+		Phone:        spec.Sender.ContactPhone,
+		Email:        strings.TrimSpace(snapshot.Shipper.Email),
+		AddressLine1: spec.Sender.AddressDetails.AddressLine1,
+		AddressLine2: spec.Sender.AddressDetails.AddressLine2,
+		City:         spec.Sender.AddressDetails.City,
+		Province:     spec.Sender.AddressDetails.ProvState,
+		PostalCode:   spec.Sender.AddressDetails.PostalCode,
+		CountryCode:  "CA",
+	}
+	destination := database.LabelAddress{
+		Name:         spec.Destination.Name,
+		Company:      spec.Destination.Company,
+		Phone:        strings.TrimSpace(snapshot.Customer.Phone),
+		Email:        strings.TrimSpace(snapshot.Customer.Email),
+		AddressLine1: spec.Destination.AddressDetails.AddressLine1,
+		AddressLine2: spec.Destination.AddressDetails.AddressLine2,
+		City:         spec.Destination.AddressDetails.City,
+		Province:     spec.Destination.AddressDetails.ProvState,
+		PostalCode:   spec.Destination.AddressDetails.PostalCode,
+		CountryCode:  strings.ToUpper(spec.Destination.AddressDetails.CountryCode),
+	}
+	return sender, destination
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"lexmodo-plugin/database"
+)
+
+func TestBuildAuthorizedReturnRequestSwapsParties(t *testing.T) {
+	original := database.LabelRecord{
+		ServiceCode: "DOM.XP",
+		Weight:      1.2,
+		Sender: database.LabelAddress{
+			Name:         "Store Owner",
+			Company:      "My Store",
+			Email:        "owner@example.com",
+			AddressLine1: "1 Main St",
+			City:         "Ottawa",
+			Province:     "ON",
+			PostalCode:   "K1A 0B1",
+			CountryCode:  "CA",
+		},
+		Destination: database.LabelAddress{
+			Name:         "Jane Customer",
+			AddressLine1: "20 King St W",
+			City:         "Toronto",
+			Province:     "on",
+			PostalCode:   "M5H 1A1",
+			CountryCode:  "CA",
+		},
+	}
+	req := buildAuthorizedReturnRequest(original, database.ShippingSettings{ContractID: "42708517"})
+
+	if req.ServiceCode != "DOM.XP" {
+		t.Fatalf("expected outbound domestic service, got %s", req.ServiceCode)
+	}
+	if req.Returner.Name != "Jane Customer" || req.Returner.DomesticAddress.PostalCode != "M5H1A1" || req.Returner.DomesticAddress.Province != "ON" {
+		t.Fatalf("unexpected returner: %+v", req.Returner)
+	}
+	if req.Receiver.Company != "My Store" || req.Receiver.DomesticAddress.PostalCode != "K1A0B1" {
+		t.Fatalf("unexpected receiver: %+v", req.Receiver)
+	}
+	if req.SettlementInfo == nil || req.SettlementInfo.ContractID != "42708517" {
+		t.Fatalf("expected contract settlement info, got %+v", req.SettlementInfo)
+	}
+
+	out, err := xml.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if !strings.Contains(string(out), "<returner><name>Jane Customer</name>") {
+		t.Fatalf("unexpected xml: %s", out)
+	}
+}
+
+func TestBuildAuthorizedReturnRequestDefaultsService(t *testing.T) {
+	original := database.LabelRecord{ServiceCode: "USA.EP"}
+	if req := buildAuthorizedReturnRequest(original, database.ShippingSettings{}); req.ServiceCode != "DOM.EP" || req.SettlementInfo != nil {
+		t.Fatalf("unexpected request: service=%s settlement=%+v", req.ServiceCode, req.SettlementInfo)
+	}
+}
+
+func TestBuildAuthorizedReturnRequestUsesLabelPreferences(t *testing.T) {
+	original := database.LabelRecord{ServiceCode: "DOM.EP"}
+	req := buildAuthorizedReturnRequest(original, database.ShippingSettings{LabelPaperSize: "4x6", LabelEncoding: "zpl"})
+	if req.PrintPreferences.OutputFormat != "4x6" || req.PrintPreferences.Encoding != "ZPL" {
+		t.Fatalf("expected the client's print preferences, got %+v", req.PrintPreferences)
+	}
+	if got := returnLabelMediaType("", req.PrintPreferences.Encoding); got != "application/zpl" {
+		t.Fatalf("expected a ZPL media type, got %s", got)
+	}
+	req = buildAuthorizedReturnRequest(original, database.ShippingSettings{})
+	if req.PrintPreferences.OutputFormat != "8.5x11" || req.PrintPreferences.Encoding != "PDF" {
+		t.Fatalf("expected the default print preferences, got %+v", req.PrintPreferences)
+	}
+}
+
+func newReturnLabelServer(t *testing.T) (*Server, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	// No Canada Post client: these calls must not reach Canada Post.
+	return &Server{Store: &database.Store{DB: db}}, mock
+}
+
+func TestCreateReturnLabelRejectsOtherClientsLabels(t *testing.T) {
+	server, mock := newReturnLabelServer(t)
+	mock.ExpectQuery(`FROM label_records\s+WHERE id = \?`).WithArgs("LBL1").WillReturnRows(labelRecordRows(map[string]driver.Value{
+		"id":              "LBL1",
+		"tracking_number": "123456789012",
+		"client_id":       8,
+	}))
+
+	if _, err := server.CreateReturnLabel(context.Background(), 7, "LBL1"); err == nil || err.Error() != "label not found" {
+		t.Fatalf("expected label not found, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateReturnLabelReturnsExistingReturn(t *testing.T) {
+	// A label saved before client_id was recorded has owner 0.
+	for _, owner := range []int{7, 0} {
+		server, mock := newReturnLabelServer(t)
+		mock.ExpectQuery(`FROM label_records\s+WHERE id = \?`).WithArgs("LBL1").WillReturnRows(labelRecordRows(map[string]driver.Value{
+			"id":              "LBL1",
+			"tracking_number": "123456789012",
+			"client_id":       owner,
+		}))
+		mock.ExpectQuery(`SELECT return_of, id\s+FROM label_records`).WithArgs("LBL1").WillReturnRows(
+			sqlmock.NewRows([]string{"return_of", "id"}).AddRow("LBL1", "RET1"),
+		)
+		mock.ExpectQuery(`FROM label_records\s+WHERE id = \?`).WithArgs("RET1").WillReturnRows(labelRecordRows(map[string]driver.Value{
+			"id":              "RET1",
+			"tracking_number": "999999999999",
+			"client_id":       7,
+			"return_of":       "LBL1",
+		}))
+
+		record, err := server.CreateReturnLabel(context.Background(), 7, "LBL1")
+		if err != nil {
+			t.Fatalf("owner %d: %v", owner, err)
+		}
+		if record.ID != "RET1" || record.ReturnOf != "LBL1" {
+			t.Fatalf("owner %d: expected the existing return, got %+v", owner, record)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
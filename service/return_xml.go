@@ -0,0 +1,47 @@
+package service
+
+import "encoding/xml"
+
+type ReturnDomesticAddress struct {
+	AddressLine1 string `xml:"address-line-1"`
+	AddressLine2 string `xml:"address-line-2,omitempty"`
+	City         string `xml:"city"`
+	Province     string `xml:"province"`
+	PostalCode   string `xml:"postal-code"`
+}
+
+// Create Authorized Return – REST
+type AuthorizedReturnRequest struct {
+	XMLName     xml.Name `xml:"authorized-return"`
+	XMLNS       string   `xml:"xmlns,attr"`
+	ServiceCode string   `xml:"service-code"`
+	Returner    struct {
+		Name            string                `xml:"name"`
+		Company         string                `xml:"company,omitempty"`
+		DomesticAddress ReturnDomesticAddress `xml:"domestic-address"`
+	} `xml:"returner"`
+	Receiver struct {
+		Name            string                `xml:"name"`
+		Company         string                `xml:"company,omitempty"`
+		Email           string                `xml:"email,omitempty"`
+		VoiceNumber     string                `xml:"receiver-voice-number,omitempty"`
+		DomesticAddress ReturnDomesticAddress `xml:"domestic-address"`
+	} `xml:"receiver"`
+	ParcelCharacteristics struct {
+		Weight kilograms `xml:"weight"`
+	} `xml:"parcel-characteristics"`
+	PrintPreferences PrintPreferences      `xml:"print-preferences"`
+	SettlementInfo   *ReturnSettlementInfo `xml:"settlement-info,omitempty"`
+}
+
+type ReturnSettlementInfo struct {
+	ContractID string `xml:"contract-id"`
+}
+
+type AuthorizedReturnInfo struct {
+	XMLName     xml.Name `xml:"authorized-return-info"`
+	TrackingPIN string   `xml:"tracking-pin"`
+	Links       struct {
+		Link []Link `xml:"link"`
+	} `xml:"links"`
+}
@@ -0,0 +1,446 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"lexmodo-plugin/config"
+	"lexmodo-plugin/database"
+)
+
+// seedServiceCatalog is used until the first discovery has been stored, so a
+// fresh install can still name services and offer them in settings. It has
+// no restrictions: weight limits, mandatory options and returns are only
+// enforced for discovered services.
+var seedServiceCatalog = []database.CatalogService{
+	{Code: "DOM.RP", Name: "Regular Parcel"},
+	{Code: "DOM.EP", Name: "Expedited Parcel"},
+	{Code: "DOM.XP", Name: "Xpresspost"},
+	{Code: "DOM.XP.CERT", Name: "Xpresspost Certified"},
+	{Code: "DOM.PC", Name: "Priority"},
+	{Code: "DOM.LIB", Name: "Library Materials"},
+	{Code: "USA.EP", Name: "Expedited Parcel USA", ClientVoiceRequired: true},
+	{Code: "USA.SP.AIR", Name: "Small Packet USA Air"},
+	{Code: "USA.TP", Name: "Tracked Packet USA", ClientVoiceRequired: true},
+	{Code: "USA.TP.LVM", Name: "Tracked Packet USA (LVM)"},
+	{Code: "USA.XP", Name: "Xpresspost USA", ClientVoiceRequired: true},
+	{Code: "INT.XP", Name: "Xpresspost International", ClientVoiceRequired: true},
+	{Code: "INT.IP.AIR", Name: "International Parcel Air"},
+	{Code: "INT.IP.SURF", Name: "International Parcel Surface"},
+	{Code: "INT.SP.AIR", Name: "Small Packet International Air"},
+	{Code: "INT.SP.SURF", Name: "Small Packet International Surface"},
+	{Code: "INT.TP", Name: "Tracked Packet International", ClientVoiceRequired: true},
+}
+
+type serviceCatalogCache struct {
+	mu       sync.RWMutex
+	services []database.CatalogService
+	byCode   map[string]database.CatalogService
+}
+
+var serviceCatalog = newServiceCatalogCache()
+
+func newServiceCatalogCache() *serviceCatalogCache {
+	seed := make([]database.CatalogService, 0, len(seedServiceCatalog))
+	for _, svc := range seedServiceCatalog {
+		svc.Active = true
+		seed = append(seed, svc)
+	}
+	cache := &serviceCatalogCache{}
+	cache.set(seed)
+	return cache
+}
+
+func (c *serviceCatalogCache) set(services []database.CatalogService) {
+	sorted := make([]database.CatalogService, 0, len(services))
+	byCode := make(map[string]database.CatalogService, len(services))
+	for _, svc := range services {
+		svc.Code = strings.ToUpper(strings.TrimSpace(svc.Code))
+		if svc.Code == "" {
+			continue
+		}
+		sorted = append(sorted, svc)
+		byCode[svc.Code] = svc
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		gi, gj := serviceGroupRank(sorted[i].Code), serviceGroupRank(sorted[j].Code)
+		if gi != gj {
+			return gi < gj
+		}
+		return sorted[i].Code < sorted[j].Code
+	})
+
+	c.mu.Lock()
+	c.services = sorted
+	c.byCode = byCode
+	c.mu.Unlock()
+}
+
+func (c *serviceCatalogCache) lookup(code string) (database.CatalogService, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	svc, ok := c.byCode[strings.ToUpper(strings.TrimSpace(code))]
+	return svc, ok
+}
+
+// CatalogServices returns the active services, domestic first, then USA and
+// international.
+func CatalogServices() []database.CatalogService {
+	serviceCatalog.mu.RLock()
+	defer serviceCatalog.mu.RUnlock()
+	out := make([]database.CatalogService, 0, len(serviceCatalog.services))
+	for _, svc := range serviceCatalog.services {
+		if svc.Active {
+			out = append(out, svc)
+		}
+	}
+	return out
+}
+
+func serviceGroupRank(code string) int {
+	switch {
+	case strings.HasPrefix(code, "DOM."):
+		return 0
+	case strings.HasPrefix(code, "USA."):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func fallbackServiceName(serviceCode string) string {
+	if svc, ok := serviceCatalog.lookup(serviceCode); ok && strings.TrimSpace(svc.Name) != "" {
+		return svc.Name
+	}
+	return strings.TrimSpace(serviceCode)
+}
+
+func requiresClientVoice(serviceCode string) bool {
+	svc, ok := serviceCatalog.lookup(serviceCode)
+	return ok && svc.ClientVoiceRequired
+}
+
+// checkServiceWeight checks every piece against the service's weight limits.
+func checkServiceWeight(serviceCode string, pieces []parcelMetrics) error {
+	svc, ok := serviceCatalog.lookup(serviceCode)
+	if !ok {
+		return nil
+	}
+	for _, piece := range pieces {
+		grams := int(math.Round(piece.Weight * 1000))
+		if svc.MaxWeightGrams > 0 && grams > svc.MaxWeightGrams {
+			return fmt.Errorf("%s is limited to %d g per parcel", defaultValue(svc.Name, svc.Code), svc.MaxWeightGrams)
+		}
+		if svc.MinWeightGrams > 0 && grams < svc.MinWeightGrams {
+			return fmt.Errorf("%s needs at least %d g per parcel", defaultValue(svc.Name, svc.Code), svc.MinWeightGrams)
+		}
+	}
+	return nil
+}
+
+// filterRateCandidatesByWeight drops the services whose weight limits the
+// pieces don't fit.
+func filterRateCandidatesByWeight(candidates []rateCandidate, pieces []parcelMetrics) []rateCandidate {
+	filtered := make([]rateCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if err := checkServiceWeight(candidate.ServiceCode, pieces); err != nil {
+			log.Printf("rate dropped: service=%s err=%v", candidate.ServiceCode, err)
+			continue
+		}
+		filtered = append(filtered, candidate)
+	}
+	return filtered
+}
+
+// withMandatoryOptions adds the options the service requires that the
+// shipment doesn't have. A mandatory option that conflicts with one already
+// there, such as another non-delivery choice of an international service, is
+// left out, and so are options that need an amount or a qualifier.
+func withMandatoryOptions(options []ShipmentOption, serviceCode string) []ShipmentOption {
+	svc, ok := serviceCatalog.lookup(serviceCode)
+	if !ok || len(svc.MandatoryOptions) == 0 {
+		return options
+	}
+	rules, _ := optionRules.rulesFor(serviceCode)
+	for _, code := range svc.MandatoryOptions {
+		if code == "COD" || code == "COV" || rules[code].QualifierRequired || hasShipmentOptionCode(options, code) {
+			continue
+		}
+		conflict := false
+		for _, opt := range options {
+			if optionsConflict(rules, code, strings.ToUpper(strings.TrimSpace(opt.Code))) {
+				conflict = true
+				break
+			}
+		}
+		if !conflict {
+			options = append(options, ShipmentOption{Code: code})
+		}
+	}
+	return options
+}
+
+// returnServiceCode is the service of an authorized return for a label: the
+// outbound service when it is domestic, else Expedited Parcel, as long as
+// the service is allowed for returns.
+func returnServiceCode(outboundCode string) (string, error) {
+	candidates := []string{"DOM.EP"}
+	if code := strings.ToUpper(strings.TrimSpace(outboundCode)); strings.HasPrefix(code, "DOM.") && code != "DOM.EP" {
+		candidates = append([]string{code}, candidates...)
+	}
+	for _, code := range candidates {
+		svc, ok := serviceCatalog.lookup(code)
+		// Seeded services have not been discovered yet, so their
+		// restrictions are unknown.
+		if !ok || svc.RefreshedAt.IsZero() || svc.AllowedAsReturn {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("%s can't be used for returns", fallbackServiceName(candidates[0]))
+}
+
+// ServiceCatalog keeps the service_catalog and service_option_rules tables in
+// sync with Canada Post and loads them into the in-memory catalog used for
+// names and per-service rules.
+type ServiceCatalog struct {
+	cpClient *CanadaPostClient
+	store    *database.Store
+	interval time.Duration
+}
+
+func NewServiceCatalog(store *database.Store, cfg config.Config) *ServiceCatalog {
+	return &ServiceCatalog{
+		cpClient: NewCanadaPostClient(
+			cfg.CanadaPost.Username,
+			cfg.CanadaPost.Password,
+			cfg.CanadaPost.CustomerNumber,
+			cfg.CanadaPost.BaseURL,
+		),
+		store:    store,
+		interval: time.Duration(cfg.ServiceCatalog.RefreshHours) * time.Hour,
+	}
+}
+
+// Start loads the stored catalog, refreshes it when it is missing or stale and
+// then refreshes on the configured interval until ctx is cancelled.
+func (c *ServiceCatalog) Start(ctx context.Context) {
+	if c == nil || c.store == nil {
+		return
+	}
+	stored, err := c.load()
+	if err != nil {
+		log.Println("service catalog: failed to load stored services:", err)
+	}
+	if c.interval <= 0 {
+		log.Println("service catalog refresh disabled")
+		return
+	}
+	log.Printf("service catalog started: interval=%s", c.interval)
+
+	wait := time.Duration(0)
+	if last := latestCatalogRefresh(stored); !last.IsZero() && time.Since(last) < c.interval {
+		wait = c.interval - time.Since(last)
+	}
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := c.Refresh(ctx); err != nil {
+			log.Println("service catalog: refresh failed:", err)
+		}
+		wait = c.interval
+	}
+}
+
+// Refresh discovers the current services, stores them and reloads the cache.
+// Services that are no longer returned are kept but marked retired.
+func (c *ServiceCatalog) Refresh(ctx context.Context) error {
+	callCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	summaries, err := c.cpClient.DiscoverServices(callCtx, "")
+	if err != nil {
+		return err
+	}
+	if len(summaries) == 0 {
+		return fmt.Errorf("canada post returned no services")
+	}
+	services := make([]database.CatalogService, 0, len(summaries))
+	rules := []database.CatalogOptionRule{}
+	optionInfo := map[string]*OptionInfoXML{}
+	for _, summary := range summaries {
+		svc := database.CatalogService{
+			Code: strings.ToUpper(strings.TrimSpace(summary.ServiceCode)),
+			Name: strings.TrimSpace(summary.ServiceName),
+		}
+		if svc.Code == "" {
+			continue
+		}
+		serviceURL := strings.TrimSpace(summary.Link.Href)
+		if serviceURL == "" {
+			serviceURL = strings.TrimRight(strings.TrimSpace(c.cpClient.BaseURL), "/") + "/rs/ship/service/" + url.PathEscape(svc.Code)
+		}
+		info, err := c.cpClient.GetService(callCtx, serviceURL)
+		if err != nil {
+			log.Printf("service catalog: get service %s failed: %v", svc.Code, err)
+		} else {
+			applyServiceInfo(&svc, info)
+			rules = append(rules, c.optionRules(callCtx, svc.Code, info, optionInfo)...)
+		}
+		svc.ClientVoiceRequired = defaultClientVoiceRequired(svc)
+		services = append(services, svc)
+	}
+	if err := c.store.SaveCatalogServices(services); err != nil {
+		return err
+	}
+	if err := c.store.SaveCatalogOptionRules(rules); err != nil {
+		return err
+	}
+	if _, err := c.load(); err != nil {
+		return err
+	}
+	log.Printf("service catalog: refreshed %d services", len(services))
+	return nil
+}
+
+func (c *ServiceCatalog) load() ([]database.CatalogService, error) {
+	stored, err := c.store.LoadCatalogServices()
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) > 0 {
+		serviceCatalog.set(stored)
+	}
+	rules, err := c.store.LoadCatalogOptionRules()
+	if err != nil {
+		return stored, err
+	}
+	optionRules.set(rules)
+	return stored, nil
+}
+
+// optionRules fetches Get Option for every option the service offers. Options
+// are the same across services, so each one is fetched once per refresh.
+// An option that cannot be fetched keeps only what the service says about it,
+// so it is still offered but has no conflicts or prerequisites.
+func (c *ServiceCatalog) optionRules(ctx context.Context, serviceCode string, info *ServiceInfoXML, fetched map[string]*OptionInfoXML) []database.CatalogOptionRule {
+	rules := make([]database.CatalogOptionRule, 0, len(info.Options.Option))
+	for _, opt := range info.Options.Option {
+		code := strings.ToUpper(strings.TrimSpace(opt.OptionCode))
+		if code == "" {
+			continue
+		}
+		option, ok := fetched[code]
+		if !ok {
+			optionURL := strings.TrimSpace(opt.Link.Href)
+			if optionURL == "" {
+				optionURL = strings.TrimRight(strings.TrimSpace(c.cpClient.BaseURL), "/") + "/rs/ship/option/" + url.PathEscape(code)
+			}
+			var err error
+			option, err = c.cpClient.GetOption(ctx, optionURL)
+			if err != nil {
+				log.Printf("service catalog: get option %s failed: %v", code, err)
+				rules = append(rules, fallbackOptionRule(serviceCode, code, opt))
+				continue
+			}
+			fetched[code] = option
+		}
+		rules = append(rules, optionRuleFromInfo(serviceCode, code, opt, option))
+	}
+	return rules
+}
+
+// fallbackOptionRule stands in for an option whose Get Option lookup
+// failed: the rule already stored for the service, else the seed rule, so
+// that its conflicts and limits are kept. An option known to neither is
+// stored without rules.
+func fallbackOptionRule(serviceCode, optionCode string, opt ServiceOptionXML) database.CatalogOptionRule {
+	if rule, ok := optionRules.serviceRule(serviceCode, optionCode); ok {
+		return rule
+	}
+	for _, seed := range seedOptionRules {
+		if seed.OptionCode == optionCode {
+			seed.ServiceCode = serviceCode
+			seed.QualifierRequired = seed.QualifierRequired || opt.QualifierRequired
+			return seed
+		}
+	}
+	return optionRuleFromInfo(serviceCode, optionCode, opt, &OptionInfoXML{})
+}
+
+func applyServiceInfo(svc *database.CatalogService, info *ServiceInfoXML) {
+	if name := strings.TrimSpace(info.ServiceName); name != "" {
+		svc.Name = name
+	}
+	svc.Options = svc.Options[:0]
+	svc.MandatoryOptions = svc.MandatoryOptions[:0]
+	for _, opt := range info.Options.Option {
+		code := strings.ToUpper(strings.TrimSpace(opt.OptionCode))
+		if code == "" {
+			continue
+		}
+		svc.Options = append(svc.Options, code)
+		if opt.Mandatory {
+			svc.MandatoryOptions = append(svc.MandatoryOptions, code)
+		}
+	}
+	svc.MinWeightGrams = info.Restrictions.WeightRestriction.Min
+	svc.MaxWeightGrams = info.Restrictions.WeightRestriction.Max
+	svc.AllowedAsReturn = info.Restrictions.AllowedAsReturnService
+}
+
+func optionRuleFromInfo(serviceCode, optionCode string, opt ServiceOptionXML, info *OptionInfoXML) database.CatalogOptionRule {
+	rule := database.CatalogOptionRule{
+		ServiceCode:       serviceCode,
+		OptionCode:        optionCode,
+		Name:              defaultValue(info.OptionName, opt.OptionName),
+		QualifierRequired: info.QualifierRequired || opt.QualifierRequired,
+		QualifierMax:      info.QualifierMax,
+	}
+	for _, code := range info.ConflictingOptions {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			rule.Conflicts = append(rule.Conflicts, code)
+		}
+	}
+	for _, code := range info.PrerequisiteOptions {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			rule.Prerequisites = append(rule.Prerequisites, code)
+		}
+	}
+	return rule
+}
+
+// defaultClientVoiceRequired decides whether a newly discovered service needs
+// the recipient phone. Canada Post does not publish this per service, so the
+// seed catalog decides: only the seeded services marked for it require the
+// phone, and services outside the seed don't. The stored value is not
+// overwritten on later refreshes.
+func defaultClientVoiceRequired(svc database.CatalogService) bool {
+	for _, seed := range seedServiceCatalog {
+		if seed.Code == svc.Code {
+			return seed.ClientVoiceRequired
+		}
+	}
+	return false
+}
+
+func latestCatalogRefresh(services []database.CatalogService) time.Time {
+	var latest time.Time
+	for _, svc := range services {
+		if svc.RefreshedAt.After(latest) {
+			latest = svc.RefreshedAt
+		}
+	}
+	return latest
+}
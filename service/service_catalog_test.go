@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"lexmodo-plugin/database"
+)
+
+func TestDiscoverServicesAndGetService(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rs/ship/service":
+			_, _ = w.Write([]byte(`<services xmlns="http://www.canadapost.ca/ws/ship/rate-v4">
+  <service>
+    <service-code>DOM.EP</service-code>
+    <service-name>Expedited Parcel</service-name>
+    <link rel="service" href="` + server.URL + `/rs/ship/service/DOM.EP" media-type="application/vnd.cpc.ship.rate-v4+xml"/>
+  </service>
+</services>`))
+		case "/rs/ship/service/DOM.EP":
+			_, _ = w.Write([]byte(`<service xmlns="http://www.canadapost.ca/ws/ship/rate-v4">
+  <service-code>DOM.EP</service-code>
+  <service-name>Expedited Parcel</service-name>
+  <options>
+    <option><option-code>SO</option-code><mandatory>false</mandatory></option>
+    <option><option-code>DC</option-code><mandatory>true</mandatory></option>
+  </options>
+  <restrictions>
+    <weight-restriction min="0" max="30000"/>
+    <allowed-as-return-service>true</allowed-as-return-service>
+  </restrictions>
+</service>`))
+		default:
+			t.Fatalf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := NewCanadaPostClient("user", "pass", "0001234567", server.URL)
+	services, err := client.DiscoverServices(context.Background(), "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(services) != 1 || services[0].ServiceCode != "DOM.EP" {
+		t.Fatalf("unexpected services: %+v", services)
+	}
+	info, err := client.GetService(context.Background(), services[0].Link.Href)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	svc := database.CatalogService{Code: "DOM.EP"}
+	applyServiceInfo(&svc, info)
+	if len(svc.Options) != 2 || len(svc.MandatoryOptions) != 1 || svc.MandatoryOptions[0] != "DC" {
+		t.Fatalf("unexpected options: %+v / %+v", svc.Options, svc.MandatoryOptions)
+	}
+	if svc.MaxWeightGrams != 30000 || !svc.AllowedAsReturn {
+		t.Fatalf("unexpected restrictions: %+v", svc)
+	}
+}
+
+func TestDefaultClientVoiceRequired(t *testing.T) {
+	cases := []struct {
+		name string
+		svc  database.CatalogService
+		want bool
+	}{
+		{name: "seeded USA service with signature", svc: database.CatalogService{Code: "USA.XP", Options: []string{"SO", "COV"}}, want: true},
+		{name: "seeded international service", svc: database.CatalogService{Code: "INT.TP"}, want: true},
+		{name: "seeded USA service without it", svc: database.CatalogService{Code: "USA.SP.AIR", Options: []string{"RASE"}}, want: false},
+		{name: "seeded domestic service with signature", svc: database.CatalogService{Code: "DOM.XP", Options: []string{"SO"}}, want: false},
+		{name: "international service outside the seed", svc: database.CatalogService{Code: "INT.NEW", Options: []string{"COV", "SO"}}, want: false},
+		{name: "USA service outside the seed", svc: database.CatalogService{Code: "USA.NEW", Options: []string{"SO"}}, want: false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := defaultClientVoiceRequired(tc.svc); got != tc.want {
+				t.Fatalf("expected %t for %s, got %t", tc.want, tc.svc.Code, got)
+			}
+		})
+	}
+}
+
+func TestServiceCatalogDrivesNamesAndRules(t *testing.T) {
+	previous := serviceCatalog
+	defer func() { serviceCatalog = previous }()
+
+	serviceCatalog = newServiceCatalogCache()
+	serviceCatalog.set([]database.CatalogService{
+		{Code: "INT.XP", Name: "Xpresspost International", ClientVoiceRequired: true, Active: true},
+		{Code: "DOM.EP", Name: "Expedited Parcel", Active: true},
+		{Code: "DOM.LIB", Name: "Library Materials", Active: false},
+	})
+
+	if got := fallbackServiceName("dom.ep"); got != "Expedited Parcel" {
+		t.Fatalf("unexpected name %q", got)
+	}
+	if got := fallbackServiceName("DOM.UNKNOWN"); got != "DOM.UNKNOWN" {
+		t.Fatalf("expected unknown code to be returned as is, got %q", got)
+	}
+	if !requiresClientVoice("INT.XP") || requiresClientVoice("DOM.EP") {
+		t.Fatalf("unexpected client voice rules")
+	}
+	active := CatalogServices()
+	if len(active) != 2 || active[0].Code != "DOM.EP" || active[1].Code != "INT.XP" {
+		t.Fatalf("unexpected active services: %+v", active)
+	}
+}
+
+func TestServiceCatalogRestrictions(t *testing.T) {
+	previousCatalog, previousRules := serviceCatalog, optionRules
+	defer func() { serviceCatalog, optionRules = previousCatalog, previousRules }()
+
+	refreshed := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	serviceCatalog = newServiceCatalogCache()
+	serviceCatalog.set([]database.CatalogService{
+		{Code: "DOM.EP", Name: "Expedited Parcel", MaxWeightGrams: 30000, AllowedAsReturn: true, Active: true, RefreshedAt: refreshed},
+		{Code: "DOM.PC", Name: "Priority", MinWeightGrams: 10, MaxWeightGrams: 30000, Active: true, RefreshedAt: refreshed},
+		{Code: "DOM.XP", Name: "Xpresspost", AllowedAsReturn: true, Active: true, RefreshedAt: refreshed},
+		{Code: "INT.XP", Name: "Xpresspost International", MandatoryOptions: []string{"RASE", "RTS", "ABAN", "COV"}, Active: true, RefreshedAt: refreshed},
+		{Code: "DOM.RP", Name: "Regular Parcel", Active: true},
+	})
+	optionRules = newOptionRuleCache()
+
+	candidates := []rateCandidate{{ServiceCode: "DOM.EP"}, {ServiceCode: "DOM.PC"}, {ServiceCode: "DOM.RP"}}
+	filtered := filterRateCandidatesByWeight(candidates, []parcelMetrics{{Weight: 2}, {Weight: 31}})
+	if len(filtered) != 1 || filtered[0].ServiceCode != "DOM.RP" {
+		t.Fatalf("expected only the service without limits for a 31 kg piece, got %+v", filtered)
+	}
+	if filtered := filterRateCandidatesByWeight(candidates, []parcelMetrics{{Weight: 0.005}}); len(filtered) != 2 {
+		t.Fatalf("expected Priority to be dropped under its minimum weight, got %+v", filtered)
+	}
+
+	options := withMandatoryOptions([]ShipmentOption{{Code: "SO"}}, "INT.XP")
+	if len(options) != 2 || options[1].Code != "RASE" {
+		t.Fatalf("expected one non-delivery option to be added, got %+v", options)
+	}
+	options = withMandatoryOptions([]ShipmentOption{{Code: "ABAN"}}, "INT.XP")
+	if len(options) != 1 {
+		t.Fatalf("expected the selected non-delivery option to be kept alone, got %+v", options)
+	}
+
+	for outbound, want := range map[string]string{"DOM.XP": "DOM.XP", "DOM.PC": "DOM.EP", "USA.EP": "DOM.EP", "DOM.RP": "DOM.RP"} {
+		if got, err := returnServiceCode(outbound); err != nil || got != want {
+			t.Fatalf("%s: got return service %q %v, want %s", outbound, got, err, want)
+		}
+	}
+	serviceCatalog.set([]database.CatalogService{{Code: "DOM.EP", Name: "Expedited Parcel", Active: true, RefreshedAt: refreshed}})
+	if _, err := returnServiceCode("DOM.EP"); err == nil {
+		t.Fatal("expected an error when the service is not allowed for returns")
+	}
+}
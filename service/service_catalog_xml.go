@@ -0,0 +1,53 @@
+package service
+
+import "encoding/xml"
+
+// Discover Services – REST
+type ServicesXML struct {
+	XMLName  xml.Name            `xml:"services"`
+	Services []ServiceSummaryXML `xml:"service"`
+}
+
+type ServiceSummaryXML struct {
+	ServiceCode string `xml:"service-code"`
+	ServiceName string `xml:"service-name"`
+	Link        Link   `xml:"link"`
+}
+
+// Get Service – REST
+type ServiceInfoXML struct {
+	XMLName     xml.Name `xml:"service"`
+	ServiceCode string   `xml:"service-code"`
+	ServiceName string   `xml:"service-name"`
+	Options     struct {
+		Option []ServiceOptionXML `xml:"option"`
+	} `xml:"options"`
+	Restrictions struct {
+		WeightRestriction struct {
+			Min int `xml:"min,attr"`
+			Max int `xml:"max,attr"`
+		} `xml:"weight-restriction"`
+		AllowedAsReturnService bool `xml:"allowed-as-return-service"`
+	} `xml:"restrictions"`
+}
+
+type ServiceOptionXML struct {
+	OptionCode        string `xml:"option-code"`
+	OptionName        string `xml:"option-name"`
+	Mandatory         bool   `xml:"mandatory"`
+	QualifierRequired bool   `xml:"qualifier-required"`
+	Link              Link   `xml:"link"`
+}
+
+// Get Option – REST
+type OptionInfoXML struct {
+	XMLName             xml.Name `xml:"option"`
+	OptionCode          string   `xml:"option-code"`
+	OptionName          string   `xml:"option-name"`
+	OptionClass         string   `xml:"option-class"`
+	PrintsOnLabel       bool     `xml:"prints-on-label"`
+	QualifierRequired   bool     `xml:"qualifier-required"`
+	QualifierMax        float64  `xml:"qualifier-max"`
+	ConflictingOptions  []string `xml:"conflicting-options>option-code"`
+	PrerequisiteOptions []string `xml:"prerequisite-options>option-code"`
+}
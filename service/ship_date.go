@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"lexmodo-plugin/database"
+)
+
+const (
+	// fieldShipDate is the day the merchant will mail the parcel, as
+	// YYYY-MM-DD, when it is not the next mailing day of the client's
+	// delivery schedule, e.g. a label printed on Friday for Monday.
+	fieldShipDate = "ship_date"
+	// shipDateMetadataKey carries the ship date in request metadata; it
+	// wins over the custom field.
+	shipDateMetadataKey = "x-ship-date"
+	maxShipDateDays     = 30
+)
+
+// shipDateFromRequest returns the requested ship date, or the zero time
+// when none was given.
+func shipDateFromRequest(ctx context.Context, values map[string]string) (time.Time, error) {
+	raw := strings.TrimSpace(values[fieldShipDate])
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if fromMetadata := md.Get(shipDateMetadataKey); len(fromMetadata) > 0 && strings.TrimSpace(fromMetadata[0]) != "" {
+			raw = strings.TrimSpace(fromMetadata[0])
+		}
+	}
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid ship date %q: use YYYY-MM-DD", raw)
+	}
+	return date, nil
+}
+
+// shipOn mails the order on date, or the next business day if it is a day
+// off, instead of after the handling days. The date can't be before the
+// order date or more than 30 days after it.
+func (e deliveryEstimate) shipOn(date time.Time) (deliveryEstimate, error) {
+	if date.Before(e.OrderDate) {
+		return e, fmt.Errorf("ship date %s is in the past", date.Format("2006-01-02"))
+	}
+	if date.After(e.OrderDate.AddDate(0, 0, maxShipDateDays)) {
+		return e, fmt.Errorf("ship date %s is more than %d days away", date.Format("2006-01-02"), maxShipDateDays)
+	}
+	e.MailingDate = e.mailing.addBusinessDays(date, 0)
+	return e, nil
+}
+
+// deliverySchedule returns the client's delivery schedule, or the default
+// one without a store or client.
+func (s *Server) deliverySchedule(clientID int64) database.DeliverySchedule {
+	settings := database.ShippingSettings{}
+	if clientID > 0 && s.Store != nil {
+		loaded, err := s.Store.LoadShippingSettings(clientID)
+		if err != nil {
+			log.Println("failed to load shipping settings:", err)
+		} else {
+			settings = loaded
+		}
+	}
+	return settings.DeliverySchedule()
+}
+
+// upcomingMailingDate is the snapshot's mailing date if it is still today
+// or later in the client's time zone, else "" so that Canada Post uses the
+// day the shipment is created.
+func upcomingMailingDate(mailingDate string, now time.Time, schedule database.DeliverySchedule) string {
+	date, err := time.Parse("2006-01-02", strings.TrimSpace(mailingDate))
+	if err != nil || date.Before(calendarDate(now.In(schedule.Location()))) {
+		return ""
+	}
+	return date.Format("2006-01-02")
+}
+
+// labelShipDate is the label's ship date: the mailing date when it is
+// later than today, else now.
+func labelShipDate(mailingDate string, now time.Time, schedule database.DeliverySchedule) uint32 {
+	loc := schedule.Location()
+	date, err := time.Parse("2006-01-02", strings.TrimSpace(mailingDate))
+	if err != nil || !date.After(calendarDate(now.In(loc))) {
+		return uint32(now.Unix())
+	}
+	return uint32(time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc).Unix())
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"lexmodo-plugin/database"
+)
+
+func TestShipDateFromRequest(t *testing.T) {
+	values := map[string]string{fieldShipDate: "2026-10-19"}
+	date, err := shipDateFromRequest(context.Background(), values)
+	if err != nil || date.Format("2006-01-02") != "2026-10-19" {
+		t.Fatalf("expected the custom field's date, got %v %v", date, err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(shipDateMetadataKey, "2026-10-20"))
+	if date, err = shipDateFromRequest(ctx, values); err != nil || date.Format("2006-01-02") != "2026-10-20" {
+		t.Fatalf("expected the metadata date to win, got %v %v", date, err)
+	}
+	if date, err = shipDateFromRequest(context.Background(), nil); err != nil || !date.IsZero() {
+		t.Fatalf("expected no ship date, got %v %v", date, err)
+	}
+	if _, err = shipDateFromRequest(context.Background(), map[string]string{fieldShipDate: "19/10/2026"}); err == nil {
+		t.Fatal("expected an invalid date to be rejected")
+	}
+}
+
+func TestDeliveryEstimateShipOn(t *testing.T) {
+	schedule := database.DeliverySchedule{HandlingDays: 2, TimeZone: "America/Toronto"}
+	// Friday October 16, 2026.
+	estimate := newDeliveryEstimate(time.Date(2026, time.October, 16, 15, 0, 0, 0, time.UTC), schedule, "ON", canadaPostDestination{Country: "CA", Province: "ON"})
+
+	// Printed on Friday for drop-off on Saturday: mailed on Monday, without
+	// the handling days.
+	shipped, err := estimate.shipOn(time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC))
+	if err != nil || shipped.MailingDate.Format("2006-01-02") != "2026-10-19" {
+		t.Fatalf("expected mailing on Monday, got %v %v", shipped.MailingDate, err)
+	}
+	for _, date := range []time.Time{
+		time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, time.November, 16, 0, 0, 0, 0, time.UTC),
+	} {
+		if _, err := estimate.shipOn(date); err == nil {
+			t.Fatalf("expected %s to be rejected", date.Format("2006-01-02"))
+		}
+	}
+
+	now := time.Date(2026, time.October, 19, 3, 0, 0, 0, time.UTC) // still the 18th in Toronto
+	if got := upcomingMailingDate("2026-10-18", now, schedule); got != "2026-10-18" {
+		t.Fatalf("expected today's date to be kept, got %q", got)
+	}
+	if got := upcomingMailingDate("2026-10-17", now, schedule); got != "" {
+		t.Fatalf("expected a past date to be dropped, got %q", got)
+	}
+}
+
+func TestContractShipmentExpectedMailingDate(t *testing.T) {
+	contract := buildContractShipmentRequest(&ShipmentRequest{RequestedShippingPoint: "K1A0B1"}, database.ShippingSettings{ContractID: "0040662505"}, time.Now())
+	contract.ExpectedMailingDate = "2026-10-19"
+	out, err := xml.Marshal(contract)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if want := "<requested-shipping-point>K1A0B1</requested-shipping-point><expected-mailing-date>2026-10-19</expected-mailing-date><delivery-spec>"; !strings.Contains(string(out), want) {
+		t.Fatalf("expected %s in %s", want, out)
+	}
+}
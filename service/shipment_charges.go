@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"lexmodo-plugin/config"
+	"lexmodo-plugin/database"
+)
+
+// fetchShipmentCharges reads what Canada Post billed for a newly created
+// shipment: the receipt for non-contract shipments and the price for contract
+// shipments.
+func (s *Server) fetchShipmentCharges(ctx context.Context, shipment *ShipmentResponse) (database.LabelCharges, error) {
+	if shipment == nil {
+		return database.LabelCharges{}, fmt.Errorf("shipment is nil")
+	}
+	link := shipmentChargesLink(shipment)
+	if link == "" {
+		return database.LabelCharges{}, fmt.Errorf("receipt link not found in response")
+	}
+	return fetchCharges(ctx, s.CanadaPost, shipment.GroupID != "", link)
+}
+
+// shipmentChargesLink is the receipt or price link of a shipment, stored
+// with its label so that charges that failed to fetch can be fetched later.
+func shipmentChargesLink(shipment *ShipmentResponse) string {
+	if shipment == nil {
+		return ""
+	}
+	for _, link := range shipment.Links.Link {
+		if link.Rel == "receipt" || link.Rel == "price" {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// fetchCharges reads the price of a contract shipment or the receipt of a
+// non-contract one.
+func fetchCharges(ctx context.Context, cpClient *CanadaPostClient, contract bool, link string) (database.LabelCharges, error) {
+	if contract {
+		price, err := cpClient.GetShipmentPrice(ctx, link)
+		if err != nil {
+			return database.LabelCharges{}, err
+		}
+		return chargesFromPrice(price, time.Now().UTC()), nil
+	}
+	receipt, err := cpClient.GetShipmentReceipt(ctx, link)
+	if err != nil {
+		return database.LabelCharges{}, err
+	}
+	return chargesFromReceipt(receipt, time.Now().UTC()), nil
+}
+
+func chargesFromReceipt(receipt *ShipmentReceiptXML, now time.Time) database.LabelCharges {
+	charges := database.LabelCharges{
+		BaseCents:    amountToCents(receipt.BaseAmount),
+		TaxesCents:   amountToCents(receipt.GSTAmount + receipt.PSTAmount + receipt.HSTAmount),
+		OptionsCents: pricedOptionsCents(receipt.PricedOptions.PricedOption),
+		BilledAt:     now,
+	}
+	// The card charge is the amount actually paid; without it the due amount
+	// is the pre-tax amount plus taxes.
+	if receipt.CCReceiptDetails.ChargeAmount > 0 {
+		charges.DueCents = amountToCents(receipt.CCReceiptDetails.ChargeAmount)
+	} else {
+		charges.DueCents = amountToCents(receipt.PreTaxAmount) + charges.TaxesCents
+	}
+	return charges
+}
+
+func chargesFromPrice(price *ShipmentPriceXML, now time.Time) database.LabelCharges {
+	return database.LabelCharges{
+		BaseCents:    amountToCents(price.BaseAmount),
+		TaxesCents:   amountToCents(price.GSTAmount + price.PSTAmount + price.HSTAmount),
+		OptionsCents: pricedOptionsCents(price.PricedOptions.PricedOption),
+		DueCents:     amountToCents(price.DueAmount),
+		BilledAt:     now,
+	}
+}
+
+func pricedOptionsCents(options []PricedOption) int64 {
+	total := int64(0)
+	for _, opt := range options {
+		total += amountToCents(opt.OptionPrice)
+	}
+	return total
+}
+
+func amountToCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// chargesBackfillInterval is how often labels whose charges failed to fetch
+// at creation are fetched again, chargesBackfillWindow how far back, and
+// maxChargesFetchAttempts how many times before a label is given up on.
+const (
+	chargesBackfillInterval = time.Hour
+	chargesBackfillWindow   = 30 * 24 * time.Hour
+	maxChargesFetchAttempts = 5
+)
+
+// ChargesBackfill fetches the billed charges of labels saved without them,
+// so that they are reconciled instead of staying missing.
+type ChargesBackfill struct {
+	cpClient  *CanadaPostClient
+	store     *database.Store
+	batchSize int
+}
+
+func NewChargesBackfill(store *database.Store, cfg config.Config) *ChargesBackfill {
+	return &ChargesBackfill{
+		cpClient: NewCanadaPostClient(
+			cfg.CanadaPost.Username,
+			cfg.CanadaPost.Password,
+			cfg.CanadaPost.CustomerNumber,
+			cfg.CanadaPost.BaseURL,
+		),
+		store:     store,
+		batchSize: 100,
+	}
+}
+
+// Start backfills right away and then every chargesBackfillInterval until
+// ctx is cancelled.
+func (b *ChargesBackfill) Start(ctx context.Context) {
+	if b == nil || b.store == nil {
+		return
+	}
+	log.Printf("charges backfill started: interval=%s", chargesBackfillInterval)
+
+	wait := time.Duration(0)
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		b.Backfill(ctx)
+		wait = chargesBackfillInterval
+	}
+}
+
+// Backfill fetches the charges of a batch of unbilled labels and returns how
+// many were stored. Labels that fail again are recorded and left for a later
+// pass, after the labels tried less often.
+func (b *ChargesBackfill) Backfill(ctx context.Context) int {
+	records, err := b.store.LoadUnbilledLabelRecords(time.Now().Add(-chargesBackfillWindow), maxChargesFetchAttempts, b.batchSize)
+	if err != nil {
+		log.Println("charges backfill: failed to load labels:", err)
+		return 0
+	}
+	saved := 0
+	for _, record := range records {
+		if ctx.Err() != nil {
+			break
+		}
+		charges, err := fetchCharges(ctx, b.cpClient, record.GroupID != "", record.ChargesLink)
+		if err != nil {
+			log.Printf("charges backfill: failed to fetch shipment charges: label_id=%s err=%v", record.ID, err)
+			if err := b.store.RecordLabelChargesAttempt(record.ID); err != nil {
+				log.Printf("charges backfill: failed to record attempt: label_id=%s err=%v", record.ID, err)
+			}
+			continue
+		}
+		if err := b.store.SaveLabelCharges(record.ID, charges); err != nil {
+			log.Printf("charges backfill: failed to store shipment charges: label_id=%s err=%v", record.ID, err)
+			continue
+		}
+		if charges.DueCents != record.ShippingChargesCents {
+			log.Printf("shipment charges differ from quote: label_id=%s quoted_cents=%d billed_cents=%d", record.ID, record.ShippingChargesCents, charges.DueCents)
+		}
+		saved++
+	}
+	if len(records) > 0 {
+		log.Printf("charges backfill: %d of %d labels billed", saved, len(records))
+	}
+	return saved
+}
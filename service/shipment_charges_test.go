@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"lexmodo-plugin/database"
+)
+
+func TestFetchShipmentChargesReadsReceipt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rs/0001234567/ncshipment/123/receipt" {
+			t.Fatalf("unexpected request %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Accept"); got != "application/vnd.cpc.ncshipment-v4+xml" {
+			t.Fatalf("unexpected accept %q", got)
+		}
+		_, _ = w.Write([]byte(`<non-contract-shipment-receipt xmlns="http://www.canadapost.ca/ws/ncshipment-v4">
+  <service-code>DOM.EP</service-code>
+  <rated-weight>2.000</rated-weight>
+  <base-amount>9.59</base-amount>
+  <pre-tax-amount>10.95</pre-tax-amount>
+  <gst-amount>0.00</gst-amount>
+  <pst-amount>0.00</pst-amount>
+  <hst-amount>1.42</hst-amount>
+  <priced-options>
+    <priced-option><option-code>SO</option-code><option-price>0.52</option-price></priced-option>
+  </priced-options>
+  <adjustments>
+    <adjustment><adjustment-code>FUELSC</adjustment-code><adjustment-amount>0.84</adjustment-amount></adjustment>
+  </adjustments>
+</non-contract-shipment-receipt>`))
+	}))
+	defer server.Close()
+
+	s := &Server{CanadaPost: NewCanadaPostClient("user", "pass", "0001234567", server.URL)}
+	shipment := &ShipmentResponse{}
+	shipment.Links.Link = []Link{{Rel: "receipt", Href: server.URL + "/rs/0001234567/ncshipment/123/receipt"}}
+	charges, err := s.fetchShipmentCharges(context.Background(), shipment)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if charges.BaseCents != 959 || charges.OptionsCents != 52 || charges.TaxesCents != 142 || charges.DueCents != 1237 {
+		t.Fatalf("unexpected charges: %+v", charges)
+	}
+	if charges.BilledAt.IsZero() {
+		t.Fatalf("expected billed time to be set")
+	}
+}
+
+func TestChargesFromPriceUsesDueAmount(t *testing.T) {
+	price := &ShipmentPriceXML{BaseAmount: 12.5, GSTAmount: 0.5, PSTAmount: 0.25, DueAmount: 14.1}
+	charges := chargesFromPrice(price, time.Now())
+	if charges.BaseCents != 1250 || charges.TaxesCents != 75 || charges.DueCents != 1410 {
+		t.Fatalf("unexpected charges: %+v", charges)
+	}
+}
+
+func TestShipmentChargesLink(t *testing.T) {
+	shipment := &ShipmentResponse{}
+	shipment.Links.Link = []Link{{Rel: "self", Href: "https://ct.soa-gw.canadapost.ca/rs/1/1/shipment/9"}, {Rel: "price", Href: "https://ct.soa-gw.canadapost.ca/rs/1/1/shipment/9/price"}}
+	if got := shipmentChargesLink(shipment); got != "https://ct.soa-gw.canadapost.ca/rs/1/1/shipment/9/price" {
+		t.Fatalf("expected the price link, got %q", got)
+	}
+	shipment.Links.Link = shipment.Links.Link[:1]
+	if got := shipmentChargesLink(shipment); got != "" {
+		t.Fatalf("expected no link, got %q", got)
+	}
+}
+
+func TestChargesBackfillRecordsFailedAttempt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	backfill := &ChargesBackfill{
+		cpClient:  NewCanadaPostClient("user", "pass", "0001234567", server.URL),
+		store:     &database.Store{DB: db},
+		batchSize: 100,
+	}
+
+	// Only labels tried fewer than maxChargesFetchAttempts times are loaded,
+	// and a failed fetch counts as an attempt.
+	mock.ExpectQuery("FROM label_records").WithArgs(database.ManifestStatusVoided, sqlmock.AnyArg(), maxChargesFetchAttempts, 100).WillReturnRows(labelRecordRows(map[string]driver.Value{
+		"id":           "LBL1",
+		"charges_link": server.URL + "/rs/0001234567/ncshipment/123/receipt",
+	}))
+	mock.ExpectExec("SET charges_attempts = charges_attempts \\+ 1").WithArgs("LBL1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if saved := backfill.Backfill(context.Background()); saved != 0 {
+		t.Fatalf("expected nothing saved, got %d", saved)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -37,11 +37,13 @@ import (
 // ============================
 type Server struct {
 	shippingpluginpb.UnimplementedShippingsServer
-	Store         *database.Store
-	Config        config.Config
-	CanadaPost    *CanadaPostClient
-	RateSnapshots *RateSnapshotStore
-	PostOffices   *PostOfficeService
+	Store          *database.Store
+	Config         config.Config
+	CanadaPost     *CanadaPostClient
+	RateSnapshots  *RateSnapshotStore
+	RateQuotes     *RateQuoteCache
+	PostOffices    *PostOfficeService
+	CustomerEmails CustomerEmailLookup
 }
 
 func NewServer(store *database.Store, cfg config.Config) *Server {
@@ -55,12 +57,17 @@ func NewServer(store *database.Store, cfg config.Config) *Server {
 	if store != nil {
 		postOffices = NewPostOfficeService(canadaPost, store.DB)
 	}
+	if strings.TrimSpace(cfg.AppSecret) == "" {
+		log.Println("⚠️ oauth.app_secret is not set: CreateLabel will not return artifact URLs such as commercial invoices")
+	}
 	return &Server{
-		Store:         store,
-		Config:        cfg,
-		CanadaPost:    canadaPost,
-		RateSnapshots: NewRateSnapshotStore(cfg.Redis),
-		PostOffices:   postOffices,
+		Store:          store,
+		Config:         cfg,
+		CanadaPost:     canadaPost,
+		RateSnapshots:  NewRateSnapshotStore(cfg.Redis),
+		RateQuotes:     NewRateQuoteCache(cfg.Redis),
+		PostOffices:    postOffices,
+		CustomerEmails: ordersCustomerEmails{addr: cfg.OrdersGRPCAddr},
 	}
 }
 
@@ -300,41 +307,88 @@ type rateCandidate struct {
 	ServiceCode            string
 	ServiceName            string
 	PriceCents             int64
+	CarrierCents           int64
 	DeliveryDate           string
 	DeliveryDays           uint32
 	DeliveryDateGuaranteed bool
-}
-
-const ouncesPerKilogram = 35.27396195
-
-func ouncesToKilograms(ounces float64) float64 {
-	if ounces <= 0 {
-		return 0
-	}
-	return math.Round(ounces/ouncesPerKilogram*100) / 100
+	Estimated              bool // from the fallback table, not Canada Post
 }
 
 func hasPositiveDimensions(length, width, height float64) bool {
 	return length > 0 && width > 0 && height > 0
 }
 
-func buildParcelsFromLabelRequest(parcel *labels.Parcel) (parcelMetrics, error) {
+// parcelDimensions returns the parcel's dimensions for Canada Post, or nil
+// when they are incomplete.
+func parcelDimensions(parcel parcelMetrics) *Dimensions {
+	if !hasPositiveDimensions(parcel.Length, parcel.Width, parcel.Height) {
+		return nil
+	}
+	return &Dimensions{
+		Length: centimetres(parcel.Length),
+		Width:  centimetres(parcel.Width),
+		Height: centimetres(parcel.Height),
+	}
+}
+
+// buildParcelsFromLabelRequest converts the request's parcel from units to
+// kg and cm. Values are kept unrounded; Canada Post's precision is applied
+// when the XML is written.
+func buildParcelsFromLabelRequest(parcel *labels.Parcel, units requestUnits) (parcelMetrics, error) {
 	if parcel == nil {
 		return parcelMetrics{}, errors.New("parcel is required")
 	}
 	if parcel.GetWeight() <= 0 {
 		return parcelMetrics{}, errors.New("parcel weight is required")
 	}
-	// Convert ounces (request payload) to kilograms (Canada Post).
-	weightKg := ouncesToKilograms(float64(parcel.GetWeight()))
+	dims := parcel.GetParcelDimensions()
 	return parcelMetrics{
-		Weight: weightKg,
-		Length: float64(parcel.GetParcelDimensions().GetLength()),
-		Width:  float64(parcel.GetParcelDimensions().GetWidth()),
-		Height: float64(parcel.GetParcelDimensions().GetHeight()),
+		Weight: measuredWeight{Value: float64(parcel.GetWeight()), Unit: units.Weight}.kilograms(),
+		Length: measuredLength{Value: float64(dims.GetLength()), Unit: units.Length}.centimetres(),
+		Width:  measuredLength{Value: float64(dims.GetWidth()), Unit: units.Length}.centimetres(),
+		Height: measuredLength{Value: float64(dims.GetHeight()), Unit: units.Length}.centimetres(),
 	}, nil
 }
 
+// newRateRequest is the mailing scenario for parcel, priced with the
+// client's contract when contract shipping is enabled.
+func (s *Server) newRateRequest(settings database.ShippingSettings, origin canadaPostOrigin, dest canadaPostDestination, parcel parcelMetrics) *RateRequest {
+	payload := &RateRequest{}
+	if strings.TrimSpace(s.Config.CanadaPost.CustomerNumber) != "" {
+		payload.CustomerNumber = s.Config.CanadaPost.CustomerNumber
+	}
+	if contractShippingEnabled(settings) {
+		payload.CustomerNumber = s.contractCustomerNumber(settings)
+		payload.ContractID = strings.TrimSpace(settings.ContractID)
+	}
+	payload.OriginPostalCode = origin.PostalCode
+	payload.ParcelCharacteristics.Weight = kilograms(parcel.Weight)
+	payload.ParcelCharacteristics.Dimensions = parcelDimensions(parcel)
+	switch strings.ToUpper(strings.TrimSpace(dest.Country)) {
+	case "CA":
+		payload.Destination.Domestic = &struct {
+			PostalCode string `xml:"postal-code"`
+		}{
+			PostalCode: dest.PostalCode,
+		}
+
+	case "US":
+		payload.Destination.UnitedStates = &struct {
+			ZipCode string `xml:"zip-code"`
+		}{
+			ZipCode: dest.PostalCode,
+		}
+
+	default:
+		payload.Destination.International = &struct {
+			CountryCode string `xml:"country-code"`
+		}{
+			CountryCode: dest.Country,
+		}
+	}
+	return payload
+}
+
 // ============================
 // Rates
 // ============================
@@ -365,23 +419,14 @@ func (s *Server) fetchRatesFromAPI(ctx context.Context, req *shippingpluginpb.Sh
 	}
 	currencyCode := resolveRequestCurrency(ctx, req)
 	rateToCad := 1.0
+	var fxRate database.ExchangeRate
 	if currencyCode != "CAD" {
-		if clientID == 0 {
-			return nil, errors.New("client_id required for currency conversion")
-		}
-		if s.Store == nil {
-			return nil, errors.New("currency rates store not configured")
-		}
-		rate, ok, err := s.Store.LoadCurrencyRate(clientID, currencyCode)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load currency rate: %w", err)
-		}
-		if !ok {
-			return nil, fmt.Errorf("missing conversion rate for %s. set it in settings", currencyCode)
+		var err error
+		if fxRate, err = s.exchangeRate(clientID, currencyCode); err != nil {
+			return nil, err
 		}
-		rateToCad = rate
+		rateToCad = fxRate.RateToCad
 	}
-	log.Printf("currency conversion: client_id=%d currency=%s rate_to_cad=%.6f", clientID, currencyCode, rateToCad)
 
 	origin := mapCanadaPostOrigin(shipRequest.GetShipper())
 	dest := mapCanadaPostDestination(shipRequest.GetCustomer())
@@ -396,47 +441,27 @@ func (s *Server) fetchRatesFromAPI(ctx context.Context, req *shippingpluginpb.Sh
 		return nil, err
 	}
 
-	parcel, err := buildParcelsFromLabelRequest(shipRequest.GetParcel())
+	pieces, err := s.parcelPiecesFromRequest(clientID, shipRequest.GetParcel(), customValues)
 	if err != nil {
 		return nil, err
 	}
+	parcel := pieces[0]
 
-	payload := &RateRequest{}
-	if strings.TrimSpace(s.Config.CanadaPost.CustomerNumber) != "" {
-		payload.CustomerNumber = s.Config.CanadaPost.CustomerNumber
+	if len(pieces) > 1 && strings.ToUpper(strings.TrimSpace(dest.Country)) != "CA" {
+		return nil, errors.New("multi-piece shipments are only available within Canada")
 	}
-	payload.OriginPostalCode = origin.PostalCode
-	payload.ParcelCharacteristics.Weight = parcel.Weight
-	if hasPositiveDimensions(parcel.Length, parcel.Width, parcel.Height) {
-		payload.ParcelCharacteristics.Dimensions = &Dimensions{
-			Length: parcel.Length,
-			Width:  parcel.Width,
-			Height: parcel.Height,
-		}
+	payload := s.newRateRequest(settings, origin, dest, parcel)
+	estimate := newDeliveryEstimate(time.Now(), settings.DeliverySchedule(), origin.Province, dest)
+	shipDate, err := shipDateFromRequest(ctx, customValues)
+	if err != nil {
+		return nil, err
 	}
-	country := strings.ToUpper(strings.TrimSpace(dest.Country))
-	switch country {
-	case "CA":
-		payload.Destination.Domestic = &struct {
-			PostalCode string `xml:"postal-code"`
-		}{
-			PostalCode: dest.PostalCode,
-		}
-
-	case "US":
-		payload.Destination.UnitedStates = &struct {
-			ZipCode string `xml:"zip-code"`
-		}{
-			ZipCode: dest.PostalCode,
-		}
-
-	default:
-		payload.Destination.International = &struct {
-			CountryCode string `xml:"country-code"`
-		}{
-			CountryCode: dest.Country,
+	if !shipDate.IsZero() {
+		if estimate, err = estimate.shipOn(shipDate); err != nil {
+			return nil, err
 		}
 	}
+	payload.ExpectedMailingDate = estimate.MailingDate.Format("2006-01-02")
 
 	rateOptions, err := buildGetRatesOptions(customValues, rateToCad, shipRequest.GetSignature().String())
 	if err != nil {
@@ -450,15 +475,32 @@ func (s *Server) fetchRatesFromAPI(ctx context.Context, req *shippingpluginpb.Sh
 
 	log.Printf("canada post rates request payload:\n%s\n", string(body))
 
-	apiRates, err := s.CanadaPost.GetRates(ctx, payload)
-	if err != nil {
+	var candidates []rateCandidate
+	apiRates, piecePrices, err := s.getCachedPieceRates(ctx, payload, pieces)
+	switch {
+	case err == nil:
+		candidates = mapAPIRates(apiRates)
+	case canadaPostUnavailable(err):
+		candidates = fallbackRateCandidates(s.loadFallbackRates(clientID), dest, combinedParcel(pieces).Weight)
+		if len(candidates) == 0 {
+			return nil, err
+		}
+		log.Printf("serving fallback rates: client_id=%d services=%d err=%v", clientID, len(candidates), err)
+	default:
 		return nil, err
 	}
-
-	candidates := mapAPIRates(apiRates)
+	candidates = estimate.apply(candidates)
+	candidates = filterRateCandidatesByWeight(candidates, pieces)
 	if len(settings.EnabledServices) > 0 {
 		candidates = filterRateCandidatesByService(candidates, settings.EnabledServices)
 	}
+	candidates = applyRateAdjustments(candidates, s.loadRateAdjustments(clientID), rateOptions)
+	candidates = applyRateRules(candidates, s.loadRateRules(clientID), rateRuleContext{
+		SubtotalCents: convertCurrencyToCadCents(orderSubtotalCents(shipRequest.GetParcel()), rateToCad),
+		Weight:        combinedParcel(pieces).Weight,
+		Country:       dest.Country,
+		Province:      dest.Province,
+	})
 
 	rates := make([]*shippingpluginpb.ShippingRate, 0, len(candidates))
 	for _, candidate := range candidates {
@@ -479,22 +521,32 @@ func (s *Server) fetchRatesFromAPI(ctx context.Context, req *shippingpluginpb.Sh
 			ServiceCode:   candidate.ServiceCode,
 			ServiceName:   candidate.ServiceName,
 			PriceCents:    candidate.PriceCents,
+			CarrierCents:  candidate.CarrierCents,
 			CurrencyCode:  currencyCode,
 			RateToCad:     rateToCad,
+			FXRateID:      fxRate.ID,
+			FXRateDate:    fxRateDate(fxRate),
 			DeliveryDate:  candidate.DeliveryDate,
+			DeliveryDays:  candidate.DeliveryDays,
+			MailingDate:   payload.ExpectedMailingDate,
+			Estimated:     candidate.Estimated,
 			Signature:     shipRequest.GetSignature().String(),
 			CustomOptions: cloneOptionsMap(customValues),
 			Shipper:       snapshotAddress(shipRequest.GetShipper()),
 			Customer:      snapshotAddress(shipRequest.GetCustomer()),
 			Origin:        origin,
 			Destination:   dest,
-			Parcel:        parcel,
-			CustomsInfo:   snapshotCustoms(shipRequest.GetCustomsInfo()),
+			Parcel:        combinedParcel(pieces),
+			CustomsInfo:   snapshotCustoms(shipRequest.GetCustomsInfo(), s.requestUnits()),
 			Insurance:     snapshotInsurance(shipRequest.GetInsurance()),
 			InvoiceUUID:   shipRequest.GetInvoiceUuid(),
 			ClientID:      clientID,
 			CreatedAt:     time.Now().UTC(),
 		}
+		if len(pieces) > 1 {
+			snapshot.Pieces = pieces
+			snapshot.PiecePrices = piecePrices[candidate.ServiceCode]
+		}
 		if s.RateSnapshots != nil {
 			if err := s.RateSnapshots.Save(ctx, snapshot); err != nil {
 				logSnapshotStoreError(rateID, err)
@@ -637,47 +689,6 @@ func filterRateCandidatesByService(candidates []rateCandidate, enabled map[strin
 	return filtered
 }
 
-func fallbackServiceName(serviceCode string) string {
-	switch strings.TrimSpace(strings.ToUpper(serviceCode)) {
-	case "DOM.RP":
-		return "Regular Parcel"
-	case "DOM.EP":
-		return "Expedited Parcel"
-	case "DOM.XP":
-		return "Xpresspost"
-	case "DOM.XP.CERT":
-		return "Xpresspost Certified"
-	case "DOM.PC":
-		return "Priority"
-	case "DOM.LIB":
-		return "Library Materials"
-	case "USA.EP":
-		return "Expedited Parcel USA"
-	case "USA.SP.AIR":
-		return "Small Packet USA Air"
-	case "USA.TP":
-		return "Tracked Packet USA"
-	case "USA.TP.LVM":
-		return "Tracked Packet USA (LVM)"
-	case "USA.XP":
-		return "Xpresspost USA"
-	case "INT.XP":
-		return "Xpresspost International"
-	case "INT.IP.AIR":
-		return "International Parcel Air"
-	case "INT.IP.SURF":
-		return "International Parcel Surface"
-	case "INT.SP.AIR":
-		return "Small Packet International Air"
-	case "INT.SP.SURF":
-		return "Small Packet International Surface"
-	case "INT.TP":
-		return "Tracked Packet International"
-	default:
-		return strings.TrimSpace(serviceCode)
-	}
-}
-
 // ============================
 // Shipment
 // ============================
@@ -708,7 +719,7 @@ func (s *Server) createShipmentFromAPI(ctx context.Context, shipRequest *labels.
 		}
 	}
 
-	parcel, err := buildParcelsFromLabelRequest(shipRequest.GetParcel())
+	parcel, err := buildParcelsFromLabelRequest(shipRequest.GetParcel(), s.requestUnits())
 	if err != nil {
 		log.Printf("❌ Parcel build failed: %v\n", err)
 		return nil, err
@@ -769,10 +780,36 @@ func (s *Server) createShipmentFromSnapshot(ctx context.Context, snapshot RateSn
 		}
 	}
 
+	settings := database.ShippingSettings{}
+	if s.Store != nil && snapshot.ClientID > 0 {
+		loaded, err := s.Store.LoadShippingSettings(snapshot.ClientID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load shipping settings: %w", err)
+		}
+		settings = loaded
+	}
+
 	payload := buildShipmentRequestFromSnapshot(snapshot, destCountry, options, notification)
+	payload.DeliverySpec.PrintPreferences = buildPrintPreferences(settings.LabelPreferences())
 	body, _ := json.Marshal(payload)
 	log.Printf("canada post shipment request payload: %s\n", string(body))
 
+	if contractShippingEnabled(settings) {
+		log.Printf("canada post contract shipment: client_id=%d contract_id=%s", snapshot.ClientID, settings.ContractID)
+		contractPayload := buildContractShipmentRequest(payload, settings, time.Now())
+		contractPayload.ExpectedMailingDate = upcomingMailingDate(snapshot.MailingDate, time.Now(), settings.DeliverySchedule())
+		shipment, err := s.CanadaPost.CreateContractShipment(ctx, s.contractCustomerNumber(settings), contractPayload)
+		if err != nil {
+			return nil, err
+		}
+		if s.Store != nil {
+			if err := s.Store.SaveManifestAddress(snapshot.ClientID, manifestAddressFromSender(contractPayload.DeliverySpec.ShipmentDeliverySpec)); err != nil {
+				log.Println("failed to save manifest address:", err)
+			}
+		}
+		return shipment, nil
+	}
+
 	return s.CanadaPost.CreateShipment(ctx, payload)
 }
 
@@ -801,14 +838,8 @@ func buildShipmentRequest(origin canadaPostOrigin, dest canadaPostDestination, p
 	payload.DeliverySpec.Destination.AddressDetails.CountryCode = defaultValue(dest.Country, "")
 	payload.DeliverySpec.Destination.AddressDetails.PostalCode = defaultValue(dest.PostalCode, "")
 
-	payload.DeliverySpec.ParcelCharacteristics.Weight = parcel.Weight
-	if hasPositiveDimensions(parcel.Length, parcel.Width, parcel.Height) {
-		payload.DeliverySpec.ParcelCharacteristics.Dimensions = &Dimensions{
-			Length: parcel.Length,
-			Width:  parcel.Width,
-			Height: parcel.Height,
-		}
-	}
+	payload.DeliverySpec.ParcelCharacteristics.Weight = kilograms(parcel.Weight)
+	payload.DeliverySpec.ParcelCharacteristics.Dimensions = parcelDimensions(parcel)
 	payload.DeliverySpec.Preferences.ShowPackingInstructions = true
 	return payload
 }
@@ -845,14 +876,8 @@ func buildShipmentRequestFromSnapshot(snapshot RateSnapshot, destCountry string,
 	payload.DeliverySpec.Destination.AddressDetails.CountryCode = defaultValue(defaultValue(snapshot.Customer.CountryCode, snapshot.Customer.Country), snapshot.Destination.Country)
 	payload.DeliverySpec.Destination.AddressDetails.PostalCode = defaultValue(snapshot.Customer.Zip, snapshot.Destination.PostalCode)
 
-	payload.DeliverySpec.ParcelCharacteristics.Weight = snapshot.Parcel.Weight
-	if hasPositiveDimensions(snapshot.Parcel.Length, snapshot.Parcel.Width, snapshot.Parcel.Height) {
-		payload.DeliverySpec.ParcelCharacteristics.Dimensions = &Dimensions{
-			Length: snapshot.Parcel.Length,
-			Width:  snapshot.Parcel.Width,
-			Height: snapshot.Parcel.Height,
-		}
-	}
+	payload.DeliverySpec.ParcelCharacteristics.Weight = kilograms(snapshot.Parcel.Weight)
+	payload.DeliverySpec.ParcelCharacteristics.Dimensions = parcelDimensions(snapshot.Parcel)
 	if notification != nil {
 		payload.DeliverySpec.Notification = notification
 	}
@@ -913,7 +938,7 @@ func buildShipmentCustoms(info *customsSnapshot, fallbackCurrency string, rateTo
 		customs.SkuList.Item = append(customs.SkuList.Item, ShipmentCustomsItem{
 			CustomsNumberOfUnits: units,
 			CustomsDescription:   defaultValue(item.Description, item.Code),
-			UnitWeight:           item.Weight,
+			UnitWeight:           kilograms(item.Weight),
 			CustomsValuePerUnit:  valuePerUnit,
 			HSTariffCode:         item.HSTariffNumber,
 			SKU:                  item.Code,
@@ -926,7 +951,11 @@ func buildShipmentCustoms(info *customsSnapshot, fallbackCurrency string, rateTo
 // ============================
 // Label
 // ============================
-func (s *Server) buildLabelURL(labelID string) string {
+func buildPrintPreferences(prefs database.LabelPreferences) *PrintPreferences {
+	return &PrintPreferences{OutputFormat: prefs.PaperSize, Encoding: prefs.Encoding}
+}
+
+func (s *Server) buildLabelURL(labelID string, extension string) string {
 	labelID = strings.TrimSpace(labelID)
 	if labelID == "" {
 		labelID = generateLabelID()
@@ -936,7 +965,7 @@ func (s *Server) buildLabelURL(labelID string) string {
 	if baseURL == "" {
 		baseURL = "http://localhost:50050"
 	}
-	return fmt.Sprintf("%s/labels/%s.pdf", baseURL, labelID)
+	return fmt.Sprintf("%s/labels/%s.%s", baseURL, labelID, extension)
 }
 
 // ============================
@@ -1016,20 +1045,6 @@ func defaultValue(value string, fallback string) string {
 
 const defaultNonDeliveryOption = "RASE"
 
-var clientVoiceRequiredServices = map[string]struct{}{
-	"USA.EP": {},
-	"USA.XP": {},
-	"USA.TP": {},
-	"INT.XP": {},
-	"INT.TP": {},
-}
-
-func requiresClientVoice(serviceCode string) bool {
-	serviceCode = strings.TrimSpace(strings.ToUpper(serviceCode))
-	_, ok := clientVoiceRequiredServices[serviceCode]
-	return ok
-}
-
 func requiresCustoms(countryCode string) bool {
 	code := strings.TrimSpace(strings.ToUpper(countryCode))
 	return code != "" && code != "CA"
@@ -1,6 +1,13 @@
 package service
 
-import "testing"
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"lexmodo-plugin/database"
+)
 
 func TestValidateCanadaPostPhone_AllowsPlaceholder(t *testing.T) {
 	if err := validateCanadaPostPhone("0000000000"); err != nil {
@@ -139,3 +146,54 @@ func TestBuildShipmentRequestFromSnapshot_D2POIncludesClientVoiceAndNotification
 		t.Fatalf("expected notification email to be set, got %q", req.DeliverySpec.Notification.Email)
 	}
 }
+
+func TestBuildContractShipmentRequest_SettlementAndGroup(t *testing.T) {
+	payload := &ShipmentRequest{RequestedShippingPoint: "K1A0B1"}
+	payload.DeliverySpec.ServiceCode = "DOM.EP"
+	settings := database.ShippingSettings{ContractID: "0040662505", PaymentMethod: "creditcard"}
+
+	contract := buildContractShipmentRequest(payload, settings, time.Date(2026, 2, 3, 10, 0, 0, 0, time.UTC))
+	if contract.GroupID != "20260203" {
+		t.Fatalf("expected date group id, got %q", contract.GroupID)
+	}
+	out, err := xml.Marshal(contract)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	body := string(out)
+	for _, want := range []string{
+		"<group-id>20260203</group-id>",
+		"<service-code>DOM.EP</service-code>",
+		"<country-code>CA</country-code>",
+		"<contract-id>0040662505</contract-id>",
+		"<intended-method-of-payment>CreditCard</intended-method-of-payment>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %s in %s", want, body)
+		}
+	}
+}
+
+func TestBuildContractShipmentRequest_PrintPreferences(t *testing.T) {
+	settings := database.ShippingSettings{ContractID: "0040662505", LabelPaperSize: "4x6", LabelEncoding: "zpl"}
+	payload := &ShipmentRequest{RequestedShippingPoint: "K1A0B1"}
+	payload.DeliverySpec.PrintPreferences = buildPrintPreferences(settings.LabelPreferences())
+
+	out, err := xml.Marshal(buildContractShipmentRequest(payload, settings, time.Now()))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if want := "<print-preferences><output-format>4x6</output-format><encoding>ZPL</encoding></print-preferences>"; !strings.Contains(string(out), want) {
+		t.Fatalf("expected %s in %s", want, out)
+	}
+}
+
+func TestLabelPreferences_ZPLRequiresThermalPaper(t *testing.T) {
+	if _, err := database.NormalizeLabelPreferences(database.LabelPreferences{PaperSize: "8.5x11", Encoding: "ZPL"}); err == nil {
+		t.Fatalf("expected ZPL on 8.5x11 to be rejected")
+	}
+	prefs := database.ShippingSettings{LabelEncoding: "ZPL"}.LabelPreferences()
+	if prefs.PaperSize != "8.5x11" || prefs.Encoding != "PDF" || prefs.Output != "inline" {
+		t.Fatalf("expected defaults for invalid stored preferences, got %+v", prefs)
+	}
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"lexmodo-plugin/config"
+	"lexmodo-plugin/database"
+)
+
+const (
+	trackingStatusInTransit = "in_transit"
+	trackingStatusDelivered = "delivered"
+	trackingStatusException = "exception"
+	trackingStatusReturned  = "returned_to_sender"
+)
+
+// Statuses after which a label no longer needs polling.
+var finalTrackingStatuses = []string{trackingStatusDelivered, trackingStatusReturned}
+
+// TrackingPoller periodically refreshes tracking for in-flight labels and,
+// with a reporter, reports status changes to the orders service.
+type TrackingPoller struct {
+	tracking  *TrackingService
+	store     *database.Store
+	reporter  OrderStatusReporter
+	interval  time.Duration
+	maxAge    time.Duration
+	batchSize int
+}
+
+// NewTrackingPoller stores the status of each in-flight label. It has no
+// reporter, since the orders service has no RPC to take the statuses.
+func NewTrackingPoller(store *database.Store, cfg config.Config) *TrackingPoller {
+	cpClient := NewCanadaPostClient(
+		cfg.CanadaPost.Username,
+		cfg.CanadaPost.Password,
+		cfg.CanadaPost.CustomerNumber,
+		cfg.CanadaPost.BaseURL,
+	)
+	maxAgeDays := cfg.Tracking.MaxAgeDays
+	if maxAgeDays <= 0 {
+		maxAgeDays = 30
+	}
+	return &TrackingPoller{
+		tracking:  NewTrackingService(cpClient, store),
+		store:     store,
+		interval:  time.Duration(cfg.Tracking.PollIntervalMinutes) * time.Minute,
+		maxAge:    time.Duration(maxAgeDays) * 24 * time.Hour,
+		batchSize: cfg.Tracking.BatchSize,
+	}
+}
+
+// Start runs the poll loop until ctx is cancelled. A zero interval disables it.
+func (p *TrackingPoller) Start(ctx context.Context) {
+	if p == nil || p.interval <= 0 {
+		log.Println("tracking poller disabled")
+		return
+	}
+	if p.reporter == nil {
+		log.Println("tracking poller: orders has no shipment status RPC, statuses will only be stored")
+	}
+	log.Printf("tracking poller started: interval=%s", p.interval)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.PollOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *TrackingPoller) PollOnce(ctx context.Context) {
+	// Final labels are only loaded again while their status is unreported,
+	// which needs a reporter.
+	labels, err := p.store.LoadLabelsForTracking(time.Now().Add(-p.maxAge), finalTrackingStatuses, p.reporter != nil, p.batchSize)
+	if err != nil {
+		log.Println("tracking poller: failed to load labels:", err)
+		return
+	}
+	for _, label := range labels {
+		if ctx.Err() != nil {
+			return
+		}
+		p.pollLabel(ctx, label)
+	}
+}
+
+func (p *TrackingPoller) pollLabel(ctx context.Context, label database.LabelRecord) {
+	status := label.TrackingStatus
+	var latest database.TrackingEvent
+	if !isFinalTrackingStatus(status) {
+		callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		result, err := p.tracking.Refresh(callCtx, label.TrackingNumber)
+		cancel()
+		if err != nil {
+			log.Printf("tracking poller: refresh failed pin=%s err=%v", label.TrackingNumber, err)
+			return
+		}
+		if len(result.Events) > 0 {
+			latest = result.Events[0]
+			status = classifyTrackingEvent(latest)
+		}
+	} else if events, err := p.store.LoadTrackingEvents(label.TrackingNumber); err == nil && len(events) > 0 {
+		latest = events[0]
+	}
+	// Saving also stamps tracking_checked_at so unreported final labels do not
+	// hold the front of the batch.
+	if err := p.store.SaveLabelTrackingStatus(label.ID, status); err != nil {
+		log.Printf("tracking poller: failed to save status label_id=%s err=%v", label.ID, err)
+		return
+	}
+
+	if status != "" && status != label.TrackingStatus {
+		log.Printf("tracking poller: status change label_id=%s pin=%s %q -> %q", label.ID, label.TrackingNumber, label.TrackingStatus, status)
+	}
+	if p.reporter == nil || status == "" || status == label.ReportedStatus {
+		return
+	}
+	// A label with no client or invoice can never be reported; it is marked
+	// as handled so that it stops coming back in every batch.
+	if label.ClientID <= 0 || strings.TrimSpace(label.InvoiceUUID) == "" {
+		log.Printf("tracking poller: label_id=%s has no client or invoice, not reporting %q", label.ID, status)
+		if err := p.store.MarkLabelTrackingStatusReported(label.ID, status); err != nil {
+			log.Printf("tracking poller: failed to mark reported label_id=%s err=%v", label.ID, err)
+		}
+		return
+	}
+
+	update := ShipmentStatusUpdate{
+		ClientID:       label.ClientID,
+		InvoiceUUID:    label.InvoiceUUID,
+		LabelID:        label.ID,
+		TrackingNumber: label.TrackingNumber,
+		Status:         status,
+		Description:    latest.Description,
+		OccurredAt:     latest.OccurredAt,
+	}
+	callCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := p.reporter.ReportShipmentStatus(callCtx, update); err != nil {
+		log.Printf("tracking poller: report failed label_id=%s err=%v", label.ID, err)
+		return
+	}
+	if err := p.store.MarkLabelTrackingStatusReported(label.ID, status); err != nil {
+		log.Printf("tracking poller: failed to mark reported label_id=%s err=%v", label.ID, err)
+	}
+}
+
+func isFinalTrackingStatus(status string) bool {
+	for _, final := range finalTrackingStatuses {
+		if status == final {
+			return true
+		}
+	}
+	return false
+}
+
+// Canada Post event identifiers for a completed delivery.
+var deliveredEventIDs = map[string]bool{
+	"1408": true,
+	"1409": true,
+	"1421": true,
+	"1422": true,
+	"1423": true,
+	"1424": true,
+	"1425": true,
+	"1426": true,
+	"1427": true,
+	"1428": true,
+	"1429": true,
+	"1430": true,
+	"1431": true,
+	"1432": true,
+	"1433": true,
+	"1434": true,
+	"1441": true,
+	"1442": true,
+	"1496": true,
+	"1497": true,
+	"1498": true,
+	"1499": true,
+}
+
+// classifyTrackingEvent maps the latest Canada Post event onto the coarse
+// statuses reported to orders.
+func classifyTrackingEvent(ev database.TrackingEvent) string {
+	desc := strings.ToLower(ev.Description)
+	switch {
+	case strings.Contains(desc, "return to sender"),
+		strings.Contains(desc, "returned to sender"),
+		strings.Contains(desc, "being returned"):
+		return trackingStatusReturned
+	case deliveredEventIDs[ev.Identifier]:
+		return trackingStatusDelivered
+	case strings.Contains(desc, "attempt"),
+		strings.Contains(desc, "notice card"),
+		strings.Contains(desc, "unable"),
+		strings.Contains(desc, "could not"),
+		strings.Contains(desc, "undeliver"),
+		strings.Contains(desc, "not delivered"),
+		strings.Contains(desc, "refused"),
+		strings.Contains(desc, "incorrect address"),
+		strings.Contains(desc, "incomplete address"),
+		strings.Contains(desc, "damaged"),
+		strings.Contains(desc, "delay"):
+		return trackingStatusException
+	case strings.Contains(desc, "delivered"):
+		return trackingStatusDelivered
+	default:
+		return trackingStatusInTransit
+	}
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"lexmodo-plugin/database"
+)
+
+// fakeTrackingClient returns canned tracking details per PIN.
+type fakeTrackingClient struct {
+	details map[string]*TrackingDetailXML
+	calls   []string
+}
+
+func (c *fakeTrackingClient) GetTrackingSummary(_ context.Context, pin string) (*PinSummaryXML, error) {
+	return nil, errTrackingNoHistory
+}
+
+func (c *fakeTrackingClient) GetTrackingDetails(_ context.Context, pin string) (*TrackingDetailXML, error) {
+	c.calls = append(c.calls, pin)
+	detail, ok := c.details[pin]
+	if !ok {
+		return nil, errTrackingNoHistory
+	}
+	return detail, nil
+}
+
+// recordingReporter stands in for the orders service.
+type recordingReporter struct {
+	updates []ShipmentStatusUpdate
+	err     error
+}
+
+func (r *recordingReporter) ReportShipmentStatus(_ context.Context, update ShipmentStatusUpdate) error {
+	r.updates = append(r.updates, update)
+	return r.err
+}
+
+// trackingEventRowColumns are the tracking_events columns the store selects.
+var trackingEventRowColumns = strings.Split("tracking_number, event_identifier, event_description, event_site, event_province, signatory_name, event_date, event_time, event_time_zone, occurred_at, created_at", ", ")
+
+func newTestTrackingPoller(t *testing.T, client trackingClient, reporter OrderStatusReporter) (*TrackingPoller, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	store := &database.Store{DB: db}
+	return &TrackingPoller{
+		tracking:  &TrackingService{cpClient: client, store: store},
+		store:     store,
+		reporter:  reporter,
+		maxAge:    30 * 24 * time.Hour,
+		batchSize: 50,
+	}, mock
+}
+
+// expectDeliveredScan expects one label to be polled and its delivery scan
+// to be stored and read back.
+func expectDeliveredScan(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("FROM label_records").WillReturnRows(labelRecordRows(map[string]driver.Value{
+		"id":              "LBL1",
+		"tracking_number": "1371134583769923",
+		"invoice_uuid":    "invoice-1",
+		"client_id":       7,
+		"tracking_status": trackingStatusInTransit,
+	}))
+	mock.ExpectBegin()
+	mock.ExpectPrepare("INSERT INTO tracking_events")
+	mock.ExpectExec("INSERT INTO tracking_events").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	occurredAt := time.Date(2026, 2, 5, 19, 47, 43, 0, time.UTC)
+	mock.ExpectQuery("FROM tracking_events").WithArgs("1371134583769923").WillReturnRows(
+		sqlmock.NewRows(trackingEventRowColumns).AddRow(
+			"1371134583769923", "1496", "Item successfully delivered", "OTTAWA", "ON", "", "2026-02-05", "14:47:43", "EST", occurredAt, occurredAt,
+		),
+	)
+	mock.ExpectExec("UPDATE label_records\\s+SET tracking_status = \\?").WithArgs(trackingStatusDelivered, "LBL1").WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func deliveredTrackingClient() *fakeTrackingClient {
+	return &fakeTrackingClient{details: map[string]*TrackingDetailXML{
+		"1371134583769923": {
+			Pin: "1371134583769923",
+			Events: []TrackingOccurrenceXML{{
+				EventIdentifier:  "1496",
+				EventDate:        "2026-02-05",
+				EventTime:        "14:47:43",
+				EventTimeZone:    "EST",
+				EventDescription: "Item successfully delivered",
+				EventSite:        "OTTAWA",
+				EventProvince:    "on",
+			}},
+		},
+	}}
+}
+
+func TestTrackingPollerReportsStatusChange(t *testing.T) {
+	client := deliveredTrackingClient()
+	reporter := &recordingReporter{}
+	poller, mock := newTestTrackingPoller(t, client, reporter)
+	expectDeliveredScan(mock)
+	mock.ExpectExec("SET tracking_status_reported = \\?").WithArgs(trackingStatusDelivered, "LBL1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	poller.PollOnce(context.Background())
+
+	if len(client.calls) != 1 {
+		t.Fatalf("expected one tracking call, got %v", client.calls)
+	}
+	if len(reporter.updates) != 1 {
+		t.Fatalf("expected one status report, got %+v", reporter.updates)
+	}
+	update := reporter.updates[0]
+	if update.ClientID != 7 || update.InvoiceUUID != "invoice-1" || update.Status != trackingStatusDelivered || update.Description != "Item successfully delivered" {
+		t.Fatalf("unexpected update: %+v", update)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrackingPollerRetriesFailedReport(t *testing.T) {
+	reporter := &recordingReporter{err: errors.New("orders unavailable")}
+	poller, mock := newTestTrackingPoller(t, deliveredTrackingClient(), reporter)
+	// The status is stored but not marked reported, so the next poll
+	// reports it again.
+	expectDeliveredScan(mock)
+
+	poller.PollOnce(context.Background())
+
+	if len(reporter.updates) != 1 {
+		t.Fatalf("expected one report attempt, got %+v", reporter.updates)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrackingPollerStoresStatusWithoutReporter(t *testing.T) {
+	poller, mock := newTestTrackingPoller(t, deliveredTrackingClient(), nil)
+	expectDeliveredScan(mock)
+
+	poller.PollOnce(context.Background())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrackingPollerMarksUnreportableLabelsHandled(t *testing.T) {
+	reporter := &recordingReporter{}
+	poller, mock := newTestTrackingPoller(t, deliveredTrackingClient(), reporter)
+	mock.ExpectQuery("FROM label_records").WillReturnRows(labelRecordRows(map[string]driver.Value{
+		"id":              "LBL1",
+		"tracking_number": "1371134583769923",
+		"tracking_status": trackingStatusDelivered,
+	}))
+	mock.ExpectQuery("FROM tracking_events").WithArgs("1371134583769923").WillReturnRows(sqlmock.NewRows(trackingEventRowColumns))
+	mock.ExpectExec("UPDATE label_records\\s+SET tracking_status = \\?").WithArgs(trackingStatusDelivered, "LBL1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SET tracking_status_reported = \\?").WithArgs(trackingStatusDelivered, "LBL1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	poller.PollOnce(context.Background())
+
+	if len(reporter.updates) != 0 {
+		t.Fatalf("expected no report for a label without client or invoice, got %+v", reporter.updates)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
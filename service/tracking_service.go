@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"lexmodo-plugin/database"
+)
+
+// trackingClient is the part of the Canada Post client tracking needs.
+type trackingClient interface {
+	GetTrackingSummary(ctx context.Context, pin string) (*PinSummaryXML, error)
+	GetTrackingDetails(ctx context.Context, pin string) (*TrackingDetailXML, error)
+}
+
+type TrackingService struct {
+	cpClient trackingClient
+	store    *database.Store
+}
+
+func NewTrackingService(cpClient *CanadaPostClient, store *database.Store) *TrackingService {
+	return &TrackingService{
+		cpClient: cpClient,
+		store:    store,
+	}
+}
+
+type TrackingResult struct {
+	TrackingNumber       string
+	ServiceName          string
+	ExpectedDeliveryDate string
+	ChangedDeliveryDate  string
+	ChangedReason        string
+	Events               []database.TrackingEvent
+}
+
+// Refresh pulls the latest scans for a PIN from Canada Post, stores them and
+// returns the full stored history (newest first).
+func (s *TrackingService) Refresh(ctx context.Context, trackingNumber string) (TrackingResult, error) {
+	if s == nil || s.store == nil {
+		return TrackingResult{}, fmt.Errorf("tracking store not configured")
+	}
+	trackingNumber = strings.TrimSpace(trackingNumber)
+	if trackingNumber == "" {
+		return TrackingResult{}, fmt.Errorf("tracking number required")
+	}
+
+	result := TrackingResult{TrackingNumber: trackingNumber}
+	detail, err := s.cpClient.GetTrackingDetails(ctx, trackingNumber)
+	switch {
+	case errors.Is(err, errTrackingNoHistory):
+		log.Printf("tracking: no history yet for %s", trackingNumber)
+	case err != nil:
+		return result, err
+	default:
+		result.ServiceName = strings.TrimSpace(detail.ServiceName)
+		result.ExpectedDeliveryDate = strings.TrimSpace(detail.ExpectedDeliveryDate)
+		result.ChangedDeliveryDate = strings.TrimSpace(detail.ChangedExpectedDate)
+		result.ChangedReason = strings.TrimSpace(detail.ChangedExpectedDeliveryReason)
+		events := normalizeTrackingEvents(trackingNumber, detail.Events)
+		if err := s.store.SaveTrackingEvents(trackingNumber, events); err != nil {
+			return result, err
+		}
+	}
+
+	events, err := s.store.LoadTrackingEvents(trackingNumber)
+	if err != nil {
+		return result, err
+	}
+	result.Events = events
+	return result, nil
+}
+
+// TrackingSummary is what the Canada Post summary of a PIN adds to its
+// scans: when it was mailed and delivered and the latest event.
+type TrackingSummary struct {
+	MailedOnDate       string
+	ActualDeliveryDate string
+	AttemptedDate      string
+	EventDescription   string
+	EventLocation      string
+}
+
+// Summary fetches the Canada Post summary of a PIN. A PIN with no scans yet
+// has an empty summary.
+func (s *TrackingService) Summary(ctx context.Context, trackingNumber string) (TrackingSummary, error) {
+	if s == nil || s.cpClient == nil {
+		return TrackingSummary{}, fmt.Errorf("tracking client not configured")
+	}
+	summary, err := s.cpClient.GetTrackingSummary(ctx, strings.TrimSpace(trackingNumber))
+	if errors.Is(err, errTrackingNoHistory) {
+		return TrackingSummary{}, nil
+	}
+	if err != nil {
+		return TrackingSummary{}, err
+	}
+	return TrackingSummary{
+		MailedOnDate:       strings.TrimSpace(summary.MailedOnDate),
+		ActualDeliveryDate: strings.TrimSpace(summary.ActualDeliveryDate),
+		AttemptedDate:      strings.TrimSpace(summary.AttemptedDate),
+		EventDescription:   strings.TrimSpace(summary.EventDescription),
+		EventLocation:      strings.TrimSpace(summary.EventLocation),
+	}, nil
+}
+
+func (s *TrackingService) StoredEvents(trackingNumber string) ([]database.TrackingEvent, error) {
+	if s == nil || s.store == nil {
+		return nil, fmt.Errorf("tracking store not configured")
+	}
+	return s.store.LoadTrackingEvents(trackingNumber)
+}
+
+func normalizeTrackingEvents(trackingNumber string, occurrences []TrackingOccurrenceXML) []database.TrackingEvent {
+	events := make([]database.TrackingEvent, 0, len(occurrences))
+	for _, occ := range occurrences {
+		ev := database.TrackingEvent{
+			TrackingNumber: trackingNumber,
+			Identifier:     strings.TrimSpace(occ.EventIdentifier),
+			Description:    strings.TrimSpace(occ.EventDescription),
+			Site:           strings.TrimSpace(occ.EventSite),
+			Province:       strings.ToUpper(strings.TrimSpace(occ.EventProvince)),
+			SignatoryName:  strings.TrimSpace(occ.SignatoryName),
+			EventDate:      strings.TrimSpace(occ.EventDate),
+			EventTime:      strings.TrimSpace(occ.EventTime),
+			EventTimeZone:  strings.ToUpper(strings.TrimSpace(occ.EventTimeZone)),
+		}
+		ev.OccurredAt = parseTrackingEventTime(ev.EventDate, ev.EventTime, ev.EventTimeZone)
+		events = append(events, ev)
+	}
+	return events
+}
+
+// Canada Post reports scan times with a local zone abbreviation.
+var trackingZoneOffsets = map[string]int{
+	"NST": -(3*3600 + 1800),
+	"NDT": -(2*3600 + 1800),
+	"AST": -4 * 3600,
+	"ADT": -3 * 3600,
+	"EST": -5 * 3600,
+	"EDT": -4 * 3600,
+	"CST": -6 * 3600,
+	"CDT": -5 * 3600,
+	"MST": -7 * 3600,
+	"MDT": -6 * 3600,
+	"PST": -8 * 3600,
+	"PDT": -7 * 3600,
+}
+
+func parseTrackingEventTime(date, clock, zone string) time.Time {
+	if date == "" {
+		return time.Time{}
+	}
+	if clock == "" {
+		clock = "00:00:00"
+	}
+	loc := time.UTC
+	if offset, ok := trackingZoneOffsets[zone]; ok {
+		loc = time.FixedZone(zone, offset)
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", date+" "+clock, loc)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
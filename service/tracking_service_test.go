@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"lexmodo-plugin/database"
+)
+
+func TestGetTrackingDetailsParsesEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/vis/track/pin/1371134583769923/detail" {
+			t.Fatalf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Accept"); got != "application/vnd.cpc.track-v2+xml" {
+			t.Fatalf("unexpected accept header %q", got)
+		}
+		w.Header().Set("Content-Type", "application/vnd.cpc.track-v2+xml")
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<tracking-detail xmlns="http://www.canadapost.ca/ws/track-v2">
+  <pin>1371134583769923</pin>
+  <expected-delivery-date>2026-02-05</expected-delivery-date>
+  <service-name>Expedited Parcels</service-name>
+  <signature-image-exists/>
+  <significant-events>
+    <occurrence>
+      <event-identifier>1496</event-identifier>
+      <event-date>2026-02-05</event-date>
+      <event-time>14:47:43</event-time>
+      <event-time-zone>EST</event-time-zone>
+      <event-description>Item successfully delivered</event-description>
+      <event-site>OTTAWA</event-site>
+      <event-province>on</event-province>
+    </occurrence>
+  </significant-events>
+</tracking-detail>`))
+	}))
+	defer server.Close()
+
+	client := NewCanadaPostClient("user", "pass", "123", server.URL)
+	detail, err := client.GetTrackingDetails(context.Background(), "1371134583769923")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if detail.ServiceName != "Expedited Parcels" || len(detail.Events) != 1 {
+		t.Fatalf("unexpected detail: %+v", detail)
+	}
+
+	events := normalizeTrackingEvents(detail.Pin, detail.Events)
+	if events[0].Province != "ON" || events[0].Identifier != "1496" {
+		t.Fatalf("unexpected event: %+v", events[0])
+	}
+	want := time.Date(2026, 2, 5, 19, 47, 43, 0, time.UTC)
+	if !events[0].OccurredAt.Equal(want) {
+		t.Fatalf("expected occurred_at %s, got %s", want, events[0].OccurredAt)
+	}
+}
+
+func TestTrackingServiceSummary(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/vis/track/pin/1371134583769923/summary" {
+			t.Fatalf("unexpected path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/vnd.cpc.track-v2+xml")
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<tracking-summary xmlns="http://www.canadapost.ca/ws/track-v2">
+  <pin-summary>
+    <pin>1371134583769923</pin>
+    <mailed-on-date>2026-02-03</mailed-on-date>
+    <actual-delivery-date>2026-02-05</actual-delivery-date>
+    <event-description>Item successfully delivered</event-description>
+    <event-location>OTTAWA</event-location>
+  </pin-summary>
+</tracking-summary>`))
+	}))
+	defer server.Close()
+
+	tracking := NewTrackingService(NewCanadaPostClient("user", "pass", "123", server.URL), nil)
+	summary, err := tracking.Summary(context.Background(), "1371134583769923")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if summary.MailedOnDate != "2026-02-03" || summary.ActualDeliveryDate != "2026-02-05" || summary.EventLocation != "OTTAWA" {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+}
+
+func TestGetTrackingDetailsNoHistory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`<messages xmlns="http://www.canadapost.ca/ws/messages">
+  <message>
+    <code>004</code>
+    <description>No Pin History</description>
+  </message>
+</messages>`))
+	}))
+	defer server.Close()
+
+	client := NewCanadaPostClient("user", "pass", "123", server.URL)
+	_, err := client.GetTrackingDetails(context.Background(), "123")
+	if !errors.Is(err, errTrackingNoHistory) {
+		t.Fatalf("expected no history error, got %v", err)
+	}
+}
+
+func TestClassifyTrackingEvent(t *testing.T) {
+	cases := []struct {
+		event database.TrackingEvent
+		want  string
+	}{
+		{database.TrackingEvent{Identifier: "1496", Description: "Item successfully delivered"}, trackingStatusDelivered},
+		{database.TrackingEvent{Identifier: "0100", Description: "Item processed"}, trackingStatusInTransit},
+		{database.TrackingEvent{Identifier: "1701", Description: "Item being returned to sender"}, trackingStatusReturned},
+		{database.TrackingEvent{Identifier: "1415", Description: "Delivery attempted; notice card left"}, trackingStatusException},
+		{database.TrackingEvent{Identifier: "1100", Description: "Item could not be delivered"}, trackingStatusException},
+	}
+	for _, tc := range cases {
+		if got := classifyTrackingEvent(tc.event); got != tc.want {
+			t.Fatalf("expected %s for %q, got %s", tc.want, tc.event.Description, got)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import "encoding/xml"
+
+// Get Tracking Summary – REST
+type TrackingSummaryXML struct {
+	XMLName    xml.Name        `xml:"http://www.canadapost.ca/ws/track-v2 tracking-summary"`
+	PinSummary []PinSummaryXML `xml:"http://www.canadapost.ca/ws/track-v2 pin-summary"`
+}
+
+type PinSummaryXML struct {
+	Pin                        string `xml:"http://www.canadapost.ca/ws/track-v2 pin"`
+	OriginPostalID             string `xml:"http://www.canadapost.ca/ws/track-v2 origin-postal-id"`
+	DestinationPostalID        string `xml:"http://www.canadapost.ca/ws/track-v2 destination-postal-id"`
+	DestinationProvince        string `xml:"http://www.canadapost.ca/ws/track-v2 destination-province"`
+	ServiceName                string `xml:"http://www.canadapost.ca/ws/track-v2 service-name"`
+	MailedOnDate               string `xml:"http://www.canadapost.ca/ws/track-v2 mailed-on-date"`
+	ExpectedDeliveryDate       string `xml:"http://www.canadapost.ca/ws/track-v2 expected-delivery-date"`
+	ActualDeliveryDate         string `xml:"http://www.canadapost.ca/ws/track-v2 actual-delivery-date"`
+	DeliveryOptionCompletedInd string `xml:"http://www.canadapost.ca/ws/track-v2 delivery-option-completed-ind"`
+	EventDateTime              string `xml:"http://www.canadapost.ca/ws/track-v2 event-date-time"`
+	EventDescription           string `xml:"http://www.canadapost.ca/ws/track-v2 event-description"`
+	AttemptedDate              string `xml:"http://www.canadapost.ca/ws/track-v2 attempted-date"`
+	CustomerRef1               string `xml:"http://www.canadapost.ca/ws/track-v2 customer-ref-1"`
+	CustomerRef2               string `xml:"http://www.canadapost.ca/ws/track-v2 customer-ref-2"`
+	ReturnPin                  string `xml:"http://www.canadapost.ca/ws/track-v2 return-pin"`
+	EventType                  string `xml:"http://www.canadapost.ca/ws/track-v2 event-type"`
+	EventLocation              string `xml:"http://www.canadapost.ca/ws/track-v2 event-location"`
+	SignatoryName              string `xml:"http://www.canadapost.ca/ws/track-v2 signatory-name"`
+}
+
+// Get Tracking Details – REST
+type TrackingDetailXML struct {
+	XMLName                       xml.Name                `xml:"http://www.canadapost.ca/ws/track-v2 tracking-detail"`
+	Pin                           string                  `xml:"http://www.canadapost.ca/ws/track-v2 pin"`
+	ActiveExists                  string                  `xml:"http://www.canadapost.ca/ws/track-v2 active-exists"`
+	ArchiveExists                 string                  `xml:"http://www.canadapost.ca/ws/track-v2 archive-exists"`
+	ChangedExpectedDate           string                  `xml:"http://www.canadapost.ca/ws/track-v2 changed-expected-date"`
+	DestinationPostalID           string                  `xml:"http://www.canadapost.ca/ws/track-v2 destination-postal-id"`
+	ExpectedDeliveryDate          string                  `xml:"http://www.canadapost.ca/ws/track-v2 expected-delivery-date"`
+	ChangedExpectedDeliveryReason string                  `xml:"http://www.canadapost.ca/ws/track-v2 changed-expected-delivery-reason"`
+	MailedByCustomerNumber        string                  `xml:"http://www.canadapost.ca/ws/track-v2 mailed-by-customer-number"`
+	OriginalPin                   string                  `xml:"http://www.canadapost.ca/ws/track-v2 original-pin"`
+	ServiceName                   string                  `xml:"http://www.canadapost.ca/ws/track-v2 service-name"`
+	CustomerRef1                  string                  `xml:"http://www.canadapost.ca/ws/track-v2 customer-ref-1"`
+	CustomerRef2                  string                  `xml:"http://www.canadapost.ca/ws/track-v2 customer-ref-2"`
+	ReturnPin                     string                  `xml:"http://www.canadapost.ca/ws/track-v2 return-pin"`
+	SignatureImageExists          bool                    `xml:"http://www.canadapost.ca/ws/track-v2 signature-image-exists"`
+	SuppressSignature             bool                    `xml:"http://www.canadapost.ca/ws/track-v2 suppress-signature"`
+	Events                        []TrackingOccurrenceXML `xml:"http://www.canadapost.ca/ws/track-v2 significant-events>occurrence"`
+}
+
+type TrackingOccurrenceXML struct {
+	EventIdentifier       string `xml:"http://www.canadapost.ca/ws/track-v2 event-identifier"`
+	EventDate             string `xml:"http://www.canadapost.ca/ws/track-v2 event-date"`
+	EventTime             string `xml:"http://www.canadapost.ca/ws/track-v2 event-time"`
+	EventTimeZone         string `xml:"http://www.canadapost.ca/ws/track-v2 event-time-zone"`
+	EventDescription      string `xml:"http://www.canadapost.ca/ws/track-v2 event-description"`
+	SignatoryName         string `xml:"http://www.canadapost.ca/ws/track-v2 signatory-name"`
+	EventSite             string `xml:"http://www.canadapost.ca/ws/track-v2 event-site"`
+	EventProvince         string `xml:"http://www.canadapost.ca/ws/track-v2 event-province"`
+	EventRetailLocationID string `xml:"http://www.canadapost.ca/ws/track-v2 event-retail-location-id"`
+	EventRetailName       string `xml:"http://www.canadapost.ca/ws/track-v2 event-retail-name"`
+}